@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/preview"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+const usage = `Usage:
+  operator                                  run the operator
+  operator validate <path> [environment]    validate bitesize file
+  operator render <path> <environment>      print manifests generated for environment
+  operator preview <base path> <path> <environment>
+                                            print diff of manifests generated from
+                                            base and proposed bitesize files, and post
+                                            it to PREVIEW_WEBHOOK_URL if set
+`
+
+// runCommand runs CLI subcommand against a local bitesize file without
+// connecting to the cluster and returns process exit code
+func runCommand(name string, args []string) int {
+	var err error
+
+	switch {
+	case name == "validate" && (len(args) == 1 || len(args) == 2):
+		err = validate(os.Stdout, args[0], args[1:]...)
+	case name == "render" && len(args) == 2:
+		err = render(os.Stdout, args[0], args[1])
+	case name == "preview" && len(args) == 3:
+		err = previewChanges(os.Stdout, args[0], args[1], args[2])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// validate checks either a single named environment, or all environments
+// in the bitesize file
+func validate(w io.Writer, path string, envName ...string) error {
+	if len(envName) > 0 {
+		e, err := loadLocalEnvironment(path, envName[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "environment %s: valid\n", e.Name)
+		return nil
+	}
+
+	e, err := bitesize.LoadFromFile(path)
+	if err != nil {
+		return err
+	}
+	for _, env := range e.Environments {
+		fmt.Fprintf(w, "environment %s: valid\n", env.Name)
+	}
+	return nil
+}
+
+// render prints manifests generated for every service in the environment
+// as a multi-document YAML
+func render(w io.Writer, path, envName string) error {
+	return renderEnvironment(path, envName, func(obj runtime.Object, out []byte) {
+		fmt.Fprintf(w, "---\n%s", out)
+	})
+}
+
+// previewChanges prints diff of manifests generated for the environment
+// from base and head bitesize files as a markdown comment, and posts the
+// comment to PREVIEW_WEBHOOK_URL if set. A missing base file is treated as
+// an empty environment
+func previewChanges(w io.Writer, basePath, headPath, envName string) error {
+	base := preview.Objects{}
+	if _, err := os.Stat(basePath); !os.IsNotExist(err) {
+		if base, err = renderObjects(basePath, envName); err != nil {
+			return fmt.Errorf("base: %s", err.Error())
+		}
+	}
+	head, err := renderObjects(headPath, envName)
+	if err != nil {
+		return err
+	}
+
+	changes, err := preview.Diff(base, head)
+	if err != nil {
+		return err
+	}
+	comment := preview.Comment(envName, changes)
+	fmt.Fprint(w, comment)
+
+	if config.Env.PreviewWebhookURL == "" {
+		return nil
+	}
+	return preview.Post(config.Env.PreviewWebhookURL, config.Env.PreviewWebhookToken, comment)
+}
+
+// renderObjects returns manifests generated for the environment by kind
+// and name
+func renderObjects(path, envName string) (preview.Objects, error) {
+	objects := preview.Objects{}
+	err := renderEnvironment(path, envName, func(obj runtime.Object, out []byte) {
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		if m, err := meta.Accessor(obj); err == nil {
+			objects[kind+"/"+m.GetName()] = string(out)
+		}
+	})
+	return objects, err
+}
+
+// renderEnvironment calls fn with every object generated for services
+// deployed in the environment and objects of its manifest gists, with
+// their YAML
+func renderEnvironment(path, envName string, fn func(obj runtime.Object, out []byte)) error {
+	e, err := loadLocalEnvironment(path, envName)
+	if err != nil {
+		return err
+	}
+
+	for _, service := range e.Services.ForEnvironment(e.Name) {
+		gists := e.ServiceGists(service)
+		mapper := &translator.KubeMapper{
+			BiteService: &service,
+			Namespace:   e.Namespace,
+			Gists:       &gists,
+		}
+
+		objects, err := mapper.Manifests()
+		if err != nil {
+			return fmt.Errorf("service %s: %s", service.Name, err.Error())
+		}
+		for _, obj := range objects {
+			out, err := yaml.Marshal(obj)
+			if err != nil {
+				return fmt.Errorf("service %s: %s", service.Name, err.Error())
+			}
+			fn(obj, out)
+		}
+	}
+
+	for _, gist := range e.Gists.FindByType(bitesize.TypeManifest) {
+		for i := range gist.Manifests {
+			obj := gist.Manifests[i].DeepCopy()
+			obj.SetNamespace(e.Namespace)
+			out, err := yaml.Marshal(obj)
+			if err != nil {
+				return fmt.Errorf("manifest %s: %s", gist.Name, err.Error())
+			}
+			fn(obj, out)
+		}
+	}
+	return nil
+}
+
+// loadLocalEnvironment loads environment from a bitesize file, resolving
+// gist paths relative to the file's directory
+func loadLocalEnvironment(path, envName string) (*bitesize.Environment, error) {
+	config.Env.GitLocalPath = filepath.Dir(path)
+	return bitesize.LoadEnvironment(path, envName)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const secretEnvBitesize = `project: test
+environments:
+- name: dev
+  namespace: dev
+  services:
+  - name: api
+    application: api
+    version: 1
+    port: 80
+    env:
+    - secret: DB_PASSWORD
+      value: db/password
+    init_containers:
+    - application: migrate
+      name: migrate
+      version: 1
+      env:
+      - secret: DB_PASSWORD
+        value: db/password
+`
+
+const blueGreenBitesize = `project: test
+environments:
+- name: dev
+  namespace: dev
+  services:
+  - name: api
+    application: api
+    version: 1
+    port: 80
+    deployment:
+      method: bluegreen
+      active: %s
+`
+
+func TestRenderSecretEnvVarOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "environments.bitesize")
+	if err := ioutil.WriteFile(path, []byte(secretEnvBitesize), 0644); err != nil {
+		t.Fatalf("Unexpected error writing bitesize file: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+	if err := render(&out, path, "dev"); err != nil {
+		t.Fatalf("Unexpected error rendering environment: %s", err.Error())
+	}
+	if !strings.Contains(out.String(), "secretKeyRef") {
+		t.Errorf("Expected secret env var in rendered manifests, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := previewChanges(&out, filepath.Join(dir, "missing.bitesize"), path, "dev"); err != nil {
+		t.Fatalf("Unexpected error previewing environment: %s", err.Error())
+	}
+	if !strings.Contains(out.String(), "Deployment/api (added)") {
+		t.Errorf("Expected deployment in preview, got:\n%s", out.String())
+	}
+}
+
+func TestPreviewActiveColourSwitch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preview")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base.bitesize")
+	head := filepath.Join(dir, "head.bitesize")
+	for path, active := range map[string]string{base: "blue", head: "green"} {
+		if err := ioutil.WriteFile(path, []byte(fmt.Sprintf(blueGreenBitesize, active)), 0644); err != nil {
+			t.Fatalf("Unexpected error writing bitesize file: %s", err.Error())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := previewChanges(&out, base, head, "dev"); err != nil {
+		t.Fatalf("Unexpected error previewing environment: %s", err.Error())
+	}
+	for _, s := range []string{"0 added, 1 changed, 0 removed", "Service/api (changed)", "-    name: api-blue", "+    name: api-green"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("Expected %q in preview, got:\n%s", s, out.String())
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"time"
@@ -10,21 +11,41 @@ import (
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/cluster"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
-	"github.com/pearsontechnology/environment-operator/pkg/git"
+	"github.com/pearsontechnology/environment-operator/pkg/health"
+	"github.com/pearsontechnology/environment-operator/pkg/leader"
 	"github.com/pearsontechnology/environment-operator/pkg/reaper"
+	"github.com/pearsontechnology/environment-operator/pkg/source"
 	"github.com/pearsontechnology/environment-operator/pkg/web"
 	"github.com/pearsontechnology/environment-operator/version"
 )
 
-var gitClient *git.Git
+var src *source.Shared
 var client *cluster.Cluster
 var reap reaper.Reaper
 
 func init() {
-	var err error
+	logLevel, err := log.ParseLevel(config.Env.LogLevel)
+	if err != nil {
+		log.Fatalf("Can't parse LOG_LEVEL \"%s\": %s", logLevel, err.Error())
+	}
+	log.SetLevel(logLevel)
+
+	// allow DEBUG=true to override LOG_LEVEL
+	if config.Env.Debug == "true" {
+		log.SetLevel(log.DebugLevel)
+	}
+}
 
-	gitClient = git.Client()
-	log.Tracef("gitClient: %#v", gitClient)
+// setup initializes config source and kubernetes clients used by the
+// operator server
+func setup() {
+	configSource, err := source.FromConfig(config.Env)
+	if err != nil {
+		log.Fatalf("Error initializing config source: %s", err.Error())
+	}
+	log.Tracef("config source: %#v", configSource)
+	src = source.NewShared(configSource)
+	web.Source = src
 
 	client, err = cluster.Client()
 	if err != nil {
@@ -36,16 +57,34 @@ func init() {
 		Wrapper:   client,
 	}
 
-	logLevel, err := log.ParseLevel(config.Env.LogLevel)
-	if err != nil {
-		log.Fatalf("Can't parse LOG_LEVEL \"%s\": %s", logLevel, err.Error())
+	if config.Env.LeaderElection {
+		leader.Election = &leader.Elector{
+			Client:        client.Interface,
+			Namespace:     config.Env.LeaderElectionNamespace,
+			Name:          config.Env.LeaderElectionLease,
+			Identity:      config.Env.PodName,
+			LeaseDuration: time.Duration(config.Env.LeaderElectionDuration) * time.Second,
+		}
+		if leader.Election.Namespace == "" {
+			leader.Election.Namespace = config.Env.Namespace
+		}
+		if leader.Election.Identity == "" {
+			leader.Election.Identity, _ = os.Hostname()
+		}
 	}
-	log.SetLevel(logLevel)
+}
 
-	// allow DEBUG=true to override LOG_LEVEL
-	if config.Env.Debug == "true" {
-		log.SetLevel(log.DebugLevel)
+// lead blocks until this replica is elected to reconcile, when leader
+// election is enabled. Operator exits once it loses the lease, so that it
+// never reconciles alongside a new leader
+func lead() {
+	if leader.Election == nil {
+		return
 	}
+	leader.Election.Acquire()
+	go leader.Election.Renew(func() {
+		log.Fatalf("leader election: lost lease %s, exiting", leader.Election.Name)
+	})
 }
 
 func webserver() {
@@ -56,57 +95,87 @@ func webserver() {
 		authenticated = web.Auth(logged)
 	}
 
-	if err := http.ListenAndServe(":8080", authenticated); err != nil {
+	// readiness probe is served without authentication
+	mux := http.NewServeMux()
+	mux.Handle("/readyz", handlers.CombinedLoggingHandler(os.Stderr, http.HandlerFunc(web.Readyz)))
+	mux.Handle("/", authenticated)
+
+	if err := http.ListenAndServe(":8080", mux); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// reconcile refreshes configuration source and applies loaded
+// environment to the cluster. Trigger tells whether reconcile was started
+// by the poll or by a change of the source
+func reconcile(src *source.Shared, trigger string) error {
+	// stale configuration is still applied when refresh fails
+	refreshErr := src.Refresh()
+	if refreshErr != nil {
+		refreshErr = fmt.Errorf("config source refresh failed with %s", refreshErr.Error())
+	}
+	revision, err := src.CurrentRevision()
+	if err == nil {
+		log.Debugf("Configuration revision: %s", revision)
+	}
+
+	configuration, err := src.Load()
+	log.Tracef("configuration: %#v", configuration)
+
+	if err != nil {
+		return fmt.Errorf("error while loading environment config: %s", err.Error())
+	}
+	if err := client.ApplyIfChangedOn(configuration, trigger); err != nil {
+		return fmt.Errorf("error when applying changes: %s", err.Error())
+	}
+	if err := reap.CleanupOn(configuration, trigger); err != nil {
+		return fmt.Errorf("error reaper failed: %s", err.Error())
+	}
+	return refreshErr
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	log.Infof("Starting up environment-operator version %s", version.Version)
+	setup()
 
 	go webserver()
+	lead()
 
-	// Polling interval
-	sleepDurationSeconds := 30
-	sleepDuration := time.Duration(sleepDurationSeconds) * time.Second
+	// Polling interval, backed off while reconcile keeps failing
+	interval := time.Duration(config.Env.ReconcileInterval) * time.Second
+	maxBackoff := time.Duration(config.Env.ReconcileMaxBackoff) * time.Second
 
-	err := gitClient.Pull()
-
-	if err != nil {
-		log.Errorf("Git clone error: %s", err.Error())
-		log.Errorf("Git Client Information:")
-		log.Errorf("  RemotePath=%s"+
-			"   LocalPath=%s"+
-			"   Branch=%s"+
-			"   SSHkey=%s"+
-			"   GITUser=%s\n",
-			gitClient.RemotePath,
-			gitClient.LocalPath,
-			gitClient.BranchName,
-			gitClient.SSHKey,
-			gitClient.GitUser,
-		)
-	}
+	changes := src.Changes()
 
+	trigger := bitesize.TriggerPoll
 	for {
-		if err := gitClient.Refresh(); err != nil {
-			log.Errorf("git client refresh failed with %s", err.Error())
+		start := time.Now()
+		err := reconcile(src, trigger)
+		health.Reconcile.Observe(time.Since(start))
+		if err != nil {
+			log.Error(err)
+			health.Reconcile.Failure(err)
+		} else {
+			health.Reconcile.Success()
 		}
-		configurationInGit, err := bitesize.LoadEnvironmentFromConfig(config.Env)
-		log.Tracef("configurationInGit: %#v", configurationInGit)
 
-		if err != nil {
-			log.Errorf("error while loading environment config: %s", err.Error())
+		sleepDuration := health.Reconcile.Backoff(interval, maxBackoff)
+		if sleepDuration > interval {
+			log.Infof("Reconcile is failing, backing off for %s", sleepDuration)
 		} else {
-			if err := client.ApplyIfChanged(configurationInGit); err != nil {
-				log.Errorf("error when applying changes: %s", err.Error())
-			}
-			if err := reap.Cleanup(configurationInGit); err != nil {
-				log.Errorf("error reaper failed: %s", err.Error())
-			}
+			log.Debugf("Sleeping %s", sleepDuration)
+		}
+		select {
+		case <-time.After(sleepDuration):
+			trigger = bitesize.TriggerPoll
+		case <-changes:
+			log.Debugf("Configuration source changed")
+			trigger = bitesize.TriggerChange
 		}
-		log.Debugf("Sleeping %d seconds", sleepDurationSeconds)
-		time.Sleep(sleepDuration)
 	}
 
 }
@@ -21,6 +21,9 @@ FULL_IMAGE="${IMAGE}:${IMAGE_TAG}"
 BUILD_IMAGE="golang:1.12-alpine"
 
 bin_dir="_output/bin"
+
+VERSION_PKG=github.com/pearsontechnology/environment-operator/version
+LDFLAGS="-X ${VERSION_PKG}.GitCommit=$(git rev-parse --short HEAD) -X ${VERSION_PKG}.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
 mkdir -p ${bin_dir} || true
 
 #CC="/usr/local/bin/gcc-6" GOOS=linux GOARCH=amd64 CGO_ENABLED=1 go build -v -x \
@@ -41,7 +44,7 @@ echo "**************************************************************"
  docker run --rm -v "$(pwd)":/go/src/github.com/pearsontechnology/environment-operator \
   	-w /go/src/github.com/pearsontechnology/environment-operator \
  	${BUILD_IMAGE} \
-    go build -v -o ${bin_dir}/environment-operator ./cmd/operator/main.go
+    go build -v -ldflags "${LDFLAGS}" -o ${bin_dir}/environment-operator ./cmd/operator/main.go
 #    /bin/sh -c  "apk update && apk add build-base && go build -v -o ${bin_dir}/environment-operator ./cmd/operator/main.go"
 
 echo "**************************************************************"
@@ -49,7 +52,7 @@ echo "***************** Building Docker Image and Pushing **********"
 echo "**************************************************************"
 
 echo "== Building docker image ${FULL_IMAGE}"
-docker build --tag "${FULL_IMAGE}" -f hack/build/Dockerfile .
+docker build --tag "${FULL_IMAGE}" --build-arg COSIGN_SHA256="${COSIGN_SHA256}" -f hack/build/Dockerfile .
 
 echo "== Uploading docker image ${FULL_IMAGE}"
 docker push "${FULL_IMAGE}"
@@ -94,5 +94,10 @@ func copyBlueGreenService(svc Service, kind BlueGreenServiceSet) Service {
 	}
 	retval.ExternalURL = externalURLs
 
+	// certificate hosts must follow the colour's own urls
+	if retval.CertManager != nil {
+		retval.CertManager.Hosts = nil
+	}
+
 	return retval
 }
@@ -3,10 +3,14 @@ package bitesize
 import (
 	"fmt"
 	"io/ioutil"
+	"strings"
 
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
 	validator "gopkg.in/validator.v2"
 	yaml "gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
+	k8Yaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
 // EnvironmentsBitesize is a 1:1 mapping to environments.bitesize file
@@ -21,6 +25,9 @@ type DeploymentSettings struct {
 	Mode       string              `yaml:"mode,omitempty" validate:"regexp=^(manual|auto)*$"`
 	BlueGreen  *BlueGreenSettings  `yaml:"-"`
 	CustomURLs map[string][]string `yaml:"custom_urls,omitempty"`
+	// Seconds to wait for the new colour to become available before
+	// switching traffic to it. Defaults to BLUE_GREEN_PROMOTION_TIMEOUT
+	PromotionTimeout int `yaml:"promotion_timeout,omitempty" validate:"min=0"`
 	// XXX    map[string]interface{} `yaml:",inline"`
 }
 
@@ -32,11 +39,75 @@ type BlueGreenSettings struct {
 	ActiveFlag       bool                 // used in "child" blue/green service to indicate whethen this environment is currently active
 }
 
+// CertManager requests ingress TLS certificate issued by cert-manager
+type CertManager struct {
+	Issuer string `yaml:"issuer" validate:"nonzero"`
+	// IssuerKind is either ClusterIssuer (default) or namespaced Issuer
+	IssuerKind string `yaml:"issuer_kind,omitempty" validate:"regexp=^(ClusterIssuer|Issuer)*$"`
+	// Hosts certificate is issued for. Defaults to external_url
+	Hosts []string `yaml:"hosts,omitempty"`
+}
+
+// SecretFetch configures init container fetching secrets (e.g. with vault
+// agent) into an in-memory volume shared with the service container
+type SecretFetch struct {
+	// Image defaults to SECRET_FETCH_IMAGE
+	Image string `yaml:"image,omitempty"`
+	// Command defaults to running vault agent with config from ConfigMap
+	Command []string `yaml:"command,omitempty"`
+	EnvVars []EnvVar `yaml:"env,omitempty"`
+	// ConfigMap is mounted to the fetching container at /vault/config
+	ConfigMap string `yaml:"config_map,omitempty"`
+	// Path secrets are written to and read from. Defaults to /vault/secrets
+	Path string `yaml:"path,omitempty"`
+}
+
+// ServiceMonitor requests Prometheus Operator to scrape metrics of the
+// service
+type ServiceMonitor struct {
+	// Port metrics are served on. Defaults to the first port of the service
+	Port int `yaml:"port,omitempty" json:"port"`
+	// Path defaults to /metrics
+	Path string `yaml:"path,omitempty" json:"path"`
+	// Interval between scrapes, e.g. 30s. Defaults to Prometheus' interval
+	Interval string `yaml:"interval,omitempty" json:"interval,omitempty" validate:"regexp=^([0-9]+(ms|s|m|h))*$"`
+}
+
+// BackendHealthCheck requests the ingress controller to actively check
+// pods of the service and stop routing to failing ones, independent of
+// kubernetes endpoints
+type BackendHealthCheck struct {
+	// Path checked with HTTP GET. Pods are checked by TCP connect if empty
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// Interval between checks, e.g. 10s. Defaults to the controller's
+	Interval string `yaml:"interval,omitempty" json:"interval,omitempty" validate:"regexp=^([0-9]+(s|m))*$"`
+}
+
+// Patch is applied to an object generated for the service just before it
+// is applied, for options bitesize has no dedicated field for
+type Patch struct {
+	// Target is the patched object, either deployment or service
+	Target string `yaml:"target" json:"target" validate:"regexp=^(deployment|service)$"`
+	// Type is strategic (default), merge or json
+	Type string `yaml:"type,omitempty" json:"type" validate:"regexp=^(strategic|merge|json)*$"`
+	// Patch contents, in YAML or JSON
+	Patch string `yaml:"patch" json:"patch" validate:"nonzero"`
+}
+
 // HorizontalPodAutoscaler maps to HPA in kubernetes
 type HorizontalPodAutoscaler struct {
 	MinReplicas int32  `yaml:"min_replicas"`
 	MaxReplicas int32  `yaml:"max_replicas"`
 	Metric      Metric `yaml:"metric"`
+	// Labels are added to the labels inherited from the service
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+// WeightedBackend is a service receiving a share of the traffic sent to
+// external_url
+type WeightedBackend struct {
+	Service string `yaml:"service" validate:"nonzero"`
+	Weight  int    `yaml:"weight"`
 }
 
 // Container maps a single application container that you want to run within a pod
@@ -96,10 +167,24 @@ type Probe struct {
 	FailureThreshold    int32 `yaml:"failure_threshold,omitempty"`
 }
 
+// setDefaults sets port of HTTP checks without one to the container port
+// traffic to the first service port is sent to
+func (p *Probe) setDefaults(ports []int, targetPort func(int) int) error {
+	if p == nil || p.HTTPGet == nil || p.HTTPGet.Port != 0 {
+		return nil
+	}
+	if len(ports) == 0 {
+		return fmt.Errorf("http_get has no port and service has no ports")
+	}
+	p.HTTPGet.Port = int32(targetPort(ports[0]))
+	return nil
+}
+
 type Handler struct {
 	Exec      *ExecAction      `yaml:"exec,omitempty"`
 	HTTPGet   *HTTPGetAction   `yaml:"http_get,omitempty"`
 	TCPSocket *TCPSocketAction `yaml:"tcp_socket,omitempty"`
+	GRPC      *GRPCAction      `yaml:"grpc,omitempty"`
 }
 
 type ExecAction struct {
@@ -124,12 +209,49 @@ type TCPSocketAction struct {
 	Host string `yaml:"host,omitempty"`
 }
 
+// GRPCAction describes a gRPC health check. Kubernetes API the operator is
+// built with has no gRPC probes, so it is applied as a TCP check of Port
+type GRPCAction struct {
+	Port    int32  `yaml:"port"`
+	Service string `yaml:"service,omitempty"`
+}
+
 // EnvVar represents environment variables in pod
 type EnvVar struct {
 	Name     string `yaml:"name,omitempty"`
 	Value    string `yaml:"value,omitempty"`
 	Secret   string `yaml:"secret,omitempty"`
 	PodField string `yaml:"pod_field,omitempty"`
+	// ExternalKey of the secret manager entry (and ExternalProperty of
+	// its JSON value) synced into the Kubernetes secret Secret refers to
+	ExternalKey      string `yaml:"external_key,omitempty"`
+	ExternalProperty string `yaml:"external_property,omitempty"`
+}
+
+// SecretRef returns name and key of the Kubernetes secret a secret env var
+// refers to, given as secret/key in Value. Key defaults to the secret name
+func (e EnvVar) SecretRef() (string, string) {
+	kv := strings.Split(e.Value, "/")
+	if len(kv) == 2 {
+		return kv[0], kv[1]
+	}
+	return kv[0], kv[0]
+}
+
+// envName returns name the env var is declared with in the container
+func (e EnvVar) envName() string {
+	if e.Secret != "" {
+		return e.Secret
+	}
+	return e.Name
+}
+
+// EnvFromSource sets env vars of all keys of a configmap or a secret,
+// optionally with Prefix prepended to their names
+type EnvFromSource struct {
+	ConfigMap string `yaml:"configmap,omitempty"`
+	Secret    string `yaml:"secret,omitempty"`
+	Prefix    string `yaml:"prefix,omitempty"`
 }
 
 // Pod represents Pod in Kubernetes
@@ -137,8 +259,27 @@ type Pod struct {
 	Name      string      `yaml:"name"`
 	Phase     v1.PodPhase `yaml:"phase"`
 	StartTime string      `yaml:"start_time"`
+	ImageID   string      `yaml:"image_id"`
 	Message   string      `yaml:"message"`
 	Logs      string      `yaml:"logs"`
+	// Containers and InitContainers hold per container status
+	Containers     []ContainerStatus `yaml:"containers"`
+	InitContainers []ContainerStatus `yaml:"init_containers,omitempty"`
+}
+
+// ContainerStatus represents status of a single container in pod
+type ContainerStatus struct {
+	Name         string `yaml:"name"`
+	Ready        bool   `yaml:"ready"`
+	RestartCount int32  `yaml:"restart_count"`
+	// State is waiting, running or terminated
+	State string `yaml:"state"`
+	// Reason of waiting or terminated state, e.g. CrashLoopBackOff
+	Reason  string `yaml:"reason,omitempty"`
+	Message string `yaml:"message,omitempty"`
+	// LastStateReason is why the container last terminated, e.g. OOMKilled
+	LastStateReason string `yaml:"last_state_reason,omitempty"`
+	LastExitCode    int32  `yaml:"last_exit_code,omitempty"`
 }
 
 // Annotation represents annotation variables in pod
@@ -165,6 +306,20 @@ type Volume struct {
 	// relative and may not contain the '..' path or start with '..'.
 	// +optional
 	Items []KeyToPath `yaml:"items"`
+	// DefaultMode bits of files mounted by secret and configmap volumes,
+	// 0644 if unset. Modes of Items override it
+	DefaultMode *int32 `yaml:"default_mode,omitempty"`
+	// Labels and Annotations are added to the volume's PVC
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	// StorageClass requested by the PVC. Defaults to aws-<type>
+	StorageClass string `yaml:"storage_class,omitempty"`
+	// HostPath is the node path mounted by hostpath volumes, checked to
+	// be of HostPathType before the pod starts
+	HostPath     string `yaml:"host_path,omitempty"`
+	HostPathType string `yaml:"host_path_type,omitempty"`
+	// Sources combined into a single mount by projected volumes
+	Sources []ProjectedSource `yaml:"sources,omitempty"`
 	// volume provisioning types accepted 'dynamic' and 'manual'
 	provisioning string `yaml:"provisioning" validate:"volume_provisioning"`
 }
@@ -186,6 +341,107 @@ type KeyToPath struct {
 	Mode *int32 `yaml:"mode,omitempty"`
 }
 
+// ProjectedSource is a single source of a projected volume. Exactly one of
+// Secret, ConfigMap, DownwardAPI or ServiceAccountToken is set
+type ProjectedSource struct {
+	// Secret and ConfigMap name the object projected, optionally limited
+	// to Items
+	Secret    string      `yaml:"secret,omitempty"`
+	ConfigMap string      `yaml:"configmap,omitempty"`
+	Items     []KeyToPath `yaml:"items,omitempty"`
+	// DownwardAPI projects pod fields, e.g. metadata.labels, into files
+	DownwardAPI         []DownwardAPIFile    `yaml:"downward_api,omitempty"`
+	ServiceAccountToken *ServiceAccountToken `yaml:"service_account_token,omitempty"`
+}
+
+// DownwardAPIFile maps a pod field onto a file of a projected volume
+type DownwardAPIFile struct {
+	Path     string `yaml:"path"`
+	FieldRef string `yaml:"field_ref"`
+}
+
+// ServiceAccountToken projects a token of the pod's service account bound
+// to the audience, e.g. for cloud IAM federation. Kubelet refreshes the
+// token before it expires
+type ServiceAccountToken struct {
+	Path              string `yaml:"path"`
+	Audience          string `yaml:"audience,omitempty"`
+	ExpirationSeconds int64  `yaml:"expiration_seconds,omitempty"`
+}
+
+// Toleration mirrors v1.Toleration, letting pods of a service be scheduled
+// on nodes with matching taints
+type Toleration struct {
+	Key               string `yaml:"key,omitempty"`
+	Operator          string `yaml:"operator,omitempty" validate:"regexp=^(Exists|Equal)*$"`
+	Value             string `yaml:"value,omitempty"`
+	Effect            string `yaml:"effect,omitempty" validate:"regexp=^(NoSchedule|PreferNoSchedule|NoExecute)*$"`
+	TolerationSeconds *int64 `yaml:"toleration_seconds,omitempty"`
+}
+
+// Affinity mirrors v1.Affinity
+type Affinity struct {
+	NodeAffinity    *NodeAffinity    `yaml:"node_affinity,omitempty"`
+	PodAffinity     *PodAffinity     `yaml:"pod_affinity,omitempty"`
+	PodAntiAffinity *PodAntiAffinity `yaml:"pod_anti_affinity,omitempty"`
+}
+
+// NodeAffinity mirrors v1.NodeAffinity. Pods are only scheduled on nodes
+// matching any of the required terms
+type NodeAffinity struct {
+	RequiredDuringSchedulingIgnoredDuringExecution  []NodeSelectorTerm        `yaml:"required_during_scheduling_ignored_during_execution,omitempty"`
+	PreferredDuringSchedulingIgnoredDuringExecution []PreferredSchedulingTerm `yaml:"preferred_during_scheduling_ignored_during_execution,omitempty"`
+}
+
+// NodeSelectorTerm matches nodes by all of its label and field requirements
+type NodeSelectorTerm struct {
+	MatchExpressions []SelectorRequirement `yaml:"match_expressions,omitempty"`
+	MatchFields      []SelectorRequirement `yaml:"match_fields,omitempty"`
+}
+
+// PreferredSchedulingTerm mirrors v1.PreferredSchedulingTerm
+type PreferredSchedulingTerm struct {
+	Weight     int32            `yaml:"weight" validate:"min=1,max=100"`
+	Preference NodeSelectorTerm `yaml:"preference"`
+}
+
+// SelectorRequirement is a requirement of a node or label selector, e.g.
+// key topology.kubernetes.io/zone, operator In and values [eu-west-1a]
+type SelectorRequirement struct {
+	Key      string   `yaml:"key" validate:"nonzero"`
+	Operator string   `yaml:"operator" validate:"regexp=^(In|NotIn|Exists|DoesNotExist|Gt|Lt)$"`
+	Values   []string `yaml:"values,omitempty"`
+}
+
+// PodAffinity mirrors v1.PodAffinity
+type PodAffinity struct {
+	RequiredDuringSchedulingIgnoredDuringExecution  []PodAffinityTerm         `yaml:"required_during_scheduling_ignored_during_execution,omitempty"`
+	PreferredDuringSchedulingIgnoredDuringExecution []WeightedPodAffinityTerm `yaml:"preferred_during_scheduling_ignored_during_execution,omitempty"`
+}
+
+// PodAntiAffinity mirrors v1.PodAntiAffinity
+type PodAntiAffinity PodAffinity
+
+// PodAffinityTerm selects pods by LabelSelector in Namespaces, the service
+// namespace when empty, running in the same TopologyKey domain
+type PodAffinityTerm struct {
+	LabelSelector *LabelSelector `yaml:"label_selector,omitempty"`
+	Namespaces    []string       `yaml:"namespaces,omitempty"`
+	TopologyKey   string         `yaml:"topology_key" validate:"nonzero"`
+}
+
+// WeightedPodAffinityTerm mirrors v1.WeightedPodAffinityTerm
+type WeightedPodAffinityTerm struct {
+	Weight          int32           `yaml:"weight" validate:"min=1,max=100"`
+	PodAffinityTerm PodAffinityTerm `yaml:"pod_affinity_term"`
+}
+
+// LabelSelector mirrors metav1.LabelSelector
+type LabelSelector struct {
+	MatchLabels      map[string]string     `yaml:"match_labels,omitempty"`
+	MatchExpressions []SelectorRequirement `yaml:"match_expressions,omitempty"`
+}
+
 func init() {
 	addCustomValidators()
 }
@@ -256,3 +512,82 @@ func LoadFromFile(path string) (*EnvironmentsBitesize, error) {
 	}
 	return LoadFromString(string(contents))
 }
+
+const (
+	// SecretFetchName names secret_fetch init container and its volume
+	SecretFetchName = "secret-fetch"
+	// SecretFetchConfigName names volume holding secret_fetch config_map
+	SecretFetchConfigName = "secret-fetch-config"
+	// SecretFetchConfigPath is where secret_fetch config_map is mounted
+	SecretFetchConfigPath = "/vault/config"
+
+	// PatchTargetDeployment patches service Deployment
+	PatchTargetDeployment = "deployment"
+	// PatchTargetService patches kubernetes Service
+	PatchTargetService = "service"
+
+	// PatchTypeStrategic is kubernetes strategic merge patch
+	PatchTypeStrategic = "strategic"
+	// PatchTypeMerge is JSON merge patch (RFC 7386)
+	PatchTypeMerge = "merge"
+	// PatchTypeJSON is JSON patch (RFC 6902)
+	PatchTypeJSON = "json"
+)
+
+// setDefaults fills in vault agent defaults for the fetching container
+func (f *SecretFetch) setDefaults() error {
+	if len(f.Command) == 0 {
+		if f.ConfigMap == "" {
+			return fmt.Errorf("either command or config_map is required")
+		}
+		f.Command = []string{
+			"vault", "agent",
+			"-config=" + SecretFetchConfigPath + "/agent.hcl",
+			"-exit-after-auth",
+		}
+	}
+	if f.Image == "" {
+		f.Image = config.Env.SecretFetchImage
+	}
+	if f.Path == "" {
+		f.Path = "/vault/secrets"
+	}
+	return nil
+}
+
+func (m *ServiceMonitor) setDefaults(ports []int) error {
+	if len(ports) == 0 {
+		return fmt.Errorf("service has no ports")
+	}
+	if m.Port == 0 {
+		m.Port = ports[0]
+	}
+	found := false
+	for _, p := range ports {
+		if p == m.Port {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("port %d is not a port of the service", m.Port)
+	}
+	if m.Path == "" {
+		m.Path = "/metrics"
+	}
+	return nil
+}
+
+// JSON returns patch contents converted to JSON. JSON patches are checked to
+// be a valid list of operations
+func (p Patch) JSON() ([]byte, error) {
+	data, err := k8Yaml.ToJSON([]byte(p.Patch))
+	if err != nil {
+		return nil, err
+	}
+	if p.Type == PatchTypeJSON {
+		if _, err := jsonpatch.DecodePatch(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,71 @@
+package bitesize
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HasDependencies returns true if service depends on other services in
+// the environment
+func (e Service) HasDependencies() bool {
+	return len(e.DependsOn) != 0
+}
+
+// SortByDependencies returns services ordered so that every service comes
+// after the services it depends on. Services without dependencies between
+// them keep their name order. Returns an error if depends_on refers to an
+// unknown service or dependencies form a cycle.
+func (slice Services) SortByDependencies() (Services, error) {
+	byName := map[string]Service{}
+	var names []string
+
+	for _, s := range slice {
+		byName[s.Name] = s
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, dep := range byName[name].DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("service %s depends on unknown service %s", name, dep)
+			}
+		}
+	}
+
+	var retval Services
+	visited := map[string]bool{}
+	visiting := map[string]bool{}
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		if visited[name] {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("dependency cycle detected: %s", strings.Join(append(path, name), " -> "))
+		}
+		visiting[name] = true
+
+		deps := append([]string{}, byName[name].DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+
+		visiting[name] = false
+		visited[name] = true
+		retval = append(retval, byName[name])
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return retval, nil
+}
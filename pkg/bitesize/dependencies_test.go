@@ -0,0 +1,68 @@
+package bitesize
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortByDependencies(t *testing.T) {
+	t.Run("dependencies applied first", testSortByDependenciesOrder)
+	t.Run("unknown dependency", testSortByDependenciesUnknown)
+	t.Run("dependency cycle", testSortByDependenciesCycle)
+}
+
+func testSortByDependenciesOrder(t *testing.T) {
+	services := Services{
+		{Name: "app", DependsOn: []string{"migrate"}},
+		{Name: "db"},
+		{Name: "migrate", DependsOn: []string{"db"}},
+		{Name: "other"},
+	}
+
+	sorted, err := services.SortByDependencies()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	var names []string
+	for _, s := range sorted {
+		names = append(names, s.Name)
+	}
+
+	expected := "db,migrate,app,other"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Unexpected order. Expected: %s, got: %s", expected, strings.Join(names, ","))
+	}
+}
+
+func testSortByDependenciesUnknown(t *testing.T) {
+	services := Services{
+		{Name: "app", DependsOn: []string{"missing"}},
+	}
+
+	if _, err := services.SortByDependencies(); err == nil {
+		t.Error("Expected error for unknown dependency, got nil")
+	}
+}
+
+func testSortByDependenciesCycle(t *testing.T) {
+	str := `
+project: test
+environments:
+  - name: dev
+    namespace: dev
+    services:
+      - name: a
+        depends_on: [ b ]
+      - name: b
+        depends_on: [ a ]
+`
+	_, err := LoadFromString(str)
+	if err == nil {
+		t.Fatal("Expected error for dependency cycle, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "dependency cycle detected: a -> b -> a") {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 
@@ -12,6 +11,7 @@ import (
 	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"github.com/pearsontechnology/environment-operator/pkg/git"
 	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 
 	"gopkg.in/validator.v2"
 )
@@ -28,6 +28,8 @@ type Environment struct {
 	Tests      []Test              `yaml:"tests,omitempty"`
 	Gists      Gists               `yaml:"gists,omitempty"`
 	Repo       GistsRepository     `yaml:"gists_repository,omitempty"`
+	// Revision of the config source the environment was loaded from
+	Revision string `yaml:"-"`
 }
 
 var gitClient *git.Git
@@ -47,11 +49,34 @@ func (slice Environments) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
-// LoadEnvironmentFromConfig returns bitesize.Environment object
-// constructed from environment variables
-func LoadEnvironmentFromConfig(c config.Config) (*Environment, error) {
-	fp := filepath.Join(c.GitLocalPath, c.EnvFile)
-	return LoadEnvironment(fp, c.EnvName)
+// LoadEnvironmentFromConfigMap loads named environment from a configmap key
+// holding bitesize file contents
+func LoadEnvironmentFromConfigMap(client *k8s.Client, name, key, envName string) (*Environment, error) {
+	cm, err := client.ConfigMap().Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, ok := cm.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in configmap %s", key, name)
+	}
+
+	return LoadEnvironmentFromString(contents, envName, fmt.Sprintf("configmap %s", name), "")
+}
+
+// LoadEnvironmentFromString loads named environment from bitesize file
+// contents. Source is used to identify where contents came from in errors.
+// Gist files are loaded relative to rootPath; sources without local files
+// pass an empty rootPath, and only environments with their own gists
+// repository can use file gists then
+func LoadEnvironmentFromString(contents, envName, source, rootPath string) (*Environment, error) {
+	e, err := LoadFromString(contents)
+	if err != nil {
+		return nil, err
+	}
+	util.LogTraceAsYaml("LoadFromString", e)
+	return findEnvironment(e, envName, source, rootPath)
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface for BitesizeEnvironment.
@@ -68,45 +93,58 @@ func (e *Environment) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err = validator.Validate(e); err != nil {
 		return fmt.Errorf("environment.%s", err.Error())
 	}
+	if _, err = e.Services.SortByDependencies(); err != nil {
+		return fmt.Errorf("environment.services.depends_on: %s", err.Error())
+	}
+	if err = validEnvironmentDependencies(e.Services); err != nil {
+		return fmt.Errorf("environment.services.depends_on: %s", err.Error())
+	}
+	if err = validUniqueExternalURLs(e.Services); err != nil {
+		return fmt.Errorf("environment.services.%s", err.Error())
+	}
 	sort.Sort(e.Services)
 	return nil
 }
 
 // LoadEnvironment loads named environment from a filename with a given path
+// in the git repository checked out into GIT_LOCAL_PATH
 func LoadEnvironment(pathToBitesizeFile, envName string) (*Environment, error) {
 	e, err := LoadFromFile(pathToBitesizeFile)
 	if err != nil {
 		return nil, err
 	}
 	util.LogTraceAsYaml("LoadFromFile", e)
+	return findEnvironment(e, envName, pathToBitesizeFile, config.Env.GitLocalPath)
+}
+
+// findEnvironment returns named environment out of bitesize file contents
+// loaded from source, with its gists and services loaded. Gist files are
+// loaded relative to rootPath, the local copy of the source, or to the
+// environment's own gists repository
+func findEnvironment(e *EnvironmentsBitesize, envName, source, rootPath string) (*Environment, error) {
+	var err error
 	for _, env := range e.Environments {
 		if env.Name == envName {
 			// Environment name found check for git configs
-			rootPath := config.Env.GitLocalPath
 			if len(env.Repo.Remote) > 0 {
 				gitClient, err = git.EnvGitClient(env.Repo.Remote,
 					env.Repo.Branch, env.Namespace, env.Name)
 				if err != nil {
 					return nil, err
 				}
+				// credentials must not end up in the error, it is reported
+				// by notifications
 				if err := gitClient.Refresh(); err != nil {
-					log.Tracef("Git Client Refresh failed:")
-					return nil, fmt.Errorf("  RemotePath=%s"+
-						"   LocalPath=%s"+
-						"   Branch=%s"+
-						"   SSHkey=%s"+
-						"   GITUser=%s\n",
-						gitClient.RemotePath,
-						gitClient.LocalPath,
-						gitClient.BranchName,
-						gitClient.SSHKey,
-						gitClient.GitUser,
-					)
+					return nil, fmt.Errorf("refresh of %s branch %s into %s failed: %s",
+						gitClient.RemotePath, gitClient.BranchName, gitClient.LocalPath, err.Error())
 				}
 				rootPath = gitClient.LocalPath
 			}
 			// load imported resources
 			for k, im := range env.Gists {
+				if rootPath == "" && (len(im.Path) > 0 || len(im.Files) > 0) {
+					return nil, fmt.Errorf("unable to load Gist %s: %s has no files, gists can only be loaded from a gists repository", im.Name, source)
+				}
 				err := LoadResource(&im, env.Namespace, rootPath)
 				if err != nil {
 					return nil, fmt.Errorf("unable to load Gist type %s, in paths %s,%v %s", im.Type, im.Path, im.Files, err.Error())
@@ -118,13 +156,38 @@ func LoadEnvironment(pathToBitesizeFile, envName string) (*Environment, error) {
 			return &env, nil
 		}
 	}
-	return nil, fmt.Errorf("environment %s not found in %s", envName, pathToBitesizeFile)
+	return nil, fmt.Errorf("environment %s not found in %s", envName, source)
+}
+
+// ServiceGists returns configmap gists mounted as volumes by the service
+// or its init containers
+func (e *Environment) ServiceGists(service Service) Gists {
+	gists := Gists{}
+	volumes := append([]Volume{}, service.Volumes...)
+	if service.InitContainers != nil {
+		for _, container := range *service.InitContainers {
+			volumes = append(volumes, container.Volumes...)
+		}
+	}
+
+	for _, vol := range volumes {
+		if vol.IsConfigMapVolume() {
+			res := e.Gists.FindByName(vol.Name, TypeConfigMap)
+			if res == nil {
+				log.Warnf("could not find import source for the configmap volume %s", vol.Name)
+				continue
+			}
+			gists = append(gists, *res)
+		}
+	}
+	return gists
 }
 
 func loadServices(env Environment) Services {
 	// load services from an environment
 	// Specifies their defaults and handles overrides of user-supplied config
 	var blueGreenServices Services
+	renameServices(env.Services)
 	for i, svc := range env.Services {
 		// Internally prepend env vars that come from secrets w/o name prefix
 		// All env from secrets in .bitesize files must be specified
@@ -1,12 +1,18 @@
 package bitesize
 
 import (
+	"io/ioutil"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestExistingEnvironment(t *testing.T) {
@@ -43,6 +49,38 @@ func TestNonExistingEnvironmentFile(t *testing.T) {
 	}
 }
 
+func TestLoadEnvironmentFromConfigMap(t *testing.T) {
+	contents, err := ioutil.ReadFile("../../test/assets/environments.bitesize")
+	if err != nil {
+		t.Fatalf("Unexpected error reading bitesize file: %s", err.Error())
+	}
+	client := &k8s.Client{
+		Namespace: "sample",
+		Interface: fake.NewSimpleClientset(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "bitesize", Namespace: "sample"},
+			Data:       map[string]string{"environments.bitesize": string(contents)},
+		}),
+	}
+
+	e, err := LoadEnvironmentFromConfigMap(client, "bitesize", "environments.bitesize", "environment2")
+	if err != nil {
+		t.Fatalf("Unexpected error loading environment: %s", err.Error())
+	}
+	if len(e.Services) != 7 {
+		t.Errorf("Unexpected count of services. Expected 7, got: %d", len(e.Services))
+	}
+
+	_, err = LoadEnvironmentFromConfigMap(client, "bitesize", "other.bitesize", "environment2")
+	if err == nil || err.Error() != "key other.bitesize not found in configmap bitesize" {
+		t.Errorf("Expected missing key error, got %v", err)
+	}
+
+	_, err = LoadEnvironmentFromConfigMap(client, "bitesize", "environments.bitesize", "non-existant")
+	if err == nil || err.Error() != "environment non-existant not found in configmap bitesize" {
+		t.Errorf("Expected missing environment error, got %v", err)
+	}
+}
+
 func TestEnvironmentSortInterface(t *testing.T) {
 	var e = Environments{
 		{Name: "b"},
@@ -165,3 +203,20 @@ func TestSortVolumesByVolName(t *testing.T) {
 	}
 	assert.Equal(t, volumesExpected, volumesSorted, "The volumes should be sorted")
 }
+
+func TestLoadEnvironmentFromStringGistWithoutFiles(t *testing.T) {
+	contents := `
+project: sample
+environments:
+  - name: dev
+    namespace: dev
+    gists:
+      - name: settings
+        path: k8s/settings.yaml
+        type: configmap
+`
+	_, err := LoadEnvironmentFromString(contents, "dev", "configmap bitesize", "")
+	if err == nil || !strings.Contains(err.Error(), "configmap bitesize has no files") {
+		t.Errorf("Expected gist without files error, got %v", err)
+	}
+}
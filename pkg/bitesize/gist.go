@@ -1,6 +1,8 @@
 package bitesize
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"path"
 
@@ -9,6 +11,7 @@ import (
 	v1batch "k8s.io/api/batch/v1"
 	"k8s.io/api/batch/v1beta1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	k8Yaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
@@ -21,6 +24,8 @@ const (
 	TypeCronJob string = "cronjob"
 	// TypeSecret k8s secret type
 	TypeSecret string = "secret"
+	// TypeManifest raw kubernetes manifests applied verbatim
+	TypeManifest string = "manifest"
 )
 
 // Gist represent a resource
@@ -33,6 +38,8 @@ type Gist struct {
 	Job       v1batch.Job     `yaml:"-"`
 	CronJob   v1beta1.CronJob `yaml:"-"`
 	Secret    v1.Secret       `yaml:"-"`
+	// Manifests are objects of any kind loaded from manifest gist
+	Manifests []unstructured.Unstructured `yaml:"-"`
 }
 
 // GistsRepository contains the repository info all the imports per env
@@ -125,6 +132,15 @@ func LoadResource(res *Gist, namespace, localPath string) error {
 				// override metadata namespace to current environment namespace
 				res.ConfigMap.ObjectMeta.SetNamespace(namespace)
 			}
+		case TypeManifest:
+			{
+				log.Debugf("adding manifests %s from path %s", res.Name, res.Path)
+				manifests, err := decodeManifests(decoder, res.Name)
+				if err != nil {
+					return err
+				}
+				res.Manifests = manifests
+			}
 		}
 	}
 
@@ -165,6 +181,38 @@ func LoadResource(res *Gist, namespace, localPath string) error {
 	return nil
 }
 
+// decodeManifests decodes all yaml documents of manifest gist, labelling
+// them as part of the gist. The label keeps them out of services and gists
+// scraped from the cluster, they are pruned through the gist's inventory
+func decodeManifests(decoder *k8Yaml.YAMLOrJSONDecoder, name string) ([]unstructured.Unstructured, error) {
+	var retval []unstructured.Unstructured
+	for {
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+
+		manifest := unstructured.Unstructured{Object: obj}
+		if manifest.GetAPIVersion() == "" || manifest.GetKind() == "" || manifest.GetName() == "" {
+			return nil, fmt.Errorf("manifest %d in %s must have apiVersion, kind and metadata.name", len(retval)+1, name)
+		}
+
+		labels := manifest.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels["manifest"] = name
+		manifest.SetLabels(labels)
+		retval = append(retval, manifest)
+	}
+	return retval, nil
+}
+
 // Find returns service with a name match
 // path is a UNC path relative to the configured repository root
 // rstype is the resource type of the imported resource
@@ -204,6 +252,7 @@ func (slice Gists) FindByName(name string, rstype string) *Gist {
 //      - bitesize.TypeConfigMap
 //      - bitesize.TypeJob
 //      - bitesize.TypeCronJob
+//      - bitesize.TypeManifest
 // if resource found returns the resource else returns nil
 func (slice Gists) FindByType(rstype string) Gists {
 	res := Gists{}
@@ -8,6 +8,7 @@ import (
 
 func TestUnmarshal(t *testing.T) {
 	t.Run("configmap parsed correctly", testUmarshalConfigMap)
+	t.Run("manifests parsed correctly", testUmarshalManifests)
 }
 
 func TestFind(t *testing.T) {
@@ -189,3 +190,29 @@ func testUmarshalConfigMap(t *testing.T) {
 		}, r.ConfigMap.Data)
 	}
 }
+
+func testUmarshalManifests(t *testing.T) {
+	r := &Gist{
+		Name: "monitoring",
+		Path: "../../test/assets/k8s/monitoring.yaml",
+		Type: TypeManifest,
+	}
+	pwd, _ := os.Getwd()
+	if err := LoadResource(r, "dev", pwd); err != nil {
+		t.Fatalf("Errors expected nil, got %v", err)
+	}
+	if len(r.Manifests) != 2 {
+		t.Fatalf("Expected 2 manifests, got %d", len(r.Manifests))
+	}
+	if r.Manifests[0].GetKind() != "ServiceMonitor" || r.Manifests[1].GetName() != "api-monitoring" {
+		t.Errorf("Unexpected manifests: %+v", r.Manifests)
+	}
+
+	expected := map[string]string{
+		"team":     "platform",
+		"manifest": "monitoring",
+	}
+	if !reflect.DeepEqual(r.Manifests[0].GetLabels(), expected) {
+		t.Errorf("Expected labels %v, got %v", expected, r.Manifests[0].GetLabels())
+	}
+}
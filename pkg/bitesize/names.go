@@ -0,0 +1,75 @@
+package bitesize
+
+import (
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+)
+
+// ServiceObjectName returns name kubernetes objects of the named service
+// are created with, with SERVICE_NAME_PREFIX and SERVICE_NAME_SUFFIX.
+// Gists and persistent volume claims are not renamed
+func ServiceObjectName(name string) string {
+	return config.Env.ServiceNamePrefix + name + config.Env.ServiceNameSuffix
+}
+
+// EnvExternalSecretName returns name of the ExternalSecret, and of the
+// secret it syncs, for env vars of the named service with external_key
+// reading secret. Services sharing a secret name each get their own
+func EnvExternalSecretName(service, secret string) string {
+	return service + "-env-" + secret
+}
+
+// IsServiceObjectName returns whether objects named name belong to services
+// of this operator. With SERVICE_NAME_PREFIX or SERVICE_NAME_SUFFIX set,
+// name must be the object name of one of configured services, as affixes
+// alone can't tell them apart from those of an operator with a longer
+// prefix, e.g. tenant-a- next to tenant-. Without prefix and suffix all
+// names match, including those of operators with a prefix or suffix in
+// the namespace
+func IsServiceObjectName(name string, configured Services) bool {
+	if config.Env.ServiceNamePrefix == "" && config.Env.ServiceNameSuffix == "" {
+		return true
+	}
+	for _, svc := range configured {
+		if svc.Name == name {
+			return true
+		}
+		for _, kind := range []BlueGreenServiceSet{BlueService, GreenService} {
+			if svc.Name+"-"+kind.String() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renameServices sets names of services to their object names. References
+// to other services of the environment (depends_on, backend and weighted
+// backends) are renamed with them
+func renameServices(services Services) {
+	if config.Env.ServiceNamePrefix == "" && config.Env.ServiceNameSuffix == "" {
+		return
+	}
+
+	names := map[string]bool{}
+	for _, svc := range services {
+		names[svc.Name] = true
+	}
+	rename := func(name string) string {
+		if names[name] {
+			return ServiceObjectName(name)
+		}
+		return name
+	}
+
+	for i := range services {
+		svc := &services[i]
+		svc.Name = ServiceObjectName(svc.Name)
+		for j, dep := range svc.DependsOn {
+			svc.DependsOn[j] = rename(dep)
+		}
+		svc.Backend = rename(svc.Backend)
+		for j, b := range svc.WeightedBackends {
+			svc.WeightedBackends[j].Service = rename(b.Service)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package bitesize
+
+import (
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestIsServiceObjectName(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.ServiceNamePrefix = "tenant-a-"
+	config.Env.ServiceNameSuffix = "-v2"
+
+	configured := Services{{Name: "tenant-a-api-v2"}}
+	var tests = []struct {
+		Name  string
+		Owned bool
+	}{
+		{"tenant-a-api-v2", true},
+		{"tenant-a-api-v2-blue", true},
+		{"tenant-a-api-v2-green", true},
+		{"tenant-b-api-v2", false},
+		{"tenant-a-api", false},
+		{"tenant-a-api-blue", false},
+		{"tenant-a-b-api-v2", false},
+		{"api", false},
+	}
+	for _, tst := range tests {
+		if owned := IsServiceObjectName(tst.Name, configured); owned != tst.Owned {
+			t.Errorf("Expected %s owned %t, got %t", tst.Name, tst.Owned, owned)
+		}
+	}
+
+	config.Env.ServiceNamePrefix = ""
+	config.Env.ServiceNameSuffix = ""
+	for _, name := range []string{"api", "tenant-a-api-v2"} {
+		if !IsServiceObjectName(name, configured) {
+			t.Errorf("Expected %s to be owned without prefix and suffix", name)
+		}
+	}
+}
+
+func TestLoadServicesRenamed(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.ServiceNamePrefix = "tenant-a-"
+
+	env := Environment{}
+	input := `
+name: dev
+services:
+  - name: api
+    backend: proxy
+    depends_on:
+      - db
+  - name: db
+  - name: proxy
+    backend: external-proxy
+  - name: front
+    deployment:
+      method: bluegreen
+      active: blue
+`
+	if err := yaml.Unmarshal([]byte(input), &env); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	services := loadServices(env)
+
+	api := services.FindByName("tenant-a-api")
+	if api == nil {
+		t.Fatalf("Expected service api to be renamed, got %v", services)
+	}
+	if api.Backend != "tenant-a-proxy" || len(api.DependsOn) != 1 || api.DependsOn[0] != "tenant-a-db" {
+		t.Errorf("Expected references to services of the environment to be renamed, got backend %s, depends_on %v", api.Backend, api.DependsOn)
+	}
+	if proxy := services.FindByName("tenant-a-proxy"); proxy == nil || proxy.Backend != "external-proxy" {
+		t.Errorf("Expected backend outside the environment to be kept, got %+v", proxy)
+	}
+	for _, name := range []string{"tenant-a-front", "tenant-a-front-blue", "tenant-a-front-green"} {
+		if services.FindByName(name) == nil {
+			t.Errorf("Expected blue/green service %s, got %v", name, services)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package bitesize
 
 import (
 	"fmt"
+	"net"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
@@ -10,58 +12,150 @@ import (
 	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 	validator "gopkg.in/validator.v2"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// TypeExternalName is a service type mapping service name onto an external
+// host through kubernetes ExternalName service
+const TypeExternalName = "externalname"
+
+// Reconcile modes of a service. Onchange services, the default, are applied
+// whenever the operator reconciles, poll services only on the periodic
+// poll, and manual services only through the /sync endpoint
+const (
+	ReconcileOnChange = "onchange"
+	ReconcilePoll     = "poll"
+	ReconcileManual   = "manual"
+)
+
+// Triggers of a reconcile: the periodic poll, or a change signalled by a
+// watched config source
+const (
+	TriggerPoll   = "poll"
+	TriggerChange = "change"
+)
+
+// TypeHostPath is a volume type mounting a path of the node
+const TypeHostPath = "hostpath"
+
+// TypeProjected is a volume type combining several sources into one mount
+const TypeProjected = "projected"
+
+// defaultTokenExpiration is kubernetes' default expiration of projected
+// service account tokens, and minTokenExpiration the shortest allowed
+const (
+	defaultTokenExpiration = 3600
+	minTokenExpiration     = 600
+)
+
+// defaultVolumeMode is kubernetes' default mode of files mounted by secret
+// and configmap volumes
+const defaultVolumeMode = 0644
+
+// validHostPathTypes are checks kubelet can make on host_path, empty for
+// no check
+var validHostPathTypes = map[string]bool{
+	"":                  true,
+	"DirectoryOrCreate": true,
+	"Directory":         true,
+	"FileOrCreate":      true,
+	"File":              true,
+	"Socket":            true,
+	"CharDevice":        true,
+	"BlockDevice":       true,
+}
+
 // Service represents a single service and it's configuration,
 // running in environment
 type Service struct {
-	Name              string                        `yaml:"name" validate:"nonzero"`
-	ExternalURL       []string                      `yaml:"-"`
-	ServiceMesh       string                        `yaml:"service_mesh,omitempty" validate:"regexp=^(enable|disable)*$"`
-	Backend           string                        `yaml:"backend"`
-	BackendPort       int                           `yaml:"backend_port"`
-	Ports             []int                         `yaml:"-"` // Ports have custom unmarshaler
-	Ssl               string                        `yaml:"ssl" validate:"regexp=^(true|false)*$"`
-	Version           string                        `yaml:"version,omitempty"`
-	Application       string                        `yaml:"application,omitempty"`
-	Replicas          int                           `yaml:"replicas,omitempty"`
-	Deployment        *DeploymentSettings           `yaml:"deployment,omitempty"`
-	HPA               HorizontalPodAutoscaler       `yaml:"hpa" validate:"hpa"`
-	Requests          ContainerRequests             `yaml:"requests" validate:"requests"`
-	Limits            ContainerLimits               `yaml:"limits" validate:"limits"`
-	HealthCheck       *HealthCheck                  `yaml:"health_check,omitempty"`
-	LivenessProbe     *Probe                        `yaml:"liveness_probe,omitempty"`
-	ReadinessProbe    *Probe                        `yaml:"readiness_probe,omitempty"`
-	EnvVars           []EnvVar                      `yaml:"env,omitempty"`
-	Commands          []string                      `yaml:"command,omitempty"`
-	InitContainers    *[]Container                  `yaml:"init_containers,omitempty"`
-	Annotations       map[string]string             `yaml:"-"` // Annotations have custom unmarshaler
-	Volumes           []Volume                      `yaml:"volumes,omitempty"`
-	Options           map[string]interface{}        `yaml:"-"` // Options have custom unmarshaler
-	HTTP2             string                        `yaml:"http2,omitempty" validate:"regexp=^(true|false)*$"`
-	HTTPSOnly         string                        `yaml:"httpsOnly" validate:"regexp=^(true|false)*$"`
-	HTTPSBackend      string                        `yaml:"httpsBackend,omitempty" validate:"regexp=^(true|false)*$"`
-	Type              string                        `yaml:"type,omitempty"`
-	Status            ServiceStatus                 `yaml:"status"`
-	DatabaseType      string                        `yaml:"database_type,omitempty" validate:"regexp=^(mongo)*$"`
-	GracePeriod       *int64                        `yaml:"graceperiod,omitempty"`
-	ResourceVersion   string                        `yaml:"resourceVersion,omitempty"`
-	TargetNamespace   string                        `yaml:"target_namespace,omitempty"`
-	Chart             string                        `yaml:"chart,omitempty"`
-	Repo              string                        `yaml:"repo,omitempty"`
-	Set               map[string]intstr.IntOrString `yaml:"set,omitempty"`
-	ValuesContent     string                        `yaml:"values_content,omitempty"`
-	Ignore            bool                          `yaml:"ignore,omitempty"`
-	Hosts             []string                      `yaml:"hosts,omitempty"`
-	Addresses         []string                      `yaml:"addresses,omitempty"`
-	ServiceEntryPorts []Port                        `yaml:"service_entry_ports,omitempty"`
-	Location          string                        `yaml:"location,omitempty"`
-	Resolution        string                        `yaml:"resolution,omitempty"`
-	Endpoints         []ServiceEntry_Endpoint       `yaml:"endpoints,omitempty"`
-	ExportTo          []string                      `yaml:"export_to,omitempty"`
-	Protocol          string                        `yaml:"protocol,omitempty"`
+	Name                     string                        `yaml:"name" validate:"nonzero"`
+	ExternalURL              []string                      `yaml:"-"`
+	ServiceMesh              string                        `yaml:"service_mesh,omitempty" validate:"regexp=^(enable|disable)*$"`
+	Backend                  string                        `yaml:"backend"`
+	BackendPort              int                           `yaml:"backend_port"`
+	Ports                    []int                         `yaml:"-"` // Ports have custom unmarshaler
+	TargetPorts              map[int]int                   `yaml:"-"` // Container ports of service ports that differ, e.g. 80:8080
+	Ssl                      string                        `yaml:"ssl" validate:"regexp=^(true|false)*$"`
+	Version                  string                        `yaml:"version,omitempty"`
+	Application              string                        `yaml:"application,omitempty"`
+	Replicas                 int                           `yaml:"replicas,omitempty"`
+	Deployment               *DeploymentSettings           `yaml:"deployment,omitempty"`
+	HPA                      HorizontalPodAutoscaler       `yaml:"hpa" validate:"hpa"`
+	Requests                 ContainerRequests             `yaml:"requests" validate:"requests"`
+	Limits                   ContainerLimits               `yaml:"limits" validate:"limits"`
+	HealthCheck              *HealthCheck                  `yaml:"health_check,omitempty"`
+	LivenessProbe            *Probe                        `yaml:"liveness_probe,omitempty"`
+	ReadinessProbe           *Probe                        `yaml:"readiness_probe,omitempty"`
+	EnvVars                  []EnvVar                      `yaml:"env,omitempty"`
+	EnvFrom                  []EnvFromSource               `yaml:"env_from,omitempty"`
+	Commands                 []string                      `yaml:"command,omitempty"`
+	InitContainers           *[]Container                  `yaml:"init_containers,omitempty"`
+	Annotations              map[string]string             `yaml:"-" validate:"mesh_annotations"` // Annotations have custom unmarshaler
+	ServiceAnnotations       map[string]string             `yaml:"-" validate:"mesh_annotations"` // ServiceAnnotations have custom unmarshaler
+	DeploymentAnnotations    map[string]string             `yaml:"-"`                             // DeploymentAnnotations have custom unmarshaler
+	IngressAnnotations       map[string]string             `yaml:"-"`                             // IngressAnnotations have custom unmarshaler
+	Volumes                  []Volume                      `yaml:"volumes,omitempty"`
+	Options                  map[string]interface{}        `yaml:"-"` // Options have custom unmarshaler
+	HTTP2                    string                        `yaml:"http2,omitempty" validate:"regexp=^(true|false)*$"`
+	HTTPSOnly                string                        `yaml:"httpsOnly" validate:"regexp=^(true|false)*$"`
+	HTTPSBackend             string                        `yaml:"httpsBackend,omitempty" validate:"regexp=^(true|false)*$"`
+	Type                     string                        `yaml:"type,omitempty"`
+	Status                   ServiceStatus                 `yaml:"status"`
+	Revision                 string                        `yaml:"-"` // Revision of the config the service is applied from
+	DatabaseType             string                        `yaml:"database_type,omitempty" validate:"regexp=^(mongo)*$"`
+	GracePeriod              *int64                        `yaml:"graceperiod,omitempty"`
+	ResourceVersion          string                        `yaml:"resourceVersion,omitempty"`
+	TargetNamespace          string                        `yaml:"target_namespace,omitempty"`
+	Chart                    string                        `yaml:"chart,omitempty"`
+	Repo                     string                        `yaml:"repo,omitempty"`
+	Set                      map[string]intstr.IntOrString `yaml:"set,omitempty"`
+	ValuesContent            string                        `yaml:"values_content,omitempty"`
+	Ignore                   bool                          `yaml:"ignore,omitempty"`
+	Hosts                    []string                      `yaml:"hosts,omitempty"`
+	Addresses                []string                      `yaml:"addresses,omitempty"`
+	ServiceEntryPorts        []Port                        `yaml:"service_entry_ports,omitempty"`
+	Location                 string                        `yaml:"location,omitempty"`
+	Resolution               string                        `yaml:"resolution,omitempty"`
+	Endpoints                []ServiceEntry_Endpoint       `yaml:"endpoints,omitempty"`
+	ExportTo                 []string                      `yaml:"export_to,omitempty"`
+	Protocol                 string                        `yaml:"protocol,omitempty"`
+	DependsOn                []string                      `yaml:"depends_on,omitempty"`
+	Environments             []string                      `yaml:"environments,omitempty"`
+	SchedulerName            string                        `yaml:"scheduler_name,omitempty"`
+	RuntimeClass             string                        `yaml:"runtime_class,omitempty"`
+	AntiAffinity             string                        `yaml:"anti_affinity,omitempty" validate:"regexp=^(name|application)*$"`
+	Affinity                 *Affinity                     `yaml:"affinity,omitempty"`
+	Tolerations              []Toleration                  `yaml:"tolerations,omitempty"`
+	NodeName                 string                        `yaml:"node_name,omitempty"`
+	NodeSelector             map[string]string             `yaml:"node_selector,omitempty"`
+	ImagePullSecrets         string                        `yaml:"image_pull_secrets,omitempty" validate:"regexp=^(none)*$"`
+	ImagePullPolicy          string                        `yaml:"image_pull_policy,omitempty" validate:"regexp=^(Always|IfNotPresent|Never)*$"`
+	HostNetwork              bool                          `yaml:"host_network,omitempty"`
+	HostPID                  bool                          `yaml:"host_pid,omitempty"`
+	HostIPC                  bool                          `yaml:"host_ipc,omitempty"`
+	Reconcile                string                        `yaml:"reconcile,omitempty" validate:"regexp=^(onchange|poll|manual)*$"`
+	Headless                 bool                          `yaml:"headless,omitempty"`
+	ClusterIP                string                        `yaml:"cluster_ip,omitempty"`
+	ServiceType              string                        `yaml:"service_type,omitempty" validate:"regexp=^(ClusterIP|NodePort|LoadBalancer)*$"`
+	LoadBalancerSourceRanges []string                      `yaml:"load_balancer_source_ranges,omitempty"`
+	ExternalName             string                        `yaml:"external_name,omitempty"`
+	PublishNotReadyAddresses bool                          `yaml:"publish_not_ready_addresses,omitempty"`
+	IngressWaitReady         bool                          `yaml:"ingress_wait_ready,omitempty"`
+	ReadyThreshold           string                        `yaml:"ready_threshold,omitempty" validate:"regexp=^([1-9][0-9]*%?)*$"`
+	Atomic                   bool                          `yaml:"atomic,omitempty"`
+	RecreateOnConflict       bool                          `yaml:"recreate_on_conflict,omitempty"`
+	WeightedBackends         []WeightedBackend             `yaml:"weighted_backends,omitempty"`
+	TopologyAwareRouting     bool                          `yaml:"topology_aware_routing,omitempty"`
+	MinReadySeconds          int32                         `yaml:"min_ready_seconds,omitempty" validate:"min=0"`
+	RevisionHistoryLimit     *int32                        `yaml:"revision_history_limit,omitempty"`
+	CertManager              *CertManager                  `yaml:"cert_manager,omitempty"`
+	BackendHealthCheck       *BackendHealthCheck           `yaml:"backend_health_check,omitempty"`
+	ReadinessGates           []string                      `yaml:"readiness_gates,omitempty"`
+	SecretFetch              *SecretFetch                  `yaml:"secret_fetch,omitempty"`
+	ServiceMonitor           *ServiceMonitor               `yaml:"service_monitor,omitempty"`
+	Patches                  []Patch                       `yaml:"patches,omitempty"`
 }
 
 // ServiceStatus represents cluster service's status metrics
@@ -70,6 +164,10 @@ type ServiceStatus struct {
 	AvailableReplicas int
 	DesiredReplicas   int
 	CurrentReplicas   int
+	ImageDigests      []string
+	// EnvExternalSecrets are names of ExternalSecrets of env vars with
+	// external_key found in the cluster
+	EnvExternalSecrets []string
 }
 
 // ServiceEntry_Endpoint represents one or more endpoints associated with the service.
@@ -112,20 +210,56 @@ func ServiceWithDefaults() *Service {
 	}
 }
 
+// RevisionHistory returns the number of old ReplicaSets kept for rollback
+// of the service's deployment, REVISION_HISTORY_LIMIT unless overridden
+func (e *Service) RevisionHistory() int32 {
+	if e.RevisionHistoryLimit != nil {
+		return *e.RevisionHistoryLimit
+	}
+	return int32(config.Env.RevisionHistoryLimit)
+}
+
+// TargetPort returns container port traffic to service port is sent to
+func (e *Service) TargetPort(port int) int {
+	if t, ok := e.TargetPorts[port]; ok {
+		return t
+	}
+	return port
+}
+
 // UnmarshalYAML converts Service yaml to *bitesize.Service
 func (e *Service) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var err error
 	ee := ServiceWithDefaults()
 
-	ports, err := unmarshalPorts(unmarshal)
+	ports, targetPorts, err := unmarshalPorts(unmarshal)
 	if err != nil {
 		return fmt.Errorf("service.ports.%s", err.Error())
 	}
 
-	annotations, err := unmarshalAnnotations(unmarshal)
+	annotations, err := unmarshalAnnotations(unmarshal, "annotations")
 	if err != nil {
 		return fmt.Errorf("service.annotations.%s", err.Error())
 	}
+	// existing deployment annotations are merged into it by diff
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	serviceAnnotations, err := unmarshalAnnotations(unmarshal, "service_annotations")
+	if err != nil {
+		return fmt.Errorf("service.service_annotations.%s", err.Error())
+	}
+
+	deploymentAnnotations, err := unmarshalAnnotations(unmarshal, "deployment_annotations")
+	if err != nil {
+		return fmt.Errorf("service.deployment_annotations.%s", err.Error())
+	}
+
+	ingressAnnotations, err := unmarshalAnnotations(unmarshal, "ingress_annotations")
+	if err != nil {
+		return fmt.Errorf("service.ingress_annotations.%s", err.Error())
+	}
 
 	externalURL, err := unmarshalExternalURL(unmarshal)
 	if err != nil {
@@ -144,11 +278,16 @@ func (e *Service) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 	*e = *ee
 	e.Ports = ports
+	e.TargetPorts = targetPorts
 	e.Annotations = annotations
+	e.ServiceAnnotations = serviceAnnotations
+	e.DeploymentAnnotations = deploymentAnnotations
+	e.IngressAnnotations = ingressAnnotations
 	e.ExternalURL = externalURL
 	e.Options = unmarshalOptions
 	if e.Type != "" {
 		e.Ports = nil
+		e.TargetPorts = nil
 	}
 	// annotation := Annotation{Name: "Name", Value: e.Name}
 	// e.Annotations = append(e.Annotations, annotation)
@@ -165,14 +304,175 @@ func (e *Service) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return fmt.Errorf("service.%s", err.Error())
 	}
 
+	if err = validEnvReferences(*e); err != nil {
+		return fmt.Errorf("service.%s", err.Error())
+	}
+
+	if err = validEnvNames(*e); err != nil {
+		return fmt.Errorf("service.%s", err.Error())
+	}
+
+	for i, src := range e.EnvFrom {
+		if (src.ConfigMap == "") == (src.Secret == "") {
+			return fmt.Errorf("service.env_from[%d]: exactly one of configmap or secret is required for service %s", i, e.Name)
+		}
+	}
+
+	if err = validExternalEnvs(*e); err != nil {
+		return fmt.Errorf("service.%s", err.Error())
+	}
+	sortEnvVars(e.EnvVars)
+
+	if e.ClusterIP != "" && net.ParseIP(e.ClusterIP) == nil {
+		return fmt.Errorf("service.cluster_ip: %s is not a valid IP address", e.ClusterIP)
+	}
+
+	if e.Type == "" && len(e.ExternalURL) != 0 && len(e.Ports) == 0 && e.BackendPort == 0 {
+		return fmt.Errorf("service.external_url: service %s has external_url but no ports or backend_port", e.Name)
+	}
+
+	if l := e.RevisionHistoryLimit; l != nil {
+		if *l < 0 {
+			return fmt.Errorf("service.revision_history_limit: %d of service %s must not be negative", *l, e.Name)
+		}
+		// a limit of the operator default is not told apart from an unset
+		// one in the cluster
+		if int(*l) == config.Env.RevisionHistoryLimit {
+			e.RevisionHistoryLimit = nil
+		}
+	}
+
+	if host := e.hostNamespaces(); len(host) != 0 {
+		if e.Type != "" {
+			return fmt.Errorf("service.%s: not supported for service %s of type %s", host[0], e.Name, e.Type)
+		}
+		// pods sharing node's namespaces can see and affect everything
+		// else running on the node
+		warnOnce("service %s: %s give pods privileged access to the node", e.Name, strings.Join(host, ", "))
+	}
+
+	for _, probe := range []*Probe{e.LivenessProbe, e.ReadinessProbe} {
+		if probe != nil && probe.GRPC != nil {
+			warnOnce("service %s: gRPC probes are not supported, checking port %d with a TCP probe instead", e.Name, probe.GRPC.Port)
+		}
+	}
+
+	if e.CertManager != nil {
+		if len(e.ExternalURL) == 0 {
+			return fmt.Errorf("service.cert_manager: service %s has cert_manager but no external_url", e.Name)
+		}
+		if e.Ssl == "true" {
+			return fmt.Errorf("service.cert_manager: can not be combined with ssl for service %s", e.Name)
+		}
+		if e.CertManager.IssuerKind == "" {
+			e.CertManager.IssuerKind = "ClusterIssuer"
+		}
+	}
+
+	if e.BackendHealthCheck != nil && len(e.ExternalURL) == 0 {
+		return fmt.Errorf("service.backend_health_check: service %s has backend_health_check but no external_url", e.Name)
+	}
+
+	if e.SecretFetch != nil {
+		if err = e.SecretFetch.setDefaults(); err != nil {
+			return fmt.Errorf("service.secret_fetch: %s for service %s", err.Error(), e.Name)
+		}
+	}
+
+	if e.ServiceMonitor != nil {
+		if e.Type != "" {
+			return fmt.Errorf("service.service_monitor: not supported for service %s of type %s", e.Name, e.Type)
+		}
+		if err = e.ServiceMonitor.setDefaults(e.Ports); err != nil {
+			return fmt.Errorf("service.service_monitor: %s for service %s", err.Error(), e.Name)
+		}
+	}
+
+	if err = e.LivenessProbe.setDefaults(e.Ports, e.TargetPort); err != nil {
+		return fmt.Errorf("service.liveness_probe: %s for service %s", err.Error(), e.Name)
+	}
+	if err = e.ReadinessProbe.setDefaults(e.Ports, e.TargetPort); err != nil {
+		return fmt.Errorf("service.readiness_probe: %s for service %s", err.Error(), e.Name)
+	}
+
+	if len(e.Patches) != 0 && e.Type != "" && !e.IsExternalName() {
+		return fmt.Errorf("service.patches: not supported for service %s of type %s", e.Name, e.Type)
+	}
+	for i := range e.Patches {
+		if e.Patches[i].Type == "" {
+			e.Patches[i].Type = PatchTypeStrategic
+		}
+		if e.Patches[i].Target == PatchTargetDeployment && e.IsExternalName() {
+			return fmt.Errorf("service.patches[%d]: service %s has no deployment", i, e.Name)
+		}
+		if _, err = e.Patches[i].JSON(); err != nil {
+			return fmt.Errorf("service.patches[%d]: invalid %s patch for service %s: %s", i, e.Patches[i].Type, e.Name, err.Error())
+		}
+	}
+
+	if e.Headless && e.ClusterIP != "" {
+		return fmt.Errorf("service.cluster_ip: can not be set for headless service %s", e.Name)
+	}
+
+	if err = validServiceType(*e); err != nil {
+		return fmt.Errorf("service.%s", err.Error())
+	}
+	if err = validScheduling(*e); err != nil {
+		return fmt.Errorf("service.%s", err.Error())
+	}
+	// ClusterIP is the kubernetes default, which is not told apart from an
+	// unset type when loaded from the cluster
+	if e.ServiceType == string(v1.ServiceTypeClusterIP) {
+		e.ServiceType = ""
+	}
+
+	if len(e.WeightedBackends) != 0 {
+		if err = normalizeWeightedBackends(e.WeightedBackends); err != nil {
+			return fmt.Errorf("service.weighted_backends: %s", err.Error())
+		}
+	}
+
+	if e.IsExternalName() {
+		e.Type = TypeExternalName
+		if e.ExternalName == "" {
+			return fmt.Errorf("service.external_name: required for service %s of type %s", e.Name, TypeExternalName)
+		}
+	}
+
 	return nil
 }
 
+// DeployedIn returns true if service is deployed in namespaces labelled
+// with the given environment. Services without environments are deployed
+// everywhere
+func (e Service) DeployedIn(environment string) bool {
+	if len(e.Environments) == 0 {
+		return true
+	}
+	for _, env := range e.Environments {
+		if env == environment {
+			return true
+		}
+	}
+	return false
+}
+
 // HasExternalURL checks if the service has an external_url defined
 func (e Service) HasExternalURL() bool {
 	return len(e.ExternalURL) != 0
 }
 
+// IsExternalName checks if the service is an alias to an external host
+func (e Service) IsExternalName() bool {
+	return strings.EqualFold(e.Type, TypeExternalName)
+}
+
+// CanaryIngressName returns name of the ingress routing the weighted share of
+// external_url traffic to the second of weighted_backends
+func (e Service) CanaryIngressName() string {
+	return fmt.Sprintf("%s-canary", e.Name)
+}
+
 // IsServiceMeshEnabled checks if the service_mesh is enabled
 func (e Service) IsServiceMeshEnabled() bool {
 	return e.ServiceMesh == "enable"
@@ -254,6 +554,23 @@ func (e Service) IsTLSEnabled() bool {
 	return e.Ssl == "true" && e.HasExternalURL()
 }
 
+// CertManagerHosts returns hosts cert-manager certificate is requested for
+func (e Service) CertManagerHosts() []string {
+	if e.CertManager == nil {
+		return nil
+	}
+	if len(e.CertManager.Hosts) != 0 {
+		return e.CertManager.Hosts
+	}
+	return e.ExternalURL
+}
+
+// CertManagerSecretName returns name of the tls secret cert-manager
+// populates with the issued certificate
+func (e Service) CertManagerSecretName() string {
+	return e.Name + "-tls"
+}
+
 func (e Service) ExternalSecretExist(namespace, name string) bool {
 
 	var err error
@@ -299,21 +616,80 @@ func (slice Services) FindByName(name string) *Service {
 	return nil
 }
 
-func unmarshalAnnotations(unmarshal func(interface{}) error) (map[string]string, error) {
-	// annotations representation in environments.bitesize
-	var bz struct {
-		Annotations []struct {
-			Name  string
-			Value string
-		} `yaml:"annotations,omitempty"`
+// ReconciledOn returns true if the service is applied automatically on
+// reconciles started by trigger
+func (e *Service) ReconciledOn(trigger string) bool {
+	switch e.Reconcile {
+	case ReconcileManual:
+		return false
+	case ReconcilePoll:
+		return trigger == TriggerPoll
+	default:
+		return true
 	}
-	annotations := map[string]string{}
+}
 
-	if err := unmarshal(&bz); err != nil {
-		return annotations, err
+// ForEnvironment returns services deployed in the given environment
+func (slice Services) ForEnvironment(environment string) Services {
+	var retval Services
+	for _, s := range slice {
+		if s.DeployedIn(environment) {
+			retval = append(retval, s)
+		}
+	}
+	return retval
+}
+
+// normalizeWeightedBackends converts relative backend weights to percentages
+// of traffic. Ingress controller splits traffic between two backends only.
+func normalizeWeightedBackends(backends []WeightedBackend) error {
+	if len(backends) != 2 {
+		return fmt.Errorf("exactly 2 backends are supported, got %d", len(backends))
+	}
+
+	total := 0
+	for _, b := range backends {
+		if b.Weight < 0 {
+			return fmt.Errorf("weight of backend %s can not be negative", b.Service)
+		}
+		total += b.Weight
+	}
+
+	if total == 0 {
+		return fmt.Errorf("at least one backend must have a weight")
+	}
+
+	backends[1].Weight = (backends[1].Weight*100 + total/2) / total
+	backends[0].Weight = 100 - backends[1].Weight
+	return nil
+}
+
+// annotationList is the representation of annotations in
+// environments.bitesize
+type annotationList []struct {
+	Name  string
+	Value string
+}
+
+// unmarshalAnnotations returns annotations listed under key, or nil if
+// there are none. Only key is decoded, so that errors are reported for it
+func unmarshalAnnotations(unmarshal func(interface{}) error, key string) (map[string]string, error) {
+	bz := reflect.New(reflect.StructOf([]reflect.StructField{{
+		Name: "Annotations",
+		Type: reflect.TypeOf(annotationList{}),
+		Tag:  reflect.StructTag(fmt.Sprintf(`yaml:"%s,omitempty"`, key)),
+	}}))
+	if err := unmarshal(bz.Interface()); err != nil {
+		return nil, err
 	}
 
-	for _, ann := range bz.Annotations {
+	list := bz.Elem().Field(0).Interface().(annotationList)
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	annotations := map[string]string{}
+	for _, ann := range list {
 		annotations[ann.Name] = ann.Value
 	}
 	return annotations, nil
@@ -370,39 +746,53 @@ func unmarshalOptions(unmarshal func(interface{}) error) (map[string]interface{}
 	return options, nil
 }
 
-func unmarshalPorts(unmarshal func(interface{}) error) ([]int, error) {
+func unmarshalPorts(unmarshal func(interface{}) error) ([]int, map[int]int, error) {
 	var portYAML struct {
 		Port  string `yaml:"port,omitempty"`
 		Ports string `yaml:"ports,omitempty"`
 	}
 
 	var ports []int
+	var targetPorts map[int]int
 
 	if err := unmarshal(&portYAML); err != nil {
-		return ports, err
+		return ports, targetPorts, err
 	}
 
 	if portYAML.Ports != "" {
-		ports = stringToIntArray(portYAML.Ports)
+		ports, targetPorts = stringToPorts(portYAML.Ports)
 	} else if portYAML.Port != "" {
-		ports = stringToIntArray(portYAML.Port)
+		ports, targetPorts = stringToPorts(portYAML.Port)
 	} else {
 		ports = []int{80}
 	}
-	return ports, nil
+	return ports, targetPorts, nil
 }
 
-func stringToIntArray(str string) []int {
+// stringToPorts parses comma separated ports. Each port can be followed by
+// the container port it targets, e.g. 80:8080
+func stringToPorts(str string) ([]int, map[int]int) {
 	var retval []int
+	var targets map[int]int
 
 	pstr := strings.Split(str, ",")
 	for _, p := range pstr {
-		j, err := strconv.Atoi(p)
-		if err == nil {
-			retval = append(retval, j)
+		parts := strings.SplitN(p, ":", 2)
+		j, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		retval = append(retval, j)
+		if len(parts) == 2 {
+			if t, err := strconv.Atoi(parts[1]); err == nil && t != j {
+				if targets == nil {
+					targets = map[int]int{}
+				}
+				targets[j] = t
+			}
 		}
 	}
-	return retval
+	return retval, targets
 }
 
 func unmarshalExternalURL(unmarshal func(interface{}) error) ([]string, error) {
@@ -445,10 +835,87 @@ func (v *Volume) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return fmt.Errorf("volume.%s", err.Error())
 	}
 
+	if err := vv.validHostPath(); err != nil {
+		return fmt.Errorf("volume.%s", err.Error())
+	}
+
+	if err := vv.validSources(); err != nil {
+		return fmt.Errorf("volume.%s", err.Error())
+	}
+
+	if len(vv.Items) != 0 && !vv.IsSecretVolume() && !vv.IsConfigMapVolume() {
+		return fmt.Errorf("volume.items: volume %s is not of type secret or configmap", vv.Name)
+	}
+	if err := validItems(vv.Name, vv.Items); err != nil {
+		return fmt.Errorf("volume.%s", err.Error())
+	}
+
+	if err := vv.validDefaultMode(); err != nil {
+		return fmt.Errorf("volume.%s", err.Error())
+	}
+
 	*v = *vv
 	return nil
 }
 
+// validHostPath checks that hostpath volumes mount a clean absolute path
+// with a valid check, and that only hostpath volumes set host_path
+func (v *Volume) validHostPath() error {
+	if !v.IsHostPathVolume() {
+		if v.HostPath != "" || v.HostPathType != "" {
+			return fmt.Errorf("host_path: volume %s is not of type %s", v.Name, TypeHostPath)
+		}
+		return nil
+	}
+
+	// scraped volumes are of lower case type
+	v.Type = TypeHostPath
+
+	if !path.IsAbs(v.HostPath) || path.Clean(v.HostPath) != v.HostPath {
+		return fmt.Errorf("host_path: %q of volume %s must be a clean absolute path", v.HostPath, v.Name)
+	}
+	if !validHostPathTypes[v.HostPathType] {
+		return fmt.Errorf("host_path_type: %s of volume %s is not one of DirectoryOrCreate, Directory, FileOrCreate, File, Socket, CharDevice, BlockDevice", v.HostPathType, v.Name)
+	}
+	return nil
+}
+
+// validItems checks that items of volume select a key, mounted at a relative
+// path within the volume with valid file mode bits
+func validItems(volume string, items []KeyToPath) error {
+	for i, it := range items {
+		if it.Key == "" || it.Path == "" {
+			return fmt.Errorf("items[%d]: volume %s must set both key and path", i, volume)
+		}
+		if path.IsAbs(it.Path) || strings.HasPrefix(it.Path, "..") || strings.Contains(it.Path, "/..") {
+			return fmt.Errorf("items[%d]: path %q of volume %s must be relative and may not contain '..'", i, it.Path, volume)
+		}
+		if it.Mode != nil && (*it.Mode < 0 || *it.Mode > 0777) {
+			return fmt.Errorf("items[%d]: mode %o of volume %s must be between 0 and 0777", i, *it.Mode, volume)
+		}
+	}
+	return nil
+}
+
+// validDefaultMode checks default_mode is only set on secret and configmap
+// volumes, with valid file mode bits. The Kubernetes default of 0644 is
+// dropped, as it is not told apart from an unset mode in the cluster
+func (v *Volume) validDefaultMode() error {
+	if v.DefaultMode == nil {
+		return nil
+	}
+	if !v.IsSecretVolume() && !v.IsConfigMapVolume() {
+		return fmt.Errorf("default_mode: volume %s is not of type secret or configmap", v.Name)
+	}
+	if *v.DefaultMode < 0 || *v.DefaultMode > 0777 {
+		return fmt.Errorf("default_mode: %o of volume %s must be between 0 and 0777", *v.DefaultMode, v.Name)
+	}
+	if *v.DefaultMode == defaultVolumeMode {
+		v.DefaultMode = nil
+	}
+	return nil
+}
+
 // HasManualProvisioning check weather the provisioning manual
 // if manual returns true
 func (v *Volume) HasManualProvisioning() bool {
@@ -466,6 +933,73 @@ func (v *Volume) IsSecretVolume() bool {
 	return false
 }
 
+// validSources checks that each source of projected volumes sets exactly
+// one kind of source, and defaults service account token expiration
+func (v *Volume) validSources() error {
+	if !v.IsProjectedVolume() {
+		if len(v.Sources) != 0 {
+			return fmt.Errorf("sources: volume %s is not of type %s", v.Name, TypeProjected)
+		}
+		return nil
+	}
+	// scraped volumes are of lower case type
+	v.Type = TypeProjected
+
+	if len(v.Sources) == 0 {
+		return fmt.Errorf("sources: projected volume %s has no sources", v.Name)
+	}
+	for i := range v.Sources {
+		s := &v.Sources[i]
+		set := 0
+		for _, ok := range []bool{s.Secret != "", s.ConfigMap != "", len(s.DownwardAPI) != 0, s.ServiceAccountToken != nil} {
+			if ok {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("sources[%d]: volume %s must set exactly one of secret, configmap, downward_api or service_account_token", i, v.Name)
+		}
+		if len(s.Items) != 0 && s.Secret == "" && s.ConfigMap == "" {
+			return fmt.Errorf("sources[%d]: items of volume %s are only supported for secret and configmap", i, v.Name)
+		}
+		if err := validItems(v.Name, s.Items); err != nil {
+			return fmt.Errorf("sources[%d].%s", i, err.Error())
+		}
+		for _, f := range s.DownwardAPI {
+			if f.Path == "" || f.FieldRef == "" {
+				return fmt.Errorf("sources[%d].downward_api: volume %s must set both path and field_ref", i, v.Name)
+			}
+		}
+		if t := s.ServiceAccountToken; t != nil {
+			if t.Path == "" {
+				return fmt.Errorf("sources[%d].service_account_token: volume %s has no path", i, v.Name)
+			}
+			if t.ExpirationSeconds == 0 {
+				t.ExpirationSeconds = defaultTokenExpiration
+			}
+			if t.ExpirationSeconds < minTokenExpiration {
+				return fmt.Errorf("sources[%d].service_account_token: expiration_seconds of volume %s must be at least %d", i, v.Name, minTokenExpiration)
+			}
+		}
+	}
+	return nil
+}
+
+// IsProjectedVolume returns true if volume combines several sources
+func (v *Volume) IsProjectedVolume() bool {
+	return strings.ToLower(v.Type) == TypeProjected
+}
+
+// IsPersistentVolume returns true if volume is backed by a PVC
+func (v *Volume) IsPersistentVolume() bool {
+	return !v.IsSecretVolume() && !v.IsConfigMapVolume() && !v.IsHostPathVolume() && !v.IsProjectedVolume()
+}
+
+// IsHostPathVolume returns true if volume mounts a path of the node
+func (v *Volume) IsHostPathVolume() bool {
+	return strings.ToLower(v.Type) == TypeHostPath
+}
+
 // IsConfigMapVolume is check for volume type defined and
 // if the type is configmap it will return true.
 func (v *Volume) IsConfigMapVolume() bool {
@@ -474,3 +1008,18 @@ func (v *Volume) IsConfigMapVolume() bool {
 	}
 	return false
 }
+
+// hostNamespaces returns options sharing node's namespaces with the pods
+func (e *Service) hostNamespaces() []string {
+	var retval []string
+	if e.HostNetwork {
+		retval = append(retval, "host_network")
+	}
+	if e.HostPID {
+		retval = append(retval, "host_pid")
+	}
+	if e.HostIPC {
+		retval = append(retval, "host_ipc")
+	}
+	return retval
+}
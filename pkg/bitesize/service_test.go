@@ -1,10 +1,13 @@
 package bitesize
 
 import (
+	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
+	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"github.com/pearsontechnology/environment-operator/pkg/util"
 
 	"gopkg.in/yaml.v2"
@@ -13,9 +16,11 @@ import (
 // Tests to see that YAML documents unmarshal correctly
 func TestUnmarshalPorts(t *testing.T) {
 	t.Run("ports string parsed correctly", testPortsString)
+	t.Run("ports with target ports", testPortsWithTargetPorts)
 	t.Run("ports preferred over port", testPortsOverPort)
 	t.Run("ports with invalid value", testPortsWithInvalidValue)
 	t.Run("empty ports return default", testPortsEmpty)
+	t.Run("external url without ports", testExternalURLWithoutPorts)
 }
 
 func TestFindByName(t *testing.T) {
@@ -44,6 +49,51 @@ func testPortsString(t *testing.T) {
 
 }
 
+func testPortsWithTargetPorts(t *testing.T) {
+	svc := &Service{}
+	str := `
+  name: something
+  ports: 80:8080,443,9090:9090
+  `
+	if err := yaml.Unmarshal([]byte(str), svc); err != nil {
+		t.Errorf("could not unmarshal yaml: %s", err.Error())
+	}
+
+	if !util.EqualArrays(svc.Ports, []int{80, 443, 9090}) {
+		t.Errorf("Ports not equal. Expected: [80 443 9090], got: %v", svc.Ports)
+	}
+	if !reflect.DeepEqual(svc.TargetPorts, map[int]int{80: 8080}) {
+		t.Errorf("Unexpected target ports. Expected: map[80:8080], got: %v", svc.TargetPorts)
+	}
+	if svc.TargetPort(80) != 8080 || svc.TargetPort(443) != 443 {
+		t.Errorf("Unexpected target ports %d and %d", svc.TargetPort(80), svc.TargetPort(443))
+	}
+}
+
+func testExternalURLWithoutPorts(t *testing.T) {
+	svc := &Service{}
+	str := `
+  name: something
+  ports: none
+  external_url: www.example.com
+  `
+	err := yaml.Unmarshal([]byte(str), svc)
+	expected := "service.external_url: service something has external_url but no ports or backend_port"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+
+	str = `
+  name: something
+  ports: none
+  external_url: www.example.com
+  backend_port: 8080
+  `
+	if err := yaml.Unmarshal([]byte(str), svc); err != nil {
+		t.Errorf("could not unmarshal yaml: %s", err.Error())
+	}
+}
+
 func testPortsOverPort(t *testing.T) {
 	svc := &Service{}
 	str := `
@@ -121,7 +171,7 @@ func testPodsEqual(t *testing.T) {
 	e1 := Pod{Name: "PodA", Phase: "Running", StartTime: "StartTime", Message: "Message", Logs: "Log Message"}
 	e2 := Pod{Name: "PodA", Phase: "Running", StartTime: "StartTime", Message: "Message", Logs: "Log Message"}
 
-	if e1 != e2 {
+	if !reflect.DeepEqual(e1, e2) {
 		t.Errorf("Expected %+v to be equal to %+v, got false", e1, e2)
 	}
 }
@@ -215,6 +265,469 @@ func testFindByNameNotFound(t *testing.T) {
 	}
 }
 
+func TestCertManager(t *testing.T) {
+	svc := &Service{}
+	if err := yaml.Unmarshal([]byte("name: front\nexternal_url: www.example.com\ncert_manager:\n  issuer: letsencrypt\n"), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if svc.CertManager.IssuerKind != "ClusterIssuer" {
+		t.Errorf("Expected default issuer kind ClusterIssuer, got %s", svc.CertManager.IssuerKind)
+	}
+	if !reflect.DeepEqual(svc.CertManagerHosts(), []string{"www.example.com"}) {
+		t.Errorf("Expected certificate hosts to default to external_url, got %v", svc.CertManagerHosts())
+	}
+
+	err := yaml.Unmarshal([]byte("name: front\ncert_manager:\n  issuer: letsencrypt\n"), svc)
+	expected := "service.cert_manager: service front has cert_manager but no external_url"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+
+	if err := yaml.Unmarshal([]byte("name: front\nexternal_url: www.example.com\nssl: \"true\"\ncert_manager:\n  issuer: letsencrypt\n"), svc); err == nil {
+		t.Error("Expected error combining ssl with cert_manager")
+	}
+}
+
+func TestSecretFetchDefaults(t *testing.T) {
+	svc := &Service{}
+	if err := yaml.Unmarshal([]byte("name: api\nsecret_fetch:\n  config_map: api-vault\n"), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	expected := &SecretFetch{
+		Image:     config.Env.SecretFetchImage,
+		Command:   []string{"vault", "agent", "-config=/vault/config/agent.hcl", "-exit-after-auth"},
+		ConfigMap: "api-vault",
+		Path:      "/vault/secrets",
+	}
+	if !reflect.DeepEqual(svc.SecretFetch, expected) {
+		t.Errorf("Expected secret_fetch %+v, got %+v", expected, svc.SecretFetch)
+	}
+
+	err := yaml.Unmarshal([]byte("name: api\nsecret_fetch:\n  path: /secrets\n"), svc)
+	expectedErr := "service.secret_fetch: either command or config_map is required for service api"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("Expected error %q, got %v", expectedErr, err)
+	}
+}
+
+func TestDeploymentAnnotations(t *testing.T) {
+	svc := &Service{}
+	if err := yaml.Unmarshal([]byte("name: api\ndeployment_annotations:\n  - name: keel.sh/policy\n    value: minor\n"), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	expected := map[string]string{"keel.sh/policy": "minor"}
+	if !reflect.DeepEqual(svc.DeploymentAnnotations, expected) {
+		t.Errorf("Expected deployment annotations %v, got %v", expected, svc.DeploymentAnnotations)
+	}
+}
+
+func TestIngressAnnotations(t *testing.T) {
+	svc := &Service{}
+	if err := yaml.Unmarshal([]byte("name: api\ningress_annotations:\n  - name: alb.ingress.kubernetes.io/target-type\n    value: ip\n"), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	expected := map[string]string{"alb.ingress.kubernetes.io/target-type": "ip"}
+	if !reflect.DeepEqual(svc.IngressAnnotations, expected) {
+		t.Errorf("Expected ingress annotations %v, got %v", expected, svc.IngressAnnotations)
+	}
+}
+
+func TestServicesForEnvironment(t *testing.T) {
+	services := Services{
+		{Name: "api"},
+		{Name: "toolbox", Environments: []string{"dev", "test"}},
+	}
+
+	var names []string
+	for _, s := range services.ForEnvironment("prod") {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"api"}) {
+		t.Errorf("Expected only api in prod, got %v", names)
+	}
+
+	if len(services.ForEnvironment("test")) != 2 {
+		t.Errorf("Expected all services in test, got %v", services.ForEnvironment("test"))
+	}
+}
+
+func TestServiceRevisionHistoryLimit(t *testing.T) {
+	svc := &Service{}
+	if err := yaml.Unmarshal([]byte("name: api\nrevision_history_limit: 25\n"), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if l := svc.RevisionHistoryLimit; l == nil || *l != 25 || svc.RevisionHistory() != 25 {
+		t.Errorf("Expected revision_history_limit 25, got %v", l)
+	}
+
+	svc = &Service{}
+	input := fmt.Sprintf("name: api\nrevision_history_limit: %d\n", config.Env.RevisionHistoryLimit)
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if svc.RevisionHistoryLimit != nil || int(svc.RevisionHistory()) != config.Env.RevisionHistoryLimit {
+		t.Errorf("Expected operator default limit to be dropped, got %v", svc.RevisionHistoryLimit)
+	}
+
+	err := yaml.Unmarshal([]byte("name: api\nrevision_history_limit: -1\n"), &Service{})
+	if err == nil || !strings.Contains(err.Error(), "service.revision_history_limit: -1 of service api must not be negative") {
+		t.Errorf("Expected negative revision_history_limit error, got %v", err)
+	}
+}
+
+func TestServiceHostNamespaces(t *testing.T) {
+	svc := &Service{}
+	if err := yaml.Unmarshal([]byte("name: agent\nhost_network: true\nhost_pid: true\n"), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if !svc.HostNetwork || !svc.HostPID || svc.HostIPC {
+		t.Errorf("Expected host_network and host_pid, got %+v", svc)
+	}
+
+	err := yaml.Unmarshal([]byte("name: db\ntype: mysql\nhost_network: true\n"), &Service{})
+	if err == nil || !strings.Contains(err.Error(), "service.host_network: not supported for service db of type mysql") {
+		t.Errorf("Expected host_network error for typed service, got %v", err)
+	}
+}
+
+func TestServiceHostPathVolumes(t *testing.T) {
+	svc := &Service{}
+	input := "name: agent\nvolumes:\n  - name: varlog\n    path: /var/log\n    type: hostPath\n    host_path: /var/log\n    host_path_type: Directory\n"
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if v := svc.Volumes[0]; v.Type != TypeHostPath || v.HostPath != "/var/log" || v.HostPathType != "Directory" {
+		t.Errorf("Unexpected hostpath volume %+v", v)
+	}
+
+	var tests = []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			"name: agent\nvolumes:\n  - name: v\n    path: /v\n    type: hostpath\n    host_path: var/log\n",
+			"volume.host_path: \"var/log\" of volume v must be a clean absolute path",
+		},
+		{
+			"name: agent\nvolumes:\n  - name: v\n    path: /v\n    type: hostpath\n    host_path: /var/../etc\n",
+			"must be a clean absolute path",
+		},
+		{
+			"name: agent\nvolumes:\n  - name: v\n    path: /v\n    type: hostpath\n    host_path: /var/log\n    host_path_type: Dir\n",
+			"volume.host_path_type: Dir of volume v is not one of",
+		},
+		{
+			"name: agent\nvolumes:\n  - name: v\n    path: /v\n    host_path: /var/log\n",
+			"volume.host_path: volume v is not of type hostpath",
+		},
+	}
+	for _, tst := range tests {
+		err := yaml.Unmarshal([]byte(tst.Input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error containing %q, got %v", tst.Expected, err)
+		}
+	}
+}
+
+func TestServiceVolumeItems(t *testing.T) {
+	svc := &Service{}
+	input := `
+name: api
+volumes:
+  - name: api-tls
+    path: /etc/tls
+    type: secret
+    items:
+      - key: tls.key
+        path: private/key.pem
+        mode: 0400
+`
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	items := svc.Volumes[0].Items
+	if len(items) != 1 || items[0].Key != "tls.key" || items[0].Path != "private/key.pem" || items[0].Mode == nil || *items[0].Mode != 0400 {
+		t.Errorf("Unexpected volume items %+v", items)
+	}
+
+	var tests = []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    items:\n      - key: a\n        path: a\n",
+			"volume.items: volume v is not of type secret or configmap",
+		},
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    type: configmap\n    items:\n      - key: a\n",
+			"volume.items[0]: volume v must set both key and path",
+		},
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    type: secret\n    items:\n      - key: a\n        path: /etc/a\n",
+			"volume.items[0]: path \"/etc/a\" of volume v must be relative",
+		},
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    type: secret\n    items:\n      - key: a\n        path: b/../../a\n",
+			"may not contain '..'",
+		},
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    type: secret\n    items:\n      - key: a\n        path: a\n        mode: 01000\n",
+			"volume.items[0]: mode 1000 of volume v must be between 0 and 0777",
+		},
+	}
+	for _, tst := range tests {
+		err := yaml.Unmarshal([]byte(tst.Input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error containing %q, got %v", tst.Expected, err)
+		}
+	}
+}
+
+func TestServiceVolumeDefaultMode(t *testing.T) {
+	svc := &Service{}
+	input := "name: api\nvolumes:\n  - name: ssh-key\n    path: /etc/ssh\n    type: secret\n    default_mode: 0400\n  - name: settings\n    path: /etc/api\n    type: configmap\n    default_mode: 0644\n"
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if m := svc.Volumes[0].DefaultMode; m == nil || *m != 0400 {
+		t.Errorf("Expected default_mode 0400, got %v", m)
+	}
+	if m := svc.Volumes[1].DefaultMode; m != nil {
+		t.Errorf("Expected kubernetes default mode to be dropped, got %o", *m)
+	}
+
+	var tests = []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    default_mode: 0400\n",
+			"volume.default_mode: volume v is not of type secret or configmap",
+		},
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    type: secret\n    default_mode: 01777\n",
+			"volume.default_mode: 1777 of volume v must be between 0 and 0777",
+		},
+	}
+	for _, tst := range tests {
+		err := yaml.Unmarshal([]byte(tst.Input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error containing %q, got %v", tst.Expected, err)
+		}
+	}
+}
+
+func TestServiceProjectedVolumes(t *testing.T) {
+	svc := &Service{}
+	input := `
+name: api
+volumes:
+  - name: aws-iam
+    path: /var/run/secrets/aws
+    type: projected
+    sources:
+      - service_account_token:
+          path: token
+          audience: sts.amazonaws.com
+      - configmap: aws-config
+`
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	v := svc.Volumes[0]
+	if len(v.Sources) != 2 || v.Sources[0].ServiceAccountToken.ExpirationSeconds != 3600 || v.Sources[1].ConfigMap != "aws-config" {
+		t.Errorf("Unexpected projected volume %+v", v)
+	}
+	if v.IsPersistentVolume() {
+		t.Error("Expected projected volume not to be persistent")
+	}
+
+	var tests = []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    type: projected\n",
+			"volume.sources: projected volume v has no sources",
+		},
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    type: projected\n    sources:\n      - secret: a\n        configmap: b\n",
+			"volume.sources[0]: volume v must set exactly one of",
+		},
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    type: projected\n    sources:\n      - service_account_token:\n          path: token\n          expiration_seconds: 60\n",
+			"expiration_seconds of volume v must be at least 600",
+		},
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    type: projected\n    sources:\n      - downward_api:\n          - path: labels\n",
+			"volume.sources[0].downward_api: volume v must set both path and field_ref",
+		},
+		{
+			"name: api\nvolumes:\n  - name: v\n    path: /v\n    sources:\n      - secret: a\n",
+			"volume.sources: volume v is not of type projected",
+		},
+	}
+	for _, tst := range tests {
+		err := yaml.Unmarshal([]byte(tst.Input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error containing %q, got %v", tst.Expected, err)
+		}
+	}
+}
+
+func TestServiceReconciledOn(t *testing.T) {
+	var tests = []struct {
+		Reconcile string
+		Poll      bool
+		Change    bool
+	}{
+		{"", true, true},
+		{ReconcileOnChange, true, true},
+		{ReconcilePoll, true, false},
+		{ReconcileManual, false, false},
+	}
+	for _, tst := range tests {
+		s := &Service{Reconcile: tst.Reconcile}
+		if s.ReconciledOn(TriggerPoll) != tst.Poll || s.ReconciledOn(TriggerChange) != tst.Change {
+			t.Errorf("Unexpected triggers for reconcile %q", tst.Reconcile)
+		}
+	}
+
+	err := yaml.Unmarshal([]byte("name: api\nreconcile: never\n"), &Service{})
+	if err == nil || !strings.Contains(err.Error(), "Reconcile") {
+		t.Errorf("Expected invalid reconcile mode error, got %v", err)
+	}
+}
+
+func TestServiceReadyThreshold(t *testing.T) {
+	for _, threshold := range []string{"2", "50%"} {
+		svc := &Service{}
+		if err := yaml.Unmarshal([]byte("name: api\nready_threshold: "+threshold+"\n"), svc); err != nil {
+			t.Errorf("Unexpected error for ready_threshold %s: %s", threshold, err.Error())
+		}
+		if svc.ReadyThreshold != threshold {
+			t.Errorf("Expected ready_threshold %s, got %s", threshold, svc.ReadyThreshold)
+		}
+	}
+
+	for _, threshold := range []string{"0", "half", "-1"} {
+		if err := yaml.Unmarshal([]byte("name: api\nready_threshold: \""+threshold+"\"\n"), &Service{}); err == nil {
+			t.Errorf("Expected error for ready_threshold %s", threshold)
+		}
+	}
+}
+
+func TestServiceMonitor(t *testing.T) {
+	svc := &Service{}
+	if err := yaml.Unmarshal([]byte("name: api\nports: 80,9102\nservice_monitor: {}\n"), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	expected := ServiceMonitor{Port: 80, Path: "/metrics"}
+	if svc.ServiceMonitor == nil || *svc.ServiceMonitor != expected {
+		t.Errorf("Expected service_monitor defaults %+v, got %+v", expected, svc.ServiceMonitor)
+	}
+
+	var tests = []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			"name: api\nport: 80\nservice_monitor:\n  port: 9102\n",
+			"service.service_monitor: port 9102 is not a port of the service for service api",
+		},
+		{
+			"name: api\nport: 80\nservice_monitor:\n  interval: often\n",
+			"regular expression mismatch",
+		},
+		{
+			"name: api\ntype: externalname\nexternal_name: api.example.com\nservice_monitor: {}\n",
+			"service.service_monitor: not supported for service api of type externalname",
+		},
+	}
+	for _, tst := range tests {
+		err := yaml.Unmarshal([]byte(tst.Input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error %q, got %v", tst.Expected, err)
+		}
+	}
+}
+
+func TestServicePatches(t *testing.T) {
+	svc := &Service{}
+	input := "name: api\nport: 80\npatches:\n  - target: deployment\n    patch: |\n      spec:\n        progressDeadlineSeconds: 120\n"
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if len(svc.Patches) != 1 || svc.Patches[0].Type != PatchTypeStrategic {
+		t.Errorf("Expected strategic patch by default, got %+v", svc.Patches)
+	}
+
+	var tests = []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			"name: api\npatches:\n  - target: ingress\n    patch: '{}'\n",
+			"service.Patches[0].Target: regular expression mismatch",
+		},
+		{
+			"name: api\npatches:\n  - target: service\n    type: json\n    patch: '{\"op\": \"add\"}'\n",
+			"service.patches[0]: invalid json patch for service api",
+		},
+		{
+			"name: api\ntype: externalname\nexternal_name: api.example.com\npatches:\n  - target: deployment\n    patch: '{}'\n",
+			"service.patches[0]: service api has no deployment",
+		},
+	}
+	for _, tst := range tests {
+		err := yaml.Unmarshal([]byte(tst.Input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error containing %q, got %v", tst.Expected, err)
+		}
+	}
+}
+
+func TestNormalizeWeightedBackends(t *testing.T) {
+	var testCases = []struct {
+		Weights  []int
+		Expected []int
+		Valid    bool
+	}{
+		{[]int{70, 30}, []int{70, 30}, true},
+		{[]int{1, 1}, []int{50, 50}, true},
+		{[]int{2, 1}, []int{67, 33}, true},
+		{[]int{0, 5}, []int{0, 100}, true},
+		{[]int{0, 0}, nil, false},
+		{[]int{-1, 10}, nil, false},
+		{[]int{50, 25, 25}, nil, false},
+	}
+
+	for _, tCase := range testCases {
+		var backends []WeightedBackend
+		for i, w := range tCase.Weights {
+			backends = append(backends, WeightedBackend{Service: fmt.Sprintf("svc%d", i), Weight: w})
+		}
+
+		err := normalizeWeightedBackends(backends)
+		if !tCase.Valid {
+			if err == nil {
+				t.Errorf("Expected error for weights %v, got nil", tCase.Weights)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error for weights %v: %s", tCase.Weights, err.Error())
+			continue
+		}
+		for i, w := range tCase.Expected {
+			if backends[i].Weight != w {
+				t.Errorf("Unexpected weights for %v. Expected: %v, got: %+v", tCase.Weights, tCase.Expected, backends)
+			}
+		}
+	}
+}
+
 func TestServiceSortInterface(t *testing.T) {
 	var s = Services{
 		{Name: "b"},
@@ -233,3 +746,233 @@ func TestServiceSortInterface(t *testing.T) {
 		t.Errorf("Service sort invalid, got %v", s)
 	}
 }
+
+func TestServiceExternalEnvs(t *testing.T) {
+	svc := &Service{}
+	input := "name: api\nenv:\n  - secret: DB_PASSWORD\n    value: api-db/password\n    external_key: prod/api/db\n    external_property: password\n"
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if e := svc.EnvVars[0]; e.ExternalKey != "prod/api/db" || e.ExternalProperty != "password" {
+		t.Errorf("Expected external key prod/api/db and property password, got %+v", e)
+	}
+
+	var tests = []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			"name: api\nenv:\n  - secret: DB_PASSWORD\n    value: api-db/password\n    external_property: password\n",
+			"env DB_PASSWORD has external_property but no external_key",
+		},
+		{
+			"name: api\nenv:\n  - name: DB_PASSWORD\n    value: x\n    external_key: prod/api/db\n",
+			"env DB_PASSWORD has external_key but is not a secret env var",
+		},
+		{
+			"name: api\ninit_containers:\n  - name: migrate\n    application: migrate\n    version: 1\n    env:\n      - secret: DB_PASSWORD\n        value: api-db/password\n        external_key: prod/api/db\n",
+			"external_key is only supported for service env vars",
+		},
+	}
+	for _, tst := range tests {
+		err := yaml.Unmarshal([]byte(tst.Input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error containing %q, got %v", tst.Expected, err)
+		}
+	}
+}
+
+func TestServiceProbePortDefault(t *testing.T) {
+	svc := &Service{}
+	input := `
+name: api
+port: 80:8080,9090
+liveness_probe:
+  handler:
+    http_get:
+      path: /healthz
+  initial_delay_seconds: 10
+readiness_probe:
+  handler:
+    http_get:
+      path: /ready
+      port: 9090
+`
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if p := svc.LivenessProbe.HTTPGet.Port; p != 8080 {
+		t.Errorf("Expected liveness probe port to default to container port 8080, got %d", p)
+	}
+	if p := svc.ReadinessProbe.HTTPGet.Port; p != 9090 {
+		t.Errorf("Expected readiness probe port 9090 to be kept, got %d", p)
+	}
+
+	err := yaml.Unmarshal([]byte("name: api\ntype: mysql\nliveness_probe:\n  handler:\n    http_get:\n      path: /healthz\n"), &Service{})
+	if err == nil || !strings.Contains(err.Error(), "http_get has no port and service has no ports") {
+		t.Errorf("Expected missing probe port error, got %v", err)
+	}
+}
+
+func TestServiceAntiAffinity(t *testing.T) {
+	svc := &Service{}
+	if err := yaml.Unmarshal([]byte("name: api\nanti_affinity: application\n"), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if svc.AntiAffinity != "application" {
+		t.Errorf("Expected anti_affinity application, got %q", svc.AntiAffinity)
+	}
+
+	err := yaml.Unmarshal([]byte("name: api\nanti_affinity: version\n"), &Service{})
+	if err == nil || !strings.Contains(err.Error(), "AntiAffinity") {
+		t.Errorf("Expected invalid anti_affinity error, got %v", err)
+	}
+}
+
+func TestServiceAffinityAndTolerations(t *testing.T) {
+	svc := &Service{}
+	input := `
+name: api
+tolerations:
+  - key: dedicated
+    operator: Equal
+    value: stateful
+    effect: NoSchedule
+affinity:
+  node_affinity:
+    required_during_scheduling_ignored_during_execution:
+      - match_expressions:
+          - key: topology.kubernetes.io/zone
+            operator: In
+            values: [eu-west-1a]
+`
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if len(svc.Tolerations) != 1 || svc.Tolerations[0].Value != "stateful" || svc.Tolerations[0].Effect != "NoSchedule" {
+		t.Errorf("Expected dedicated=stateful:NoSchedule toleration, got %+v", svc.Tolerations)
+	}
+	if svc.Affinity == nil || svc.Affinity.NodeAffinity == nil || len(svc.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Fatalf("Expected required node affinity, got %+v", svc.Affinity)
+	}
+	req := svc.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0].MatchExpressions[0]
+	if req.Key != "topology.kubernetes.io/zone" || req.Operator != "In" || len(req.Values) != 1 {
+		t.Errorf("Expected zone requirement, got %+v", req)
+	}
+
+	var tests = []struct {
+		Input    string
+		Expected string
+	}{
+		{"name: api\ntolerations:\n  - key: dedicated\n    operator: Equals\n", "Operator"},
+		{"name: api\ntolerations:\n  - key: dedicated\n    effect: NoRun\n", "Effect"},
+		{"name: api\ntolerations:\n  - key: dedicated\n    operator: Exists\n    value: stateful\n", "tolerations[0]: value must be empty with operator Exists"},
+		{"name: api\ntolerations:\n  - effect: NoSchedule\n", "tolerations[0]: operator must be Exists without key"},
+		{"name: api\naffinity:\n  node_affinity:\n    preferred_during_scheduling_ignored_during_execution:\n      - weight: 0\n        preference: {}\n", "Weight"},
+		{"name: api\nanti_affinity: name\naffinity:\n  pod_anti_affinity:\n    required_during_scheduling_ignored_during_execution:\n      - topology_key: kubernetes.io/hostname\n", "can not be combined with anti_affinity"},
+	}
+	for _, tst := range tests {
+		err := yaml.Unmarshal([]byte(tst.Input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error containing %q for %q, got %v", tst.Expected, tst.Input, err)
+		}
+	}
+}
+
+func TestServiceBackendHealthCheck(t *testing.T) {
+	svc := &Service{}
+	input := "name: api\nexternal_url: api.example.com\nbackend_health_check:\n  path: /healthz\n  interval: 10s\n"
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if hc := svc.BackendHealthCheck; hc == nil || hc.Path != "/healthz" || hc.Interval != "10s" {
+		t.Errorf("Expected /healthz check every 10s, got %+v", hc)
+	}
+
+	var tests = []struct {
+		Input    string
+		Expected string
+	}{
+		{"name: api\nbackend_health_check:\n  path: /healthz\n", "service api has backend_health_check but no external_url"},
+		{"name: api\nexternal_url: api.example.com\nbackend_health_check:\n  interval: 500ms\n", "Interval"},
+	}
+	for _, tst := range tests {
+		err := yaml.Unmarshal([]byte(tst.Input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error containing %q for %q, got %v", tst.Expected, tst.Input, err)
+		}
+	}
+}
+
+func TestServiceImagePullPolicy(t *testing.T) {
+	svc := &Service{}
+	if err := yaml.Unmarshal([]byte("name: api\nimage_pull_policy: Always\n"), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if svc.ImagePullPolicy != "Always" {
+		t.Errorf("Expected image_pull_policy Always, got %q", svc.ImagePullPolicy)
+	}
+
+	err := yaml.Unmarshal([]byte("name: api\nimage_pull_policy: always\n"), &Service{})
+	if err == nil || !strings.Contains(err.Error(), "ImagePullPolicy") {
+		t.Errorf("Expected invalid image_pull_policy error, got %v", err)
+	}
+}
+
+func TestServiceEnvFrom(t *testing.T) {
+	svc := &Service{}
+	input := "name: api\nenv_from:\n  - configmap: settings\n  - secret: credentials\n    prefix: DB_\n"
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	expected := []EnvFromSource{{ConfigMap: "settings"}, {Secret: "credentials", Prefix: "DB_"}}
+	if !reflect.DeepEqual(svc.EnvFrom, expected) {
+		t.Errorf("Expected env_from %+v, got %+v", expected, svc.EnvFrom)
+	}
+
+	for _, input := range []string{
+		"name: api\nenv_from:\n  - prefix: DB_\n",
+		"name: api\nenv_from:\n  - configmap: settings\n    secret: credentials\n",
+	} {
+		err := yaml.Unmarshal([]byte(input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), "exactly one of configmap or secret") {
+			t.Errorf("Expected env_from source error for %q, got %v", input, err)
+		}
+	}
+}
+
+func TestServiceType(t *testing.T) {
+	svc := &Service{}
+	input := "name: api\nservice_type: LoadBalancer\nload_balancer_source_ranges:\n  - 10.0.0.0/8\n"
+	if err := yaml.Unmarshal([]byte(input), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if svc.ServiceType != "LoadBalancer" || len(svc.LoadBalancerSourceRanges) != 1 {
+		t.Errorf("Expected LoadBalancer service with a source range, got %s %v", svc.ServiceType, svc.LoadBalancerSourceRanges)
+	}
+
+	svc = &Service{}
+	if err := yaml.Unmarshal([]byte("name: api\nservice_type: ClusterIP\n"), svc); err != nil {
+		t.Fatalf("could not unmarshal yaml: %s", err.Error())
+	}
+	if svc.ServiceType != "" {
+		t.Errorf("Expected default ClusterIP type to be dropped, got %s", svc.ServiceType)
+	}
+
+	var tests = []struct {
+		Input    string
+		Expected string
+	}{
+		{"name: api\nservice_type: ExternalName\n", "ServiceType"},
+		{"name: api\nservice_type: NodePort\nheadless: true\n", "service_type: NodePort can not be combined with headless"},
+		{"name: db\ntype: mysql\nservice_type: NodePort\n", "service_type: not supported for service db of type mysql"},
+		{"name: api\nservice_type: NodePort\nload_balancer_source_ranges:\n  - 10.0.0.0/8\n", "service api is not of service_type LoadBalancer"},
+		{"name: api\nservice_type: LoadBalancer\nload_balancer_source_ranges:\n  - 10.0.0.300/8\n", "10.0.0.300/8 of service api is not a valid CIDR"},
+	}
+	for _, tst := range tests {
+		err := yaml.Unmarshal([]byte(tst.Input), &Service{})
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error containing %q, got %v", tst.Expected, err)
+		}
+	}
+}
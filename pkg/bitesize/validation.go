@@ -2,13 +2,17 @@ package bitesize
 
 import (
 	"fmt"
+	"net"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
 	validator "gopkg.in/validator.v2"
+	v1 "k8s.io/api/core/v1"
 )
 
 func addCustomValidators() {
@@ -19,6 +23,7 @@ func addCustomValidators() {
 	validator.SetValidationFunc("requests", validRequests)
 	validator.SetValidationFunc("limits", validLimits)
 	validator.SetValidationFunc("external_url", validExternalURL)
+	validator.SetValidationFunc("mesh_annotations", validMeshAnnotations)
 }
 
 func validVolumeModes(v interface{}, param string) error {
@@ -223,3 +228,261 @@ func validExternalURL(urls interface{}, param string) error {
 	}
 	return nil
 }
+
+// validUniqueExternalURLs returns an error if the same external_url host is
+// declared by more than one service, as their ingresses would conflict
+func validUniqueExternalURLs(services Services) error {
+	owners := map[string][]string{}
+	var hosts []string
+
+	for _, svc := range services {
+		for _, url := range svc.ExternalURL {
+			host := strings.ToLower(url)
+			if len(owners[host]) == 0 {
+				hosts = append(hosts, host)
+			}
+			owners[host] = append(owners[host], svc.Name)
+		}
+	}
+
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		if len(owners[host]) > 1 {
+			return fmt.Errorf("external_url %s is declared by multiple services: %s",
+				host, strings.Join(owners[host], ","))
+		}
+	}
+	return nil
+}
+
+// meshAnnotationValues lists accepted values for service mesh sidecar
+// injection annotations
+var meshAnnotationValues = map[string][]string{
+	"sidecar.istio.io/inject": {"true", "false"},
+	"linkerd.io/inject":       {"enabled", "disabled", "ingress"},
+}
+
+// meshPortAnnotations lists service mesh annotations holding a comma
+// separated list of ports
+var meshPortAnnotations = []string{
+	"traffic.sidecar.istio.io/includeInboundPorts",
+	"traffic.sidecar.istio.io/excludeInboundPorts",
+	"traffic.sidecar.istio.io/excludeOutboundPorts",
+	"config.linkerd.io/skip-inbound-ports",
+	"config.linkerd.io/skip-outbound-ports",
+}
+
+func validMeshAnnotations(annotations interface{}, param string) error {
+	m, ok := annotations.(map[string]string)
+	if !ok {
+		return fmt.Errorf("annotations %v are invalid", annotations)
+	}
+
+	for name, allowed := range meshAnnotationValues {
+		value, ok := m[name]
+		if !ok {
+			continue
+		}
+		valid := false
+		for _, a := range allowed {
+			if value == a {
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("annotation %s value %s is invalid; valid values: %s",
+				name, value, strings.Join(allowed, ","))
+		}
+	}
+
+	for _, name := range meshPortAnnotations {
+		value, ok := m[name]
+		if !ok || value == "*" {
+			continue
+		}
+		ok, err := regexp.MatchString("^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$", value)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("annotation %s value %s is invalid; expected comma separated ports", name, value)
+		}
+	}
+	return nil
+}
+
+// envReference matches kubernetes $(VAR) references, and $$ escapes which
+// must be skipped so $$(VAR) stays literal
+var envReference = regexp.MustCompile(`\$\$|\$\(([A-Za-z_][A-Za-z0-9_.-]*)\)`)
+
+// serviceLinkEnv matches env vars kubernetes sets for services in the
+// namespace, e.g. REDIS_SERVICE_HOST or REDIS_PORT_6379_TCP_ADDR
+var serviceLinkEnv = regexp.MustCompile(`^[A-Z0-9_]+_(SERVICE_HOST|SERVICE_PORT.*|PORT.*)$`)
+
+// validEnvReferences returns an error if service command refers to an env
+// var that is not declared for the container, or an env value refers to one
+// not declared before it. Kubernetes would leave such reference unexpanded.
+// Service link env vars are not declared in the config and are not checked,
+// nor are references of services setting env vars of unknown keys env_from
+func validEnvReferences(svc Service) error {
+	if len(svc.EnvFrom) > 0 {
+		return nil
+	}
+	declared := map[string]bool{}
+	for _, e := range svc.EnvVars {
+		name := e.Name
+		if e.Secret != "" {
+			name = e.Secret
+		} else if undeclared := undeclaredEnvReferences(e.Value, declared); len(undeclared) != 0 {
+			return fmt.Errorf("env %s refers to undeclared or later declared env vars: %s", name, strings.Join(undeclared, ","))
+		}
+		declared[name] = true
+	}
+
+	// blue/green colour is appended after the configured env
+	if svc.DeploymentMethod() == "bluegreen" {
+		declared["POD_DEPLOYMENT_COLOUR"] = true
+	}
+
+	for _, cmd := range svc.Commands {
+		if undeclared := undeclaredEnvReferences(cmd, declared); len(undeclared) != 0 {
+			return fmt.Errorf("command %q refers to undeclared env vars: %s", cmd, strings.Join(undeclared, ","))
+		}
+	}
+	return nil
+}
+
+// validExternalEnvs checks env vars synced from a secret manager are secret
+// env vars of the service itself
+func validExternalEnvs(svc Service) error {
+	for _, e := range svc.EnvVars {
+		if e.ExternalKey == "" {
+			if e.ExternalProperty != "" {
+				return fmt.Errorf("env %s has external_property but no external_key", e.envName())
+			}
+			continue
+		}
+		if e.Secret == "" {
+			return fmt.Errorf("env %s has external_key but is not a secret env var", e.envName())
+		}
+	}
+	if svc.InitContainers == nil {
+		return nil
+	}
+	for _, c := range *svc.InitContainers {
+		for _, e := range c.EnvVars {
+			if e.ExternalKey != "" || e.ExternalProperty != "" {
+				return fmt.Errorf("env %s of init container %s: external_key is only supported for service env vars", e.envName(), c.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// validServiceType checks service_type is only set for services with a
+// kubernetes Service of their own that has a cluster IP, and that
+// load_balancer_source_ranges are CIDRs of a LoadBalancer service
+func validServiceType(svc Service) error {
+	if svc.ServiceType != "" && svc.ServiceType != string(v1.ServiceTypeClusterIP) {
+		switch {
+		case svc.Type != "":
+			return fmt.Errorf("service_type: not supported for service %s of type %s", svc.Name, svc.Type)
+		case svc.Headless:
+			return fmt.Errorf("service_type: %s can not be combined with headless for service %s", svc.ServiceType, svc.Name)
+		}
+	}
+	if len(svc.LoadBalancerSourceRanges) == 0 {
+		return nil
+	}
+	if svc.ServiceType != string(v1.ServiceTypeLoadBalancer) {
+		return fmt.Errorf("load_balancer_source_ranges: service %s is not of service_type LoadBalancer", svc.Name)
+	}
+	for _, r := range svc.LoadBalancerSourceRanges {
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return fmt.Errorf("load_balancer_source_ranges: %s of service %s is not a valid CIDR", r, svc.Name)
+		}
+	}
+	return nil
+}
+
+// validScheduling checks anti_affinity preset is not combined with its own
+// pod_anti_affinity and tolerations with the Exists operator match no value
+func validScheduling(svc Service) error {
+	if svc.AntiAffinity != "" && svc.Affinity != nil && svc.Affinity.PodAntiAffinity != nil {
+		return fmt.Errorf("affinity.pod_anti_affinity: can not be combined with anti_affinity for service %s", svc.Name)
+	}
+	for i, t := range svc.Tolerations {
+		if t.Operator == string(v1.TolerationOpExists) && t.Value != "" {
+			return fmt.Errorf("tolerations[%d]: value must be empty with operator Exists for service %s", i, svc.Name)
+		}
+		if t.Key == "" && t.Operator != string(v1.TolerationOpExists) {
+			return fmt.Errorf("tolerations[%d]: operator must be Exists without key for service %s", i, svc.Name)
+		}
+	}
+	return nil
+}
+
+// validEnvNames returns an error if an env var is declared more than once.
+// Kubernetes would silently use the last of them
+func validEnvNames(svc Service) error {
+	declared := map[string]bool{}
+	for _, e := range svc.EnvVars {
+		name := e.envName()
+		if declared[name] {
+			return fmt.Errorf("env %s is declared more than once for service %s", name, svc.Name)
+		}
+		declared[name] = true
+	}
+	return nil
+}
+
+// sortEnvVars orders env vars by name, so that reordering them in the config
+// doesn't change the deployment. Env vars referring to other env vars are
+// kept in config order after the rest, as references are only expanded to
+// env vars declared earlier
+func sortEnvVars(vars []EnvVar) {
+	refers := func(e EnvVar) bool {
+		return e.Secret == "" && len(undeclaredEnvReferences(e.Value, nil)) != 0
+	}
+	sort.SliceStable(vars, func(i, j int) bool {
+		ri, rj := refers(vars[i]), refers(vars[j])
+		if ri || rj {
+			return !ri && rj
+		}
+		return vars[i].envName() < vars[j].envName()
+	})
+}
+
+func undeclaredEnvReferences(s string, declared map[string]bool) []string {
+	var retval []string
+	for _, match := range envReference.FindAllStringSubmatch(s, -1) {
+		if match[1] != "" && !declared[match[1]] && !serviceLinkEnv.MatchString(match[1]) {
+			retval = append(retval, match[1])
+		}
+	}
+	return retval
+}
+
+// validEnvironmentDependencies returns an error if a service depends on a
+// service that is not deployed in all of the environments it is deployed in
+func validEnvironmentDependencies(services Services) error {
+	for _, svc := range services {
+		for _, name := range svc.DependsOn {
+			dep := services.FindByName(name)
+			if dep == nil || len(dep.Environments) == 0 {
+				continue
+			}
+			if len(svc.Environments) == 0 {
+				return fmt.Errorf("service %s depends on %s, which is only deployed in environments %s",
+					svc.Name, name, strings.Join(dep.Environments, ","))
+			}
+			for _, env := range svc.Environments {
+				if !dep.DeployedIn(env) {
+					return fmt.Errorf("service %s depends on %s, which is not deployed in environment %s",
+						svc.Name, name, env)
+				}
+			}
+		}
+	}
+	return nil
+}
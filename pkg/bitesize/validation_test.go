@@ -2,9 +2,11 @@ package bitesize
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"gopkg.in/yaml.v2"
 )
 
 func TestValidationVolumeNames(t *testing.T) {
@@ -214,3 +216,194 @@ func TestValidExternalURL(t *testing.T) {
 	}
 
 }
+
+func TestValidUniqueExternalURLs(t *testing.T) {
+	services := Services{
+		{Name: "a", ExternalURL: []string{"a.example.com"}},
+		{Name: "b", ExternalURL: []string{"b.example.com", "shared.example.com"}},
+		{Name: "c"},
+	}
+	if err := validUniqueExternalURLs(services); err != nil {
+		t.Errorf("Unexpected external_url validation error: %v", err)
+	}
+
+	services = append(services, Service{Name: "d", ExternalURL: []string{"Shared.example.com"}})
+	err := validUniqueExternalURLs(services)
+	if err == nil {
+		t.Fatal("Expected external_url validation error for duplicate host, got nil")
+	}
+
+	expected := "external_url shared.example.com is declared by multiple services: b,d"
+	if err.Error() != expected {
+		t.Errorf("Unexpected error. Expected: %s, got: %s", expected, err.Error())
+	}
+}
+
+func TestValidEnvReferences(t *testing.T) {
+	var testCases = []struct {
+		Service  Service
+		Expected string
+	}{
+		{
+			Service{
+				EnvVars:  []EnvVar{{Name: "PORT", Value: "8080"}, {Secret: "TOKEN", Value: "token"}},
+				Commands: []string{"server", "--port=$(PORT)", "--token=$(TOKEN)", "--literal=$$(HOME)"},
+			},
+			"",
+		},
+		{
+			Service{
+				EnvVars:  []EnvVar{{Name: "PORT", Value: "8080"}},
+				Commands: []string{"server", "--port=$(PROT)"},
+			},
+			"command \"--port=$(PROT)\" refers to undeclared env vars: PROT",
+		},
+		{
+			Service{
+				EnvVars: []EnvVar{{Name: "URL", Value: "http://localhost:$(PORT)"}, {Name: "PORT", Value: "8080"}},
+			},
+			"env URL refers to undeclared or later declared env vars: PORT",
+		},
+		{
+			Service{
+				Deployment: &DeploymentSettings{Method: "bluegreen"},
+				Commands:   []string{"server", "--colour=$(POD_DEPLOYMENT_COLOUR)"},
+			},
+			"",
+		},
+		{
+			Service{
+				EnvVars:  []EnvVar{{Name: "REDIS_URL", Value: "redis://$(REDIS_SERVICE_HOST):$(REDIS_SERVICE_PORT)"}},
+				Commands: []string{"server", "--cache=$(CACHE_PORT_6379_TCP_ADDR)"},
+			},
+			"",
+		},
+		{
+			Service{
+				EnvFrom:  []EnvFromSource{{ConfigMap: "app"}},
+				EnvVars:  []EnvVar{{Name: "URL", Value: "http://localhost:$(PORT)"}},
+				Commands: []string{"run", "--port=$(PORT)"},
+			},
+			"",
+		},
+		{
+			Service{Commands: []string{"server", "--colour=$(POD_DEPLOYMENT_COLOUR)"}},
+			"command \"--colour=$(POD_DEPLOYMENT_COLOUR)\" refers to undeclared env vars: POD_DEPLOYMENT_COLOUR",
+		},
+	}
+
+	for _, tCase := range testCases {
+		err := validEnvReferences(tCase.Service)
+		if tCase.Expected == "" && err != nil {
+			t.Errorf("Unexpected env reference validation error: %s", err.Error())
+		}
+		if tCase.Expected != "" && (err == nil || err.Error() != tCase.Expected) {
+			t.Errorf("Unexpected error. Expected: %s, got: %v", tCase.Expected, err)
+		}
+	}
+}
+
+func TestValidEnvNames(t *testing.T) {
+	svc := &Service{}
+	str := `
+  name: api
+  env:
+    - name: PORT
+      value: 8080
+    - secret: TOKEN
+      value: api-token
+    - name: PORT
+      value: 9090
+  `
+	err := yaml.Unmarshal([]byte(str), svc)
+	expected := "service.env PORT is declared more than once for service api"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Unexpected error. Expected: %s, got: %v", expected, err)
+	}
+
+	svc = &Service{}
+	str = `
+  name: api
+  env:
+    - name: TOKEN
+      value: abc
+    - secret: TOKEN
+      value: api-token
+  `
+	err = yaml.Unmarshal([]byte(str), svc)
+	expected = "service.env TOKEN is declared more than once for service api"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Unexpected error. Expected: %s, got: %v", expected, err)
+	}
+}
+
+func TestSortEnvVars(t *testing.T) {
+	vars := []EnvVar{
+		{Name: "URL", Value: "http://$(HOST):$(PORT)"},
+		{Name: "PORT", Value: "8080"},
+		{Secret: "TOKEN", Value: "token"},
+		{Name: "ADDR", Value: "$(URL)/api"},
+		{Name: "HOST", Value: "localhost"},
+	}
+	sortEnvVars(vars)
+
+	var names []string
+	for _, e := range vars {
+		names = append(names, e.envName())
+	}
+	expected := []string{"HOST", "PORT", "TOKEN", "URL", "ADDR"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Unexpected env order. Expected: %v, got: %v", expected, names)
+	}
+}
+
+func TestValidEnvironmentDependencies(t *testing.T) {
+	services := Services{
+		{Name: "db", Environments: []string{"dev", "prod"}},
+		{Name: "api", DependsOn: []string{"db"}, Environments: []string{"prod"}},
+		{Name: "cache"},
+		{Name: "worker", DependsOn: []string{"cache"}},
+	}
+	if err := validEnvironmentDependencies(services); err != nil {
+		t.Errorf("Unexpected environment dependency validation error: %v", err)
+	}
+
+	services[1].Environments = []string{"prod", "staging"}
+	expected := "service api depends on db, which is not deployed in environment staging"
+	if err := validEnvironmentDependencies(services); err == nil || err.Error() != expected {
+		t.Errorf("Unexpected error. Expected: %s, got: %v", expected, err)
+	}
+
+	services[1].Environments = nil
+	expected = "service api depends on db, which is only deployed in environments dev,prod"
+	if err := validEnvironmentDependencies(services); err == nil || err.Error() != expected {
+		t.Errorf("Unexpected error. Expected: %s, got: %v", expected, err)
+	}
+}
+
+func TestValidMeshAnnotations(t *testing.T) {
+	var testCases = []struct {
+		Value interface{}
+		Valid bool
+	}{
+		{map[string]string{"sidecar.istio.io/inject": "false"}, true},
+		{map[string]string{"sidecar.istio.io/inject": "no"}, false},
+		{map[string]string{"linkerd.io/inject": "enabled"}, true},
+		{map[string]string{"linkerd.io/inject": "true"}, false},
+		{map[string]string{"traffic.sidecar.istio.io/excludeInboundPorts": "9090,8000-8010"}, true},
+		{map[string]string{"traffic.sidecar.istio.io/includeInboundPorts": "*"}, true},
+		{map[string]string{"config.linkerd.io/skip-outbound-ports": "http"}, false},
+		{map[string]string{"random_annotation": "ok_value"}, true},
+		{map[string]string{}, true},
+	}
+
+	for _, tCase := range testCases {
+		err := validMeshAnnotations(tCase.Value, "")
+		if tCase.Valid && err != nil {
+			t.Errorf("Unexpected mesh annotations validation error for %v: %v", tCase.Value, err)
+		}
+		if !tCase.Valid && err == nil {
+			t.Errorf("Expected mesh annotations validation error for %v, got nil", tCase.Value)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package bitesize
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// warned holds warnings logged while loading config, so that they are not
+// repeated every time the operator loads config that didn't change
+var warned = struct {
+	sync.Mutex
+	messages map[string]bool
+}{messages: map[string]bool{}}
+
+// warnOnce logs a warning unless the same warning was logged before
+func warnOnce(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	warned.Lock()
+	defer warned.Unlock()
+	if warned.messages[msg] {
+		return
+	}
+	warned.messages[msg] = true
+	log.Warn(msg)
+}
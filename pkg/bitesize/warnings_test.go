@@ -0,0 +1,51 @@
+package bitesize
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestWarnOncePerConfig(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	cfg := `
+name: grpc
+port: 50051
+readiness_probe:
+  handler:
+    grpc:
+      port: 50051
+`
+	for i := 0; i < 3; i++ {
+		var s Service
+		if err := yaml.Unmarshal([]byte(cfg), &s); err != nil {
+			t.Fatalf("Unexpected error loading service: %s", err.Error())
+		}
+	}
+
+	if n := strings.Count(out.String(), "gRPC probes are not supported"); n != 1 {
+		t.Errorf("Expected warning to be logged once, got %d times:\n%s", n, out.String())
+	}
+
+	out.Reset()
+	for _, cfg := range []string{"name: node-agent\nhost_network: true\n", "name: node-agent\nhost_network: true\n", "name: node-agent\nhost_network: true\nhost_pid: true\n"} {
+		var s Service
+		if err := yaml.Unmarshal([]byte(cfg), &s); err != nil {
+			t.Fatalf("Unexpected error loading service: %s", err.Error())
+		}
+	}
+
+	// changed config is warned about again
+	for _, s := range []string{"host_network give pods", "host_network, host_pid give pods"} {
+		if n := strings.Count(out.String(), s); n != 1 {
+			t.Errorf("Expected %q to be logged once, got %d times:\n%s", s, n, out.String())
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// waitForPromotion blocks switching blue/green parent service to a new
+// colour until the colour's deployment is available (all replicas, unless
+// ready_threshold is set). Error is returned
+// once promotion timeout is reached, in which case traffic stays on the
+// currently active colour.
+func waitForPromotion(service *bitesize.Service, client *k8s.Client) error {
+	current, err := client.Service().Get(service.Name)
+	if err != nil {
+		// parent service is created for the first time
+		return nil
+	}
+
+	active := service.ActiveDeploymentTag().String()
+	previous := current.Annotations["deployment_active"]
+	if previous == "" || previous == active {
+		return nil
+	}
+
+	timeout := time.Duration(config.Env.BlueGreenPromotionTimeout) * time.Second
+	if service.Deployment.PromotionTimeout != 0 {
+		timeout = time.Duration(service.Deployment.PromotionTimeout) * time.Second
+	}
+
+	name := service.ActiveDeploymentName()
+	log.Infof("waiting for %s to become available before promoting %s from %s to %s", name, service.Name, previous, active)
+	err = wait.PollImmediate(dependencyPollInterval, timeout, func() (bool, error) {
+		return client.Deployment().ReadyThreshold(name, service.ReadyThreshold), nil
+	})
+	if err != nil {
+		return fmt.Errorf("aborted promotion of %s to %s, keeping %s: deployment %s is not available after %s",
+			service.Name, active, previous, name, timeout)
+	}
+	log.Infof("promoting %s from %s to %s", service.Name, previous, active)
+	return nil
+}
+
+// blueGreenParentsLast moves blue/green parent services behind the rest, so
+// a colour deployed in the same run is applied before traffic is switched
+// to it. Parents other services depend on are moved right behind their
+// colours instead, keeping them ahead of their dependents
+func blueGreenParentsLast(services bitesize.Services) bitesize.Services {
+	dependedOn := map[string]bool{}
+	for _, service := range services {
+		for _, name := range service.DependsOn {
+			dependedOn[name] = true
+		}
+	}
+
+	added := map[string]bool{}
+	var retval, parents bitesize.Services
+	add := func(service bitesize.Service) {
+		if !added[service.Name] {
+			added[service.Name] = true
+			retval = append(retval, service)
+		}
+	}
+
+	for _, service := range services {
+		switch {
+		case !service.IsBlueGreenParentDeployment():
+			add(service)
+		case !dependedOn[service.Name]:
+			parents = append(parents, service)
+		default:
+			for _, colour := range []bitesize.BlueGreenServiceSet{bitesize.BlueService, bitesize.GreenService} {
+				if child := services.FindByName(service.Name + "-" + colour.String()); child != nil {
+					add(*child)
+				}
+			}
+			add(service)
+		}
+	}
+	return append(retval, parents...)
+}
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	apps_v1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func blueGreenParent(active bitesize.BlueGreenServiceSet) *bitesize.Service {
+	return &bitesize.Service{
+		Name: "app",
+		Deployment: &bitesize.DeploymentSettings{
+			Method:           "bluegreen",
+			BlueGreen:        &bitesize.BlueGreenSettings{Active: &active},
+			PromotionTimeout: 1,
+		},
+	}
+}
+
+func TestWaitForPromotion(t *testing.T) {
+	dependencyPollInterval = 10 * time.Millisecond
+	defer func() { dependencyPollInterval = 5 * time.Second }()
+
+	clientset := fake.NewSimpleClientset(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "app",
+				Namespace:   "sample",
+				Annotations: map[string]string{"deployment_active": "blue"},
+			},
+		},
+		&apps_v1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-green", Namespace: "sample"},
+		},
+	)
+	client := &k8s.Client{Interface: clientset, Namespace: "sample"}
+
+	if err := waitForPromotion(blueGreenParent(bitesize.BlueService), client); err != nil {
+		t.Errorf("Expected no wait without colour switch, got %s", err.Error())
+	}
+
+	err := waitForPromotion(blueGreenParent(bitesize.GreenService), client)
+	if err == nil || !strings.HasPrefix(err.Error(), "aborted promotion of app to green, keeping blue") {
+		t.Errorf("Expected promotion to be aborted, got %v", err)
+	}
+
+	deployment, _ := clientset.AppsV1().Deployments("sample").Get("app-green", metav1.GetOptions{})
+	deployment.Status.UpdatedReplicas = 1
+	deployment.Status.AvailableReplicas = 1
+	clientset.AppsV1().Deployments("sample").UpdateStatus(deployment)
+
+	if err := waitForPromotion(blueGreenParent(bitesize.GreenService), client); err != nil {
+		t.Errorf("Expected promotion once green is available, got %s", err.Error())
+	}
+}
+
+func TestBlueGreenParentsLast(t *testing.T) {
+	services := bitesize.Services{
+		*blueGreenParent(bitesize.BlueService),
+		{Name: "app-blue"},
+		{Name: "app-green"},
+	}
+
+	sorted := blueGreenParentsLast(services)
+	if sorted[2].Name != "app" || sorted[0].Name != "app-blue" || sorted[1].Name != "app-green" {
+		t.Errorf("Expected parent to be applied last, got %s, %s, %s", sorted[0].Name, sorted[1].Name, sorted[2].Name)
+	}
+
+	services = bitesize.Services{
+		*blueGreenParent(bitesize.BlueService),
+		{Name: "worker", DependsOn: []string{"app"}},
+		{Name: "app-blue"},
+		{Name: "app-green"},
+	}
+
+	sorted = blueGreenParentsLast(services)
+	var names []string
+	for _, s := range sorted {
+		names = append(names, s.Name)
+	}
+	expected := []string{"app-blue", "app-green", "app", "worker"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected colours, parent and its dependents in order %v, got %v", expected, names)
+	}
+}
+
+func TestApplyBlueGreenParent(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+	)
+	cluster := Cluster{Interface: clientset, CRDClient: loadEmptyCRDs()}
+
+	service := blueGreenParent(bitesize.BlueService)
+	service.Ports = []int{80}
+	if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err != nil {
+		t.Fatalf("Unexpected error applying blue/green parent: %s", err.Error())
+	}
+
+	svc, err := clientset.CoreV1().Services("sample").Get("app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected parent service to be applied: %s", err.Error())
+	}
+	if svc.Spec.Selector["name"] != "app-blue" {
+		t.Errorf("Expected parent service to select app-blue, got %s", svc.Spec.Selector["name"])
+	}
+	if _, err := clientset.AppsV1().Deployments("sample").Get("app", metav1.GetOptions{}); err == nil {
+		t.Error("Expected no deployment for blue/green parent")
+	}
+}
@@ -4,23 +4,28 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"github.com/pearsontechnology/environment-operator/pkg/diff"
 	"github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
 	"github.com/pearsontechnology/environment-operator/pkg/translator"
 	"github.com/pearsontechnology/environment-operator/pkg/util"
 	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
 // Client returns default in-cluster kubernetes client
 func Client() (*Cluster, error) {
-	restConfig, err := rest.InClusterConfig()
+	restConfig, err := k8s.RestConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -41,10 +46,20 @@ func Client() (*Cluster, error) {
 // the current client environment. If there are any changes, c is applied
 // to the current config
 func (cluster *Cluster) ApplyIfChanged(newConfig *bitesize.Environment) error {
+	return cluster.ApplyIfChangedOn(newConfig, bitesize.TriggerPoll)
+}
+
+// ApplyIfChangedOn is ApplyIfChanged for a reconcile started by trigger.
+// Changed services not reconciled on the trigger are left as they are
+func (cluster *Cluster) ApplyIfChangedOn(newConfig *bitesize.Environment, trigger string) error {
 	var err error
 	if newConfig == nil {
 		return errors.New("could not compare against config (nil)")
 	}
+	if err = NamespaceAllowed(newConfig.Namespace); err != nil {
+		log.Error(err.Error())
+		return err
+	}
 
 	log.Debugf("loading namespace: %s", newConfig.Namespace)
 	currentConfig, err := cluster.ScrapeResourcesForNamespace(newConfig.Namespace)
@@ -53,70 +68,272 @@ func (cluster *Cluster) ApplyIfChanged(newConfig *bitesize.Environment) error {
 		log.Errorf("error while loading environment: %s", err.Error())
 		return err
 	}
-	if diff.Compare(*newConfig, *currentConfig) {
-		util.LogTraceAsYaml("ApplyIfChanged newConfig", newConfig)
+
+	// services not deployed in namespace's environment are skipped
+	desired := *newConfig
+	desired.Services = newConfig.Services.ForEnvironment(currentConfig.Name)
+
+	if diff.Compare(desired, *currentConfig) {
+		util.LogTraceAsYaml("ApplyIfChanged newConfig", desired)
 		util.LogTraceAsYaml("ApplyIfChanged currentConfig", currentConfig)
-		err = cluster.ApplyEnvironment(currentConfig, newConfig)
+		err = cluster.applyEnvironment(currentConfig, &desired, trigger)
+	}
+
+	// manifests are not part of the compared model and are applied on
+	// every run, unchanged objects are skipped by the client
+	if e := cluster.ApplyManifests(newConfig); e != nil && err == nil {
+		err = e
 	}
 
 	return err
 }
 
+// Diff returns changes, keyed by service name, that ApplyIfChanged would
+// apply to the namespace of newConfig, without applying them
+func (cluster *Cluster) Diff(newConfig *bitesize.Environment) (map[string]string, error) {
+	if newConfig == nil {
+		return nil, errors.New("could not compare against config (nil)")
+	}
+
+	currentConfig, err := cluster.ScrapeResourcesForNamespace(newConfig.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := *newConfig
+	desired.Services = newConfig.Services.ForEnvironment(currentConfig.Name)
+
+	return diff.Diff(desired, *currentConfig), nil
+}
+
+// SyncService compares a single service of newConfig against the cluster and
+// applies only that service if it changed, regardless of its reconcile mode.
+// Other services of the environment are neither compared nor applied. The
+// change applied is returned, empty if the service was already up to date
+func (cluster *Cluster) SyncService(newConfig *bitesize.Environment, name string) (string, error) {
+	if newConfig == nil {
+		return "", errors.New("could not compare against config (nil)")
+	}
+
+	currentConfig, err := cluster.ScrapeResourcesForNamespace(newConfig.Namespace)
+	if err != nil {
+		return "", err
+	}
+
+	service := newConfig.Services.ForEnvironment(currentConfig.Name).FindByName(name)
+	if service == nil {
+		return "", fmt.Errorf("service %s not found in environment %s", name, currentConfig.Name)
+	}
+
+	desired := *newConfig
+	desired.Services = bitesize.Services{*service}
+
+	change, changed := diff.Diff(desired, *currentConfig)[name]
+	if !changed {
+		log.Infof("service %s: no changes to sync", name)
+		return "", nil
+	}
+
+	log.Infof("service %s: syncing changes", name)
+	return change, cluster.applyEnvironmentService(currentConfig, newConfig, *service)
+}
+
+// ApplyManifests applies objects of manifest gists verbatim and prunes
+// objects removed from the manifests since they were last applied
+func (cluster *Cluster) ApplyManifests(env *bitesize.Environment) error {
+	client := &k8s.Client{
+		Namespace: env.Namespace,
+		Interface: cluster.Interface,
+	}
+	var failed []string
+
+	for _, gist := range env.Gists.FindByType(bitesize.TypeManifest) {
+		manifests := client.Manifest()
+
+		previous, err := manifests.Inventory(gist.Name)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", gist.Name, err.Error()))
+			continue
+		}
+
+		applied := true
+		for i := range gist.Manifests {
+			obj := &gist.Manifests[i]
+			log.Debugf("applying manifest %s %s/%s", gist.Name, obj.GetKind(), obj.GetName())
+			if err := manifests.Apply(obj); err != nil {
+				applied = false
+				failed = append(failed, fmt.Sprintf("%s: %s %s: %s", gist.Name, obj.GetKind(), obj.GetName(), err.Error()))
+			}
+		}
+		// keep previous inventory so that nothing is pruned on failure
+		if !applied {
+			continue
+		}
+
+		for _, obj := range previous {
+			if containsManifest(gist.Manifests, obj) {
+				continue
+			}
+			log.Infof("pruning manifest %s %s/%s", gist.Name, obj.GetKind(), obj.GetName())
+			if err := manifests.Destroy(&obj, k8s.DeleteOptionsFor("manifest")); err != nil {
+				log.Errorf("failed to prune %s %s: %s", obj.GetKind(), obj.GetName(), err.Error())
+			}
+		}
+
+		if err := manifests.SaveInventory(gist.Name, gist.Manifests); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", gist.Name, err.Error()))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to apply manifests: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
 // ApplyEnvironment executes kubectl apply against ingresses, services, deployments
-// etc.
+// etc. Services are applied concurrently through the shared scheduler, in
+// depends_on order, and a service is only applied once the services it
+// depends on are ready.
 func (cluster *Cluster) ApplyEnvironment(currentEnvironment, newEnvironment *bitesize.Environment) error {
-	var err error
+	return cluster.applyEnvironment(currentEnvironment, newEnvironment, bitesize.TriggerPoll)
+}
+
+func (cluster *Cluster) applyEnvironment(currentEnvironment, newEnvironment *bitesize.Environment, trigger string) error {
+	var failed []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	services, err := newEnvironment.Services.SortByDependencies()
+	if err != nil {
+		return err
+	}
+	services = blueGreenParentsLast(services)
+
+	applied := map[string]chan struct{}{}
 
-	for _, service := range newEnvironment.Services {
+	for _, service := range services {
 		if !shouldDeployOnChange(currentEnvironment, newEnvironment, service.Name) {
 			continue
 		}
+		if !service.ReconciledOn(trigger) {
+			log.Infof("service %s changed, not applying %s service on %s", service.Name, service.Reconcile, trigger)
+			continue
+		}
 
-		gists := bitesize.Gists{}
-		// Load configmaps for the service
-		for _, vol := range service.Volumes {
-			if vol.IsConfigMapVolume() {
-				res := newEnvironment.Gists.FindByName(vol.Name, bitesize.TypeConfigMap)
-				if res == nil {
-					log.Warnf("could not find import source for the configmap volume %s", vol.Name)
-					continue
-				}
-				gists = append(gists, *res)
+		service := service
+		done := make(chan struct{})
+		applied[service.Name] = done
+
+		// dependencies applied in this run must finish before the service
+		// starts, as must colours of blue/green parents
+		names := service.DependsOn
+		if service.IsBlueGreenParentDeployment() {
+			names = append([]string{service.ActiveDeploymentName(), service.InactiveDeploymentName()}, names...)
+		}
+		var dependencies []chan struct{}
+		for _, name := range names {
+			if ch, ok := applied[name]; ok {
+				dependencies = append(dependencies, ch)
 			}
 		}
 
-		// Load configmaps for the init containers
-		if service.InitContainers != nil {
-			for _, containers := range *service.InitContainers {
-				for _, vol := range containers.Volumes {
-					if vol.IsConfigMapVolume() {
-						res := newEnvironment.Gists.FindByName(vol.Name, bitesize.TypeConfigMap)
-						if res == nil {
-							log.Warnf("could not find import source for the configmap volume %s", vol.Name)
-							continue
-						}
-						gists = append(gists, *res)
-					}
-				}
+		// the service waits for its dependencies outside the scheduler and
+		// is only submitted once it is ready to apply, so that waiting
+		// services don't hold workers other services and namespaces need
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done)
+
+			for _, ch := range dependencies {
+				<-ch
 			}
+
+			e := cluster.awaitService(newEnvironment, service)
+			if e == nil {
+				finished := make(chan struct{})
+				scheduler.Submit(newEnvironment.Namespace, func() {
+					defer close(finished)
+					e = cluster.applyReadyService(currentEnvironment, newEnvironment, service)
+				})
+				<-finished
+			}
+			if e != nil {
+				mu.Lock()
+				failed = append(failed, fmt.Sprintf("%s: %s", service.Name, e.Error()))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to apply services: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// applyEnvironmentService waits for a single service of the environment to
+// be ready to apply, and applies it
+func (cluster *Cluster) applyEnvironmentService(currentEnvironment, newEnvironment *bitesize.Environment, service bitesize.Service) error {
+	if err := cluster.awaitService(newEnvironment, service); err != nil {
+		return err
+	}
+	return cluster.applyReadyService(currentEnvironment, newEnvironment, service)
+}
+
+// awaitService blocks until the services the service depends on are ready
+// and, for blue/green parents, until the colour traffic is switched to is
+// available
+func (cluster *Cluster) awaitService(environment *bitesize.Environment, service bitesize.Service) (err error) {
+	defer recoverServicePanic(service.Name, &err)
+
+	if service.HasDependencies() {
+		if err := cluster.waitForDependencies(service, environment); err != nil {
+			log.Errorf("skipping service %s: %s", service.Name, err.Error())
+			return err
 		}
-		// TODO: load jobs and cronjobs
-		if service.Version == "" {
-			service.Version = currentEnvironment.Services.FindByName(service.Name).Version
+	}
+
+	if service.IsBlueGreenParentDeployment() {
+		client := &k8s.Client{Interface: cluster.Interface, Namespace: environment.Namespace}
+		if err := waitForPromotion(&service, client); err != nil {
+			log.Errorf("service %s: %s", service.Name, err.Error())
+			return err
 		}
+	}
+	return nil
+}
 
-		err = cluster.ApplyService(&service, &gists, newEnvironment.Namespace)
+// applyReadyService loads gists for a single service of the environment and
+// applies it, once awaitService returned
+func (cluster *Cluster) applyReadyService(currentEnvironment, newEnvironment *bitesize.Environment, service bitesize.Service) (err error) {
+	defer recoverServicePanic(service.Name, &err)
+
+	gists := newEnvironment.ServiceGists(service)
+	service.Revision = newEnvironment.Revision
+	// TODO: load jobs and cronjobs
+	if service.Version == "" {
+		if current := currentEnvironment.Services.FindByName(service.Name); current != nil {
+			service.Version = current.Version
+		}
 	}
-	return err
+
+	return applyWithTimeout(newEnvironment.Namespace, service.Name, applyTimeout(), func() error {
+		return cluster.ApplyService(&service, &gists, newEnvironment.Namespace)
+	})
 }
 
-// ApplyService applies a single service to the namespace
-func (cluster *Cluster) ApplyService(service *bitesize.Service, gists *bitesize.Gists, namespace string) error {
-	var err error
-	mapper := &translator.KubeMapper{
-		BiteService: service,
-		Namespace:   namespace,
-		Gists:       gists,
+// ApplyService applies a single service to the namespace. Resources of the
+// service are applied even if some of them fail, the first error is returned.
+// Resources of atomic services are restored to their prior state instead.
+// Panics while mapping or applying the service are returned as errors
+func (cluster *Cluster) ApplyService(service *bitesize.Service, gists *bitesize.Gists, namespace string) (err error) {
+	if err := NamespaceAllowed(namespace); err != nil {
+		log.Errorf("service %s: %s", service.Name, err.Error())
+		return err
 	}
 
 	client := &k8s.Client{
@@ -125,73 +342,163 @@ func (cluster *Cluster) ApplyService(service *bitesize.Service, gists *bitesize.
 		CRDClient: cluster.CRDClient,
 	}
 
+	mapper := &translator.KubeMapper{
+		BiteService: service,
+		Namespace:   namespace,
+		Gists:       gists,
+		Client:      client,
+	}
+
+	// fail logs the error and keeps the first one to be returned
+	fail := func(e error) {
+		log.Errorf("service %s: %s", service.Name, e.Error())
+		if err == nil {
+			err = e
+		}
+	}
+
+	tx := newTransaction(client, service)
+	defer func() {
+		// the panic is recovered before rolling back, atomic services are
+		// restored whether applying them failed or panicked
+		if r := recover(); r != nil {
+			err = servicePanicError(service.Name, r)
+		}
+		if err != nil && service.Atomic {
+			log.Infof("service %s failed to apply, rolling back", service.Name)
+			tx.Rollback(service.Name)
+		}
+	}()
+
 	// if no type specified, deploy:
 	//  - PersistentVolumeClaims()
 	//  - ConfigMaps()
+	//  - ExternalSecrets of env vars with external_key
 	//  - Deployment()
 	//  - Service()
 	//  - HPA()
 	//
 	// if ExternalURL is set, also deploy:
 	//  - Ingress()
+	//  - CanaryIngress() if weighted backends are set
 	//  - if ExternalSecretsEnabled
 	//     - ExternalSecrets
 	//  - If Istio enabled:
 	//     - ExternalSecret
 	//     - Gateway
 	//     - VirtualService
+	//
+	// if type is externalname, deploy:
+	//  - Service()
+	//
+	// environments wait for promotion in awaitService already, in which
+	// case this returns right away
+	if service.IsBlueGreenParentDeployment() {
+		if e := waitForPromotion(service, client); e != nil {
+			fail(e)
+			return err
+		}
+	}
+
 	if service.Type == "" {
 		log.Debugf("applying pvcs for service %s", service.Name)
-		pvc, _ := mapper.PersistentVolumeClaims()
+		pvc, e := mapper.PersistentVolumeClaims()
+		if e != nil {
+			fail(e)
+		}
 		for _, claim := range pvc {
 			log.Debugf("pvc: %s", claim.Name)
-			if err = client.PVC().Apply(&claim); err != nil {
-				log.Error(err)
+			if e := tx.PVC(&claim); e != nil {
+				fail(e)
 			}
 		}
 
 		log.Debugf("applying configmaps for service %s", service.Name)
-		cMaps, _ := mapper.ConfigMaps()
+		cMaps, e := mapper.ConfigMaps()
+		if e != nil {
+			fail(e)
+		}
 		for _, c := range cMaps {
 			log.Debugf("configmap: %s", c.Name)
-			if err = client.ConfigMap().Apply(&c); err != nil {
-				log.Error(err)
+			if e := tx.ConfigMap(&c); e != nil {
+				fail(e)
 			}
 		}
 
+		if e := applyEnvExternalSecrets(mapper, tx); e != nil {
+			fail(e)
+			return err
+		}
+
 		log.Debugf("applying deployment for service %s", service.Name)
-		deployment, err := mapper.Deployment()
-		if err != nil {
-			log.Error(err)
+		deployment, e := mapper.Deployment()
+		if e != nil {
+			fail(e)
 			return err
 		}
 
-		if err = client.Deployment().Apply(deployment); err != nil {
-			log.Error(err)
+		// blue/green parents have no deployment of their own, only the
+		// service and ingress pointing to the active colour
+		if deployment != nil {
+			if e := verifyImages(deployment, client); e != nil {
+				fail(e)
+				return err
+			}
+
+			if e := verifyConfigMaps(deployment, client); e != nil {
+				fail(e)
+				return err
+			}
+			if e := verifyEnvFrom(deployment, client); e != nil {
+				fail(e)
+				return err
+			}
+
+			stampDeployEvent(deployment, service)
+			if e := tx.Deployment(deployment); e != nil {
+				fail(e)
+			}
 		}
 
-		svc, _ := mapper.Service()
-		if err = client.Service().Apply(svc); err != nil {
-			log.Error(err)
+		if svc, e := mapper.Service(); e != nil {
+			fail(e)
+		} else if e := tx.Service(svc); e != nil {
+			fail(e)
 			log.Debugf("service +%v", svc)
 		}
 
-		hpa, _ := mapper.HPA()
-		if err = client.HorizontalPodAutoscaler().Apply(hpa); err != nil {
-			log.Error(err)
+		if hpa, e := mapper.HPA(); e != nil {
+			fail(e)
+		} else if e := tx.HPA(hpa); e != nil {
+			fail(e)
+		}
+
+		if e := applyServiceMonitor(mapper, tx); e != nil {
+			fail(e)
 		}
 
-		if service.HasExternalURL() {
+		if service.HasExternalURL() && !ingressBackendReady(service, client) {
+			// ingress is applied on one of the next runs, once diff picks
+			// up the missing external_url
+			log.Infof("deferring ingress for service %s until its deployment is ready", service.Name)
+		} else if service.HasExternalURL() {
 
 			log.Debugf("applying ingress for service %s", service.Name)
-			ingress, _ := mapper.Ingress()
-			if err = client.Ingress().Apply(ingress); err != nil {
-				log.Error(err)
+			if ingress, e := mapper.Ingress(); e != nil {
+				fail(e)
+			} else if e := tx.Ingress(ingress); e != nil {
+				fail(e)
+			}
+
+			if canary, e := mapper.CanaryIngress(); e != nil {
+				fail(e)
+			} else if e := tx.Ingress(canary); e != nil {
+				fail(e)
 			}
 
 			if k8s.ExternalSecretsEnabled {
 				log.Debugf("applying external secret for ingress %s", service.Name)
-				if err := createExternalSecret(mapper, *client, ""); err != nil {
+				if err := createExternalSecret(mapper, tx, ""); err != nil {
 					log.Error("Failed to create ExternalSecret")
 				}
 			}
@@ -199,50 +506,67 @@ func (cluster *Cluster) ApplyService(service *bitesize.Service, gists *bitesize.
 			if service.IsServiceMeshEnabled() {
 
 				if k8s.ExternalSecretsEnabled {
-					if err := createExternalSecret(mapper, *client, "istio-system"); err != nil {
+					if err := createExternalSecret(mapper, tx, "istio-system"); err != nil {
 						log.Error("Failed to create ExternalSecret")
 					}
 				}
 
-				client.CRDClient, err = k8s.CRDClient(&schema.GroupVersion{
+				client.CRDClient, e = k8s.CRDClient(&schema.GroupVersion{
 					Group:   "networking.istio.io",
 					Version: "v1alpha3",
 				})
 
-				if err != nil {
-					log.Fatalf("Error creating kubernetes client for ServiceMesh use: %s", err.Error())
+				if e != nil {
+					fail(fmt.Errorf("error creating kubernetes client for ServiceMesh use: %s", e.Error()))
+					return err
 				}
 
-				gateway, _ := mapper.ServiceMeshGateway()
-				if err = client.CustomResourceDefinition("Gateway").Apply(gateway); err != nil {
-					log.Error(err)
+				if gateway, e := mapper.ServiceMeshGateway(); e != nil {
+					fail(e)
+				} else if e := tx.CustomResource("Gateway", gateway); e != nil {
+					fail(e)
 				} else {
 					log.Infof("Successfully updated Gateway CRD resource: %s", gateway.Name)
 				}
 
-				virtualService, _ := mapper.ServiceMeshVirtualService()
-				if err = client.CustomResourceDefinition("VirtualService").Apply(virtualService); err != nil {
-					log.Error(err)
+				if virtualService, e := mapper.ServiceMeshVirtualService(); e != nil {
+					fail(e)
+				} else if e := tx.CustomResource("VirtualService", virtualService); e != nil {
+					fail(e)
 				} else {
-					log.Infof("Successfully updated VirtualService CRD resource: %s", gateway.Name)
+					log.Infof("Successfully updated VirtualService CRD resource: %s", virtualService.Name)
 				}
 			}
 		}
+	} else if service.IsExternalName() {
+		log.Debugf("applying external name service %s", service.Name)
+		if svc, e := mapper.Service(); e != nil {
+			fail(e)
+		} else if e := tx.Service(svc); e != nil {
+			fail(e)
+		}
 		// Deploy CRD resource
 	} else {
-		crd, _ := mapper.CustomResourceDefinition()
+		crd, e := mapper.CustomResourceDefinition()
+		if e != nil {
+			fail(e)
+			return err
+		}
 
-		client.CRDClient, err = k8s.CRDClient(&schema.GroupVersion{
-			Group:   strings.Split(crd.TypeMeta.APIVersion, "/")[0],
-			Version: strings.Split(crd.TypeMeta.APIVersion, "/")[1],
-		})
+		gv, e := schema.ParseGroupVersion(crd.TypeMeta.APIVersion)
+		if e != nil || gv.Group == "" {
+			fail(fmt.Errorf("invalid apiVersion %q of custom resource %s", crd.TypeMeta.APIVersion, crd.Name))
+			return err
+		}
 
-		if err != nil {
-			log.Fatalf("Error creating kubernetes client: %s", err.Error())
+		client.CRDClient, e = k8s.CRDClient(&gv)
+		if e != nil {
+			fail(fmt.Errorf("error creating kubernetes client: %s", e.Error()))
+			return err
 		}
 
-		if err = client.CustomResourceDefinition(crd.Kind).Apply(crd); err != nil {
-			log.Error(err)
+		if e := tx.CustomResource(crd.Kind, crd); e != nil {
+			fail(e)
 		} else {
 			log.Infof("successfully updated CRD resource: %s", crd.Name)
 		}
@@ -250,8 +574,100 @@ func (cluster *Cluster) ApplyService(service *bitesize.Service, gists *bitesize.
 	return err
 }
 
-// LoadPods returns Pod object loaded from Kubernetes API
-func (cluster *Cluster) LoadPods(namespace string) ([]bitesize.Pod, error) {
+// recoverServicePanic converts a panic while applying a service into an
+// error, so one broken service doesn't take down the whole reconcile
+func recoverServicePanic(name string, err *error) {
+	if r := recover(); r != nil {
+		*err = servicePanicError(name, r)
+	}
+}
+
+// servicePanicError logs recovered panic r with its stack and returns it as
+// an error
+func servicePanicError(name string, r interface{}) error {
+	log.Errorf("recovered from panic applying service %s: %v\n%s", name, r, debug.Stack())
+	return fmt.Errorf("panic: %v", r)
+}
+
+// applyServiceMonitor applies ServiceMonitor of the service, or removes it
+// once service_monitor is unset. Nothing is done if Prometheus Operator is
+// not installed
+func applyServiceMonitor(mapper *translator.KubeMapper, tx *transaction) error {
+	monitor, err := mapper.ServiceMonitor()
+	if err != nil {
+		return err
+	}
+
+	if !tx.client.Manifest().Served(k8s.ServiceMonitorAPIVersion, "ServiceMonitor") {
+		if monitor != nil {
+			log.Warnf("service %s: ServiceMonitor is not served by the cluster, skipping service_monitor", mapper.BiteService.Name)
+		}
+		return nil
+	}
+
+	if monitor == nil {
+		identity := &unstructured.Unstructured{}
+		identity.SetAPIVersion(k8s.ServiceMonitorAPIVersion)
+		identity.SetKind("ServiceMonitor")
+		identity.SetName(mapper.BiteService.Name)
+		return tx.DestroyManifest(identity)
+	}
+	log.Debugf("applying service monitor for service %s", mapper.BiteService.Name)
+	return tx.Manifest(monitor)
+}
+
+// ingressBackendReady returns false if service asks for its ingress to wait
+// for ready pods and its deployment has fewer than ready_threshold available
+func ingressBackendReady(service *bitesize.Service, client *k8s.Client) bool {
+	if !service.IngressWaitReady {
+		return true
+	}
+
+	name := service.Name
+	if service.IsBlueGreenParentDeployment() {
+		name = service.ActiveDeploymentName()
+	}
+	return client.Deployment().ReadyThreshold(name, service.ReadyThreshold)
+}
+
+// RestartService performs a rolling restart of service pods without changing
+// its configuration. Both blue and green deployments are restarted for
+// blue/green services.
+func (cluster *Cluster) RestartService(service *bitesize.Service, namespace string) error {
+	client := &k8s.Client{
+		Interface: cluster.Interface,
+		Namespace: namespace,
+	}
+
+	if service.Type != "" {
+		return fmt.Errorf("service %s of type %s has no pods to restart", service.Name, service.Type)
+	}
+
+	names := []string{service.Name}
+	if service.IsBlueGreenParentDeployment() {
+		names = []string{
+			fmt.Sprintf("%s-%s", service.Name, bitesize.BlueService),
+			fmt.Sprintf("%s-%s", service.Name, bitesize.GreenService),
+		}
+	}
+
+	for _, name := range names {
+		if !client.Deployment().Exist(name) {
+			continue
+		}
+		log.Infof("restarting deployment %s", name)
+		if err := client.Deployment().Restart(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadPods returns Pod object loaded from Kubernetes API, with logs of the
+// given container. Logs of the main service container are returned if
+// container is empty. If previous is set, logs of the container instance
+// that last terminated are returned, e.g. to see why it crashed
+func (cluster *Cluster) LoadPods(namespace, container string, previous bool) ([]bitesize.Pod, error) {
 	client := &k8s.Client{
 		Namespace: namespace,
 		Interface: cluster.Interface,
@@ -265,24 +681,48 @@ func (cluster *Cluster) LoadPods(namespace string) ([]bitesize.Pod, error) {
 	}
 
 	for _, pod := range pods {
-		logs, err := client.Pod().GetLogs(pod.ObjectMeta.Name)
-		message := ""
-		if err != nil {
+		var logs, message string
+		name := logsContainer(pod, container)
+		if name == "" {
+			message = fmt.Sprintf("Error retrieving Pod Logs: container %s not found", container)
+		} else if logs, err = client.Pod().GetLogs(pod.ObjectMeta.Name, name, previous); err != nil {
 			message = fmt.Sprintf("Error retrieving Pod Logs: %s", err.Error())
-
 		}
 		podval := bitesize.Pod{
 			Name:      pod.ObjectMeta.Name,
 			Phase:     pod.Status.Phase,
 			StartTime: pod.Status.StartTime.String(),
+			ImageID:   imageID(pod),
 			Message:   message,
 			Logs:      logs,
+
+			Containers:     containerStatuses(pod.Status.ContainerStatuses),
+			InitContainers: containerStatuses(pod.Status.InitContainerStatuses),
 		}
 		deployedPods = append(deployedPods, podval)
 	}
 	return deployedPods, err
 }
 
+// NamespaceEnvironment returns environment namespace is labelled with,
+// falling back to the configured ENVIRONMENT_NAME if the label is absent
+func (cluster *Cluster) NamespaceEnvironment(namespace string) (string, error) {
+	client := &k8s.Client{
+		Namespace: namespace,
+		Interface: cluster.Interface,
+	}
+
+	ns, err := client.Ns().Get()
+	if err != nil {
+		return "", fmt.Errorf("error while retrieving namespace: %s", err.Error())
+	}
+	if name := ns.ObjectMeta.Labels["environment"]; name != "" {
+		return name, nil
+	}
+	log.Warnf("namespace %s has no environment label, using configured environment name %q", namespace, config.Env.EnvName)
+	return config.Env.EnvName, nil
+}
+
 // ScrapeResourcesForNamespace returns BitesizeEnvironment object loaded from Kubernetes API
 func (cluster *Cluster) ScrapeResourcesForNamespace(namespace string) (*bitesize.Environment, error) {
 	serviceMap := make(ServiceMap)
@@ -293,11 +733,10 @@ func (cluster *Cluster) ScrapeResourcesForNamespace(namespace string) (*bitesize
 		CRDClient: cluster.CRDClient,
 	}
 
-	ns, err := client.Ns().Get()
+	environmentName, err := cluster.NamespaceEnvironment(namespace)
 	if err != nil {
-		return nil, fmt.Errorf("error while retrieving namespace: %s", err.Error())
+		return nil, err
 	}
-	environmentName := ns.ObjectMeta.Labels["environment"]
 
 	services, err := client.Service().List()
 	if err != nil {
@@ -315,6 +754,24 @@ func (cluster *Cluster) ScrapeResourcesForNamespace(namespace string) (*bitesize
 		serviceMap.AddDeployment(deployment)
 	}
 
+	pods, err := client.Pod().List()
+	if err != nil {
+		log.Errorf("error loading kubernetes pods: %s", err.Error())
+	}
+	for _, pod := range pods {
+		serviceMap.AddPod(pod)
+	}
+
+	if k8s.ExternalSecretsEnabled {
+		secrets, err := listEnvExternalSecrets(namespace)
+		if err != nil {
+			log.Errorf("error loading external secrets: %s", err.Error())
+		}
+		for _, es := range secrets {
+			serviceMap.AddExternalSecret(es)
+		}
+	}
+
 	hpas, err := client.HorizontalPodAutoscaler().List()
 	if err != nil {
 		log.Errorf("error loading kubernetes hpas: %s", err.Error())
@@ -352,11 +809,12 @@ func (cluster *Cluster) ScrapeResourcesForNamespace(namespace string) (*bitesize
 			}
 		}
 
-		for _, supported := range k8_extensions.SupportedCustomResources {
-			crds, _ := client.CustomResourceDefinition(supported).List()
-			for _, crd := range crds {
-				serviceMap.AddCustomResourceDefinition(crd)
-			}
+		crds, err := listCustomResources(client)
+		if err != nil {
+			log.Errorf("error loading %s custom resources: %s", apis, err.Error())
+		}
+		for _, crd := range crds {
+			serviceMap.AddCustomResourceDefinition(crd)
 		}
 	}
 
@@ -378,6 +836,50 @@ func (cluster *Cluster) ScrapeResourcesForNamespace(namespace string) (*bitesize
 	return &bitesizeConfig, nil
 }
 
+// listCustomResources lists all supported custom resource kinds of client's
+// CRDClient, CUSTOM_RESOURCE_CONCURRENCY kinds at a time. Resources are
+// returned in the order of kinds. Kinds not installed in the cluster are
+// skipped, errors listing other kinds are returned together
+func listCustomResources(client *k8s.Client) ([]k8_extensions.PrsnExternalResource, error) {
+	kinds := k8_extensions.SupportedCustomResources
+	results := make([][]k8_extensions.PrsnExternalResource, len(kinds))
+	errs := make([]error, len(kinds))
+
+	max := config.Env.CustomResourceConcurrency
+	if max < 1 {
+		max = 1
+	}
+	sem := make(chan struct{}, max)
+
+	var wg sync.WaitGroup
+	for i, kind := range kinds {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, kind string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = client.CustomResourceDefinition(kind).List()
+		}(i, kind)
+	}
+	wg.Wait()
+
+	var retval []k8_extensions.PrsnExternalResource
+	var failed []string
+	for i, kind := range kinds {
+		if errs[i] != nil {
+			if !k8serrors.IsNotFound(errs[i]) {
+				failed = append(failed, fmt.Sprintf("%s: %s", kind, errs[i].Error()))
+			}
+			continue
+		}
+		retval = append(retval, results[i]...)
+	}
+	if len(failed) > 0 {
+		return retval, errors.New(strings.Join(failed, "; "))
+	}
+	return retval, nil
+}
+
 // Only deploy k8s resources when the environment was actually deployed and
 // changed or if the service has specified a version
 func shouldDeployOnChange(currentEnvironment, newEnvironment *bitesize.Environment, serviceName string) bool {
@@ -408,10 +910,15 @@ func shouldDeployOnChange(currentEnvironment, newEnvironment *bitesize.Environme
 		log.Tracef("Service has specified a version")
 		return true
 	}
+
+	if updatedService.IsExternalName() {
+		log.Tracef("Service is an external name alias")
+		return true
+	}
 	return false
 }
 
-func createExternalSecret(mapper *translator.KubeMapper, client k8s.Client, ns string) error {
+func createExternalSecret(mapper *translator.KubeMapper, tx *transaction, ns string) error {
 
 	es, err := mapper.ExternalSecretTLS()
 	if err != nil {
@@ -419,6 +926,7 @@ func createExternalSecret(mapper *translator.KubeMapper, client k8s.Client, ns s
 		return err
 	}
 
+	client := *tx.client
 	if ns != "" {
 		es.Namespace = ns
 		client.Namespace = ns
@@ -434,8 +942,8 @@ func createExternalSecret(mapper *translator.KubeMapper, client k8s.Client, ns s
 		return err
 	}
 
-	if err = client.ExternalSecret().Apply(es); err != nil {
-		log.Fatalf("Error creating external secret CRD for ingress: %s", err.Error())
+	if err = tx.ExternalSecret(client.ExternalSecret(), es); err != nil {
+		log.Errorf("Error creating external secret CRD for ingress: %s", err.Error())
 		return err
 	} else {
 		log.Infof("Successfully updated ExternalSecret CRD resource: %s", es.Name)
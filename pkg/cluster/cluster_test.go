@@ -1,14 +1,22 @@
 package cluster
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"github.com/pearsontechnology/environment-operator/pkg/diff"
 	ext "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
 	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 	fakecrd "github.com/pearsontechnology/environment-operator/pkg/util/k8s/fake"
 	apps_v1 "k8s.io/api/apps/v1"
 	autoscale_v2beta2 "k8s.io/api/autoscaling/v2beta2"
@@ -16,8 +24,11 @@ import (
 	netwk_v1beta1 "k8s.io/api/networking/v1beta1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
-	fakerest "k8s.io/client-go/rest/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 // func init() {
@@ -152,6 +163,93 @@ func TestApplyIfChanged(t *testing.T) {
 	runningCluster.ApplyIfChanged(envFromConfigFile)
 }
 
+func TestApplyIfChangedSkipsServicesNotInEnvironment(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "sample",
+				Labels: map[string]string{"environment": "prod"},
+			},
+		},
+	)
+	runningCluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	api := bitesize.ServiceWithDefaults()
+	api.Name = "api"
+	api.Application = "api"
+	api.Version = "1"
+
+	toolbox := bitesize.ServiceWithDefaults()
+	toolbox.Name = "toolbox"
+	toolbox.Application = "toolbox"
+	toolbox.Version = "1"
+	toolbox.Environments = []string{"dev"}
+
+	desired := &bitesize.Environment{
+		Name:      "prod",
+		Namespace: "sample",
+		Services:  bitesize.Services{*api, *toolbox},
+	}
+	if err := runningCluster.ApplyIfChanged(desired); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	if _, err := client.AppsV1().Deployments("sample").Get("api", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected api to be applied: %s", err.Error())
+	}
+	if _, err := client.AppsV1().Deployments("sample").Get("toolbox", metav1.GetOptions{}); err == nil {
+		t.Error("Expected toolbox to be skipped outside dev")
+	}
+}
+
+func TestApplyIfChangedOnReconcileMode(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+	)
+	runningCluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	var services bitesize.Services
+	for _, mode := range []string{"", bitesize.ReconcilePoll, bitesize.ReconcileManual} {
+		s := bitesize.ServiceWithDefaults()
+		s.Name = "svc-" + mode
+		s.Application = s.Name
+		s.Version = "1"
+		s.Reconcile = mode
+		services = append(services, *s)
+	}
+	desired := &bitesize.Environment{Name: "dev", Namespace: "sample", Services: services}
+
+	deployed := func() []string {
+		var names []string
+		list, _ := client.AppsV1().Deployments("sample").List(metav1.ListOptions{})
+		for _, d := range list.Items {
+			names = append(names, d.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if err := runningCluster.ApplyIfChangedOn(desired, bitesize.TriggerChange); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if got := deployed(); !reflect.DeepEqual(got, []string{"svc-"}) {
+		t.Errorf("Expected only onchange service applied on change, got %v", got)
+	}
+
+	if err := runningCluster.ApplyIfChangedOn(desired, bitesize.TriggerPoll); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if got := deployed(); !reflect.DeepEqual(got, []string{"svc-", "svc-poll"}) {
+		t.Errorf("Expected manual service not to be applied, got %v", got)
+	}
+}
+
 func TestShouldDeployOnChange(t *testing.T) {
 
 	e1, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment2")
@@ -213,7 +311,7 @@ func TestGetPods(t *testing.T) {
 		Interface: client,
 		CRDClient: crdcli,
 	}
-	pods, err := cluster.LoadPods("dev")
+	pods, err := cluster.LoadPods("dev", "", false)
 
 	if err != nil {
 		t.Fatalf("Unexpected err: %s", err.Error())
@@ -277,7 +375,7 @@ func validMeta(namespace, name string) metav1.ObjectMeta {
 	}
 }
 
-func loadTestCRDs() *fakerest.RESTClient {
+func loadTestCRDs() *rest.RESTClient {
 	return fakecrd.CRDClient(
 		"prsn.io",
 		"v1",
@@ -312,7 +410,7 @@ func loadTestCRDs() *fakerest.RESTClient {
 	)
 }
 
-func loadTestExternalSecrets() *fakerest.RESTClient {
+func loadTestExternalSecrets() *rest.RESTClient {
 	return fakecrd.CRDClient(
 		"kubernetes-client.io",
 		"v1",
@@ -677,6 +775,348 @@ func TestEnvironmentAnnotations(t *testing.T) {
 
 }
 
+func TestApplyIngressWaitReady(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	service := bitesize.ServiceWithDefaults()
+	service.Name = "front"
+	service.Application = "front"
+	service.Version = "1"
+	service.ExternalURL = []string{"front.example.com"}
+	service.IngressWaitReady = true
+
+	if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	if _, err := client.NetworkingV1beta1().Ingresses("sample").Get("front", metav1.GetOptions{}); err == nil {
+		t.Error("Expected ingress to be deferred until deployment is ready")
+	}
+
+	deployment, _ := client.AppsV1().Deployments("sample").Get("front", metav1.GetOptions{})
+	deployment.Status.UpdatedReplicas = 1
+	deployment.Status.AvailableReplicas = 1
+	client.AppsV1().Deployments("sample").UpdateStatus(deployment)
+
+	k8sClient := &k8s.Client{Interface: client, Namespace: "sample"}
+	if !ingressBackendReady(service, k8sClient) {
+		t.Error("Expected ingress to be applied once deployment is ready")
+	}
+}
+
+func TestScrapeIgnoresUnmanagedResources(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample", Labels: map[string]string{"environment": "sample"}}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "managed", Namespace: "sample", Labels: map[string]string{"creator": "pipeline"}}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "sample", Labels: map[string]string{"app.kubernetes.io/managed-by": "helm"}}},
+	)
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	env, err := cluster.ScrapeResourcesForNamespace("sample")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if env.Services.FindByName("managed") == nil {
+		t.Error("Expected managed service to be loaded")
+	}
+	if env.Services.FindByName("foreign") != nil {
+		t.Error("Expected service created by another tool not to be loaded")
+	}
+}
+
+func TestApplyStampsDeployEvent(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.DeployEventAnnotation = "example.com/deploy-event"
+
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample", Labels: map[string]string{"environment": "sample"}}},
+	)
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	service := bitesize.ServiceWithDefaults()
+	service.Name = "api"
+	service.Application = "api"
+	service.Version = "1.2"
+	service.Revision = "b1eb9c8"
+	if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	d, err := client.AppsV1().Deployments("sample").Get("api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	var event deployEvent
+	if err := json.Unmarshal([]byte(d.Annotations["example.com/deploy-event"]), &event); err != nil {
+		t.Fatalf("Expected deploy event annotation, got %v: %s", d.Annotations, err.Error())
+	}
+	if event.Version != "1.2" || event.Revision != "b1eb9c8" {
+		t.Errorf("Expected deploy event of version 1.2 and revision b1eb9c8, got %+v", event)
+	}
+	if _, err := time.Parse(time.RFC3339, event.AppliedAt); err != nil {
+		t.Errorf("Expected RFC3339 applied_at, got %s", event.AppliedAt)
+	}
+
+	env, err := cluster.ScrapeResourcesForNamespace("sample")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if s := env.Services.FindByName("api"); s == nil || s.DeploymentAnnotations["example.com/deploy-event"] != "" {
+		t.Errorf("Expected deploy event annotation not to be loaded back, got %+v", s)
+	}
+}
+
+func TestApplyAffinityAndTolerations(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample", Labels: map[string]string{"environment": "sample"}}},
+	)
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	service := bitesize.ServiceWithDefaults()
+	service.Name = "db"
+	service.Application = "db"
+	service.Version = "1"
+	service.AntiAffinity = "name"
+	service.Tolerations = []bitesize.Toleration{{Key: "dedicated", Operator: "Equal", Value: "stateful", Effect: "NoSchedule"}}
+	service.Affinity = &bitesize.Affinity{
+		NodeAffinity: &bitesize.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []bitesize.NodeSelectorTerm{
+				{MatchExpressions: []bitesize.SelectorRequirement{{Key: "topology.kubernetes.io/zone", Operator: "In", Values: []string{"eu-west-1a"}}}},
+			},
+		},
+	}
+	if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	env, err := cluster.ScrapeResourcesForNamespace("sample")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	current := env.Services.FindByName("db")
+	if current == nil {
+		t.Fatalf("Expected service db to be loaded back")
+	}
+	if !reflect.DeepEqual(current.Affinity, service.Affinity) || !reflect.DeepEqual(current.Tolerations, service.Tolerations) {
+		t.Errorf("Expected affinity %+v and tolerations %+v to be loaded back, got %+v and %+v",
+			service.Affinity, service.Tolerations, current.Affinity, current.Tolerations)
+	}
+
+	desired := *current
+	desired.Tolerations = []bitesize.Toleration{{Key: "dedicated", Operator: "Exists", Effect: "NoSchedule"}}
+	desiredEnv := bitesize.Environment{Services: bitesize.Services{desired}}
+	if !diff.Compare(desiredEnv, *env) {
+		t.Errorf("Expected changed tolerations to be detected")
+	}
+}
+
+func TestApplyClearsImagePullSecrets(t *testing.T) {
+	os.Setenv("DOCKER_PULL_SECRETS", "pullsecret")
+	defer os.Unsetenv("DOCKER_PULL_SECRETS")
+
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample", Labels: map[string]string{"environment": "sample"}}},
+	)
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	for _, name := range []string{"private", "public"} {
+		service := bitesize.ServiceWithDefaults()
+		service.Name = name
+		service.Application = name
+		service.Version = "1"
+		if name == "public" {
+			service.ImagePullSecrets = "none"
+		}
+		if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	env, err := cluster.ScrapeResourcesForNamespace("sample")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if s := env.Services.FindByName("private"); s == nil || s.ImagePullSecrets != "" {
+		t.Errorf("Expected default pull secrets to be loaded back, got %+v", s)
+	}
+	if s := env.Services.FindByName("public"); s == nil || s.ImagePullSecrets != "none" {
+		t.Errorf("Expected cleared pull secrets to be loaded back as none, got %+v", s)
+	}
+}
+
+func TestApplyServiceMonitorNotServed(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample", Labels: map[string]string{"environment": "sample"}}},
+	)
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	service := bitesize.ServiceWithDefaults()
+	service.Name = "api"
+	service.Application = "api"
+	service.Version = "1"
+	service.ServiceMonitor = &bitesize.ServiceMonitor{Port: 80, Path: "/metrics"}
+
+	if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err != nil {
+		t.Fatalf("Expected service_monitor to be skipped without Prometheus Operator, got %s", err.Error())
+	}
+
+	env, err := cluster.ScrapeResourcesForNamespace("sample")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	scraped := env.Services.FindByName("api")
+	if scraped == nil || scraped.ServiceMonitor == nil || *scraped.ServiceMonitor != *service.ServiceMonitor {
+		t.Errorf("Expected service_monitor to be loaded from the service, got %+v", scraped)
+	}
+}
+
+func TestApplyEnvironmentIsolatesFailures(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	broken := bitesize.ServiceWithDefaults()
+	broken.Name = "broken"
+	broken.Application = "broken"
+	broken.Version = "1"
+	broken.ExternalURL = []string{"broken.example.com"}
+	broken.Ports = nil
+
+	good := bitesize.ServiceWithDefaults()
+	good.Name = "good"
+	good.Application = "good"
+	good.Version = "1"
+
+	current := &bitesize.Environment{Name: "sample", Namespace: "sample"}
+	desired := &bitesize.Environment{
+		Name:      "sample",
+		Namespace: "sample",
+		Services:  bitesize.Services{*broken, *good},
+	}
+
+	diff.Compare(*desired, *current)
+	err := cluster.ApplyEnvironment(current, desired)
+	if err == nil || !strings.Contains(err.Error(), "broken: service broken has external_url but no ports or backend_port") {
+		t.Errorf("Expected error for broken service, got %v", err)
+	}
+
+	if _, err := client.AppsV1().Deployments("sample").Get("good", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected good service to be applied: %s", err.Error())
+	}
+}
+
+func TestApplyEnvironmentWaitDoesNotHoldScheduler(t *testing.T) {
+	defer func(s *Scheduler) { scheduler = s }(scheduler)
+	scheduler = NewScheduler(1)
+
+	dependencyPollInterval = 10 * time.Millisecond
+	defer func() { dependencyPollInterval = 5 * time.Second }()
+	defer func(timeout int) { config.Env.DependencyWaitTimeout = timeout }(config.Env.DependencyWaitTimeout)
+	config.Env.DependencyWaitTimeout = 2
+
+	// readiness of db is polled once web waits for it
+	var polls int32
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.GetAction).GetName() == "db" {
+			atomic.AddInt32(&polls, 1)
+		}
+		return false, nil, nil
+	})
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	service := func(name string, dependsOn ...string) bitesize.Service {
+		s := bitesize.ServiceWithDefaults()
+		s.Name = name
+		s.Application = name
+		s.Version = "1"
+		s.DependsOn = dependsOn
+		return *s
+	}
+
+	// web waits for db, which never becomes ready in the fake cluster
+	waiting := &bitesize.Environment{
+		Name:      "waiting",
+		Namespace: "waiting",
+		Services:  bitesize.Services{service("db"), service("web", "db")},
+	}
+	unrelated := &bitesize.Environment{
+		Name:      "unrelated",
+		Namespace: "unrelated",
+		Services:  bitesize.Services{service("app")},
+	}
+
+	// changes are kept by service name, so both environments are compared at once
+	diff.Compare(bitesize.Environment{Services: append(waiting.Services, unrelated.Services...)}, bitesize.Environment{})
+
+	waitingDone := make(chan struct{})
+	go func() {
+		defer close(waitingDone)
+		cluster.ApplyEnvironment(&bitesize.Environment{Name: "waiting", Namespace: "waiting"}, waiting)
+	}()
+
+	err := wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		return atomic.LoadInt32(&polls) > 5, nil
+	})
+	if err != nil {
+		t.Fatal("Expected web to wait for db")
+	}
+
+	start := time.Now()
+	if err := cluster.ApplyEnvironment(&bitesize.Environment{Name: "unrelated", Namespace: "unrelated"}, unrelated); err != nil {
+		t.Fatalf("Unexpected error applying unrelated environment: %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected unrelated environment to be applied while web waits for db, took %s", elapsed)
+	}
+
+	<-waitingDone
+	if _, err := client.AppsV1().Deployments("waiting").Get("web", metav1.GetOptions{}); err == nil {
+		t.Error("Expected web not to be applied without db being ready")
+	}
+}
+
+func TestApplyCustomResourceClientError(t *testing.T) {
+	cluster := Cluster{Interface: fake.NewSimpleClientset(), CRDClient: loadEmptyCRDs()}
+
+	service := bitesize.ServiceWithDefaults()
+	service.Name = "db"
+	service.Type = "mysql"
+
+	// outside a cluster the custom resource client can't be created, the
+	// error is returned instead of exiting
+	err := cluster.ApplyService(service, &bitesize.Gists{}, "sample")
+	if err == nil || !strings.HasPrefix(err.Error(), "error creating kubernetes client") {
+		t.Errorf("Expected client error, got %v", err)
+	}
+}
+
+func TestRecoverServicePanic(t *testing.T) {
+	apply := func() (err error) {
+		defer recoverServicePanic("test", &err)
+		var ports []int
+		_ = ports[0]
+		return nil
+	}
+
+	err := apply()
+	if err == nil || !strings.HasPrefix(err.Error(), "panic: ") {
+		t.Errorf("Expected panic to be returned as error, got %v", err)
+	}
+}
+
 func TestApplyNewHPA(t *testing.T) {
 
 	crdcli := loadEmptyCRDs()
@@ -714,6 +1154,106 @@ func TestApplyNewHPA(t *testing.T) {
 	}
 }
 
+func TestApplyHPAVersionChange(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+	)
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	service := bitesize.ServiceWithDefaults()
+	service.Name = "api"
+	service.Application = "api"
+	service.Version = "1"
+	service.HPA = bitesize.HorizontalPodAutoscaler{
+		MinReplicas: 2,
+		MaxReplicas: 5,
+		Metric:      bitesize.Metric{Name: "cpu", TargetAverageUtilization: 80},
+		Labels:      map[string]string{"team": "payments"},
+	}
+
+	if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	client.ClearActions()
+	service.Version = "2"
+	if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" && action.GetResource().Resource == "horizontalpodautoscalers" {
+			t.Error("Expected hpa not to be updated on version change")
+		}
+	}
+
+	hpa, _ := client.AutoscalingV2beta2().HorizontalPodAutoscalers("sample").Get("api", metav1.GetOptions{})
+	if _, ok := hpa.Labels["version"]; ok {
+		t.Errorf("Expected hpa without version label, got %v", hpa.Labels)
+	}
+	if hpa.Labels["team"] != "payments" {
+		t.Errorf("Expected hpa specific label team=payments, got %v", hpa.Labels)
+	}
+
+	e, err := cluster.ScrapeResourcesForNamespace("sample")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if s := e.Services.FindByName("api"); s == nil || s.HPA.Labels["team"] != "payments" {
+		t.Errorf("Expected hpa labels to be loaded from the cluster, got %v", s)
+	}
+}
+
+func TestApplyHPAVersionChangeKeepsReplicas(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+	)
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	service := bitesize.ServiceWithDefaults()
+	service.Name = "api"
+	service.Application = "api"
+	service.Version = "1"
+	service.Replicas = 2
+	service.HPA = bitesize.HorizontalPodAutoscaler{
+		MinReplicas: 2,
+		MaxReplicas: 5,
+		Metric:      bitesize.Metric{Name: "cpu", TargetAverageUtilization: 80},
+	}
+
+	if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	// HPA scales the deployment up
+	deployments := client.AppsV1().Deployments("sample")
+	d, _ := deployments.Get("api", metav1.GetOptions{})
+	replicas := int32(4)
+	d.Spec.Replicas = &replicas
+	if _, err := deployments.Update(d); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	service.Version = "2"
+	if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	d, _ = deployments.Get("api", metav1.GetOptions{})
+	if d.Spec.Replicas == nil || *d.Spec.Replicas != 4 {
+		t.Errorf("Expected replicas set by HPA to be kept, got %v", d.Spec.Replicas)
+	}
+	if d.Labels["version"] != "2" {
+		t.Errorf("Expected deployment version 2, got %s", d.Labels["version"])
+	}
+}
+
 func TestApplyExistingHPA(t *testing.T) {
 	var min, target int32 = 2, 75
 	customMetricValue, _ := resource.ParseQuantity("200")
@@ -808,6 +1348,307 @@ func TestApplyExistingHPA(t *testing.T) {
 		t.Errorf("Expected loaded environments to be equal, yet diff is: %s", diff.Changes())
 	}
 }
-func loadEmptyCRDs() *fakerest.RESTClient {
+func loadEmptyCRDs() *rest.RESTClient {
 	return fakecrd.CRDClient("prsn.io", "v1")
 }
+
+func TestApplyRefusesUnverifiedImage(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.ImageVerify = true
+	config.Env.CosignKey = "/etc/cosign/cosign.pub"
+	config.Env.CosignBinary = "/nonexistent/cosign"
+
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+	)
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	service := bitesize.ServiceWithDefaults()
+	service.Name = "api"
+	service.Application = "api"
+	service.Version = "1"
+
+	err := cluster.ApplyService(service, &bitesize.Gists{}, "sample")
+	if err == nil || !strings.Contains(err.Error(), "signature verification failed") {
+		t.Fatalf("Expected signature verification error, got %v", err)
+	}
+	if _, err := client.AppsV1().Deployments("sample").Get("api", metav1.GetOptions{}); err == nil {
+		t.Error("Expected deployment with unverified image not to be applied")
+	}
+}
+
+func TestApplyConfigMapVolume(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+
+	var tests = []struct {
+		Objects  []runtime.Object
+		Policy   string
+		Error    bool
+		Deployed bool
+	}{
+		{
+			Objects:  []runtime.Object{&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "sample"}}},
+			Policy:   k8s.MissingReferenceFail,
+			Deployed: true,
+		},
+		{
+			Policy: k8s.MissingReferenceFail,
+			Error:  true,
+		},
+		{
+			Policy:   k8s.MissingReferenceWarn,
+			Deployed: true,
+		},
+	}
+
+	for _, tst := range tests {
+		config.Env.MissingReferencePolicy = tst.Policy
+		objects := append(tst.Objects, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}})
+		client := fake.NewSimpleClientset(objects...)
+		cluster := Cluster{
+			Interface: client,
+			CRDClient: loadEmptyCRDs(),
+		}
+
+		service := bitesize.ServiceWithDefaults()
+		service.Name = "api"
+		service.Application = "api"
+		service.Version = "1"
+		service.Volumes = []bitesize.Volume{{Name: "settings", Path: "/etc/settings", Type: "configmap"}}
+
+		err := cluster.ApplyService(service, &bitesize.Gists{}, "sample")
+		if tst.Error && (err == nil || !strings.Contains(err.Error(), "unable to find configmap [settings]")) {
+			t.Errorf("Expected missing configmap error with policy %s, got %v", tst.Policy, err)
+		}
+		if !tst.Error && err != nil {
+			t.Errorf("Unexpected err with policy %s: %s", tst.Policy, err.Error())
+		}
+		_, err = client.AppsV1().Deployments("sample").Get("api", metav1.GetOptions{})
+		if deployed := err == nil; deployed != tst.Deployed {
+			t.Errorf("Expected deployment applied %t with policy %s, got %t", tst.Deployed, tst.Policy, deployed)
+		}
+	}
+}
+
+func TestVerifyConfigMapsSkipsOptional(t *testing.T) {
+	optional := true
+	client := &k8s.Client{Interface: fake.NewSimpleClientset(), Namespace: "sample"}
+	deployment := &apps_v1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api"},
+		Spec: apps_v1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Volumes: []v1.Volume{{
+						Name: "settings",
+						VolumeSource: v1.VolumeSource{
+							ConfigMap: &v1.ConfigMapVolumeSource{
+								LocalObjectReference: v1.LocalObjectReference{Name: "settings"},
+								Optional:             &optional,
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+	if err := verifyConfigMaps(deployment, client); err != nil {
+		t.Errorf("Unexpected err for optional configmap: %s", err.Error())
+	}
+}
+
+func TestVerifyEnvFrom(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.MissingReferencePolicy = k8s.MissingReferenceFail
+
+	client := &k8s.Client{
+		Interface: fake.NewSimpleClientset(
+			&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "sample"}},
+		),
+		Namespace: "sample",
+	}
+	deployment := func(sources ...v1.EnvFromSource) *apps_v1.Deployment {
+		return &apps_v1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "api"},
+			Spec: apps_v1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{Containers: []v1.Container{{Name: "api", EnvFrom: sources}}},
+				},
+			},
+		}
+	}
+	settings := v1.EnvFromSource{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "settings"}}}
+	credentials := v1.EnvFromSource{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "credentials"}}}
+
+	if err := verifyEnvFrom(deployment(settings), client); err != nil {
+		t.Errorf("Unexpected err for existing configmap: %s", err.Error())
+	}
+	err := verifyEnvFrom(deployment(settings, credentials), client)
+	if err == nil || !strings.Contains(err.Error(), "unable to find secret [credentials]") {
+		t.Errorf("Expected missing secret error, got %v", err)
+	}
+
+	config.Env.MissingReferencePolicy = k8s.MissingReferenceWarn
+	if err := verifyEnvFrom(deployment(credentials), client); err != nil {
+		t.Errorf("Expected missing secret to be a warning, got %s", err.Error())
+	}
+}
+
+func TestNamespaceEnvironmentWithoutLabel(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.EnvName = "environment2"
+
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "labelled",
+				Labels: map[string]string{"environment": "environment3"},
+			},
+		},
+	)
+	cluster := Cluster{Interface: client}
+
+	name, err := cluster.NamespaceEnvironment("sample")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if name != "environment2" {
+		t.Errorf("Expected environment name environment2, got %q", name)
+	}
+
+	name, err = cluster.NamespaceEnvironment("labelled")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if name != "environment3" {
+		t.Errorf("Expected environment name environment3, got %q", name)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "environment-dev",
+				Labels: map[string]string{"environment": "environment2"},
+			},
+		},
+	)
+	cluster := Cluster{
+		Interface: client,
+		CRDClient: loadTestCRDs(),
+	}
+
+	e, err := bitesize.LoadEnvironment("../../test/assets/environments.bitesize", "environment2")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	changes, err := cluster.Diff(e)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if _, ok := changes["annotated_service"]; !ok {
+		t.Errorf("Expected annotated_service to be in the diff, got %v", changes)
+	}
+	if _, ok := changes["annotated_service2"]; ok {
+		t.Errorf("Expected service without version not to be in the diff, got %v", changes)
+	}
+
+	deployments, _ := client.AppsV1().Deployments("environment-dev").List(metav1.ListOptions{})
+	if len(deployments.Items) != 0 {
+		t.Errorf("Expected diff not to apply anything, got %d deployments", len(deployments.Items))
+	}
+}
+
+func TestSyncService(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "sample",
+				Labels: map[string]string{"environment": "prod"},
+			},
+		},
+	)
+	runningCluster := Cluster{
+		Interface: client,
+		CRDClient: loadEmptyCRDs(),
+	}
+
+	var services bitesize.Services
+	for _, name := range []string{"api", "worker"} {
+		s := bitesize.ServiceWithDefaults()
+		s.Name = name
+		s.Application = name
+		s.Version = "1"
+		s.Reconcile = bitesize.ReconcileManual
+		s.Annotations = map[string]string{}
+		services = append(services, *s)
+	}
+	desired := &bitesize.Environment{Name: "prod", Namespace: "sample", Services: services}
+
+	change, err := runningCluster.SyncService(desired, "api")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if change == "" {
+		t.Error("Expected api change to be returned")
+	}
+	if _, err := client.AppsV1().Deployments("sample").Get("api", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected api to be applied: %s", err.Error())
+	}
+	if _, err := client.AppsV1().Deployments("sample").Get("worker", metav1.GetOptions{}); err == nil {
+		t.Error("Expected worker not to be applied")
+	}
+
+	change, err = runningCluster.SyncService(desired, "api")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+	if change != "" {
+		t.Errorf("Expected no changes on second sync, got %q", change)
+	}
+
+	if _, err := runningCluster.SyncService(desired, "missing"); err == nil {
+		t.Error("Expected error syncing unknown service")
+	}
+}
+
+func TestListCustomResources(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.CustomResourceConcurrency = 2
+
+	client := &k8s.Client{
+		Namespace: "sample",
+		Interface: fake.NewSimpleClientset(),
+		CRDClient: fakecrd.CRDClient(
+			"prsn.io",
+			"v1",
+			&ext.PrsnExternalResource{
+				TypeMeta:   metav1.TypeMeta{Kind: "Redis", APIVersion: "prsn.io/v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "sample"},
+			},
+			&ext.PrsnExternalResource{
+				TypeMeta:   metav1.TypeMeta{Kind: "Mysql", APIVersion: "prsn.io/v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "sample"},
+			},
+		),
+	}
+
+	crds, err := listCustomResources(client)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	var names []string
+	for _, crd := range crds {
+		names = append(names, crd.Name)
+	}
+	// resources are returned in the order of SupportedCustomResources
+	if !reflect.DeepEqual(names, []string{"db", "cache"}) {
+		t.Errorf("Expected custom resources [db cache], got %v", names)
+	}
+}
@@ -0,0 +1,43 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// dependencyPollInterval is how often readiness of depends_on services is checked
+var dependencyPollInterval = 5 * time.Second
+
+// waitForDependencies blocks until all deployments the service depends on
+// are ready, as defined by each dependency's ready_threshold, or returns an error once DEPENDENCY_WAIT_TIMEOUT is reached.
+// Dependencies without a deployment (custom resources, blue/green parents)
+// are considered ready once applied.
+func (cluster *Cluster) waitForDependencies(service bitesize.Service, environment *bitesize.Environment) error {
+	client := &k8s.Client{
+		Interface: cluster.Interface,
+		Namespace: environment.Namespace,
+	}
+	timeout := time.Duration(config.Env.DependencyWaitTimeout) * time.Second
+
+	for _, name := range service.DependsOn {
+		dependency := environment.Services.FindByName(name)
+		if dependency == nil || dependency.Type != "" || dependency.IsBlueGreenParentDeployment() {
+			continue
+		}
+
+		log.Debugf("waiting for dependency %s of service %s", name, service.Name)
+		err := wait.PollImmediate(dependencyPollInterval, timeout, func() (bool, error) {
+			return client.Deployment().ReadyThreshold(name, dependency.ReadyThreshold), nil
+		})
+		if err != nil {
+			return fmt.Errorf("dependency %s of service %s is not ready: %s", name, service.Name, err.Error())
+		}
+	}
+	return nil
+}
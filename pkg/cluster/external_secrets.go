@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	ext "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// externalSecretPollInterval is how often secrets synced from a secret
+// manager are checked for
+var externalSecretPollInterval = 2 * time.Second
+
+// externalSecretsClient returns kubernetes-external-secrets client for
+// ExternalSecrets in namespace
+var externalSecretsClient = func(namespace string) (*k8s.ExternalSecret, error) {
+	crd, err := k8s.CRDClient(&schema.GroupVersion{
+		Group:   "kubernetes-client.io",
+		Version: "v1",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &k8s.ExternalSecret{Interface: crd, Namespace: namespace, Type: "ExternalSecret"}, nil
+}
+
+// listEnvExternalSecrets lists ExternalSecrets of env vars with external_key
+// in namespace
+func listEnvExternalSecrets(namespace string) ([]ext.ExternalSecret, error) {
+	es, err := externalSecretsClient(namespace)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := es.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var retval []ext.ExternalSecret
+	for _, s := range secrets {
+		if s.Labels[k8s.EnvSecretLabel] != "" {
+			retval = append(retval, s)
+		}
+	}
+	return retval, nil
+}
+
+// applyEnvExternalSecrets applies ExternalSecrets syncing env vars with
+// external_key from the secret manager, and waits for the secrets to be
+// created so that the deployment referring to them can start. Secrets not
+// synced within EXTERNAL_SECRETS_SYNC_TIMEOUT are left to the deployment's
+// missing secret check
+func applyEnvExternalSecrets(mapper *translator.KubeMapper, tx *transaction) error {
+	client := tx.client
+	secrets := mapper.EnvExternalSecrets()
+	if len(secrets) == 0 {
+		return nil
+	}
+	if !k8s.ExternalSecretsEnabled {
+		return fmt.Errorf("env of service %s has external_key, but external secrets are not enabled (EXTERNAL_CRD_EXTERNAL_SECRETS_ENABLED)", mapper.BiteService.Name)
+	}
+
+	es, err := externalSecretsClient(client.Namespace)
+	if err != nil {
+		return fmt.Errorf("error creating kubernetes client for External Secrets use: %s", err.Error())
+	}
+	for i := range secrets {
+		log.Debugf("applying external secret %s for service %s", secrets[i].Name, mapper.BiteService.Name)
+		if err := tx.ExternalSecret(es, &secrets[i]); err != nil {
+			return fmt.Errorf("could not apply ExternalSecret %s: %s", secrets[i].Name, err.Error())
+		}
+	}
+
+	timeout := time.Duration(config.Env.ExternalSecretsSyncTimeout) * time.Second
+	for _, s := range secrets {
+		name := s.Name
+		err := wait.PollImmediate(externalSecretPollInterval, timeout, func() (bool, error) {
+			return client.Secret().Exists(name), nil
+		})
+		if err != nil {
+			log.Warnf("service %s: secret %s was not synced from %s within %s", mapper.BiteService.Name, name, config.Env.ExternalSecretsBackend, timeout)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	ext "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+// recordExternalSecrets stubs ExternalSecrets client with one that has no
+// existing resources and records created ones
+func recordExternalSecrets(created *[]ext.ExternalSecret) func() {
+	orig := externalSecretsClient
+	externalSecretsClient = func(namespace string) (*k8s.ExternalSecret, error) {
+		handler := func(req *http.Request) (*http.Response, error) {
+			header := http.Header{}
+			header.Set("Content-Type", runtime.ContentTypeJSON)
+			if req.Method != http.MethodPost {
+				return &http.Response{StatusCode: http.StatusNotFound, Header: header, Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+			}
+			data, _ := ioutil.ReadAll(req.Body)
+			var es ext.ExternalSecret
+			_ = json.Unmarshal(data, &es)
+			*created = append(*created, es)
+			return &http.Response{StatusCode: http.StatusCreated, Header: header, Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+		}
+		crd := &fakerest.RESTClient{
+			GroupVersion:         schema.GroupVersion{Group: "kubernetes-client.io", Version: "v1"},
+			NegotiatedSerializer: serializer.WithoutConversionCodecFactory{CodecFactory: scheme.Codecs},
+			Client:               fakerest.CreateHTTPClient(handler),
+		}
+		return &k8s.ExternalSecret{Interface: crd, Namespace: namespace, Type: "ExternalSecret"}, nil
+	}
+	return func() { externalSecretsClient = orig }
+}
+
+func externalEnvMapper() *translator.KubeMapper {
+	return &translator.KubeMapper{
+		BiteService: &bitesize.Service{
+			Name: "api",
+			EnvVars: []bitesize.EnvVar{
+				{Secret: "DB_PASSWORD", Value: "api-db/password", ExternalKey: "prod/api/db", ExternalProperty: "password"},
+				{Name: "LOG_LEVEL", Value: "info"},
+			},
+		},
+		Namespace: "sample",
+	}
+}
+
+func TestApplyEnvExternalSecretsDisabled(t *testing.T) {
+	defer func(enabled bool) { k8s.ExternalSecretsEnabled = enabled }(k8s.ExternalSecretsEnabled)
+	k8s.ExternalSecretsEnabled = false
+
+	client := &k8s.Client{Interface: fake.NewSimpleClientset(), Namespace: "sample"}
+	err := applyEnvExternalSecrets(externalEnvMapper(), newTransaction(client, &bitesize.Service{}))
+	if err == nil || !strings.Contains(err.Error(), "external secrets are not enabled") {
+		t.Errorf("Expected external secrets not enabled error, got %v", err)
+	}
+}
+
+func TestApplyEnvExternalSecrets(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	defer func(enabled bool) { k8s.ExternalSecretsEnabled = enabled }(k8s.ExternalSecretsEnabled)
+	k8s.ExternalSecretsEnabled = true
+	config.Env.ExternalSecretsSyncTimeout = 0
+
+	var created []ext.ExternalSecret
+	defer recordExternalSecrets(&created)()
+
+	client := &k8s.Client{
+		Interface: fake.NewSimpleClientset(
+			&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "api-env-api-db", Namespace: "sample"}},
+		),
+		Namespace: "sample",
+	}
+	if err := applyEnvExternalSecrets(externalEnvMapper(), newTransaction(client, &bitesize.Service{})); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(created) != 1 || created[0].Name != "api-env-api-db" {
+		t.Fatalf("Expected ExternalSecret api-env-api-db to be created, got %+v", created)
+	}
+	expected := map[string]string{"key": "prod/api/db", "name": "password", "property": "password"}
+	if data := created[0].SecretDescriptor.Data; len(data) != 1 || data[0]["key"] != expected["key"] ||
+		data[0]["name"] != expected["name"] || data[0]["property"] != expected["property"] {
+		t.Errorf("Expected ExternalSecret data %v, got %v", expected, data)
+	}
+}
@@ -3,7 +3,9 @@ package cluster
 import (
 	"sort"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 	v1batch "k8s.io/api/batch/v1"
 	v1beta1 "k8s.io/api/batch/v1beta1"
 	v1 "k8s.io/api/core/v1"
@@ -15,6 +17,10 @@ type GistMap map[string]*bitesize.Gist
 
 // AddConfigMap adds imported ConfigMap resource to GistMap
 func (m GistMap) AddConfigMap(gist v1.ConfigMap) *bitesize.Gist {
+	if inventory := gist.Labels[k8s.ManifestInventoryLabel]; inventory != "" {
+		return m.addManifestInventory(inventory, gist)
+	}
+
 	name := gist.Name
 	// Create with some defaults -- defaults should probably live in bitesize.Gist
 	if m[name] == nil {
@@ -27,6 +33,23 @@ func (m GistMap) AddConfigMap(gist v1.ConfigMap) *bitesize.Gist {
 	return m[name]
 }
 
+// addManifestInventory adds manifest gist with the objects listed in its
+// inventory ConfigMap
+func (m GistMap) addManifestInventory(name string, gist v1.ConfigMap) *bitesize.Gist {
+	if m[name] == nil {
+		manifests, err := k8s.ManifestInventory(gist)
+		if err != nil {
+			log.Error(err)
+		}
+		m[name] = &bitesize.Gist{
+			Name:      name,
+			Type:      bitesize.TypeManifest,
+			Manifests: manifests,
+		}
+	}
+	return m[name]
+}
+
 // AddJob adds imported v1batch.Job Gist to GistMap
 func (m GistMap) AddJob(gist v1batch.Job) *bitesize.Gist {
 	if m[gist.Name] == nil {
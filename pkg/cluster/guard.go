@@ -0,0 +1,34 @@
+package cluster
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+)
+
+// NamespaceAllowed returns an error if the operator is not allowed to
+// mutate the namespace. Namespaces matching NAMESPACE_DENYLIST are always
+// refused, and when NAMESPACE_ALLOWLIST is set only namespaces matching it
+// are allowed. Both lists accept shell patterns, e.g. team-*
+func NamespaceAllowed(namespace string) error {
+	if namespace == "" {
+		return fmt.Errorf("refusing to manage environment without a namespace")
+	}
+	if matchNamespace(config.Env.NamespaceDenylist, namespace) {
+		return fmt.Errorf("refusing to manage namespace %s: namespace is in NAMESPACE_DENYLIST", namespace)
+	}
+	if len(config.Env.NamespaceAllowlist) > 0 && !matchNamespace(config.Env.NamespaceAllowlist, namespace) {
+		return fmt.Errorf("refusing to manage namespace %s: namespace is not in NAMESPACE_ALLOWLIST", namespace)
+	}
+	return nil
+}
+
+func matchNamespace(patterns []string, namespace string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceAllowed(t *testing.T) {
+	defer func(allow, deny []string) {
+		config.Env.NamespaceAllowlist = allow
+		config.Env.NamespaceDenylist = deny
+	}(config.Env.NamespaceAllowlist, config.Env.NamespaceDenylist)
+
+	var tests = []struct {
+		Allowlist []string
+		Denylist  []string
+		Namespace string
+		Allowed   bool
+	}{
+		{nil, []string{"kube-system"}, "sample", true},
+		{nil, []string{"kube-system"}, "kube-system", false},
+		{nil, []string{"kube-*"}, "kube-public", false},
+		{[]string{"team-*"}, nil, "team-a", true},
+		{[]string{"team-*"}, nil, "sample", false},
+		{[]string{"team-*"}, []string{"team-admin"}, "team-admin", false},
+		{nil, nil, "", false},
+	}
+
+	for _, tst := range tests {
+		config.Env.NamespaceAllowlist = tst.Allowlist
+		config.Env.NamespaceDenylist = tst.Denylist
+		err := NamespaceAllowed(tst.Namespace)
+		if (err == nil) != tst.Allowed {
+			t.Errorf("Namespace %q with allowlist %v, denylist %v: expected allowed %t, got %v",
+				tst.Namespace, tst.Allowlist, tst.Denylist, tst.Allowed, err)
+		}
+	}
+}
+
+func TestApplyRefusesDeniedNamespace(t *testing.T) {
+	defer func(deny []string) { config.Env.NamespaceDenylist = deny }(config.Env.NamespaceDenylist)
+	config.Env.NamespaceDenylist = []string{"kube-system"}
+
+	client := fake.NewSimpleClientset()
+	cluster := Cluster{Interface: client}
+	env := &bitesize.Environment{
+		Name:      "system",
+		Namespace: "kube-system",
+		Services:  bitesize.Services{{Name: "api", Application: "api", Version: "1"}},
+	}
+
+	if err := cluster.ApplyIfChanged(env); err == nil {
+		t.Error("Expected apply to denied namespace to be refused")
+	}
+	if err := cluster.ApplyService(&env.Services[0], nil, env.Namespace); err == nil {
+		t.Error("Expected service apply to denied namespace to be refused")
+	}
+	if n := len(client.Actions()); n != 0 {
+		t.Errorf("Expected no requests to the cluster, got %v", client.Actions())
+	}
+}
@@ -1,18 +1,31 @@
 package cluster
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/util/cosign"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 	apps_v1 "k8s.io/api/apps/v1"
+	autoscale_v2beta2 "k8s.io/api/autoscaling/v2beta2"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 func envVars(deployment apps_v1.Deployment) []bitesize.EnvVar {
+	return containerEnvVars(deployment.Spec.Template.Spec.Containers[0])
+}
+
+func containerEnvVars(container v1.Container) []bitesize.EnvVar {
 	var retval []bitesize.EnvVar
-	for _, e := range deployment.Spec.Template.Spec.Containers[0].Env {
+	for _, e := range container.Env {
 		var v bitesize.EnvVar
 		// Reserved vars
 		if isReservedEnvVar(e) {
@@ -47,6 +60,33 @@ func envVars(deployment apps_v1.Deployment) []bitesize.EnvVar {
 	return retval
 }
 
+// secretFetch returns settings of the secret_fetch init container, if
+// deployment has one
+func secretFetch(deployment apps_v1.Deployment) *bitesize.SecretFetch {
+	for _, c := range deployment.Spec.Template.Spec.InitContainers {
+		if c.Name != bitesize.SecretFetchName {
+			continue
+		}
+		retval := &bitesize.SecretFetch{
+			Image:   cosign.Unpin(c.Image),
+			Command: c.Command,
+			EnvVars: containerEnvVars(c),
+		}
+		for _, mount := range c.VolumeMounts {
+			if mount.Name == bitesize.SecretFetchName {
+				retval.Path = mount.MountPath
+			}
+		}
+		for _, v := range deployment.Spec.Template.Spec.Volumes {
+			if v.Name == bitesize.SecretFetchConfigName && v.ConfigMap != nil {
+				retval.ConfigMap = v.ConfigMap.Name
+			}
+		}
+		return retval
+	}
+	return nil
+}
+
 func isReservedEnvVar(e v1.EnvVar) bool {
 	reserved := []string{"POD_DEPLOYMENT_COLOUR"}
 	for _, i := range reserved {
@@ -150,6 +190,185 @@ func getAnnotation(metadata metav1.ObjectMeta, annotation string) string {
 	return annotations[annotation]
 }
 
+// volumeClaimLabels returns user defined labels of the PVC, leaving out the
+// ones environment operator uses to store volume settings
+func volumeClaimLabels(claim v1.PersistentVolumeClaim) map[string]string {
+	var retval map[string]string
+	for k, v := range claim.Labels {
+		switch k {
+		case "creator", "deployment", "mount_path", "size", "type":
+			continue
+		}
+		if retval == nil {
+			retval = map[string]string{}
+		}
+		retval[k] = v
+	}
+	return retval
+}
+
+// volumeClaimAnnotations returns user defined annotations of the PVC, leaving
+// out the ones set by kubernetes volume binding and provisioning
+func volumeClaimAnnotations(claim v1.PersistentVolumeClaim) map[string]string {
+	var retval map[string]string
+	for k, v := range claim.Annotations {
+		if strings.HasPrefix(k, "pv.kubernetes.io/") ||
+			strings.HasPrefix(k, "volume.kubernetes.io/") ||
+			strings.HasPrefix(k, "volume.beta.kubernetes.io/") {
+			continue
+		}
+		if retval == nil {
+			retval = map[string]string{}
+		}
+		retval[k] = v
+	}
+	return retval
+}
+
+// serviceAnnotations returns user defined annotations of the service, leaving
+// out the ones environment operator uses to store deployment settings
+func serviceAnnotations(metadata metav1.ObjectMeta) map[string]string {
+	var retval map[string]string
+	for k, v := range metadata.Annotations {
+		switch k {
+		case "deployment_method", "deployment_active", k8s.TopologyAwareHintsAnnotation,
+			k8s.PatchesAnnotation, k8s.ServiceMonitorAnnotation:
+			continue
+		}
+		if retval == nil {
+			retval = map[string]string{}
+		}
+		retval[k] = v
+	}
+	return retval
+}
+
+// servicePatches returns patches recorded on the kubernetes service
+func servicePatches(metadata metav1.ObjectMeta) []bitesize.Patch {
+	var retval []bitesize.Patch
+	if patches := getAnnotation(metadata, k8s.PatchesAnnotation); patches != "" {
+		if err := json.Unmarshal([]byte(patches), &retval); err != nil {
+			log.Errorf("invalid %s annotation on service %s: %s", k8s.PatchesAnnotation, metadata.Name, err.Error())
+		}
+	}
+	return retval
+}
+
+// ingressAnnotations returns annotations the operator set on the ingress
+// for ingress_annotations. Annotations added by other tools and the ones
+// set for cert_manager, weighted_backends and backend_health_check are
+// left out
+func ingressAnnotations(metadata metav1.ObjectMeta) map[string]string {
+	var retval map[string]string
+	owned := k8s.OwnedIngressAnnotations(metadata)
+	for k, v := range metadata.Annotations {
+		if !owned[k] || k8s.IsManagedIngressAnnotation(k) {
+			continue
+		}
+		if retval == nil {
+			retval = map[string]string{}
+		}
+		retval[k] = v
+	}
+	return retval
+}
+
+// serviceMonitor returns ServiceMonitor settings recorded on the kubernetes
+// service
+func serviceMonitor(metadata metav1.ObjectMeta) *bitesize.ServiceMonitor {
+	monitor := getAnnotation(metadata, k8s.ServiceMonitorAnnotation)
+	if monitor == "" {
+		return nil
+	}
+	retval := &bitesize.ServiceMonitor{}
+	if err := json.Unmarshal([]byte(monitor), retval); err != nil {
+		log.Errorf("invalid %s annotation on service %s: %s", k8s.ServiceMonitorAnnotation, metadata.Name, err.Error())
+		return nil
+	}
+	return retval
+}
+
+// backendHealthCheck returns backend_health_check settings recorded on the
+// ingress
+func backendHealthCheck(metadata metav1.ObjectMeta) *bitesize.BackendHealthCheck {
+	settings := getAnnotation(metadata, k8s.BackendHealthCheckAnnotation)
+	if settings == "" {
+		return nil
+	}
+	retval := &bitesize.BackendHealthCheck{}
+	if err := json.Unmarshal([]byte(settings), retval); err != nil {
+		log.Errorf("invalid %s annotation on ingress %s: %s", k8s.BackendHealthCheckAnnotation, metadata.Name, err.Error())
+		return nil
+	}
+	return retval
+}
+
+// deploymentAnnotations returns user defined annotations of the deployment,
+// leaving out the ones kubernetes and kubectl maintain
+func deploymentAnnotations(metadata metav1.ObjectMeta) map[string]string {
+	var retval map[string]string
+	for k, v := range metadata.Annotations {
+		if strings.HasPrefix(k, "deployment.kubernetes.io/") || k == v1.LastAppliedConfigAnnotation ||
+			k == config.Env.DeployEventAnnotation {
+			continue
+		}
+		if retval == nil {
+			retval = map[string]string{}
+		}
+		retval[k] = v
+	}
+	return retval
+}
+
+// deployEvent is the value of DEPLOY_EVENT_ANNOTATION deployments are
+// stamped with
+type deployEvent struct {
+	Version   string `json:"version"`
+	AppliedAt string `json:"applied_at"`
+	Revision  string `json:"revision,omitempty"`
+}
+
+// stampDeployEvent annotates deployment with the version of service, the
+// revision of the config it is applied from and the time it is applied
+// at, unless DEPLOY_EVENT_ANNOTATION is empty. The annotation is left out
+// when the deployment is loaded back, so it doesn't show as a change
+func stampDeployEvent(deployment *apps_v1.Deployment, service *bitesize.Service) {
+	key := config.Env.DeployEventAnnotation
+	if key == "" {
+		return
+	}
+	value, err := json.Marshal(deployEvent{
+		Version:   service.Version,
+		AppliedAt: time.Now().UTC().Format(time.RFC3339),
+		Revision:  service.Revision,
+	})
+	if err != nil {
+		log.Errorf("error encoding deploy event of service %s: %s", service.Name, err.Error())
+		return
+	}
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	deployment.Annotations[key] = string(value)
+}
+
+// hpaLabels returns hpa specific labels, leaving out the ones inherited
+// from the service
+func hpaLabels(hpa autoscale_v2beta2.HorizontalPodAutoscaler) map[string]string {
+	var retval map[string]string
+	for k, v := range hpa.Labels {
+		switch k {
+		case "creator", "application", "name", "version":
+			continue
+		}
+		if retval == nil {
+			retval = map[string]string{}
+		}
+		retval[k] = v
+	}
+	return retval
+}
+
 func getAccessModesAsString(modes []v1.PersistentVolumeAccessMode) string {
 
 	var modesStr []string
@@ -181,12 +400,13 @@ func volumes(deployment apps_v1.Deployment) []bitesize.Volume {
 	volumeMounts := deployment.Spec.Template.Spec.Containers[0].VolumeMounts
 	// add ConfigMap volumes to diff
 	for _, v := range deployment.Spec.Template.Spec.Volumes {
-		// if ConfigMap volume
-		if v.VolumeSource.ConfigMap != nil {
+		// if ConfigMap volume, other than secret_fetch config
+		if v.VolumeSource.ConfigMap != nil && v.Name != bitesize.SecretFetchConfigName {
 			vol := bitesize.Volume{
-				Name:  v.Name,
-				Type:  bitesize.TypeConfigMap,
-				Modes: "ReadWriteOnce",
+				Name:        v.Name,
+				Type:        bitesize.TypeConfigMap,
+				Modes:       "ReadWriteOnce",
+				DefaultMode: volumeDefaultMode(v.ConfigMap.DefaultMode),
 			}
 			// find the mount path for the volume
 			for _, mount := range volumeMounts {
@@ -205,9 +425,10 @@ func volumes(deployment apps_v1.Deployment) []bitesize.Volume {
 			volumes = append(volumes, vol)
 		} else if v.VolumeSource.Secret != nil {
 			vol := bitesize.Volume{
-				Name:  v.Name,
-				Type:  bitesize.TypeSecret,
-				Modes: "ReadWriteOnce",
+				Name:        v.Name,
+				Type:        bitesize.TypeSecret,
+				Modes:       "ReadWriteOnce",
+				DefaultMode: volumeDefaultMode(v.Secret.DefaultMode),
 			}
 			// find the mount path for the volume
 			for _, mount := range volumeMounts {
@@ -224,8 +445,430 @@ func volumes(deployment apps_v1.Deployment) []bitesize.Volume {
 				})
 			}
 			volumes = append(volumes, vol)
+		} else if v.VolumeSource.Projected != nil {
+			vol := bitesize.Volume{
+				Name:    v.Name,
+				Type:    bitesize.TypeProjected,
+				Modes:   "ReadWriteOnce",
+				Sources: projectedSources(v.Projected.Sources),
+			}
+			for _, mount := range volumeMounts {
+				if mount.Name == v.Name {
+					vol.Path = mount.MountPath
+				}
+			}
+			volumes = append(volumes, vol)
+		} else if v.VolumeSource.HostPath != nil {
+			vol := bitesize.Volume{
+				Name:     v.Name,
+				Type:     bitesize.TypeHostPath,
+				Modes:    "ReadWriteOnce",
+				HostPath: v.HostPath.Path,
+			}
+			if v.HostPath.Type != nil {
+				vol.HostPathType = string(*v.HostPath.Type)
+			}
+			for _, mount := range volumeMounts {
+				if mount.Name == v.Name {
+					vol.Path = mount.MountPath
+				}
+			}
+			volumes = append(volumes, vol)
 		}
 
 	}
 	return volumes
 }
+
+func projectedSources(projections []v1.VolumeProjection) []bitesize.ProjectedSource {
+	var retval []bitesize.ProjectedSource
+	for _, p := range projections {
+		var s bitesize.ProjectedSource
+		var items []v1.KeyToPath
+		switch {
+		case p.Secret != nil:
+			s.Secret = p.Secret.Name
+			items = p.Secret.Items
+		case p.ConfigMap != nil:
+			s.ConfigMap = p.ConfigMap.Name
+			items = p.ConfigMap.Items
+		case p.DownwardAPI != nil:
+			for _, f := range p.DownwardAPI.Items {
+				if f.FieldRef != nil {
+					s.DownwardAPI = append(s.DownwardAPI, bitesize.DownwardAPIFile{Path: f.Path, FieldRef: f.FieldRef.FieldPath})
+				}
+			}
+		case p.ServiceAccountToken != nil:
+			s.ServiceAccountToken = &bitesize.ServiceAccountToken{
+				Path:     p.ServiceAccountToken.Path,
+				Audience: p.ServiceAccountToken.Audience,
+			}
+			if p.ServiceAccountToken.ExpirationSeconds != nil {
+				s.ServiceAccountToken.ExpirationSeconds = *p.ServiceAccountToken.ExpirationSeconds
+			}
+		}
+		for _, it := range items {
+			s.Items = append(s.Items, bitesize.KeyToPath{Key: it.Key, Path: it.Path, Mode: it.Mode})
+		}
+		retval = append(retval, s)
+	}
+	return retval
+}
+
+// imageID returns the resolved image reference (including digest) of the
+// pod's application container, as reported by the kubelet
+func imageID(pod v1.Pod) string {
+	if len(pod.Spec.Containers) == 0 {
+		return ""
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == pod.Spec.Containers[0].Name {
+			return status.ImageID
+		}
+	}
+	return ""
+}
+
+// logsContainer returns name of pod's container to retrieve logs from,
+// defaulting to the main service container. Returns empty string if the
+// pod has no such container
+func logsContainer(pod v1.Pod, container string) string {
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		return pod.Spec.Containers[0].Name
+	}
+	for _, c := range append(pod.Spec.Containers, pod.Spec.InitContainers...) {
+		if c.Name == container {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// containerStatuses maps kubernetes container statuses to their bitesize
+// representation, including reasons containers are waiting or terminated
+func containerStatuses(statuses []v1.ContainerStatus) []bitesize.ContainerStatus {
+	var retval []bitesize.ContainerStatus
+	for _, s := range statuses {
+		status := bitesize.ContainerStatus{
+			Name:         s.Name,
+			Ready:        s.Ready,
+			RestartCount: s.RestartCount,
+		}
+
+		switch {
+		case s.State.Waiting != nil:
+			status.State = "waiting"
+			status.Reason = s.State.Waiting.Reason
+			status.Message = s.State.Waiting.Message
+		case s.State.Terminated != nil:
+			status.State = "terminated"
+			status.Reason = s.State.Terminated.Reason
+			status.Message = s.State.Terminated.Message
+		case s.State.Running != nil:
+			status.State = "running"
+		}
+
+		if last := s.LastTerminationState.Terminated; last != nil {
+			status.LastStateReason = last.Reason
+			status.LastExitCode = last.ExitCode
+		}
+		retval = append(retval, status)
+	}
+	return retval
+}
+
+// containsManifest checks whether object with the same apiVersion, kind and
+// name as obj is present in manifests
+func containsManifest(manifests []unstructured.Unstructured, obj unstructured.Unstructured) bool {
+	for _, m := range manifests {
+		if m.GetAPIVersion() == obj.GetAPIVersion() && m.GetKind() == obj.GetKind() && m.GetName() == obj.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeDefaultMode returns mode of a secret or configmap volume, nil for the
+// 0644 kubernetes defaults unset modes to
+func volumeDefaultMode(mode *int32) *int32 {
+	if mode == nil || *mode == v1.SecretVolumeSourceDefaultMode {
+		return nil
+	}
+	return mode
+}
+
+// nodeSelector returns the deployment node selector, or nil when it is
+// the DEFAULT_NODE_SELECTOR applied to services without node_selector
+func nodeSelector(selector map[string]string) map[string]string {
+	if len(selector) == 0 || reflect.DeepEqual(selector, config.Env.DefaultNodeSelector) {
+		return nil
+	}
+	return selector
+}
+
+// affinity returns the service affinity of pods. Pod anti-affinity is left
+// out when it is set by the anti_affinity preset
+func affinity(affinity *v1.Affinity, preset bool) *bitesize.Affinity {
+	if affinity == nil {
+		return nil
+	}
+	retval := &bitesize.Affinity{}
+	if a := affinity.NodeAffinity; a != nil {
+		retval.NodeAffinity = &bitesize.NodeAffinity{}
+		if a.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+			for _, term := range a.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+				retval.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(
+					retval.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution, nodeSelectorTerm(term))
+			}
+		}
+		for _, term := range a.PreferredDuringSchedulingIgnoredDuringExecution {
+			retval.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+				retval.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+				bitesize.PreferredSchedulingTerm{Weight: term.Weight, Preference: nodeSelectorTerm(term.Preference)},
+			)
+		}
+	}
+	if a := affinity.PodAffinity; a != nil {
+		pa := podAffinity(a.RequiredDuringSchedulingIgnoredDuringExecution, a.PreferredDuringSchedulingIgnoredDuringExecution)
+		retval.PodAffinity = &pa
+	}
+	if a := affinity.PodAntiAffinity; a != nil && !preset {
+		pa := bitesize.PodAntiAffinity(podAffinity(a.RequiredDuringSchedulingIgnoredDuringExecution, a.PreferredDuringSchedulingIgnoredDuringExecution))
+		retval.PodAntiAffinity = &pa
+	}
+	if retval.NodeAffinity == nil && retval.PodAffinity == nil && retval.PodAntiAffinity == nil {
+		return nil
+	}
+	return retval
+}
+
+func nodeSelectorTerm(term v1.NodeSelectorTerm) bitesize.NodeSelectorTerm {
+	var retval bitesize.NodeSelectorTerm
+	for _, r := range term.MatchExpressions {
+		retval.MatchExpressions = append(retval.MatchExpressions, bitesize.SelectorRequirement{
+			Key:      r.Key,
+			Operator: string(r.Operator),
+			Values:   r.Values,
+		})
+	}
+	for _, r := range term.MatchFields {
+		retval.MatchFields = append(retval.MatchFields, bitesize.SelectorRequirement{
+			Key:      r.Key,
+			Operator: string(r.Operator),
+			Values:   r.Values,
+		})
+	}
+	return retval
+}
+
+func podAffinity(required []v1.PodAffinityTerm, preferred []v1.WeightedPodAffinityTerm) bitesize.PodAffinity {
+	var retval bitesize.PodAffinity
+	for _, term := range required {
+		retval.RequiredDuringSchedulingIgnoredDuringExecution = append(
+			retval.RequiredDuringSchedulingIgnoredDuringExecution, podAffinityTerm(term))
+	}
+	for _, term := range preferred {
+		retval.PreferredDuringSchedulingIgnoredDuringExecution = append(
+			retval.PreferredDuringSchedulingIgnoredDuringExecution,
+			bitesize.WeightedPodAffinityTerm{Weight: term.Weight, PodAffinityTerm: podAffinityTerm(term.PodAffinityTerm)},
+		)
+	}
+	return retval
+}
+
+func podAffinityTerm(term v1.PodAffinityTerm) bitesize.PodAffinityTerm {
+	retval := bitesize.PodAffinityTerm{
+		Namespaces:  term.Namespaces,
+		TopologyKey: term.TopologyKey,
+	}
+	if s := term.LabelSelector; s != nil {
+		retval.LabelSelector = &bitesize.LabelSelector{MatchLabels: s.MatchLabels}
+		for _, r := range s.MatchExpressions {
+			retval.LabelSelector.MatchExpressions = append(retval.LabelSelector.MatchExpressions, bitesize.SelectorRequirement{
+				Key:      r.Key,
+				Operator: string(r.Operator),
+				Values:   r.Values,
+			})
+		}
+	}
+	return retval
+}
+
+func tolerations(tolerations []v1.Toleration) []bitesize.Toleration {
+	var retval []bitesize.Toleration
+	for _, t := range tolerations {
+		retval = append(retval, bitesize.Toleration{
+			Key:               t.Key,
+			Operator:          string(t.Operator),
+			Value:             t.Value,
+			Effect:            string(t.Effect),
+			TolerationSeconds: t.TolerationSeconds,
+		})
+	}
+	return retval
+}
+
+// antiAffinity returns label pods are spread across nodes by, as set by
+// anti_affinity, or empty for pods without such anti-affinity
+func antiAffinity(affinity *v1.Affinity) string {
+	if affinity == nil || affinity.PodAntiAffinity == nil {
+		return ""
+	}
+	for _, term := range affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		selector := term.PodAffinityTerm.LabelSelector
+		if term.PodAffinityTerm.TopologyKey != v1.LabelHostname || selector == nil || len(selector.MatchLabels) != 1 {
+			continue
+		}
+		for label := range selector.MatchLabels {
+			if label == "name" || label == "application" {
+				return label
+			}
+		}
+	}
+	return ""
+}
+
+// verifyConfigMaps checks configmaps mounted as volumes of deployment's pods
+// exist in the namespace, so that pods don't get stuck waiting for them.
+// Optional configmaps are not checked
+func verifyConfigMaps(deployment *apps_v1.Deployment, client *k8s.Client) error {
+	var names []string
+	for _, vol := range deployment.Spec.Template.Spec.Volumes {
+		if cm := vol.ConfigMap; cm != nil && (cm.Optional == nil || !*cm.Optional) {
+			names = append(names, cm.Name)
+		}
+		if vol.Projected == nil {
+			continue
+		}
+		for _, source := range vol.Projected.Sources {
+			if cm := source.ConfigMap; cm != nil && (cm.Optional == nil || !*cm.Optional) {
+				names = append(names, cm.Name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		if client.ConfigMap().Exist(name) {
+			continue
+		}
+		err := fmt.Errorf("unable to find configmap [%s] in namespace [%s] when processing volumes for deployment [%s]", name, client.Namespace, deployment.Name)
+		if err := k8s.MissingReference(err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyEnvFrom checks configmaps and secrets whose keys are set as env vars
+// of deployment's containers exist in the namespace
+func verifyEnvFrom(deployment *apps_v1.Deployment, client *k8s.Client) error {
+	for _, c := range deployment.Spec.Template.Spec.Containers {
+		for _, src := range c.EnvFrom {
+			var err error
+			switch {
+			case src.ConfigMapRef != nil && !client.ConfigMap().Exist(src.ConfigMapRef.Name):
+				log.Debugf("Unable to find ConfigMap %s", src.ConfigMapRef.Name)
+				err = fmt.Errorf("unable to find configmap [%s] in namespace [%s] when processing env_from for deployment [%s]", src.ConfigMapRef.Name, client.Namespace, deployment.Name)
+			case src.SecretRef != nil && !client.Secret().Exists(src.SecretRef.Name):
+				log.Debugf("Unable to find Secret %s", src.SecretRef.Name)
+				err = fmt.Errorf("unable to find secret [%s] in namespace [%s] when processing env_from for deployment [%s]", src.SecretRef.Name, client.Namespace, deployment.Name)
+			}
+			if err == nil {
+				continue
+			}
+			if err := k8s.MissingReference(err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// envFrom returns configmaps and secrets env vars of the container are
+// set from
+func envFrom(sources []v1.EnvFromSource) []bitesize.EnvFromSource {
+	var retval []bitesize.EnvFromSource
+	for _, src := range sources {
+		source := bitesize.EnvFromSource{Prefix: src.Prefix}
+		if src.ConfigMapRef != nil {
+			source.ConfigMap = src.ConfigMapRef.Name
+		}
+		if src.SecretRef != nil {
+			source.Secret = src.SecretRef.Name
+		}
+		retval = append(retval, source)
+	}
+	return retval
+}
+
+// verifyImages checks signatures of all images deployment's pods run,
+// including init containers, when IMAGE_VERIFY is enabled, and pins the
+// containers to the image digests verified. Registries are accessed with
+// credentials of the deployment's image pull secrets
+func verifyImages(deployment *apps_v1.Deployment, client *k8s.Client) error {
+	if !cosign.Enabled() {
+		return nil
+	}
+
+	dockerConfig, err := registryCredentials(deployment, client)
+	if err != nil {
+		return err
+	}
+
+	pinned := map[string]string{}
+	pin := func(containers []v1.Container) error {
+		for i := range containers {
+			image := containers[i].Image
+			if pinned[image] == "" {
+				p, err := cosign.Verify(image, dockerConfig)
+				if err != nil {
+					return err
+				}
+				pinned[image] = p
+			}
+			containers[i].Image = pinned[image]
+		}
+		return nil
+	}
+
+	spec := &deployment.Spec.Template.Spec
+	if err := pin(spec.InitContainers); err != nil {
+		return err
+	}
+	return pin(spec.Containers)
+}
+
+// registryCredentials merges registry credentials of deployment's image
+// pull secrets into a docker config.json. Missing secrets are skipped, the
+// images may be public
+func registryCredentials(deployment *apps_v1.Deployment, client *k8s.Client) ([]byte, error) {
+	auths := map[string]json.RawMessage{}
+	for _, ref := range deployment.Spec.Template.Spec.ImagePullSecrets {
+		secret, err := client.Secret().Get(ref.Name)
+		if err != nil {
+			log.Warnf("image pull secret %s of deployment %s: %s", ref.Name, deployment.Name, err.Error())
+			continue
+		}
+
+		var cfg struct {
+			Auths map[string]json.RawMessage `json:"auths"`
+		}
+		switch secret.Type {
+		case v1.SecretTypeDockerConfigJson:
+			err = json.Unmarshal(secret.Data[v1.DockerConfigJsonKey], &cfg)
+		case v1.SecretTypeDockercfg:
+			err = json.Unmarshal(secret.Data[v1.DockerConfigKey], &cfg.Auths)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid image pull secret %s: %s", ref.Name, err.Error())
+		}
+		for registry, auth := range cfg.Auths {
+			auths[registry] = auth
+		}
+	}
+
+	if len(auths) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(map[string]interface{}{"auths": auths})
+}
@@ -1,12 +1,20 @@
 package cluster
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+
 	"k8s.io/apimachinery/pkg/util/intstr"
 
 	apps_v1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestHealthCheck(t *testing.T) {
@@ -169,3 +177,164 @@ func TestReservedEnvVar(t *testing.T) {
 		}
 	}
 }
+
+func TestContainerStatuses(t *testing.T) {
+	statuses := []v1.ContainerStatus{
+		{
+			Name:         "api",
+			RestartCount: 4,
+			State: v1.ContainerState{
+				Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off 40s restarting failed container"},
+			},
+			LastTerminationState: v1.ContainerState{
+				Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+			},
+		},
+		{
+			Name:  "proxy",
+			Ready: true,
+			State: v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+		},
+	}
+
+	expected := []bitesize.ContainerStatus{
+		{
+			Name:            "api",
+			RestartCount:    4,
+			State:           "waiting",
+			Reason:          "CrashLoopBackOff",
+			Message:         "back-off 40s restarting failed container",
+			LastStateReason: "OOMKilled",
+			LastExitCode:    137,
+		},
+		{
+			Name:  "proxy",
+			Ready: true,
+			State: "running",
+		},
+	}
+
+	if got := containerStatuses(statuses); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected container statuses. Expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestLogsContainer(t *testing.T) {
+	pod := v1.Pod{
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "migrate"}},
+			Containers:     []v1.Container{{Name: "api"}, {Name: "proxy"}},
+		},
+	}
+
+	var tests = []struct {
+		Container string
+		Expected  string
+	}{
+		{"", "api"},
+		{"proxy", "proxy"},
+		{"migrate", "migrate"},
+		{"missing", ""},
+	}
+
+	for _, tst := range tests {
+		if got := logsContainer(pod, tst.Container); got != tst.Expected {
+			t.Errorf("Unexpected logs container for %q. Expected %q, got %q", tst.Container, tst.Expected, got)
+		}
+	}
+}
+
+func TestVolumeDefaultMode(t *testing.T) {
+	defaulted := v1.SecretVolumeSourceDefaultMode
+	private := int32(0400)
+
+	if m := volumeDefaultMode(nil); m != nil {
+		t.Errorf("Expected nil for unset mode, got %o", *m)
+	}
+	if m := volumeDefaultMode(&defaulted); m != nil {
+		t.Errorf("Expected nil for kubernetes default mode, got %o", *m)
+	}
+	if m := volumeDefaultMode(&private); m == nil || *m != private {
+		t.Errorf("Expected mode %o, got %v", private, m)
+	}
+}
+
+func TestNodeSelector(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.DefaultNodeSelector = map[string]string{"role": "minion"}
+
+	if s := nodeSelector(map[string]string{"role": "minion"}); s != nil {
+		t.Errorf("Expected operator default nodeSelector to be dropped, got %v", s)
+	}
+	if s := nodeSelector(map[string]string{}); s != nil {
+		t.Errorf("Expected empty nodeSelector to be dropped, got %v", s)
+	}
+	if s := nodeSelector(map[string]string{"pool": "gpu"}); s["pool"] != "gpu" || len(s) != 1 {
+		t.Errorf("Expected service nodeSelector to be loaded back, got %v", s)
+	}
+}
+
+func TestAntiAffinity(t *testing.T) {
+	if l := antiAffinity(nil); l != "" {
+		t.Errorf("Expected no anti-affinity for pods without affinity, got %s", l)
+	}
+
+	for _, label := range []string{"name", "application"} {
+		mapper := &translator.KubeMapper{
+			BiteService: &bitesize.Service{Name: "api-blue", Application: "api", AntiAffinity: label},
+			Namespace:   "sample",
+		}
+		deployment, err := mapper.Deployment()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if l := antiAffinity(deployment.Spec.Template.Spec.Affinity); l != label {
+			t.Errorf("Expected anti-affinity %s to be loaded back, got %q", label, l)
+		}
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	client := &k8s.Client{
+		Interface: fake.NewSimpleClientset(
+			&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "sample"},
+				Type:       v1.SecretTypeDockerConfigJson,
+				Data: map[string][]byte{
+					v1.DockerConfigJsonKey: []byte(`{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz"}}}`),
+				},
+			},
+		),
+		Namespace: "sample",
+	}
+	deployment := &apps_v1.Deployment{}
+	deployment.Spec.Template.Spec.ImagePullSecrets = []v1.LocalObjectReference{{Name: "registry"}, {Name: "missing"}}
+
+	data, err := registryCredentials(deployment, client)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	expected := `{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz"}}}`
+	if string(data) != expected {
+		t.Errorf("Expected credentials %s, got %s", expected, data)
+	}
+
+	deployment.Spec.Template.Spec.ImagePullSecrets = nil
+	if data, _ := registryCredentials(deployment, client); data != nil {
+		t.Errorf("Expected no credentials without image pull secrets, got %s", data)
+	}
+}
+
+func TestIngressAnnotations(t *testing.T) {
+	metadata := metav1.ObjectMeta{Annotations: map[string]string{
+		"alb.ingress.kubernetes.io/target-type":        "ip",
+		"alb.ingress.kubernetes.io/load-balancer-name": "k8s-sample",
+		k8s.CertManagerClusterIssuerAnnotation:         "letsencrypt",
+		k8s.OwnedIngressAnnotationsAnnotation:          "alb.ingress.kubernetes.io/target-type",
+	}}
+
+	expected := map[string]string{"alb.ingress.kubernetes.io/target-type": "ip"}
+	if got := ingressAnnotations(metadata); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected only annotations set by the operator, got %v", got)
+	}
+}
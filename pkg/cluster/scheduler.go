@@ -0,0 +1,115 @@
+package cluster
+
+import (
+	"sync"
+
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Scheduler runs apply tasks with a global concurrency cap. Tasks are queued
+// per namespace and dispatched round-robin across namespaces, so a single
+// large environment can't monopolize the worker pool.
+type Scheduler struct {
+	mu         sync.Mutex
+	max        int
+	running    int
+	queues     map[string][]func()
+	namespaces []string
+	next       int
+	// depth, if set, reports number of queued tasks by namespace
+	depth *prometheus.GaugeVec
+}
+
+// scheduler is shared by all clusters so the cap applies operator-wide
+var scheduler = NewScheduler(config.Env.ReconcileConcurrency)
+
+func init() {
+	scheduler.depth = metrics.ReconcileQueueDepth
+}
+
+// QueueDepth returns number of service applies waiting for a worker of the
+// operator-wide scheduler
+func QueueDepth() int {
+	return scheduler.Depth()
+}
+
+// NewScheduler returns a Scheduler running at most max tasks at once
+func NewScheduler(max int) *Scheduler {
+	if max < 1 {
+		max = 1
+	}
+	return &Scheduler{
+		max:    max,
+		queues: map[string][]func(){},
+	}
+}
+
+// Submit queues task for the namespace. Tasks for the same namespace are
+// started in the order they were submitted.
+func (s *Scheduler) Submit(namespace string, task func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.queues[namespace]; !ok {
+		s.namespaces = append(s.namespaces, namespace)
+	}
+	s.queues[namespace] = append(s.queues[namespace], task)
+	s.dispatch()
+	s.observe(namespace)
+}
+
+// Depth returns number of tasks queued, not counting running ones
+func (s *Scheduler) Depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	depth := 0
+	for _, q := range s.queues {
+		depth += len(q)
+	}
+	return depth
+}
+
+// observe reports queue depth of the namespace. Must be called with s.mu
+// held.
+func (s *Scheduler) observe(namespace string) {
+	if s.depth != nil {
+		s.depth.WithLabelValues(namespace).Set(float64(len(s.queues[namespace])))
+	}
+}
+
+// dispatch starts queued tasks until the cap is reached. Must be called
+// with s.mu held.
+func (s *Scheduler) dispatch() {
+	for s.running < s.max && len(s.namespaces) > 0 {
+		if s.next >= len(s.namespaces) {
+			s.next = 0
+		}
+		ns := s.namespaces[s.next]
+		task := s.queues[ns][0]
+		s.queues[ns] = s.queues[ns][1:]
+
+		if len(s.queues[ns]) == 0 {
+			delete(s.queues, ns)
+			s.namespaces = append(s.namespaces[:s.next], s.namespaces[s.next+1:]...)
+		} else {
+			s.next++
+		}
+
+		s.observe(ns)
+		s.running++
+		go s.run(task)
+	}
+}
+
+func (s *Scheduler) run(task func()) {
+	defer func() {
+		s.mu.Lock()
+		s.running--
+		s.dispatch()
+		s.mu.Unlock()
+	}()
+	task()
+}
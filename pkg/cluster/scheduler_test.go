@@ -0,0 +1,121 @@
+package cluster
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestSchedulerConcurrencyCap(t *testing.T) {
+	s := NewScheduler(2)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	running, peak := 0, 0
+	release := make(chan struct{})
+
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		s.Submit("ns", func() {
+			defer wg.Done()
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+
+			<-release
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+	}
+
+	close(release)
+	wg.Wait()
+
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent tasks, got %d", peak)
+	}
+}
+
+func TestSchedulerFairness(t *testing.T) {
+	s := NewScheduler(1)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var order []string
+	block := make(chan struct{})
+
+	// occupy the only worker so the rest of the tasks queue up
+	wg.Add(1)
+	s.Submit("blocker", func() {
+		defer wg.Done()
+		<-block
+	})
+
+	record := func(ns string) func() {
+		return func() {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, ns)
+			mu.Unlock()
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		s.Submit("large", record("large"))
+	}
+	wg.Add(1)
+	s.Submit("small", record("small"))
+
+	close(block)
+	wg.Wait()
+
+	if len(order) != 4 || order[1] != "small" {
+		t.Errorf("Expected small namespace to be scheduled second, got %v", order)
+	}
+}
+
+func gaugeValue(g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	g.Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestSchedulerQueueDepth(t *testing.T) {
+	s := NewScheduler(1)
+	s.depth = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "queue_depth"}, []string{"namespace"})
+
+	var wg sync.WaitGroup
+	block := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		s.Submit("ns", func() {
+			defer wg.Done()
+			<-block
+		})
+	}
+
+	if d := s.Depth(); d != 2 {
+		t.Errorf("Expected 2 tasks queued behind the running one, got %d", d)
+	}
+	if d := gaugeValue(s.depth.WithLabelValues("ns")); d != 2 {
+		t.Errorf("Expected queue depth gauge 2, got %v", d)
+	}
+
+	close(block)
+	wg.Wait()
+
+	if d := s.Depth(); d != 0 {
+		t.Errorf("Expected empty queue, got %d", d)
+	}
+	if d := gaugeValue(s.depth.WithLabelValues("ns")); d != 0 {
+		t.Errorf("Expected queue depth gauge 0, got %v", d)
+	}
+}
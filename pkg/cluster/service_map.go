@@ -3,12 +3,16 @@ package cluster
 import (
 	"fmt"
 	"os"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
 	"github.com/pearsontechnology/environment-operator/pkg/util"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 	apps_v1 "k8s.io/api/apps/v1"
 	autoscale_v2beta2 "k8s.io/api/autoscaling/v2beta2"
 	v1 "k8s.io/api/core/v1"
@@ -60,13 +64,39 @@ func (s ServiceMap) AddService(svc v1.Service) {
 	biteservice := s.CreateOrGet(name)
 	biteservice.Application = getLabel(svc.ObjectMeta, "application")
 	biteservice.Deployment = s.addDeploymentSettings(svc.ObjectMeta)
+	biteservice.ServiceAnnotations = serviceAnnotations(svc.ObjectMeta)
+	biteservice.PublishNotReadyAddresses = svc.Spec.PublishNotReadyAddresses
+	biteservice.TopologyAwareRouting = getAnnotation(svc.ObjectMeta, k8s.TopologyAwareHintsAnnotation) == "Auto"
+	biteservice.Patches = servicePatches(svc.ObjectMeta)
+	biteservice.ServiceMonitor = serviceMonitor(svc.ObjectMeta)
+
+	if svc.Spec.Type == v1.ServiceTypeExternalName {
+		biteservice.Type = bitesize.TypeExternalName
+		biteservice.ExternalName = svc.Spec.ExternalName
+	} else if svc.Spec.ClusterIP == v1.ClusterIPNone {
+		biteservice.Headless = true
+	} else {
+		biteservice.ClusterIP = svc.Spec.ClusterIP
+	}
+
+	if t := svc.Spec.Type; t == v1.ServiceTypeNodePort || t == v1.ServiceTypeLoadBalancer {
+		biteservice.ServiceType = string(t)
+		biteservice.LoadBalancerSourceRanges = svc.Spec.LoadBalancerSourceRanges
+	}
 
 	if len(svc.Spec.Ports) > 0 {
 		biteservice.Ports = []int{}
 	}
 
+	biteservice.TargetPorts = nil
 	for _, port := range svc.Spec.Ports {
 		biteservice.Ports = append(biteservice.Ports, int(port.Port))
+		if target := int(port.TargetPort.IntVal); target != 0 && target != int(port.Port) {
+			if biteservice.TargetPorts == nil {
+				biteservice.TargetPorts = map[int]int{}
+			}
+			biteservice.TargetPorts[int(port.Port)] = target
+		}
 	}
 	util.LogTraceAsYaml("AddService biteservice", biteservice)
 }
@@ -121,6 +151,8 @@ func (s ServiceMap) AddDeployment(deployment apps_v1.Deployment) {
 	biteservice.Application = getLabel(deployment.ObjectMeta, "application")
 	biteservice.HTTPSBackend = getLabel(deployment.ObjectMeta, "httpsBackend")
 	biteservice.EnvVars = envVars(deployment)
+	biteservice.SecretFetch = secretFetch(deployment)
+	biteservice.DeploymentAnnotations = deploymentAnnotations(deployment.ObjectMeta)
 	biteservice.HealthCheck = healthCheck(deployment)
 	biteservice.LivenessProbe = livenessProbe(deployment)
 	biteservice.ReadinessProbe = readinessProbe(deployment)
@@ -135,9 +167,42 @@ func (s ServiceMap) AddDeployment(deployment apps_v1.Deployment) {
 		biteservice.Volumes = sortedVols
 	}
 
+	// kubernetes fills in the default scheduler when none is requested
+	if deployment.Spec.Template.Spec.SchedulerName != v1.DefaultSchedulerName {
+		biteservice.SchedulerName = deployment.Spec.Template.Spec.SchedulerName
+	}
+
+	biteservice.NodeName = deployment.Spec.Template.Spec.NodeName
+	biteservice.NodeSelector = nodeSelector(deployment.Spec.Template.Spec.NodeSelector)
+	if len(deployment.Spec.Template.Spec.ImagePullSecrets) == 0 && util.RegistrySecrets() != "" {
+		biteservice.ImagePullSecrets = "none"
+	}
+	biteservice.HostNetwork = deployment.Spec.Template.Spec.HostNetwork
+	biteservice.HostPID = deployment.Spec.Template.Spec.HostPID
+	biteservice.HostIPC = deployment.Spec.Template.Spec.HostIPC
+
+	if deployment.Spec.Template.Spec.RuntimeClassName != nil {
+		biteservice.RuntimeClass = *deployment.Spec.Template.Spec.RuntimeClassName
+	}
+
+	biteservice.AntiAffinity = antiAffinity(deployment.Spec.Template.Spec.Affinity)
+	biteservice.Affinity = affinity(deployment.Spec.Template.Spec.Affinity, biteservice.AntiAffinity != "")
+	biteservice.Tolerations = tolerations(deployment.Spec.Template.Spec.Tolerations)
+	biteservice.MinReadySeconds = deployment.Spec.MinReadySeconds
+	if l := deployment.Spec.RevisionHistoryLimit; l != nil && int(*l) != config.Env.RevisionHistoryLimit {
+		limit := *l
+		biteservice.RevisionHistoryLimit = &limit
+	}
+
+	for _, gate := range deployment.Spec.Template.Spec.ReadinessGates {
+		biteservice.ReadinessGates = append(biteservice.ReadinessGates, string(gate.ConditionType))
+	}
+
 	for _, cmd := range deployment.Spec.Template.Spec.Containers[0].Command {
 		biteservice.Commands = append(biteservice.Commands, string(cmd))
 	}
+	biteservice.ImagePullPolicy = string(deployment.Spec.Template.Spec.Containers[0].ImagePullPolicy)
+	biteservice.EnvFrom = envFrom(deployment.Spec.Template.Spec.Containers[0].EnvFrom)
 
 	if deployment.Spec.Template.ObjectMeta.Annotations != nil {
 		biteservice.Annotations = deployment.Spec.Template.ObjectMeta.Annotations
@@ -156,6 +221,54 @@ func (s ServiceMap) AddDeployment(deployment apps_v1.Deployment) {
 	util.LogTraceAsYaml("AddDeployment biteservice", biteservice)
 }
 
+// AddPod records the image digest pod is running to its biteservice status.
+// Pods not belonging to a known deployment are ignored
+func (s ServiceMap) AddPod(pod v1.Pod) {
+	name := getLabel(pod.ObjectMeta, "name")
+	digest := imageID(pod)
+
+	if s[name] == nil || digest == "" {
+		return
+	}
+
+	biteservice := s[name]
+	for _, d := range biteservice.Status.ImageDigests {
+		if d == digest {
+			return
+		}
+	}
+	biteservice.Status.ImageDigests = append(biteservice.Status.ImageDigests, digest)
+	sort.Strings(biteservice.Status.ImageDigests)
+}
+
+// AddExternalSecret records secret manager entries env vars of biteservice
+// are synced from. Env vars read the secret by the ExternalSecret name and
+// are set back to the secret they are configured with
+func (s ServiceMap) AddExternalSecret(es k8_extensions.ExternalSecret) {
+	name := getLabel(es.ObjectMeta, "name")
+	secret := getLabel(es.ObjectMeta, k8s.EnvSecretLabel)
+	if s[name] == nil || secret == "" {
+		return
+	}
+
+	biteservice := s[name]
+	biteservice.Status.EnvExternalSecrets = append(biteservice.Status.EnvExternalSecrets, es.Name)
+	for i := range biteservice.EnvVars {
+		e := &biteservice.EnvVars[i]
+		ref, key := e.SecretRef()
+		if e.Secret == "" || ref != es.Name {
+			continue
+		}
+		for _, entry := range es.SecretDescriptor.Data {
+			if entry["name"] == key {
+				e.Value = fmt.Sprintf("%s/%s", secret, key)
+				e.ExternalKey = entry["key"]
+				e.ExternalProperty = entry["property"]
+			}
+		}
+	}
+}
+
 // AddHPA adds Kubernetes HPA to biteservice
 func (s ServiceMap) AddHPA(hpa autoscale_v2beta2.HorizontalPodAutoscaler) {
 	name := hpa.Name
@@ -165,6 +278,7 @@ func (s ServiceMap) AddHPA(hpa autoscale_v2beta2.HorizontalPodAutoscaler) {
 	biteservice.HPA.MinReplicas = *hpa.Spec.MinReplicas
 	biteservice.HPA.MaxReplicas = hpa.Spec.MaxReplicas
 	biteservice.Replicas = int(biteservice.HPA.MinReplicas)
+	biteservice.HPA.Labels = hpaLabels(hpa)
 
 	if hpa.Spec.Metrics[0].Type == "Resource" {
 		if hpa.Spec.Metrics[0].Resource.Name == "cpu" {
@@ -203,6 +317,12 @@ func (s ServiceMap) AddVolumeClaim(claim v1.PersistentVolumeClaim) {
 		Size:  claim.ObjectMeta.Labels["size"],
 		Name:  claim.ObjectMeta.Name,
 		Type:  claim.ObjectMeta.Labels["type"],
+
+		Labels:      volumeClaimLabels(claim),
+		Annotations: volumeClaimAnnotations(claim),
+	}
+	if claim.Spec.StorageClassName != nil && getAnnotation(claim.ObjectMeta, "volume.beta.kubernetes.io/storage-class") == "" {
+		vol.StorageClass = *claim.Spec.StorageClassName
 	}
 
 	vols := append(biteservice.Volumes, vol)
@@ -241,6 +361,11 @@ func (s ServiceMap) AddCustomResourceDefinition(crd k8_extensions.PrsnExternalRe
 
 // AddIngress adds Kubernetes ingress fields to biteservice
 func (s ServiceMap) AddIngress(ingress netwk_v1beta1.Ingress) {
+	if getAnnotation(ingress.ObjectMeta, "nginx.ingress.kubernetes.io/canary") == "true" {
+		s.addCanaryIngress(ingress)
+		return
+	}
+
 	name := ingress.Name
 	biteservice := s.CreateOrGet(name)
 
@@ -263,12 +388,18 @@ func (s ServiceMap) AddIngress(ingress netwk_v1beta1.Ingress) {
 		}
 	}
 
+	biteservice.CertManager = ingressCertManager(ingress, biteservice.ExternalURL)
+	biteservice.BackendHealthCheck = backendHealthCheck(ingress.ObjectMeta)
+	biteservice.IngressAnnotations = ingressAnnotations(ingress.ObjectMeta)
+
 	biteservice.HTTPSBackend = httpsBackend
 	biteservice.HTTP2 = ingress.Labels["http2"]
 
 	// backend service has been overridden
 	backendService := ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.ServiceName
-	if backendService != biteservice.Name {
+	if ingress.Labels["weighted"] == "true" {
+		weightedBackends(biteservice)[0].Service = backendService
+	} else if backendService != biteservice.Name {
 		biteservice.Backend = backendService
 	}
 	// backend port has been overriden
@@ -278,3 +409,41 @@ func (s ServiceMap) AddIngress(ingress netwk_v1beta1.Ingress) {
 	}
 	util.LogTraceAsYaml("AddIngress biteservice", biteservice)
 }
+
+// ingressCertManager returns cert-manager settings requested by ingress
+// issuer annotations. Hosts are only set if they differ from ingress rules
+func ingressCertManager(ingress netwk_v1beta1.Ingress, externalURL []string) *bitesize.CertManager {
+	retval := &bitesize.CertManager{IssuerKind: "ClusterIssuer"}
+	if retval.Issuer = getAnnotation(ingress.ObjectMeta, k8s.CertManagerClusterIssuerAnnotation); retval.Issuer == "" {
+		retval.IssuerKind = "Issuer"
+		retval.Issuer = getAnnotation(ingress.ObjectMeta, k8s.CertManagerIssuerAnnotation)
+	}
+	if retval.Issuer == "" {
+		return nil
+	}
+	if len(ingress.Spec.TLS) > 0 && !reflect.DeepEqual(ingress.Spec.TLS[0].Hosts, externalURL) {
+		retval.Hosts = ingress.Spec.TLS[0].Hosts
+	}
+	return retval
+}
+
+// addCanaryIngress adds weighted backend served by canary ingress to biteservice
+func (s ServiceMap) addCanaryIngress(ingress netwk_v1beta1.Ingress) {
+	biteservice := s.CreateOrGet(getLabel(ingress.ObjectMeta, "name"))
+	weight, _ := strconv.Atoi(getAnnotation(ingress.ObjectMeta, "nginx.ingress.kubernetes.io/canary-weight"))
+
+	backends := weightedBackends(biteservice)
+	backends[1].Service = ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.ServiceName
+	backends[1].Weight = weight
+	backends[0].Weight = 100 - weight
+	util.LogTraceAsYaml("addCanaryIngress biteservice", biteservice)
+}
+
+// weightedBackends returns primary and canary backends of biteservice,
+// initializing them if ingresses were not seen yet
+func weightedBackends(biteservice *bitesize.Service) []bitesize.WeightedBackend {
+	if len(biteservice.WeightedBackends) != 2 {
+		biteservice.WeightedBackends = []bitesize.WeightedBackend{{Weight: 100}, {}}
+	}
+	return biteservice.WeightedBackends
+}
@@ -1,8 +1,14 @@
 package cluster
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	ext "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"github.com/pearsontechnology/environment-operator/pkg/translator"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -31,3 +37,399 @@ func TestAddDeploymentSetting(t *testing.T) {
 		t.Errorf("unexpected active deployment name. expected test-blue, got: %+v", biteservice.ActiveDeploymentName())
 	}
 }
+
+func TestAddExternalSecret(t *testing.T) {
+	serviceMap := ServiceMap{}
+	biteservice := serviceMap.CreateOrGet("api")
+	biteservice.EnvVars = []bitesize.EnvVar{
+		{Secret: "DB_PASSWORD", Value: "api-env-db/password"},
+		{Secret: "TOKEN", Value: "token/token"},
+	}
+
+	serviceMap.AddExternalSecret(ext.ExternalSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "api-env-db",
+			Labels: map[string]string{"name": "api", k8s.EnvSecretLabel: "db"},
+		},
+		SecretDescriptor: ext.ExternalSecretSecretDescriptor{
+			Data: []map[string]string{{"key": "prod/db", "name": "password", "property": "pass"}},
+		},
+	})
+
+	expected := []bitesize.EnvVar{
+		{Secret: "DB_PASSWORD", Value: "db/password", ExternalKey: "prod/db", ExternalProperty: "pass"},
+		{Secret: "TOKEN", Value: "token/token"},
+	}
+	if !reflect.DeepEqual(biteservice.EnvVars, expected) {
+		t.Errorf("Expected env vars %+v, got %+v", expected, biteservice.EnvVars)
+	}
+	if s := biteservice.Status.EnvExternalSecrets; len(s) != 1 || s[0] != "api-env-db" {
+		t.Errorf("Expected ExternalSecret api-env-db to be recorded, got %v", s)
+	}
+}
+
+func TestAddPodImageDigests(t *testing.T) {
+	serviceMap := ServiceMap{}
+	serviceMap.CreateOrGet("test")
+
+	pod := func(name, digest string) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"name": "test"},
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "test"}},
+			},
+			Status: v1.PodStatus{
+				ContainerStatuses: []v1.ContainerStatus{
+					{Name: "test", ImageID: digest},
+				},
+			},
+		}
+	}
+
+	serviceMap.AddPod(pod("test-1", "docker-pullable://test@sha256:bbb"))
+	serviceMap.AddPod(pod("test-2", "docker-pullable://test@sha256:bbb"))
+
+	biteservice := serviceMap.CreateOrGet("test")
+	if len(biteservice.Status.ImageDigests) != 1 {
+		t.Errorf("unexpected image digests. expected 1, got: %+v", biteservice.Status.ImageDigests)
+	}
+
+	serviceMap.AddPod(pod("test-3", "docker-pullable://test@sha256:aaa"))
+	if len(biteservice.Status.ImageDigests) != 2 ||
+		biteservice.Status.ImageDigests[0] != "docker-pullable://test@sha256:aaa" {
+		t.Errorf("unexpected image digests after drift: %+v", biteservice.Status.ImageDigests)
+	}
+
+	other := pod("other-1", "docker-pullable://other@sha256:ccc")
+	other.Labels["name"] = "other"
+	serviceMap.AddPod(other)
+	if _, ok := serviceMap["other"]; ok {
+		t.Error("unexpected service created for pod without deployment")
+	}
+}
+
+func TestAddExternalNameService(t *testing.T) {
+	svc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db",
+			Namespace: "sample",
+		},
+		Spec: v1.ServiceSpec{
+			Type:         v1.ServiceTypeExternalName,
+			ExternalName: "db.example.com",
+		},
+	}
+	serviceMap := ServiceMap{}
+
+	serviceMap.AddService(svc)
+
+	biteservice := serviceMap.CreateOrGet("db")
+	if !biteservice.IsExternalName() {
+		t.Errorf("unexpected service type. expected externalname, got: %s", biteservice.Type)
+	}
+
+	if biteservice.ExternalName != "db.example.com" {
+		t.Errorf("unexpected external name. expected db.example.com, got: %s", biteservice.ExternalName)
+	}
+}
+
+func TestAddVolumeClaimLabels(t *testing.T) {
+	vol := bitesize.Volume{
+		Name:         "data",
+		Path:         "/data",
+		Modes:        "ReadWriteOnce",
+		Size:         "1Gi",
+		Labels:       map[string]string{"backup": "daily"},
+		Annotations:  map[string]string{"backup.velero.io/backup-volumes": "data"},
+		StorageClass: "gp3",
+	}
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{Name: "db", Volumes: []bitesize.Volume{vol}},
+		Namespace:   "sample",
+	}
+	pvcs, _ := mapper.PersistentVolumeClaims()
+	claim := pvcs[0]
+	claim.Annotations["pv.kubernetes.io/bind-completed"] = "yes"
+
+	serviceMap := ServiceMap{}
+	serviceMap.AddVolumeClaim(claim)
+
+	got := serviceMap.CreateOrGet("db").Volumes[0]
+	if !reflect.DeepEqual(got.Labels, vol.Labels) {
+		t.Errorf("unexpected volume labels. expected %v, got: %v", vol.Labels, got.Labels)
+	}
+	if !reflect.DeepEqual(got.Annotations, vol.Annotations) {
+		t.Errorf("unexpected volume annotations. expected %v, got: %v", vol.Annotations, got.Annotations)
+	}
+	if got.StorageClass != "gp3" {
+		t.Errorf("unexpected storage class. expected gp3, got: %s", got.StorageClass)
+	}
+}
+
+func TestAddDeploymentSecretFetch(t *testing.T) {
+	fetch := &bitesize.SecretFetch{
+		Image:     "hashicorp/vault:1.13",
+		Command:   []string{"vault", "agent"},
+		EnvVars:   []bitesize.EnvVar{{Name: "VAULT_ADDR", Value: "https://vault:8200"}},
+		ConfigMap: "api-vault",
+		Path:      "/vault/secrets",
+	}
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{Name: "api", SecretFetch: fetch},
+		Namespace:   "sample",
+	}
+	deployment, err := mapper.Deployment()
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	serviceMap := ServiceMap{}
+	serviceMap.AddDeployment(*deployment)
+
+	biteservice := serviceMap.CreateOrGet("api")
+	if !reflect.DeepEqual(biteservice.SecretFetch, fetch) {
+		t.Errorf("unexpected secret_fetch. expected %+v, got: %+v", fetch, biteservice.SecretFetch)
+	}
+	if len(biteservice.Volumes) != 0 {
+		t.Errorf("unexpected volumes: %+v", biteservice.Volumes)
+	}
+}
+
+func TestAddDeploymentAnnotations(t *testing.T) {
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{
+			Name:                  "api",
+			DeploymentAnnotations: map[string]string{"keel.sh/policy": "minor"},
+		},
+		Namespace: "sample",
+	}
+	deployment, _ := mapper.Deployment()
+	deployment.Annotations["deployment.kubernetes.io/revision"] = "3"
+
+	serviceMap := ServiceMap{}
+	serviceMap.AddDeployment(*deployment)
+
+	expected := map[string]string{"keel.sh/policy": "minor"}
+	if got := serviceMap.CreateOrGet("api").DeploymentAnnotations; !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected deployment annotations. expected %v, got: %v", expected, got)
+	}
+}
+
+func TestAddDeploymentNodeName(t *testing.T) {
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{Name: "api", NodeName: "node-1"},
+		Namespace:   "sample",
+	}
+	deployment, _ := mapper.Deployment()
+
+	serviceMap := ServiceMap{}
+	serviceMap.AddDeployment(*deployment)
+
+	if got := serviceMap.CreateOrGet("api").NodeName; got != "node-1" {
+		t.Errorf("unexpected node name. expected node-1, got: %s", got)
+	}
+}
+
+func TestAddDeploymentHostPathVolume(t *testing.T) {
+	vol := bitesize.Volume{
+		Name:         "docker-sock",
+		Path:         "/var/run/docker.sock",
+		Type:         bitesize.TypeHostPath,
+		Modes:        "ReadWriteOnce",
+		HostPath:     "/var/run/docker.sock",
+		HostPathType: "Socket",
+	}
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{Name: "agent", Volumes: []bitesize.Volume{vol}},
+		Namespace:   "sample",
+	}
+	deployment, _ := mapper.Deployment()
+
+	serviceMap := ServiceMap{}
+	serviceMap.AddDeployment(*deployment)
+
+	expected := []bitesize.Volume{vol}
+	if got := serviceMap.CreateOrGet("agent").Volumes; !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected volumes. expected %+v, got: %+v", expected, got)
+	}
+}
+
+func TestAddDeploymentProjectedVolume(t *testing.T) {
+	vol := bitesize.Volume{
+		Name:  "aws-iam",
+		Path:  "/var/run/secrets/aws",
+		Type:  bitesize.TypeProjected,
+		Modes: "ReadWriteOnce",
+		Sources: []bitesize.ProjectedSource{
+			{ServiceAccountToken: &bitesize.ServiceAccountToken{Path: "token", Audience: "sts.amazonaws.com", ExpirationSeconds: 3600}},
+			{Secret: "ca", Items: []bitesize.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}}},
+			{DownwardAPI: []bitesize.DownwardAPIFile{{Path: "labels", FieldRef: "metadata.labels"}}},
+		},
+	}
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{Name: "api", Volumes: []bitesize.Volume{vol}},
+		Namespace:   "sample",
+	}
+	deployment, _ := mapper.Deployment()
+
+	serviceMap := ServiceMap{}
+	serviceMap.AddDeployment(*deployment)
+
+	expected := []bitesize.Volume{vol}
+	if got := serviceMap.CreateOrGet("api").Volumes; !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected volumes. expected %+v, got: %+v", expected, got)
+	}
+}
+
+func TestAddDeploymentHostNamespaces(t *testing.T) {
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{Name: "agent", HostNetwork: true, HostIPC: true},
+		Namespace:   "sample",
+	}
+	deployment, _ := mapper.Deployment()
+
+	serviceMap := ServiceMap{}
+	serviceMap.AddDeployment(*deployment)
+
+	svc := serviceMap.CreateOrGet("agent")
+	if !svc.HostNetwork || svc.HostPID || !svc.HostIPC {
+		t.Errorf("unexpected host namespaces. expected host_network and host_ipc, got: %+v", svc)
+	}
+}
+
+func TestAddDeploymentRevisionHistoryLimit(t *testing.T) {
+	limit := int32(3)
+	for _, l := range []*int32{nil, &limit} {
+		mapper := &translator.KubeMapper{
+			BiteService: &bitesize.Service{Name: "api", RevisionHistoryLimit: l},
+			Namespace:   "sample",
+		}
+		deployment, _ := mapper.Deployment()
+
+		serviceMap := ServiceMap{}
+		serviceMap.AddDeployment(*deployment)
+
+		svc := serviceMap.CreateOrGet("api")
+		if !reflect.DeepEqual(svc.RevisionHistoryLimit, l) {
+			t.Errorf("unexpected revision history limit. expected %v, got: %v", l, svc.RevisionHistoryLimit)
+		}
+	}
+}
+
+func TestAddIngressCertManager(t *testing.T) {
+	for _, cm := range []*bitesize.CertManager{
+		nil,
+		{Issuer: "letsencrypt", IssuerKind: "ClusterIssuer"},
+		{Issuer: "local", IssuerKind: "Issuer", Hosts: []string{"api.example.com"}},
+	} {
+		mapper := &translator.KubeMapper{
+			BiteService: &bitesize.Service{
+				Name:        "front",
+				Ports:       []int{80},
+				ExternalURL: []string{"front.example.com"},
+				CertManager: cm,
+			},
+			Namespace: "sample",
+		}
+		ingress, err := mapper.Ingress()
+		if err != nil {
+			t.Fatalf("Unexpected err: %s", err.Error())
+		}
+
+		serviceMap := ServiceMap{}
+		serviceMap.AddIngress(*ingress)
+
+		if got := serviceMap.CreateOrGet("front").CertManager; !reflect.DeepEqual(got, cm) {
+			t.Errorf("unexpected cert_manager. expected %+v, got: %+v", cm, got)
+		}
+	}
+}
+
+func TestAddIngressBackendHealthCheck(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.IngressController = "haproxy"
+
+	hc := &bitesize.BackendHealthCheck{Path: "/healthz", Interval: "10s"}
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{
+			Name:               "front",
+			Ports:              []int{80},
+			ExternalURL:        []string{"front.example.com"},
+			BackendHealthCheck: hc,
+		},
+		Namespace: "sample",
+	}
+	ingress, err := mapper.Ingress()
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	serviceMap := ServiceMap{}
+	serviceMap.AddIngress(*ingress)
+
+	front := serviceMap.CreateOrGet("front")
+	if !reflect.DeepEqual(front.BackendHealthCheck, hc) {
+		t.Errorf("unexpected backend_health_check. expected %+v, got: %+v", hc, front.BackendHealthCheck)
+	}
+	if len(front.IngressAnnotations) != 0 {
+		t.Errorf("Expected health check annotations not to be loaded as ingress_annotations, got %v", front.IngressAnnotations)
+	}
+}
+
+func TestAddServicePatches(t *testing.T) {
+	patches := []bitesize.Patch{
+		{Target: "service", Type: "merge", Patch: `{"spec": {"sessionAffinity": "ClientIP"}}`},
+	}
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{
+			Name:               "api",
+			Ports:              []int{80},
+			Patches:            patches,
+			ServiceAnnotations: map[string]string{"team": "platform"},
+		},
+		Namespace: "sample",
+	}
+	svc, err := mapper.Service()
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	serviceMap := ServiceMap{}
+	serviceMap.AddService(*svc)
+
+	biteservice := serviceMap.CreateOrGet("api")
+	if !reflect.DeepEqual(biteservice.Patches, patches) {
+		t.Errorf("unexpected patches. expected %+v, got: %+v", patches, biteservice.Patches)
+	}
+	expected := map[string]string{"team": "platform"}
+	if !reflect.DeepEqual(biteservice.ServiceAnnotations, expected) {
+		t.Errorf("unexpected service annotations. expected %v, got: %v", expected, biteservice.ServiceAnnotations)
+	}
+}
+
+func TestAddServiceTargetPorts(t *testing.T) {
+	mapper := &translator.KubeMapper{
+		BiteService: &bitesize.Service{
+			Name:        "api",
+			Ports:       []int{80, 443},
+			TargetPorts: map[int]int{80: 8080},
+		},
+		Namespace: "sample",
+	}
+	svc, err := mapper.Service()
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err.Error())
+	}
+
+	serviceMap := ServiceMap{}
+	serviceMap.AddService(*svc)
+
+	biteservice := serviceMap.CreateOrGet("api")
+	expected := map[int]int{80: 8080}
+	if !reflect.DeepEqual(biteservice.TargetPorts, expected) {
+		t.Errorf("unexpected target ports. expected %v, got: %v", expected, biteservice.TargetPorts)
+	}
+}
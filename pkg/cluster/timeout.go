@@ -0,0 +1,59 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+)
+
+// abandoned holds namespace/service keys of applies that timed out but are
+// still running in the background
+var abandoned sync.Map
+
+// applyTimeout returns SERVICE_APPLY_TIMEOUT as a duration, 0 for no timeout
+func applyTimeout() time.Duration {
+	return time.Duration(config.Env.ServiceApplyTimeout) * time.Second
+}
+
+// applyWithTimeout runs apply, giving up on it once timeout is reached so
+// that a stuck service doesn't block the rest of the environment. Kubernetes
+// requests already sent can't be cancelled, so an abandoned apply keeps
+// running in the background; the service is not applied again until it
+// finishes
+func applyWithTimeout(namespace, name string, timeout time.Duration, apply func() error) error {
+	key := namespace + "/" + name
+	if _, running := abandoned.Load(key); running {
+		return fmt.Errorf("previous apply of service %s timed out and is still running", name)
+	}
+	if timeout <= 0 {
+		return apply()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- apply()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		abandoned.Store(key, struct{}{})
+		go func() {
+			if err := <-result; err != nil {
+				log.Errorf("service %s: abandoned apply failed: %s", name, err.Error())
+			}
+			log.Infof("service %s: abandoned apply finished", name)
+			abandoned.Delete(key)
+		}()
+		log.Errorf("service %s: apply abandoned after %s", name, timeout)
+		return fmt.Errorf("apply of service %s timed out after %s", name, timeout)
+	}
+}
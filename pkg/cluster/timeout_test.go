@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyWithTimeout(t *testing.T) {
+	err := applyWithTimeout("sample", "fast", time.Second, func() error { return errors.New("failed") })
+	if err == nil || err.Error() != "failed" {
+		t.Errorf("Expected apply error to be returned, got %v", err)
+	}
+
+	release := make(chan struct{})
+	err = applyWithTimeout("sample", "stuck", 10*time.Millisecond, func() error {
+		<-release
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected timeout error, got %v", err)
+	}
+
+	called := false
+	err = applyWithTimeout("sample", "stuck", time.Second, func() error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Errorf("Expected service not to be applied while abandoned apply is running, got %v", err)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, running := abandoned.Load("sample/stuck"); !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected abandoned apply to be cleared once it finished")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := applyWithTimeout("sample", "stuck", 0, func() error { return nil }); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
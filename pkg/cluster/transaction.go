@@ -0,0 +1,253 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	ext "github.com/pearsontechnology/environment-operator/pkg/k8_extensions"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscale_v2beta2 "k8s.io/api/autoscaling/v2beta2"
+	v1 "k8s.io/api/core/v1"
+	netwk_v1beta1 "k8s.io/api/networking/v1beta1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// transaction applies objects of a service. For atomic services it records
+// the state each object had before it was applied, so that all of them can
+// be restored if applying the service fails part way. Services can also opt
+// in to recreate objects that can't be updated
+type transaction struct {
+	client   *k8s.Client
+	atomic   bool
+	recreate bool
+	undo     []undoStep
+}
+
+type undoStep struct {
+	kind string
+	name string
+	fn   func() error
+}
+
+func newTransaction(client *k8s.Client, service *bitesize.Service) *transaction {
+	return &transaction{
+		client:   client,
+		atomic:   service.Atomic,
+		recreate: service.RecreateOnConflict,
+	}
+}
+
+// record adds the step restoring object to its prior state. Objects that
+// didn't exist are deleted on rollback
+func (t *transaction) record(kind, name string, getErr error, restore, destroy func() error) error {
+	if !t.atomic {
+		return nil
+	}
+	switch {
+	case getErr == nil:
+		t.undo = append(t.undo, undoStep{kind: kind, name: name, fn: restore})
+	case k8serrors.IsNotFound(getErr):
+		t.undo = append(t.undo, undoStep{kind: kind, name: name, fn: destroy})
+	default:
+		return fmt.Errorf("could not record prior state of %s %s: %s", kind, name, getErr.Error())
+	}
+	return nil
+}
+
+// recreateOnConflict deletes and creates object again if it failed to
+// update because of a change to an immutable field, for services with
+// recreate_on_conflict set
+func (t *transaction) recreateOnConflict(kind, name string, err error, destroy, create func() error) error {
+	if err == nil || !t.recreate || !k8serrors.IsInvalid(err) {
+		return err
+	}
+	log.Warnf("%s %s can not be updated, recreating: %s", kind, name, err.Error())
+	if e := destroy(); e != nil && !k8serrors.IsNotFound(e) {
+		return e
+	}
+	return create()
+}
+
+// recreateOptions delete the object right away, leaving its dependents to
+// the garbage collector
+var recreateOptions = k8s.DeleteOptions{Propagation: metav1.DeletePropagationBackground}
+
+// PVC applies claim. Existing claims are not restored on rollback, as most
+// of their spec can't be changed back, and never recreated, as that would
+// delete their data
+func (t *transaction) PVC(claim *v1.PersistentVolumeClaim) error {
+	c := t.client.PVC()
+	if _, err := c.Get(claim.Name); k8serrors.IsNotFound(err) {
+		t.record("pvc", claim.Name, err, nil, func() error { return c.Destroy(claim.Name) })
+	}
+	return c.Apply(claim)
+}
+
+// ConfigMap applies configmap
+func (t *transaction) ConfigMap(cm *v1.ConfigMap) error {
+	c := t.client.ConfigMap()
+	prior, err := c.Get(cm.Name)
+	if e := t.record("configmap", cm.Name, err,
+		func() error { return c.Update(prior) },
+		func() error { return c.Destroy(cm.Name) }); e != nil {
+		return e
+	}
+	return t.recreateOnConflict("configmap", cm.Name, c.Apply(cm),
+		func() error { return c.Destroy(cm.Name, recreateOptions) },
+		func() error { return c.Create(cm) })
+}
+
+// Deployment applies deployment
+func (t *transaction) Deployment(deployment *apps_v1.Deployment) error {
+	c := t.client.Deployment()
+	prior, err := c.Get(deployment.Name)
+	if e := t.record("deployment", deployment.Name, err,
+		func() error { return c.Update(prior) },
+		func() error { return c.Destroy(deployment.Name) }); e != nil {
+		return e
+	}
+	return t.recreateOnConflict("deployment", deployment.Name, c.Apply(deployment),
+		func() error { return c.Destroy(deployment.Name, recreateOptions) },
+		func() error { return c.Create(deployment) })
+}
+
+// Service applies kubernetes service
+func (t *transaction) Service(svc *v1.Service) error {
+	c := t.client.Service()
+	prior, err := c.Get(svc.Name)
+	if e := t.record("service", svc.Name, err,
+		func() error { return c.Update(prior) },
+		func() error { return c.Destroy(svc.Name) }); e != nil {
+		return e
+	}
+	return t.recreateOnConflict("service", svc.Name, c.Apply(svc),
+		func() error { return c.Destroy(svc.Name, recreateOptions) },
+		func() error { return c.Create(svc) })
+}
+
+// HPA applies horizontal pod autoscaler, which may be nil
+func (t *transaction) HPA(hpa *autoscale_v2beta2.HorizontalPodAutoscaler) error {
+	if hpa == nil {
+		return nil
+	}
+	c := t.client.HorizontalPodAutoscaler()
+	prior, err := c.Get(hpa.Name)
+	if e := t.record("hpa", hpa.Name, err,
+		func() error { return c.Update(prior) },
+		func() error { return c.Destroy(hpa.Name) }); e != nil {
+		return e
+	}
+	return t.recreateOnConflict("hpa", hpa.Name, c.Apply(hpa),
+		func() error { return c.Destroy(hpa.Name, recreateOptions) },
+		func() error { return c.Create(hpa) })
+}
+
+// Ingress applies ingress, which may be nil
+func (t *transaction) Ingress(ingress *netwk_v1beta1.Ingress) error {
+	if ingress == nil {
+		return nil
+	}
+	c := t.client.Ingress()
+	prior, err := c.Get(ingress.Name)
+	if e := t.record("ingress", ingress.Name, err,
+		func() error { return c.Replace(prior) },
+		func() error { return c.Destroy(ingress.Name) }); e != nil {
+		return e
+	}
+	return t.recreateOnConflict("ingress", ingress.Name, c.Apply(ingress),
+		func() error { return c.Destroy(ingress.Name, recreateOptions) },
+		func() error { return c.Create(ingress) })
+}
+
+// ExternalSecret applies ExternalSecret es with client c, which is bound to
+// the namespace of es
+func (t *transaction) ExternalSecret(c *k8s.ExternalSecret, es *ext.ExternalSecret) error {
+	prior, err := c.Get(es.Name)
+	if e := t.record("externalsecret", es.Name, err,
+		func() error { return c.Update(prior) },
+		func() error { return c.Destroy(es.Name) }); e != nil {
+		return e
+	}
+	return c.Apply(es)
+}
+
+// CustomResource applies custom resource rsc of kind
+func (t *transaction) CustomResource(kind string, rsc *ext.PrsnExternalResource) error {
+	c := t.client.CustomResourceDefinition(kind)
+	prior, err := c.Get(rsc.Name)
+	if e := t.record(strings.ToLower(kind), rsc.Name, err,
+		func() error { return c.Update(prior) },
+		func() error { return c.Destroy(rsc.Name) }); e != nil {
+		return e
+	}
+	return c.Apply(rsc)
+}
+
+// Manifest applies arbitrary object obj
+func (t *transaction) Manifest(obj *unstructured.Unstructured) error {
+	c := t.client.Manifest()
+	prior, err := c.Get(obj)
+	if e := t.record(strings.ToLower(obj.GetKind()), obj.GetName(), err,
+		func() error { return c.Apply(restorable(prior)) },
+		func() error { return c.Destroy(obj) }); e != nil {
+		return e
+	}
+	return c.Apply(obj)
+}
+
+// DestroyManifest deletes arbitrary object obj if it exists. It is created
+// again on rollback
+func (t *transaction) DestroyManifest(obj *unstructured.Unstructured) error {
+	c := t.client.Manifest()
+	prior, err := c.Get(obj)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	t.record(strings.ToLower(obj.GetKind()), obj.GetName(), nil,
+		func() error { return c.Apply(restorable(prior)) }, nil)
+	return c.Destroy(obj)
+}
+
+// restorable strips server populated fields off obj read from the cluster,
+// so that it can be applied again, also after it was deleted
+func restorable(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	obj = obj.DeepCopy()
+	obj.SetResourceVersion("")
+	obj.SetUID("")
+	obj.SetSelfLink("")
+	obj.SetGeneration(0)
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetManagedFields(nil)
+	unstructured.RemoveNestedField(obj.Object, "status")
+	annotations := obj.GetAnnotations()
+	delete(annotations, k8s.ManifestHashAnnotation)
+	obj.SetAnnotations(annotations)
+	return obj
+}
+
+// Rollback restores recorded objects in reverse order of application. All
+// objects are attempted, the first error is returned
+func (t *transaction) Rollback(service string) error {
+	var err error
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		step := t.undo[i]
+		if e := step.fn(); e != nil {
+			log.Errorf("service %s: could not roll back %s %s: %s", service, step.kind, step.name, e.Error())
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		log.Infof("service %s: rolled back %s %s", service, step.kind, step.name)
+	}
+	t.undo = nil
+	return err
+}
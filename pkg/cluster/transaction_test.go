@@ -0,0 +1,229 @@
+package cluster
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	apps_v1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	netwk_v1beta1 "k8s.io/api/networking/v1beta1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func atomicService() *bitesize.Service {
+	service := bitesize.ServiceWithDefaults()
+	service.Name = "api"
+	service.Application = "api"
+	service.Version = "2"
+	service.Ports = []int{80}
+	service.Atomic = true
+	return service
+}
+
+func failServiceCreate(client *fake.Clientset) {
+	client.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("service quota exceeded")
+	})
+}
+
+func TestAtomicApplyRemovesCreatedObjects(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+	)
+	failServiceCreate(client)
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	if err := cluster.ApplyService(atomicService(), &bitesize.Gists{}, "sample"); err == nil {
+		t.Fatal("Expected error applying service")
+	}
+	if _, err := client.AppsV1().Deployments("sample").Get("api", metav1.GetOptions{}); err == nil {
+		t.Error("Expected deployment created for failed atomic service to be removed")
+	}
+}
+
+func TestAtomicApplyRestoresPriorObjects(t *testing.T) {
+	replicas := int32(1)
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+		&apps_v1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "api",
+				Namespace: "sample",
+				Labels:    map[string]string{"creator": "pipeline", "version": "1"},
+			},
+			Spec: apps_v1.DeploymentSpec{
+				Replicas: &replicas,
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Name: "api", Image: "api:1"}},
+					},
+				},
+			},
+		},
+	)
+	failServiceCreate(client)
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	if err := cluster.ApplyService(atomicService(), &bitesize.Gists{}, "sample"); err == nil {
+		t.Fatal("Expected error applying service")
+	}
+	d, err := client.AppsV1().Deployments("sample").Get("api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected prior deployment to be kept: %s", err.Error())
+	}
+	if d.Labels["version"] != "1" || d.Spec.Template.Spec.Containers[0].Image != "api:1" {
+		t.Errorf("Expected deployment to be rolled back to version 1, got %v %s",
+			d.Labels, d.Spec.Template.Spec.Containers[0].Image)
+	}
+}
+
+func TestNonAtomicApplyKeepsAppliedObjects(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+	)
+	failServiceCreate(client)
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	service := atomicService()
+	service.Atomic = false
+	if err := cluster.ApplyService(service, &bitesize.Gists{}, "sample"); err == nil {
+		t.Fatal("Expected error applying service")
+	}
+	if _, err := client.AppsV1().Deployments("sample").Get("api", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected deployment to be kept: %s", err.Error())
+	}
+}
+
+func TestRecreateOnConflict(t *testing.T) {
+	replicas := int32(1)
+	existing := &apps_v1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "api",
+			Namespace: "sample",
+			Labels:    map[string]string{"creator": "pipeline", "version": "1"},
+		},
+		Spec: apps_v1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "api", Image: "api:1"}},
+				},
+			},
+		},
+	}
+
+	for _, recreate := range []bool{false, true} {
+		client := fake.NewSimpleClientset(
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+			existing.DeepCopy(),
+		)
+		client.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, k8serrors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "Deployment"}, "api",
+				field.ErrorList{field.Invalid(field.NewPath("spec", "selector"), nil, "field is immutable")})
+		})
+		cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+		service := atomicService()
+		service.Atomic = false
+		service.RecreateOnConflict = recreate
+		err := cluster.ApplyService(service, &bitesize.Gists{}, "sample")
+
+		deleted := false
+		for _, action := range client.Actions() {
+			if action.Matches("delete", "deployments") {
+				deleted = true
+			}
+		}
+		if recreate && (err != nil || !deleted) {
+			t.Errorf("Expected deployment to be recreated, got err %v, deleted %t", err, deleted)
+		}
+		if !recreate && (err == nil || deleted) {
+			t.Errorf("Expected update error without recreate, got err %v, deleted %t", err, deleted)
+		}
+	}
+}
+
+func TestRecreateServiceOnHeadlessChange(t *testing.T) {
+	for _, recreate := range []bool{false, true} {
+		client := fake.NewSimpleClientset(
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+			&v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "sample"},
+				Spec:       v1.ServiceSpec{ClusterIP: "10.0.0.10"},
+			},
+		)
+		cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+		service := atomicService()
+		service.Atomic = false
+		service.Headless = true
+		service.RecreateOnConflict = recreate
+		err := cluster.ApplyService(service, &bitesize.Gists{}, "sample")
+
+		svc, _ := client.CoreV1().Services("sample").Get("api", metav1.GetOptions{})
+		if recreate && (err != nil || svc.Spec.ClusterIP != v1.ClusterIPNone) {
+			t.Errorf("Expected service to be recreated headless, got err %v, cluster ip %s", err, svc.Spec.ClusterIP)
+		}
+		if !recreate && (!k8serrors.IsInvalid(err) || svc.Spec.ClusterIP != "10.0.0.10") {
+			t.Errorf("Expected invalid error and service kept without recreate, got err %v, cluster ip %s", err, svc.Spec.ClusterIP)
+		}
+	}
+}
+
+func TestAtomicApplyRollsBackOnPanic(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample"}},
+	)
+	client.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		panic("service create panicked")
+	})
+	cluster := Cluster{Interface: client, CRDClient: loadEmptyCRDs()}
+
+	if err := cluster.ApplyService(atomicService(), &bitesize.Gists{}, "sample"); err == nil {
+		t.Fatal("Expected error applying service")
+	}
+	if _, err := client.AppsV1().Deployments("sample").Get("api", metav1.GetOptions{}); err == nil {
+		t.Error("Expected deployment created for panicked atomic service to be removed")
+	}
+}
+
+func TestRollbackRestoresIngressAnnotations(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&netwk_v1beta1.Ingress{ObjectMeta: metav1.ObjectMeta{
+			Name:        "api",
+			Namespace:   "sample",
+			Annotations: map[string]string{"prior": "true"},
+		}},
+	)
+	tx := newTransaction(&k8s.Client{Interface: client, Namespace: "sample"}, atomicService())
+
+	applied := &netwk_v1beta1.Ingress{ObjectMeta: metav1.ObjectMeta{
+		Name:        "api",
+		Namespace:   "sample",
+		Annotations: map[string]string{"failed": "true"},
+	}}
+	if err := tx.Ingress(applied); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := tx.Rollback("api"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	ingress, err := client.NetworkingV1beta1().Ingresses("sample").Get("api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	expected := map[string]string{"prior": "true"}
+	if !reflect.DeepEqual(ingress.Annotations, expected) {
+		t.Errorf("Expected annotations %v after rollback, got %v", expected, ingress.Annotations)
+	}
+}
@@ -1,27 +1,36 @@
 package config
 
 import (
+	"fmt"
+	"net/mail"
+	"regexp"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/kelseyhightower/envconfig"
 )
 
-// Config contains environment variables used to configure the app
+// Config contains environment variables used to configure the app.
+// Fields tagged sensitive hold credentials and are masked by Redacted
 type Config struct {
 	LogLevel     string `envconfig:"LOG_LEVEL" default:"info"`
 	UseAuth      bool   `envconfig:"USE_AUTH" default:"true"`
 	GitRepo      string `envconfig:"GIT_REMOTE_REPOSITORY"`
 	GitBranch    string `envconfig:"GIT_BRANCH" default:"master"`
-	GitKey       string `envconfig:"GIT_PRIVATE_KEY"`
+	GitKey       string `envconfig:"GIT_PRIVATE_KEY" sensitive:"true"`
 	GitKeyPath   string `envconfig:"GIT_PRIVATE_KEY_PATH" default:"/etc/git/key"`
 	GitUser      string `envconfig:"GIT_USER"`
-	GitToken     string `envconfig:"GIT_TOKEN"`
+	GitToken     string `envconfig:"GIT_TOKEN" sensitive:"true"`
 	GitLocalPath string `envconfig:"GIT_LOCAL_PATH" default:"/tmp/repository"`
 	GitRootPath  string `envconfig:"GIT_ROOT_PATH" default:"/tmp/"`
+	// Git identity of the operator. It is only recorded and shown by /config,
+	// the operator makes no commits
+	GitAuthorName  string `envconfig:"GIT_AUTHOR_NAME" default:"environment-operator"`
+	GitAuthorEmail string `envconfig:"GIT_AUTHOR_EMAIL" default:"environment-operator@localhost"`
 
 	//Gists
 	GistsUser  string `envconfig:"GISTS_USER"`
-	GistsToken string `envconfig:"GISTS_TOKEN"`
-	GistsKey   string `envconfig:"GISTS_PRIVATE_KEY"`
+	GistsToken string `envconfig:"GISTS_TOKEN" sensitive:"true"`
+	GistsKey   string `envconfig:"GISTS_PRIVATE_KEY" sensitive:"true"`
 
 	EnvName           string `envconfig:"ENVIRONMENT_NAME"`
 	EnvFile           string `envconfig:"BITESIZE_FILE"`
@@ -43,6 +52,138 @@ type Config struct {
 
 	TokenFile string `envconfig:"AUTH_TOKEN_FILE"`
 
+	// Maximum number of lines and bytes of pod logs returned, 0 for no limit
+	LogTailLines  int `envconfig:"LOG_TAIL_LINES" default:"500"`
+	LogLimitBytes int `envconfig:"LOG_LIMIT_BYTES" default:"1048576"`
+
+	// Seconds to wait for depends_on services to become ready
+	DependencyWaitTimeout int `envconfig:"DEPENDENCY_WAIT_TIMEOUT" default:"300"`
+	// Seconds to wait for a blue/green colour to become available before
+	// switching traffic to it
+	BlueGreenPromotionTimeout int `envconfig:"BLUE_GREEN_PROMOTION_TIMEOUT" default:"300"`
+	// Default image of services' secret_fetch init container
+	SecretFetchImage string `envconfig:"SECRET_FETCH_IMAGE" default:"hashicorp/vault:1.13"`
+	// What reaper does with services found in the cluster but not in the
+	// config: prune, warn or ignore
+	OrphanPolicy string `envconfig:"ORPHAN_POLICY" default:"prune"`
+	// Seconds reaper waits for a deleted object to be removed before
+	// reporting it stuck on finalizers
+	ReaperDeleteTimeout int `envconfig:"REAPER_DELETE_TIMEOUT" default:"60"`
+	// Remove finalizers of objects stuck terminating after the timeout
+	ReaperForceFinalizers bool `envconfig:"REAPER_FORCE_FINALIZERS" default:"false"`
+	// Propagation policy objects are deleted with by kind, e.g.
+	// deployment:Foreground,pvc:Background
+	DeletePropagation map[string]string `envconfig:"DELETE_PROPAGATION"`
+	// Grace period in seconds objects are deleted with, -1 keeps the
+	// grace period of the object
+	DeleteGracePeriod int `envconfig:"DELETE_GRACE_PERIOD" default:"-1"`
+	// Times an update is retried when the object changed since it was read,
+	// e.g. by another controller
+	UpdateConflictRetries int `envconfig:"UPDATE_CONFLICT_RETRIES" default:"4"`
+	// Node selector applied to deployments of services without their own
+	// node_selector, e.g. role:minion. Empty schedules on any node
+	DefaultNodeSelector map[string]string `envconfig:"DEFAULT_NODE_SELECTOR"`
+	// Ingress controller serving ingresses, alb or haproxy. Selects the
+	// annotations backend_health_check is applied with
+	IngressController string `envconfig:"INGRESS_CONTROLLER"`
+	// What to do when a deployment references a secret or configmap missing
+	// from the namespace: fail the deployment or warn and apply it anyway
+	MissingReferencePolicy string `envconfig:"MISSING_REFERENCE_POLICY" default:"fail"`
+	// Refuse to deploy images without a trusted cosign signature
+	ImageVerify bool `envconfig:"IMAGE_VERIFY" default:"false"`
+	// Path of the cosign binary images are verified with
+	CosignBinary string `envconfig:"COSIGN_BINARY" default:"cosign"`
+	// Public key (file or KMS URI) images must be signed with
+	CosignKey string `envconfig:"COSIGN_KEY"`
+	// Keyless signing identity, matched exactly, and OIDC issuer, used without a key
+	CosignCertificateIdentity   string `envconfig:"COSIGN_CERTIFICATE_IDENTITY"`
+	CosignCertificateOIDCIssuer string `envconfig:"COSIGN_CERTIFICATE_OIDC_ISSUER"`
+	// Namespaces operator refuses to mutate, shell patterns allowed
+	NamespaceDenylist []string `envconfig:"NAMESPACE_DENYLIST" default:"kube-system,kube-public,kube-node-lease"`
+	// When set, the only namespaces operator is allowed to mutate
+	NamespaceAllowlist []string `envconfig:"NAMESPACE_ALLOWLIST"`
+	// Name the operator is recorded with in managedFields of objects it changes
+	FieldManager string `envconfig:"FIELD_MANAGER" default:"environment-operator"`
+	// Label selector of objects the operator manages. Objects without
+	// matching labels are not loaded, diffed or reaped
+	ManagedLabelSelector string `envconfig:"MANAGED_LABEL_SELECTOR" default:"creator=pipeline"`
+	// Maximum number of custom resource kinds listed at once
+	CustomResourceConcurrency int `envconfig:"CUSTOM_RESOURCE_CONCURRENCY" default:"8"`
+	// Maximum number of services applied at once, across all namespaces
+	ReconcileConcurrency int `envconfig:"RECONCILE_CONCURRENCY" default:"1"`
+	// Seconds between reconcile loops, and the most the interval is backed
+	// off to while consecutive loops fail
+	ReconcileInterval   int `envconfig:"RECONCILE_INTERVAL" default:"30"`
+	ReconcileMaxBackoff int `envconfig:"RECONCILE_MAX_BACKOFF" default:"600"`
+	// kubernetes-external-secrets backend env vars with external_key are
+	// synced from, e.g. secretsManager or gcpSecretsManager, and seconds to
+	// wait for the synced secret before the deployment is applied
+	ExternalSecretsBackend     string `envconfig:"EXTERNAL_SECRETS_BACKEND" default:"secretsManager"`
+	ExternalSecretsSyncTimeout int    `envconfig:"EXTERNAL_SECRETS_SYNC_TIMEOUT" default:"60"`
+	// Annotation deployments are stamped with when applied, for
+	// progressive delivery tools to observe. Not set when empty
+	DeployEventAnnotation string `envconfig:"DEPLOY_EVENT_ANNOTATION"`
+	// Prepended and appended to names of objects created for services, so
+	// the same environment can be deployed several times in a namespace
+	ServiceNamePrefix string `envconfig:"SERVICE_NAME_PREFIX"`
+	ServiceNameSuffix string `envconfig:"SERVICE_NAME_SUFFIX"`
+	// Old ReplicaSets kept for rollback of deployments, unless a service sets
+	// its own revision_history_limit
+	RevisionHistoryLimit int `envconfig:"REVISION_HISTORY_LIMIT" default:"10"`
+	// Seconds a single service may take to apply before it is abandoned,
+	// 0 for no limit
+	ServiceApplyTimeout int `envconfig:"SERVICE_APPLY_TIMEOUT" default:"600"`
+	// Run several operator replicas with a single one, holding the lease,
+	// reconciling at a time
+	LeaderElection bool `envconfig:"LEADER_ELECTION" default:"false"`
+	// Name and namespace (defaults to NAMESPACE) of the leader election lease
+	LeaderElectionLease     string `envconfig:"LEADER_ELECTION_LEASE" default:"environment-operator"`
+	LeaderElectionNamespace string `envconfig:"LEADER_ELECTION_NAMESPACE"`
+	// Seconds the leader's lease is valid for without being renewed
+	LeaderElectionDuration int `envconfig:"LEADER_ELECTION_LEASE_DURATION" default:"15"`
+	// Name of the operator pod, identifying it in the lease. Defaults to
+	// the hostname
+	PodName string `envconfig:"POD_NAME"`
+	// Consecutive failed reconciles before operator is reported unhealthy
+	ReconcileFailureThreshold int `envconfig:"RECONCILE_FAILURE_THRESHOLD" default:"5"`
+	// URL `operator preview` posts the manifest diff comment to, e.g.
+	// https://api.github.com/repos/{owner}/{repo}/issues/{number}/comments
+	PreviewWebhookURL string `envconfig:"PREVIEW_WEBHOOK_URL"`
+	// Bearer token sent with preview comments
+	PreviewWebhookToken string `envconfig:"PREVIEW_WEBHOOK_TOKEN" sensitive:"true"`
+	// URL to POST health change notifications to, sensitive as chat
+	// webhook URLs embed their credentials
+	NotifyWebhookURL string `envconfig:"NOTIFY_WEBHOOK_URL" sensitive:"true"`
+	// Where environment is loaded from: git, configmap or s3
+	ConfigSource string `envconfig:"CONFIG_SOURCE"`
+	// Name of the configmap in NAMESPACE holding BITESIZE_FILE. When set,
+	// environment is loaded from the configmap instead of git
+	EnvConfigMap string `envconfig:"BITESIZE_CONFIGMAP"`
+
+	// S3 config source. Object key defaults to BITESIZE_FILE
+	S3Bucket    string `envconfig:"S3_BUCKET"`
+	S3Key       string `envconfig:"S3_KEY"`
+	S3Region    string `envconfig:"S3_REGION" default:"us-east-1"`
+	S3Endpoint  string `envconfig:"S3_ENDPOINT"`
+	S3VersionID string `envconfig:"S3_VERSION_ID"`
+	// Static AWS credentials. When not set, credentials are looked up like
+	// the AWS SDKs do: web identity, container credentials and instance
+	// metadata, and requests are anonymous if none is available
+	AWSAccessKeyID     string `envconfig:"AWS_ACCESS_KEY_ID"`
+	AWSSecretAccessKey string `envconfig:"AWS_SECRET_ACCESS_KEY" sensitive:"true"`
+	AWSSessionToken    string `envconfig:"AWS_SESSION_TOKEN" sensitive:"true"`
+	// Web identity (IAM roles for service accounts)
+	AWSWebIdentityTokenFile string `envconfig:"AWS_WEB_IDENTITY_TOKEN_FILE"`
+	AWSRoleARN              string `envconfig:"AWS_ROLE_ARN"`
+	AWSRoleSessionName      string `envconfig:"AWS_ROLE_SESSION_NAME" default:"environment-operator"`
+	// Container credentials (ECS task roles, EKS Pod Identity)
+	AWSContainerCredentialsRelativeURI string `envconfig:"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"`
+	AWSContainerCredentialsFullURI     string `envconfig:"AWS_CONTAINER_CREDENTIALS_FULL_URI"`
+	AWSContainerAuthorizationToken     string `envconfig:"AWS_CONTAINER_AUTHORIZATION_TOKEN" sensitive:"true"`
+	AWSContainerAuthorizationTokenFile string `envconfig:"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"`
+	// Disables instance metadata credentials (EC2 instance profiles)
+	AWSEC2MetadataDisabled bool `envconfig:"AWS_EC2_METADATA_DISABLED"`
+
 	Debug string `envconfig:"DEBUG"`
 }
 
@@ -60,4 +201,53 @@ func init() {
 	if Env.GitKey != "" && Env.GitToken != "" {
 		log.Fatal("Please choose either Gitkey or GitToken but not both")
 	}
+
+	if err := validEmail(Env.GitAuthorEmail); err != nil {
+		log.Fatalf("GIT_AUTHOR_EMAIL: %s", err.Error())
+	}
+
+	if err := validNameAffix(Env.ServiceNamePrefix); err != nil {
+		log.Fatalf("SERVICE_NAME_PREFIX: %s", err.Error())
+	}
+	if err := validNameAffix(Env.ServiceNameSuffix); err != nil {
+		log.Fatalf("SERVICE_NAME_SUFFIX: %s", err.Error())
+	}
+
+	// updates are attempted UPDATE_CONFLICT_RETRIES + 1 times, so negative
+	// values would skip them altogether
+	if Env.UpdateConflictRetries < 0 {
+		log.Fatalf("UPDATE_CONFLICT_RETRIES: %d must not be negative", Env.UpdateConflictRetries)
+	}
+
+	if err := validOrphanPolicy(Env.OrphanPolicy); err != nil {
+		log.Fatalf("ORPHAN_POLICY: %s", err.Error())
+	}
+}
+
+// validOrphanPolicy checks s is one of the reaper's orphan policies
+func validOrphanPolicy(s string) error {
+	switch s {
+	case "prune", "warn", "ignore":
+		return nil
+	}
+	return fmt.Errorf("%q is not one of prune, warn or ignore", s)
+}
+
+var nameAffix = regexp.MustCompile(`^[a-z0-9-]*$`)
+
+// validNameAffix checks s can be part of kubernetes object names
+func validNameAffix(s string) error {
+	if !nameAffix.MatchString(s) {
+		return fmt.Errorf("%q may only contain lowercase letters, digits and '-'", s)
+	}
+	return nil
+}
+
+// validEmail checks s is a bare email address, without a display name
+func validEmail(s string) error {
+	address, err := mail.ParseAddress(s)
+	if err != nil || address.Address != s {
+		return fmt.Errorf("%q is not a valid email address", s)
+	}
+	return nil
 }
@@ -0,0 +1,48 @@
+package config
+
+import "testing"
+
+func TestValidEmail(t *testing.T) {
+	var tests = []struct {
+		Email string
+		Valid bool
+	}{
+		{"environment-operator@localhost", true},
+		{"release-bot@example.com", true},
+		{"", false},
+		{"release-bot", false},
+		{"Release Bot <release-bot@example.com>", false},
+	}
+	for _, tst := range tests {
+		if err := validEmail(tst.Email); (err == nil) != tst.Valid {
+			t.Errorf("Expected %q valid %t, got %v", tst.Email, tst.Valid, err)
+		}
+	}
+}
+
+func TestValidNameAffix(t *testing.T) {
+	var tests = []struct {
+		Affix string
+		Valid bool
+	}{
+		{"", true},
+		{"tenant-a-", true},
+		{"-2", true},
+		{"Tenant-", false},
+		{"tenant_a", false},
+		{"tenant.a", false},
+	}
+	for _, tst := range tests {
+		if err := validNameAffix(tst.Affix); (err == nil) != tst.Valid {
+			t.Errorf("Expected %q valid %t, got %v", tst.Affix, tst.Valid, err)
+		}
+	}
+}
+
+func TestValidOrphanPolicy(t *testing.T) {
+	for policy, valid := range map[string]bool{"prune": true, "warn": true, "ignore": true, "": false, "delete": false} {
+		if err := validOrphanPolicy(policy); (err == nil) != valid {
+			t.Errorf("Expected %q valid %t, got %v", policy, valid, err)
+		}
+	}
+}
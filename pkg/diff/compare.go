@@ -2,19 +2,30 @@ package diff
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/kylelemons/godebug/pretty"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
 	"github.com/pearsontechnology/environment-operator/pkg/util"
 	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // Compare creates a changeMap for the diff between environment configs and returns a boolean if changes were detected
 func Compare(desiredCfg, existingCfg bitesize.Environment) bool {
-	newChangeMap()
+	changeMap = Diff(desiredCfg, existingCfg)
+	return len(changeMap) > 0
+}
+
+// Diff returns changes, keyed by service name, that applying desired
+// environment config would make to the existing one. Unlike Compare, it
+// does not replace the changes reported by Changes and ServiceChanged
+func Diff(desiredCfg, existingCfg bitesize.Environment) map[string]string {
+	changes := make(map[string]string)
 
 	util.LogTraceAsYaml("Desired Environment Config", desiredCfg)
 	util.LogTraceAsYaml("Existing Environment Config", existingCfg)
@@ -56,7 +67,7 @@ func Compare(desiredCfg, existingCfg bitesize.Environment) bool {
 		if existingCfgSvc == nil && desiredCfgSvc.IsBlueGreenParentDeployment() {
 			log.Debugf("Forcing change for blue/green \"parent\" service")
 			// add the Name field to the parent service
-			addServiceChange(serviceName, fmt.Sprintf("Name: +%s", serviceName))
+			changes[serviceName] = fmt.Sprintf("Name: +%s", serviceName)
 		}
 
 		if desiredCfgSvc.IsActiveBlueGreenDeployment() {
@@ -69,7 +80,7 @@ func Compare(desiredCfg, existingCfg bitesize.Environment) bool {
 			if existingCfgSvc == nil {
 				log.Debugf("Applying changes for blue/green \"parent\" service")
 				generatedConfig := compareConfig.Compare(nil, desiredCfgSvc)
-				addServiceChange(serviceName, generatedConfig)
+				changes[serviceName] = generatedConfig
 				continue
 			}
 
@@ -77,7 +88,7 @@ func Compare(desiredCfg, existingCfg bitesize.Environment) bool {
 			if serviceDiff := compareConfig.Compare(existingCfgSvc.ExternalURL, desiredCfgSvc.ExternalURL); serviceDiff != "" {
 				log.Debugf("change detected for blue/green service ExternalURL %s", serviceName)
 				util.LogTraceAsYaml("Service Config Change of ExternalURL", serviceDiff)
-				addServiceChange(serviceName, serviceDiff)
+				changes[serviceName] = serviceDiff
 				continue
 			}
 
@@ -85,7 +96,7 @@ func Compare(desiredCfg, existingCfg bitesize.Environment) bool {
 			if serviceDiff := compareConfig.Compare(existingCfgSvc.ActiveDeploymentName(), desiredCfgSvc.ActiveDeploymentName()); serviceDiff != "" {
 				log.Debugf("change detected for blue/green service ActiveDeploymentName %s", serviceName)
 				util.LogTraceAsYaml("Service Config Change of ActiveDeploymentName()", serviceDiff)
-				addServiceChange(serviceName, serviceDiff)
+				changes[serviceName] = serviceDiff
 				continue
 			}
 		}
@@ -94,7 +105,9 @@ func Compare(desiredCfg, existingCfg bitesize.Environment) bool {
 		//  - config in git for service has version set
 		// OR
 		//  - deployed config for service has version set
-		gitConfigHasVersionSetForService := desiredCfgSvc.Version != ""
+		// OR
+		//  - service is an externalname alias, which has no version
+		gitConfigHasVersionSetForService := desiredCfgSvc.Version != "" || desiredCfgSvc.IsExternalName()
 		deployedConfigHasVersionSetForService := (existingCfgSvc != nil &&
 			existingCfgSvc.Version != "")
 
@@ -111,7 +124,7 @@ func Compare(desiredCfg, existingCfg bitesize.Environment) bool {
 			if serviceDiff := compareConfig.Compare(existingCfgSvc, desiredCfgSvc); serviceDiff != "" {
 				log.Debugf("change detected for service %s", serviceName)
 				util.LogTraceAsYaml("Service Changes", serviceDiff)
-				addServiceChange(serviceName, serviceDiff)
+				changes[serviceName] = serviceDiff
 			}
 		} else {
 			log.Debugf("\"version\" field not set for Service %s. Skipping deployment.", serviceName)
@@ -120,18 +133,18 @@ func Compare(desiredCfg, existingCfg bitesize.Environment) bool {
 		if k8s.ExternalSecretsEnabled && desiredCfgSvc.IsTLSEnabled() &&
 			!desiredCfgSvc.ExternalSecretExist(desiredCfg.Namespace, serviceName) {
 			log.Debugf("changes detected for externalsecrets for %s", serviceName)
-			addServiceChange(serviceName, fmt.Sprintf("ExternalSecrets: +%s", serviceName))
+			changes[serviceName] = fmt.Sprintf("ExternalSecrets: +%s", serviceName)
 		}
 	}
 
-	cmCount := len(changeMap)
+	cmCount := len(changes)
 	if cmCount == 0 {
 		log.Debugf("No changes detected for environment")
 	} else {
 		log.Debugf("Detected %d changes in environment", cmCount)
 
 	}
-	return cmCount > 0
+	return changes
 }
 
 // Can't think of a better word
@@ -151,6 +164,49 @@ func alignServices(desiredCfg, currentCfg *bitesize.Service) {
 	// Copy status from currentCfg (status is only stored in the cluster)
 	desiredCfg.Status = currentCfg.Status
 
+	// depends_on, ingress_wait_ready, ready_threshold, reconcile, atomic and
+	// recreate_on_conflict only control how the service is applied and are not
+	// stored in the cluster
+	currentCfg.DependsOn = desiredCfg.DependsOn
+	currentCfg.IngressWaitReady = desiredCfg.IngressWaitReady
+	currentCfg.ReadyThreshold = desiredCfg.ReadyThreshold
+	currentCfg.Reconcile = desiredCfg.Reconcile
+	currentCfg.Atomic = desiredCfg.Atomic
+	currentCfg.RecreateOnConflict = desiredCfg.RecreateOnConflict
+
+	// kubernetes defaults pull policy of containers without one by image tag
+	if desiredCfg.ImagePullPolicy == "" {
+		currentCfg.ImagePullPolicy = ""
+	}
+
+	// DEFAULT_NODE_SELECTOR is read back as unset, also when the service
+	// sets the same selector itself
+	if reflect.DeepEqual(desiredCfg.NodeSelector, config.Env.DefaultNodeSelector) {
+		desiredCfg.NodeSelector = nil
+	}
+
+	// cluster ip is allocated by kubernetes unless pinned in the config
+	if desiredCfg.ClusterIP == "" {
+		currentCfg.ClusterIP = ""
+	}
+
+	// kubernetes assigns the default storage class to claims without one
+	for _, desiredVol := range desiredCfg.Volumes {
+		if desiredVol.StorageClass != "" {
+			continue
+		}
+		for i := range currentCfg.Volumes {
+			if currentCfg.Volumes[i].Name == desiredVol.Name {
+				currentCfg.Volumes[i].StorageClass = ""
+			}
+		}
+	}
+
+	// promotion timeout only controls how blue/green switch is applied
+	if desiredCfg.Deployment != nil && currentCfg.Deployment != nil {
+		currentCfg.Deployment.PromotionTimeout = desiredCfg.Deployment.PromotionTimeout
+	}
+
 	// Ignore changes to internal info
 	if desiredCfg.Deployment != nil {
 		desiredCfg.Deployment.BlueGreen = nil
@@ -197,6 +253,23 @@ func alignServices(desiredCfg, currentCfg *bitesize.Service) {
 		desiredCfg.Replicas = currentCfg.Replicas
 	}
 
+	// replicas of deployments annotated by an external scaler are kept
+	if value, ok := currentCfg.DeploymentAnnotations[k8s.ReplicasAnnotation]; ok {
+		if _, set := desiredCfg.DeploymentAnnotations[k8s.ReplicasAnnotation]; !set {
+			if desiredCfg.DeploymentAnnotations == nil {
+				desiredCfg.DeploymentAnnotations = map[string]string{}
+			}
+			desiredCfg.DeploymentAnnotations[k8s.ReplicasAnnotation] = value
+		}
+		meta := metav1.ObjectMeta{Name: currentCfg.Name, Annotations: desiredCfg.DeploymentAnnotations}
+		if _, valid := k8s.AnnotatedReplicas(meta); valid {
+			desiredCfg.Replicas = currentCfg.Replicas
+		}
+	}
+
+	alignGRPCProbe(desiredCfg.LivenessProbe, currentCfg.LivenessProbe)
+	alignGRPCProbe(desiredCfg.ReadinessProbe, currentCfg.ReadinessProbe)
+
 	if desiredCfg.LivenessProbe != nil && currentCfg.LivenessProbe != nil {
 		if desiredCfg.LivenessProbe.InitialDelaySeconds == 0 {
 			desiredCfg.LivenessProbe.InitialDelaySeconds = currentCfg.LivenessProbe.InitialDelaySeconds
@@ -289,4 +362,25 @@ func alignServices(desiredCfg, currentCfg *bitesize.Service) {
 			}
 		}
 	}
+
+	// Apply all existing service annotations
+	for k, v := range currentCfg.ServiceAnnotations {
+		if desiredCfg.ServiceAnnotations == nil {
+			desiredCfg.ServiceAnnotations = map[string]string{}
+		}
+		if desiredCfg.ServiceAnnotations[k] == "" {
+			desiredCfg.ServiceAnnotations[k] = v
+		}
+	}
+}
+
+// gRPC probes are applied as TCP checks of the same port, which is what
+// is loaded back from the cluster
+func alignGRPCProbe(desired, current *bitesize.Probe) {
+	if desired == nil || current == nil || desired.GRPC == nil || current.TCPSocket == nil {
+		return
+	}
+	if current.TCPSocket.Port == desired.GRPC.Port && current.TCPSocket.Host == "" {
+		current.Handler = desired.Handler
+	}
 }
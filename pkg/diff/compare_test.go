@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
 )
 
 func TestDiffEmpty(t *testing.T) {
@@ -113,6 +115,154 @@ func TestQuantitiesWithDiffUnits(t *testing.T) {
 	}
 }
 
+func TestGRPCProbeAppliedAsTCP(t *testing.T) {
+	desired := bitesize.Environment{
+		Services: bitesize.Services{
+			{
+				Name:    "a",
+				Version: "1",
+				LivenessProbe: &bitesize.Probe{
+					Handler: bitesize.Handler{GRPC: &bitesize.GRPCAction{Port: 9090}},
+				},
+			},
+		},
+	}
+	existing := bitesize.Environment{
+		Services: bitesize.Services{
+			{
+				Name:    "a",
+				Version: "1",
+				LivenessProbe: &bitesize.Probe{
+					Handler: bitesize.Handler{TCPSocket: &bitesize.TCPSocketAction{Port: 9090}},
+				},
+			},
+		},
+	}
+
+	if Compare(desired, existing) {
+		t.Errorf("Expected to be the same, but got diff %s", Changes())
+	}
+
+	existing.Services[0].LivenessProbe = &bitesize.Probe{
+		Handler: bitesize.Handler{TCPSocket: &bitesize.TCPSocketAction{Port: 8080}},
+	}
+	if !Compare(desired, existing) {
+		t.Error("Expected diff for changed gRPC probe port")
+	}
+}
+
+func TestAnnotatedReplicasIgnored(t *testing.T) {
+	desired := bitesize.Environment{
+		Services: bitesize.Services{{Name: "a", Version: "1", Replicas: 2}},
+	}
+	existing := bitesize.Environment{
+		Services: bitesize.Services{
+			{
+				Name:                  "a",
+				Version:               "1",
+				Replicas:              9,
+				DeploymentAnnotations: map[string]string{k8s.ReplicasAnnotation: "9"},
+			},
+		},
+	}
+
+	if Compare(desired, existing) {
+		t.Errorf("Expected externally scaled replicas to be ignored, got diff %s", Changes())
+	}
+
+	existing.Services[0].DeploymentAnnotations = nil
+	if !Compare(desired, existing) {
+		t.Error("Expected replicas diff without the annotation")
+	}
+}
+
+func TestExternalKeyChange(t *testing.T) {
+	desired := bitesize.Environment{
+		Services: bitesize.Services{
+			{
+				Name:    "a",
+				Version: "1",
+				EnvVars: []bitesize.EnvVar{{Secret: "DB_PASSWORD", Value: "db/password", ExternalKey: "prod/db"}},
+			},
+		},
+	}
+	existing := bitesize.Environment{
+		Services: bitesize.Services{
+			{
+				Name:    "a",
+				Version: "1",
+				EnvVars: []bitesize.EnvVar{{Secret: "DB_PASSWORD", Value: "db/password"}},
+			},
+		},
+	}
+
+	if !Compare(desired, existing) {
+		t.Error("Expected diff when external_key is added")
+	}
+
+	existing.Services[0].EnvVars[0].ExternalKey = "prod/db"
+	if Compare(desired, existing) {
+		t.Errorf("Expected no diff with the ExternalSecret in the cluster, got %s", Changes())
+	}
+}
+
+func TestServiceTypeChange(t *testing.T) {
+	desired := bitesize.Environment{
+		Services: bitesize.Services{{Name: "a", Version: "1", ServiceType: "NodePort"}},
+	}
+	existing := bitesize.Environment{
+		Services: bitesize.Services{{Name: "a", Version: "1"}},
+	}
+
+	if !Compare(desired, existing) {
+		t.Error("Expected diff for changed service type")
+	}
+
+	existing.Services[0].ServiceType = "NodePort"
+	if Compare(desired, existing) {
+		t.Errorf("Expected to be the same, but got diff %s", Changes())
+	}
+}
+
+func TestImagePullPolicy(t *testing.T) {
+	desired := bitesize.Environment{
+		Services: bitesize.Services{{Name: "a", Version: "1"}},
+	}
+	existing := bitesize.Environment{
+		Services: bitesize.Services{{Name: "a", Version: "1", ImagePullPolicy: "IfNotPresent"}},
+	}
+
+	if Compare(desired, existing) {
+		t.Errorf("Expected defaulted pull policy to be ignored, got diff %s", Changes())
+	}
+
+	desired.Services[0].ImagePullPolicy = "Always"
+	if !Compare(desired, existing) {
+		t.Error("Expected diff for changed pull policy")
+	}
+}
+
+func TestDefaultNodeSelector(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.DefaultNodeSelector = map[string]string{"role": "minion"}
+
+	desired := bitesize.Environment{
+		Services: bitesize.Services{{Name: "a", Version: "1", NodeSelector: map[string]string{"role": "minion"}}},
+	}
+	existing := bitesize.Environment{
+		Services: bitesize.Services{{Name: "a", Version: "1"}},
+	}
+
+	if Compare(desired, existing) {
+		t.Errorf("Expected node selector equal to the default to be ignored, got diff %s", Changes())
+	}
+
+	desired.Services[0].NodeSelector = map[string]string{"role": "edge"}
+	if !Compare(desired, existing) {
+		t.Error("Expected diff for changed node selector")
+	}
+}
+
 func TestBlueGreenExternalUrls(t *testing.T) {
 	var saTests = []struct {
 		versionA []string
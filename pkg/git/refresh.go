@@ -1,11 +1,23 @@
 package git
 
-import log "github.com/Sirupsen/logrus"
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+)
 
 // Refresh checks if local git repository copy is outdated. If it is,
 // changes are pulled in.
 func (g *Git) Refresh() error {
+	objectsBefore := dirSize(filepath.Join(g.LocalPath, ".git"))
+	defer g.recordSize(objectsBefore)
+
+	start := time.Now()
 	ok, err := g.UpdatesExist()
+	metrics.GitRefreshDuration.WithLabelValues(g.RemotePath, "fetch").Observe(time.Since(start).Seconds())
 
 	//TODO update to return the repo status and stop from comparing if there are no new changes.
 	if err != nil {
@@ -15,7 +27,10 @@ func (g *Git) Refresh() error {
 
 	if ok {
 		log.Infof("updates in repository: %s", g.RemotePath)
-		if err := g.Pull(); err != nil {
+		start = time.Now()
+		err := g.Pull()
+		metrics.GitRefreshDuration.WithLabelValues(g.RemotePath, "pull").Observe(time.Since(start).Seconds())
+		if err != nil {
 			log.Errorf("error while pulling the changes from repository: %s", err)
 			return err
 		}
@@ -23,3 +38,24 @@ func (g *Git) Refresh() error {
 
 	return nil
 }
+
+// recordSize updates repository size metrics. Bytes received are counted as
+// the growth of the object store since objectsBefore
+func (g *Git) recordSize(objectsBefore int64) {
+	if received := dirSize(filepath.Join(g.LocalPath, ".git")) - objectsBefore; received > 0 {
+		metrics.GitReceivedBytes.WithLabelValues(g.RemotePath).Add(float64(received))
+	}
+	metrics.GitCheckoutBytes.WithLabelValues(g.RemotePath).Set(float64(dirSize(g.LocalPath)))
+}
+
+// dirSize returns total size of regular files under path
+func dirSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -1,6 +1,11 @@
 package git
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	dto "github.com/prometheus/client_model/go"
+)
 
 func TestRefreshSuccess(t *testing.T) {
 	remotePath := createTestRepo(t)
@@ -17,3 +22,29 @@ func TestRefreshSuccess(t *testing.T) {
 	}
 
 }
+
+func TestRefreshMetrics(t *testing.T) {
+	remotePath := createTestRepo(t)
+	localPath := createSrcPath(t)
+	defer cleanupTestPath(localPath)
+	defer cleanupTestPath(remotePath)
+
+	g := initAndClone(t, localPath, remotePath)
+	commitTestJunk(t, remotePath, "zzz.bitesize")
+
+	if err := g.Refresh(); err != nil {
+		t.Fatalf("Expected success, got: %s", err.Error())
+	}
+
+	var m dto.Metric
+	metrics.GitCheckoutBytes.WithLabelValues(remotePath).Write(&m)
+	if m.GetGauge().GetValue() <= 0 {
+		t.Errorf("Expected checkout size to be recorded, got %v", m.GetGauge().GetValue())
+	}
+
+	m.Reset()
+	metrics.GitReceivedBytes.WithLabelValues(remotePath).Write(&m)
+	if m.GetCounter().GetValue() <= 0 {
+		t.Errorf("Expected received bytes to be recorded, got %v", m.GetCounter().GetValue())
+	}
+}
@@ -0,0 +1,10 @@
+package git
+
+// Revision returns commit hash of the local HEAD
+func (g *Git) Revision() (string, error) {
+	ref, err := g.Repository.Head()
+	if err != nil {
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
@@ -0,0 +1,31 @@
+package git
+
+import "testing"
+
+func TestRevision(t *testing.T) {
+	local := createSrcPath(t)
+	remote := createTestRepo(t)
+
+	defer cleanupTestPath(local)
+	defer cleanupTestPath(remote)
+
+	g := initAndClone(t, local, remote)
+
+	before, err := g.Revision()
+	if err != nil {
+		t.Fatalf("Unexpected error getting revision: %s", err.Error())
+	}
+
+	commitTestJunk(t, remote, "zzz.bitesize")
+	if err := g.Refresh(); err != nil {
+		t.Fatalf("Unexpected error on refresh: %s", err.Error())
+	}
+
+	after, err := g.Revision()
+	if err != nil {
+		t.Fatalf("Unexpected error getting revision: %s", err.Error())
+	}
+	if before == after {
+		t.Errorf("Expected revision to change after refresh, got %s", after)
+	}
+}
@@ -0,0 +1,190 @@
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+)
+
+// Notifier is notified when reconcile health changes
+type Notifier interface {
+	Notify(event Event) error
+}
+
+// Event describes reconcile health change
+type Event struct {
+	Namespace   string `json:"namespace"`
+	Environment string `json:"environment"`
+	Healthy     bool   `json:"healthy"`
+	Failures    int    `json:"failures"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Tracker counts consecutive reconcile failures and reports the
+// operator unhealthy once Threshold is reached. Reconcile metrics are
+// labelled with Namespace and Environment
+type Tracker struct {
+	Threshold   int
+	Notifier    Notifier
+	Namespace   string
+	Environment string
+
+	mu       sync.Mutex
+	failures int
+	lastErr  error
+}
+
+// Reconcile tracks health of the operator reconcile loop
+var Reconcile = &Tracker{
+	Threshold:   config.Env.ReconcileFailureThreshold,
+	Notifier:    &Webhook{URL: config.Env.NotifyWebhookURL},
+	Namespace:   config.Env.Namespace,
+	Environment: config.Env.EnvName,
+}
+
+// Success resets consecutive failure count
+func (t *Tracker) Success() {
+	t.mu.Lock()
+	wasHealthy := t.healthy()
+	t.failures = 0
+	t.lastErr = nil
+	metrics.ReconcileFailures.WithLabelValues(t.Namespace, t.Environment).Set(0)
+	metrics.Reconciles.WithLabelValues(t.Namespace, t.Environment, "succeeded").Inc()
+	event := t.event()
+	t.mu.Unlock()
+
+	if !wasHealthy {
+		log.Infof("reconcile recovered, marking operator healthy")
+		t.notify(event)
+	}
+}
+
+// Failure records failed reconcile
+func (t *Tracker) Failure(err error) {
+	t.mu.Lock()
+	wasHealthy := t.healthy()
+	t.failures++
+	t.lastErr = err
+	metrics.ReconcileFailures.WithLabelValues(t.Namespace, t.Environment).Set(float64(t.failures))
+	metrics.Reconciles.WithLabelValues(t.Namespace, t.Environment, "failed").Inc()
+	event := t.event()
+	t.mu.Unlock()
+
+	if wasHealthy && !event.Healthy {
+		log.Errorf("reconcile failed %d times in a row, marking operator unhealthy: %s", event.Failures, err.Error())
+		t.notify(event)
+	}
+}
+
+// Observe records duration of a reconcile loop
+func (t *Tracker) Observe(duration time.Duration) {
+	metrics.ReconcileDuration.WithLabelValues(t.Namespace, t.Environment).Observe(duration.Seconds())
+}
+
+// Healthy returns false once consecutive failures reach Threshold
+func (t *Tracker) Healthy() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.healthy()
+}
+
+// Status returns consecutive failure count and last error
+func (t *Tracker) Status() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failures, t.lastErr
+}
+
+// Backoff returns how long to wait before the next reconcile: base
+// interval after a successful one, doubled for each consecutive failure up
+// to max. Up to a fifth of the interval is subtracted at random when
+// backing off, so operators failing together don't retry in lockstep
+func (t *Tracker) Backoff(base, max time.Duration) time.Duration {
+	t.mu.Lock()
+	failures := t.failures
+	t.mu.Unlock()
+
+	if failures == 0 || base <= 0 {
+		return base
+	}
+	if max < base {
+		max = base
+	}
+
+	interval := base
+	for i := 0; i < failures && interval < max; i++ {
+		interval *= 2
+	}
+	if interval > max {
+		interval = max
+	}
+	if jitter := int64(interval / 5); jitter > 0 {
+		interval -= time.Duration(rand.Int63n(jitter))
+	}
+	return interval
+}
+
+func (t *Tracker) healthy() bool {
+	return t.Threshold <= 0 || t.failures < t.Threshold
+}
+
+// event returns the current health as an Event, t.mu must be held
+func (t *Tracker) event() Event {
+	event := Event{
+		Namespace:   t.Namespace,
+		Environment: t.Environment,
+		Healthy:     t.healthy(),
+		Failures:    t.failures,
+	}
+	if t.lastErr != nil {
+		event.Error = t.lastErr.Error()
+	}
+	return event
+}
+
+// notify sends event to Notifier. It is called without holding t.mu, so
+// that a slow notifier doesn't block Healthy and Status
+func (t *Tracker) notify(event Event) {
+	if t.Notifier == nil {
+		return
+	}
+	if err := t.Notifier.Notify(event); err != nil {
+		log.Errorf("error sending health notification: %s", err.Error())
+	}
+}
+
+// Webhook posts health events as JSON to URL. Nothing is sent when
+// URL is empty
+type Webhook struct {
+	URL string
+}
+
+// Notify implements Notifier
+func (w *Webhook) Notify(event Event) error {
+	if w.URL == "" {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", w.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type fakeNotifier struct {
+	events []Event
+}
+
+func (n *fakeNotifier) Notify(event Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestTrackerThreshold(t *testing.T) {
+	n := &fakeNotifier{}
+	tracker := &Tracker{Threshold: 3, Notifier: n}
+
+	tracker.Failure(errors.New("git auth failed"))
+	tracker.Failure(errors.New("git auth failed"))
+	if !tracker.Healthy() {
+		t.Error("Expected tracker to be healthy below threshold")
+	}
+	if len(n.events) != 0 {
+		t.Errorf("Expected no notifications below threshold, got %v", n.events)
+	}
+
+	tracker.Failure(errors.New("git auth failed"))
+	tracker.Failure(errors.New("git auth failed"))
+	if tracker.Healthy() {
+		t.Error("Expected tracker to be unhealthy at threshold")
+	}
+	if len(n.events) != 1 {
+		t.Fatalf("Expected single notification, got %v", n.events)
+	}
+	if n.events[0].Healthy || n.events[0].Failures != 3 || n.events[0].Error != "git auth failed" {
+		t.Errorf("Unexpected unhealthy event: %+v", n.events[0])
+	}
+
+	tracker.Success()
+	if !tracker.Healthy() {
+		t.Error("Expected tracker to recover after success")
+	}
+	if len(n.events) != 2 || !n.events[1].Healthy {
+		t.Errorf("Expected recovery notification, got %v", n.events)
+	}
+	if failures, err := tracker.Status(); failures != 0 || err != nil {
+		t.Errorf("Expected status reset, got %d, %v", failures, err)
+	}
+}
+
+func TestTrackerSuccessResetsFailures(t *testing.T) {
+	tracker := &Tracker{Threshold: 2}
+
+	tracker.Failure(errors.New("timeout"))
+	tracker.Success()
+	tracker.Failure(errors.New("timeout"))
+	if !tracker.Healthy() {
+		t.Error("Expected non-consecutive failures to keep tracker healthy")
+	}
+}
+
+// blockingNotifier blocks until release is closed
+type blockingNotifier struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(event Event) error {
+	close(n.started)
+	<-n.release
+	return nil
+}
+
+func TestTrackerNotifyDoesNotBlockHealthy(t *testing.T) {
+	n := &blockingNotifier{started: make(chan struct{}), release: make(chan struct{})}
+	tracker := &Tracker{Threshold: 1, Notifier: n}
+
+	go tracker.Failure(errors.New("timeout"))
+	<-n.started
+	defer close(n.release)
+
+	healthy := make(chan bool)
+	go func() { healthy <- tracker.Healthy() }()
+	select {
+	case h := <-healthy:
+		if h {
+			t.Error("Expected tracker to be unhealthy")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected Healthy not to wait for the notifier")
+	}
+}
+
+func TestTrackerBackoff(t *testing.T) {
+	tracker := &Tracker{}
+	base, max := 30*time.Second, 300*time.Second
+
+	if d := tracker.Backoff(base, max); d != base {
+		t.Errorf("Expected base interval without failures, got %s", d)
+	}
+
+	var tests = []struct {
+		Failures int
+		Interval time.Duration
+	}{
+		{1, 60 * time.Second},
+		{2, 120 * time.Second},
+		{3, 240 * time.Second},
+		{4, 300 * time.Second},
+		{100, 300 * time.Second},
+	}
+	for _, tst := range tests {
+		tracker.failures = tst.Failures
+		d := tracker.Backoff(base, max)
+		if d > tst.Interval || d <= tst.Interval*4/5 {
+			t.Errorf("Expected backoff after %d failures to be within 20%% below %s, got %s", tst.Failures, tst.Interval, d)
+		}
+	}
+
+	tracker.Success()
+	if d := tracker.Backoff(base, max); d != base {
+		t.Errorf("Expected base interval after success, got %s", d)
+	}
+}
+
+func TestWebhookNotify(t *testing.T) {
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Unexpected error decoding event: %s", err.Error())
+		}
+	}))
+	defer server.Close()
+
+	w := &Webhook{URL: server.URL}
+	if err := w.Notify(Event{Namespace: "sample", Failures: 5, Error: "boom"}); err != nil {
+		t.Fatalf("Unexpected error notifying: %s", err.Error())
+	}
+	if received.Namespace != "sample" || received.Failures != 5 || received.Error != "boom" {
+		t.Errorf("Unexpected event received: %+v", received)
+	}
+}
+
+func TestTrackerMetricsByNamespace(t *testing.T) {
+	failing := &Tracker{Threshold: 3, Namespace: "failing", Environment: "dev"}
+	healthy := &Tracker{Threshold: 3, Namespace: "healthy", Environment: "prd"}
+
+	failing.Failure(errors.New("git auth failed"))
+	failing.Failure(errors.New("git auth failed"))
+	healthy.Success()
+	healthy.Observe(2 * time.Second)
+
+	m := &dto.Metric{}
+	metrics.ReconcileFailures.WithLabelValues("failing", "dev").Write(m)
+	if m.GetGauge().GetValue() != 2 {
+		t.Errorf("Expected 2 consecutive failures in namespace failing, got %v", m.GetGauge().GetValue())
+	}
+	m = &dto.Metric{}
+	metrics.ReconcileFailures.WithLabelValues("healthy", "prd").Write(m)
+	if m.GetGauge().GetValue() != 0 {
+		t.Errorf("Expected no failures in namespace healthy, got %v", m.GetGauge().GetValue())
+	}
+	m = &dto.Metric{}
+	metrics.Reconciles.WithLabelValues("failing", "dev", "failed").Write(m)
+	if m.GetCounter().GetValue() != 2 {
+		t.Errorf("Expected 2 failed reconciles in namespace failing, got %v", m.GetCounter().GetValue())
+	}
+	m = &dto.Metric{}
+	metrics.ReconcileDuration.WithLabelValues("healthy", "prd").(prometheus.Histogram).Write(m)
+	if m.GetHistogram().GetSampleCount() != 1 || m.GetHistogram().GetSampleSum() != 2 {
+		t.Errorf("Expected single 2s reconcile in namespace healthy, got %v", m.GetHistogram())
+	}
+}
@@ -0,0 +1,226 @@
+package leader
+
+// leader package elects a single operator replica to reconcile the
+// environment, so that the operator can run with several replicas
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	coordination_v1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Election is the elector of the running operator, nil when leader
+// election is disabled
+var Election *Elector
+
+// IsLeader returns true if this replica holds the lease, or if leader
+// election is disabled
+func IsLeader() bool {
+	return Election == nil || Election.IsLeader()
+}
+
+// Elector holds a coordination.k8s.io Lease named Name in Namespace for
+// Identity. The lease is renewed every third of LeaseDuration; other
+// replicas take it over once they haven't seen it renewed for
+// LeaseDuration, measured by their own clock so that clock skew between
+// nodes doesn't matter.
+// The leader steps down when it couldn't renew the lease for two thirds
+// of LeaseDuration, before another replica can take it over
+type Elector struct {
+	Client        kubernetes.Interface
+	Namespace     string
+	Name          string
+	Identity      string
+	LeaseDuration time.Duration
+
+	mu          sync.Mutex
+	leader      bool
+	renewed     time.Time
+	steppedDown bool
+	// observed is the lease record last seen, observedAt the local time
+	// it was first seen at
+	observed   coordination_v1.LeaseSpec
+	observedAt time.Time
+}
+
+// IsLeader returns true while this replica holds the lease
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Acquire blocks until this replica becomes the leader
+func (e *Elector) Acquire() {
+	logged := false
+	for {
+		ok, err := e.TryAcquire()
+		if err != nil {
+			log.Errorf("leader election: %s", err.Error())
+		}
+		if ok {
+			log.Infof("leader election: %s is the leader", e.Identity)
+			return
+		}
+		if !logged {
+			log.Infof("leader election: %s is standing by for lease %s/%s", e.Identity, e.Namespace, e.Name)
+			logged = true
+		}
+		time.Sleep(e.retryPeriod())
+	}
+}
+
+// Renew keeps renewing the lease. onLost is called once the lease could
+// not be renewed within the renew deadline or is taken by another replica
+func (e *Elector) Renew(onLost func()) {
+	for {
+		time.Sleep(e.retryPeriod())
+		if !e.renew() {
+			e.mu.Lock()
+			e.leader = false
+			e.steppedDown = true
+			e.mu.Unlock()
+			onLost()
+			return
+		}
+	}
+}
+
+// renew makes a single attempt to renew the lease and returns false once
+// it is lost. Requests still running at the renew deadline are abandoned
+func (e *Elector) renew() bool {
+	e.mu.Lock()
+	deadline := e.renewed.Add(e.renewDeadline())
+	e.mu.Unlock()
+
+	type result struct {
+		ok  bool
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ok, err := e.TryAcquire()
+		done <- result{ok, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			log.Errorf("leader election: %s", r.err.Error())
+			return time.Now().Before(deadline)
+		}
+		return r.ok
+	case <-time.After(time.Until(deadline)):
+		log.Errorf("leader election: lease %s was not renewed within %s", e.Name, e.renewDeadline())
+		return false
+	}
+}
+
+// TryAcquire makes a single attempt to take or renew the lease and
+// returns true if this replica holds it
+func (e *Elector) TryAcquire() (bool, error) {
+	leases := e.Client.CoordinationV1().Leases(e.Namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(e.LeaseDuration / time.Second)
+
+	lease, err := leases.Get(e.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		lease = &coordination_v1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: e.Name, Namespace: e.Namespace},
+			Spec: coordination_v1.LeaseSpec{
+				HolderIdentity:       &e.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if _, err := leases.Create(lease); err != nil {
+			return false, fmt.Errorf("could not create lease %s: %s", e.Name, err.Error())
+		}
+		e.observe(lease.Spec, now.Time)
+		e.acquired(now.Time)
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not get lease %s: %s", e.Name, err.Error())
+	}
+
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	e.observe(lease.Spec, now.Time)
+	if holder != e.Identity && holder != "" && !e.expired(lease, now.Time) {
+		e.mu.Lock()
+		e.leader = false
+		e.mu.Unlock()
+		return false, nil
+	}
+
+	if holder != e.Identity {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.HolderIdentity = &e.Identity
+		lease.Spec.AcquireTime = &now
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(lease); err != nil {
+		return false, fmt.Errorf("could not update lease %s: %s", e.Name, err.Error())
+	}
+	e.observe(lease.Spec, now.Time)
+	e.acquired(now.Time)
+	return true, nil
+}
+
+func (e *Elector) acquired(at time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// renewals abandoned at the renew deadline may still complete
+	if e.steppedDown {
+		return
+	}
+	e.leader = true
+	e.renewed = at
+}
+
+func (e *Elector) retryPeriod() time.Duration {
+	return e.LeaseDuration / 3
+}
+
+func (e *Elector) renewDeadline() time.Duration {
+	return e.LeaseDuration * 2 / 3
+}
+
+// observe records the lease record and the local time it changed at
+func (e *Elector) observe(spec coordination_v1.LeaseSpec, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.observedAt.IsZero() || !reflect.DeepEqual(e.observed, spec) {
+		e.observed = *spec.DeepCopy()
+		e.observedAt = now
+	}
+}
+
+// expired returns true if the lease record hasn't changed within the
+// lease duration. Change is measured from when this replica observed it
+// rather than from RenewTime, which was set by the holder's clock
+func (e *Elector) expired(lease *coordination_v1.Lease, now time.Time) bool {
+	if lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	duration := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.observedAt.Add(duration).Before(now)
+}
@@ -0,0 +1,94 @@
+package leader
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTryAcquire(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	first := &Elector{Client: client, Namespace: "sample", Name: "eo", Identity: "eo-1", LeaseDuration: 15 * time.Second}
+	second := &Elector{Client: client, Namespace: "sample", Name: "eo", Identity: "eo-2", LeaseDuration: 15 * time.Second}
+
+	if ok, err := first.TryAcquire(); !ok || err != nil {
+		t.Fatalf("Expected first replica to acquire the lease, got %t %v", ok, err)
+	}
+	if ok, err := second.TryAcquire(); ok || err != nil {
+		t.Fatalf("Expected second replica to stand by, got %t %v", ok, err)
+	}
+	if !first.IsLeader() || second.IsLeader() {
+		t.Error("Expected only first replica to be the leader")
+	}
+	if ok, _ := first.TryAcquire(); !ok {
+		t.Error("Expected leader to renew the lease")
+	}
+
+	// leader renews the lease with a clock running behind, the renewal
+	// is still seen as recent
+	lease, _ := client.CoordinationV1().Leases("sample").Get("eo", metav1.GetOptions{})
+	skewed := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	lease.Spec.RenewTime = &skewed
+	client.CoordinationV1().Leases("sample").Update(lease)
+
+	if ok, err := second.TryAcquire(); ok || err != nil {
+		t.Fatalf("Expected second replica to stand by for renewed lease, got %t %v", ok, err)
+	}
+
+	// leader stops renewing the lease
+	second.mu.Lock()
+	second.observedAt = second.observedAt.Add(-time.Minute)
+	second.mu.Unlock()
+
+	if ok, err := second.TryAcquire(); !ok || err != nil {
+		t.Fatalf("Expected second replica to take over expired lease, got %t %v", ok, err)
+	}
+	lease, _ = client.CoordinationV1().Leases("sample").Get("eo", metav1.GetOptions{})
+	if *lease.Spec.HolderIdentity != "eo-2" || *lease.Spec.LeaseTransitions != 1 {
+		t.Errorf("Expected lease held by eo-2 after 1 transition, got %s %d", *lease.Spec.HolderIdentity, *lease.Spec.LeaseTransitions)
+	}
+	if ok, _ := first.TryAcquire(); ok || first.IsLeader() {
+		t.Error("Expected previous leader to lose the lease")
+	}
+}
+
+func TestRenewStepsDownAtDeadline(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	e := &Elector{Client: client, Namespace: "sample", Name: "eo", Identity: "eo-1", LeaseDuration: 300 * time.Millisecond}
+	if ok, err := e.TryAcquire(); !ok || err != nil {
+		t.Fatalf("Expected to acquire the lease, got %t %v", ok, err)
+	}
+	acquired := time.Now()
+
+	// renewals hang until long after other replicas could take over
+	client.PrependReactor("get", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		time.Sleep(time.Second)
+		return false, nil, nil
+	})
+
+	lost := make(chan time.Time, 1)
+	go e.Renew(func() { lost <- time.Now() })
+
+	select {
+	case at := <-lost:
+		if at.Sub(acquired) >= e.LeaseDuration {
+			t.Errorf("Expected to step down before the lease expires, stepped down after %s", at.Sub(acquired))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected leader to step down")
+	}
+	if e.IsLeader() {
+		t.Error("Expected replica to no longer be the leader")
+	}
+}
+
+func TestIsLeaderWithoutElection(t *testing.T) {
+	Election = nil
+	if !IsLeader() {
+		t.Error("Expected operator to lead without leader election")
+	}
+}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"github.com/pearsontechnology/environment-operator/version"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -18,8 +19,107 @@ var ConfigMapDeploys = prometheus.NewCounterVec(
 	},
 	[]string{"status"},
 )
+var Restarts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_restarts_total",
+		Help: "Restart requests received from clients.",
+	},
+	[]string{"status"},
+)
+var Syncs = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_syncs_total",
+		Help: "Sync requests received from clients.",
+	},
+	[]string{"status"},
+)
+var ReconcileFailures = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "eo_reconcile_consecutive_failures",
+		Help: "Consecutive failed reconcile loops.",
+	},
+	[]string{"namespace", "environment"},
+)
+var Reconciles = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_reconciles_total",
+		Help: "Reconcile loops run.",
+	},
+	[]string{"namespace", "environment", "status"},
+)
+var ReconcileDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "eo_reconcile_duration_seconds",
+		Help:    "Duration of reconcile loops.",
+		Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600},
+	},
+	[]string{"namespace", "environment"},
+)
+var ReconcileQueueDepth = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "eo_reconcile_queue_depth",
+		Help: "Service applies queued behind RECONCILE_CONCURRENCY, waiting for a worker.",
+	},
+	[]string{"namespace"},
+)
+var ReaperStuckDeletions = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_reaper_stuck_deletions_total",
+		Help: "Objects deleted by reaper still terminating after the delete timeout.",
+	},
+	[]string{"kind"},
+)
+var OrphanServices = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "eo_orphan_services",
+		Help: "Services found in the cluster but not in the config, kept by ORPHAN_POLICY.",
+	},
+	[]string{"namespace"},
+)
+var GitRefreshDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: "eo_git_refresh_duration_seconds",
+		Help: "Duration of git fetch and pull operations.",
+	},
+	[]string{"repository", "operation"},
+)
+var GitReceivedBytes = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "eo_git_received_bytes_total",
+		Help: "Growth of the local git object store from fetches and pulls.",
+	},
+	[]string{"repository"},
+)
+var GitCheckoutBytes = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "eo_git_checkout_bytes",
+		Help: "Size of the local repository copy, including the git object store.",
+	},
+	[]string{"repository"},
+)
+var BuildInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "eo_build_info",
+		Help: "Build of the running operator, always 1.",
+	},
+	[]string{"version", "git_commit", "build_date"},
+)
 
 func init() {
 	prometheus.MustRegister(Deploys)
 	prometheus.MustRegister(ConfigMapDeploys)
+	prometheus.MustRegister(Restarts)
+	prometheus.MustRegister(Syncs)
+	prometheus.MustRegister(ReconcileFailures)
+	prometheus.MustRegister(Reconciles)
+	prometheus.MustRegister(ReconcileDuration)
+	prometheus.MustRegister(ReconcileQueueDepth)
+	prometheus.MustRegister(ReaperStuckDeletions)
+	prometheus.MustRegister(OrphanServices)
+	prometheus.MustRegister(GitRefreshDuration)
+	prometheus.MustRegister(GitReceivedBytes)
+	prometheus.MustRegister(GitCheckoutBytes)
+	prometheus.MustRegister(BuildInfo)
+
+	BuildInfo.WithLabelValues(version.Version, version.GitCommit, version.BuildDate).Set(1)
 }
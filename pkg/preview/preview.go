@@ -0,0 +1,133 @@
+// Package preview formats the difference between manifests rendered from
+// two revisions of an environment and posts it for review, e.g. as a pull
+// request comment
+package preview
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// maxCommentLength keeps comments under GitHub's 65536 character limit
+const maxCommentLength = 60000
+
+// Objects maps object identity, e.g. Deployment/api, to its rendered YAML
+type Objects map[string]string
+
+// Change is the diff of a single object between two revisions
+type Change struct {
+	Object string
+	// Action is one of added, removed or changed
+	Action string
+	Diff   string
+}
+
+// Diff returns changes of objects between base and head, sorted by object
+func Diff(base, head Objects) ([]Change, error) {
+	keys := map[string]bool{}
+	for k := range base {
+		keys[k] = true
+	}
+	for k := range head {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []Change
+	for _, k := range sorted {
+		before, inBase := base[k]
+		after, inHead := head[k]
+		if before == after {
+			continue
+		}
+
+		action := "changed"
+		if !inBase {
+			action = "added"
+		} else if !inHead {
+			action = "removed"
+		}
+
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(before),
+			B:        difflib.SplitLines(after),
+			FromFile: "base/" + k,
+			ToFile:   "head/" + k,
+			Context:  3,
+		})
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, Change{Object: k, Action: action, Diff: diff})
+	}
+	return changes, nil
+}
+
+// Comment formats changes of the environment as a markdown comment, with
+// a collapsible diff per object. Diffs not fitting the comment are left out
+func Comment(env string, changes []Change) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### environment-operator preview: %s\n\n", env)
+	if len(changes) == 0 {
+		b.WriteString("No changes to Kubernetes objects.\n")
+		return b.String()
+	}
+
+	counts := map[string]int{}
+	for _, c := range changes {
+		counts[c.Action]++
+	}
+	fmt.Fprintf(&b, "%d added, %d changed, %d removed\n\n", counts["added"], counts["changed"], counts["removed"])
+
+	for i, c := range changes {
+		section := fmt.Sprintf("<details><summary>%s (%s)</summary>\n\n```diff\n%s```\n</details>\n\n", c.Object, c.Action, c.Diff)
+		if b.Len()+len(section) > maxCommentLength {
+			fmt.Fprintf(&b, "%d more objects changed, run `operator preview` locally to see the full diff.\n", len(changes)-i)
+			break
+		}
+		b.WriteString(section)
+	}
+	return b.String()
+}
+
+// Post sends comment as {"body": comment} to url, e.g. GitHub's
+// /repos/{owner}/{repo}/issues/{number}/comments endpoint. Token, if set,
+// is sent as a bearer token
+func Post(url, token, comment string) error {
+	body, err := json.Marshal(map[string]string{"body": comment})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("preview webhook %s returned %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package preview
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	base := Objects{
+		"Deployment/api": "replicas: 1\nimage: api:1\n",
+		"Service/api":    "port: 80\n",
+		"Ingress/api":    "host: api.example.com\n",
+	}
+	head := Objects{
+		"Deployment/api": "replicas: 1\nimage: api:2\n",
+		"Service/api":    "port: 80\n",
+		"HPA/api":        "max: 5\n",
+	}
+
+	changes, err := Diff(base, head)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	expected := []struct{ Object, Action string }{
+		{"Deployment/api", "changed"},
+		{"HPA/api", "added"},
+		{"Ingress/api", "removed"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %+v", len(expected), changes)
+	}
+	for i, e := range expected {
+		if changes[i].Object != e.Object || changes[i].Action != e.Action {
+			t.Errorf("Expected %s %s, got %s %s", e.Object, e.Action, changes[i].Object, changes[i].Action)
+		}
+	}
+	if !strings.Contains(changes[0].Diff, "-image: api:1\n+image: api:2\n") {
+		t.Errorf("Unexpected diff:\n%s", changes[0].Diff)
+	}
+}
+
+func TestComment(t *testing.T) {
+	if c := Comment("dev", nil); !strings.Contains(c, "No changes") {
+		t.Errorf("Expected no changes comment, got %s", c)
+	}
+
+	changes := []Change{
+		{Object: "Deployment/api", Action: "changed", Diff: "-image: api:1\n+image: api:2\n"},
+		{Object: "HPA/api", Action: "added", Diff: "+max: 5\n"},
+	}
+	c := Comment("dev", changes)
+	for _, s := range []string{"preview: dev", "1 added, 1 changed, 0 removed", "<summary>Deployment/api (changed)</summary>", "```diff\n-image: api:1"} {
+		if !strings.Contains(c, s) {
+			t.Errorf("Expected comment to contain %q, got %s", s, c)
+		}
+	}
+
+	large := []Change{
+		{Object: "ConfigMap/a", Action: "added", Diff: strings.Repeat("+x\n", maxCommentLength/4)},
+		{Object: "ConfigMap/b", Action: "added", Diff: strings.Repeat("+x\n", maxCommentLength/4)},
+	}
+	c = Comment("dev", large)
+	if len(c) > maxCommentLength || !strings.Contains(c, "1 more objects changed") {
+		t.Errorf("Expected truncated comment, got %d characters", len(c))
+	}
+}
+
+func TestPost(t *testing.T) {
+	var body map[string]string
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	if err := Post(ts.URL, "secret", "comment"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if body["body"] != "comment" || auth != "Bearer secret" {
+		t.Errorf("Unexpected request body %v, authorization %q", body, auth)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer failing.Close()
+	if err := Post(failing.URL, "", "comment"); err == nil {
+		t.Error("Expected error on failed request")
+	}
+}
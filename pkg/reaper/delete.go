@@ -0,0 +1,79 @@
+package reaper
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// deletePollInterval is how often deleted objects are checked to be gone
+var deletePollInterval = time.Second
+
+// removeFinalizersPatch is a merge patch clearing object's finalizers
+var removeFinalizersPatch = []byte(`{"metadata":{"finalizers":null}}`)
+
+// deletion describes how to delete a single object and watch it go away
+type deletion struct {
+	kind   string
+	name   string
+	get    func() (metav1.Object, error)
+	delete func() error
+	// patch applies a merge patch to the object
+	patch func(data []byte) error
+}
+
+// destroy deletes the object and waits up to REAPER_DELETE_TIMEOUT for it
+// to be removed. Objects still terminating after the timeout are reported
+// with the finalizers holding them, and have their finalizers removed if
+// REAPER_FORCE_FINALIZERS is set. Objects already terminating are not
+// deleted again and are only waited on for the rest of the timeout
+func (r *Reaper) destroy(d deletion) error {
+	obj, err := d.get()
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(config.Env.ReaperDeleteTimeout) * time.Second
+	if ts := obj.GetDeletionTimestamp(); ts != nil {
+		timeout -= time.Since(ts.Time)
+	} else if err := d.delete(); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if timeout > 0 {
+		err = wait.PollImmediate(deletePollInterval, timeout, func() (bool, error) {
+			if obj, err = d.get(); k8serrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+		if err != wait.ErrWaitTimeout {
+			return err
+		}
+	}
+
+	metrics.ReaperStuckDeletions.WithLabelValues(d.kind).Inc()
+	log.Warnf("REAPER: %s %s is stuck terminating, waiting on finalizers %v", d.kind, d.name, obj.GetFinalizers())
+
+	if !config.Env.ReaperForceFinalizers {
+		return fmt.Errorf("%s %s was not deleted within %ds", d.kind, d.name, config.Env.ReaperDeleteTimeout)
+	}
+
+	log.Warnf("REAPER: removing finalizers %v from %s %s", obj.GetFinalizers(), d.kind, d.name)
+	if err := d.patch(removeFinalizersPatch); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package reaper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func stuckClaimReaper() *Reaper {
+	deleted := metav1.NewTime(time.Now().Add(-5 * time.Minute))
+	c := fake.NewSimpleClientset(
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "data",
+				Namespace:         "sample",
+				DeletionTimestamp: &deleted,
+				Finalizers:        []string{"kubernetes.io/pvc-protection"},
+			},
+		},
+	)
+	return &Reaper{
+		Wrapper:   &cluster.Cluster{Interface: c},
+		Namespace: "sample",
+	}
+}
+
+func TestDestroyStuckTerminating(t *testing.T) {
+	defer func(timeout int, force bool) {
+		config.Env.ReaperDeleteTimeout, config.Env.ReaperForceFinalizers = timeout, force
+	}(config.Env.ReaperDeleteTimeout, config.Env.ReaperForceFinalizers)
+	config.Env.ReaperDeleteTimeout = 60
+
+	var tests = []struct {
+		Force      bool
+		Error      bool
+		Finalizers int
+	}{
+		{Force: false, Error: true, Finalizers: 1},
+		{Force: true, Error: false, Finalizers: 0},
+	}
+
+	for _, tst := range tests {
+		config.Env.ReaperForceFinalizers = tst.Force
+		r := stuckClaimReaper()
+
+		err := r.destroyPersistentVolume("data")
+		if (err != nil) != tst.Error {
+			t.Errorf("force %t: unexpected error %v", tst.Force, err)
+		}
+
+		pvc, err := r.Wrapper.CoreV1().PersistentVolumeClaims("sample").Get("data", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if len(pvc.Finalizers) != tst.Finalizers {
+			t.Errorf("force %t: expected %d finalizers, got %v", tst.Force, tst.Finalizers, pvc.Finalizers)
+		}
+	}
+}
+
+func TestDestroyMissing(t *testing.T) {
+	r := &Reaper{
+		Wrapper:   &cluster.Cluster{Interface: fake.NewSimpleClientset()},
+		Namespace: "sample",
+	}
+	if err := r.destroyPersistentVolume("data"); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
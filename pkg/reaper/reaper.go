@@ -7,8 +7,25 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
 	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Policies for orphan services, found in the cluster but not in the config.
+// ORPHAN_POLICY is checked to be one of them at startup
+const (
+	// OrphanPrune deletes orphan services
+	OrphanPrune = "prune"
+	// OrphanWarn logs orphan services and keeps them
+	OrphanWarn = "warn"
+	// OrphanIgnore keeps orphan services silently
+	OrphanIgnore = "ignore"
 )
 
 // Reaper goes through orphan objects defined in Namespace and deletes them
@@ -20,36 +37,75 @@ type Reaper struct {
 // Cleanup collects all orphan services or service components (not mentioned in cfg) and
 // deletes them from the cluster
 func (r *Reaper) Cleanup(cfg *bitesize.Environment) error {
+	return r.CleanupOn(cfg, bitesize.TriggerPoll)
+}
+
+// CleanupOn is Cleanup for a reconcile started by trigger. Orphan services
+// are handled according to ORPHAN_POLICY, while components removed from
+// services not reconciled on the trigger are left in place
+func (r *Reaper) CleanupOn(cfg *bitesize.Environment, trigger string) error {
 
 	if cfg == nil || cfg.Services == nil {
 		return errors.New("REAPER: error with bitesize file, configuration is nil")
 	}
+	if err := cluster.NamespaceAllowed(r.Namespace); err != nil {
+		return fmt.Errorf("REAPER: %s", err.Error())
+	}
 
 	current, err := r.Wrapper.ScrapeResourcesForNamespace(r.Namespace)
 	if err != nil {
 		return fmt.Errorf("REAPER: error loading environment: %s", err.Error())
 	}
 
+	// services not deployed in namespace's environment are orphans too
+	services := cfg.Services.ForEnvironment(current.Name)
+	policy := config.Env.OrphanPolicy
+	orphans := 0
+
 	for _, service := range current.Services {
-		configService := cfg.Services.FindByName(service.Name)
+		// services of operators sharing the namespace with another
+		// SERVICE_NAME_PREFIX or SERVICE_NAME_SUFFIX are not ours to reap
+		if !bitesize.IsServiceObjectName(service.Name, cfg.Services) {
+			continue
+		}
+		configService := services.FindByName(service.Name)
 
 		if configService == nil {
-			log.Infof("REAPER: found orphan service %s, deleting.", service.Name)
-			if err := r.deleteService(service); err != nil {
-				log.Errorf("REAPER: delete orphan service %s failed with %s", service.Name, err.Error())
+			switch policy {
+			case OrphanPrune:
+				log.Infof("REAPER: found orphan service %s, deleting.", service.Name)
+				if err := r.deleteService(service); err != nil {
+					log.Errorf("REAPER: delete orphan service %s failed with %s", service.Name, err.Error())
+				}
+			case OrphanWarn:
+				log.Warnf("REAPER: found orphan service %s in namespace %s, not in config", service.Name, r.Namespace)
+				orphans++
+			case OrphanIgnore:
+				orphans++
 			}
-		} else if configService.IsBlueGreenParentDeployment() {
+			continue
+		}
+
+		if configService.IsBlueGreenParentDeployment() {
 			if err := r.destroyDeployment(service.Name); err != nil {
 				log.Errorf("REAPER: delete orphan deployment %s failed with %s", service.Name, err.Error())
 			}
 		}
 
+		if !configService.ReconciledOn(trigger) {
+			continue
+		}
+
 		// delete ingresses that were removed from the service config
-		r.CleanupIngress(cfg.Services.FindByName(service.Name), &service)
+		r.CleanupIngress(configService, &service)
 		// delete HPA objects  that were removed from the service config
-		r.CleanupHPA(cfg.Services.FindByName(service.Name), &service)
+		r.CleanupHPA(configService, &service)
+		// delete ExternalSecrets of env vars no longer synced from external_key
+		r.CleanupEnvExternalSecrets(configService, &service)
 	}
 
+	metrics.OrphanServices.WithLabelValues(r.Namespace).Set(float64(orphans))
+
 	// cleanup all resources that were removed from the service config
 	r.CleanupGists(cfg.Gists, current.Gists)
 
@@ -64,6 +120,12 @@ func (r *Reaper) deleteService(svc bitesize.Service) error {
 		log.Errorf("REAPER: failed to destroy ingress: %s", err.Error())
 	}
 
+	if len(svc.WeightedBackends) != 0 {
+		if err := r.destroyIngress(svc.CanaryIngressName()); err != nil {
+			log.Errorf("REAPER: failed to destroy ingress: %s", err.Error())
+		}
+	}
+
 	if err := r.destroyDeployment(svc.Name); err != nil {
 		log.Errorf("REAPER: failed to destroy deployment: %s", err.Error())
 	}
@@ -76,8 +138,14 @@ func (r *Reaper) deleteService(svc bitesize.Service) error {
 		log.Errorf("REAPER: failed to destroy HPA failed: %s", err.Error())
 	}
 
+	if svc.ServiceMonitor != nil {
+		if err := r.destroyServiceMonitor(svc.Name); err != nil {
+			log.Errorf("REAPER: failed to destroy ServiceMonitor: %s", err.Error())
+		}
+	}
+
 	for _, volume := range svc.Volumes {
-		if volume.IsConfigMapVolume() || volume.IsSecretVolume() {
+		if !volume.IsPersistentVolume() {
 			continue
 		}
 		if err := r.destroyPersistentVolume(volume.Name); err != nil {
@@ -85,6 +153,12 @@ func (r *Reaper) deleteService(svc bitesize.Service) error {
 		}
 	}
 
+	for _, name := range svc.Status.EnvExternalSecrets {
+		if err := r.destroyExternalSecret(name); err != nil {
+			log.Errorf("REAPER: failed to destroy ExternalSecret %s: %s", name, err.Error())
+		}
+	}
+
 	if err := r.destroyCustomResourceDefinition(svc.Name); err != nil {
 		log.Errorf("REAPER: failed to destroy custom resources: %s", err.Error())
 	}
@@ -103,7 +177,16 @@ func (r *Reaper) destroyIngress(name string) error {
 		log.Errorf("REAPER: failed to destroy ExternalSecret: %s", err.Error())
 	}
 
-	return client.Destroy(name)
+	return r.destroy(deletion{
+		kind:   "ingress",
+		name:   name,
+		get:    func() (metav1.Object, error) { return client.Get(name) },
+		delete: func() error { return client.Destroy(name, k8s.DeleteOptionsFor("ingress")) },
+		patch: func(data []byte) error {
+			_, err := client.NetworkingV1beta1().Ingresses(r.Namespace).Patch(name, types.MergePatchType, data)
+			return err
+		},
+	})
 }
 
 func (r *Reaper) destroyExternalSecret(name string) error {
@@ -131,10 +214,16 @@ func (r *Reaper) destroyDeployment(name string) error {
 		Interface: r.Wrapper.Interface,
 		Namespace: r.Namespace,
 	}
-	if client.Exist(name) {
-		return client.Destroy(name)
-	}
-	return nil
+	return r.destroy(deletion{
+		kind:   "deployment",
+		name:   name,
+		get:    func() (metav1.Object, error) { return client.Get(name) },
+		delete: func() error { return client.Destroy(name, k8s.DeleteOptionsFor("deployment")) },
+		patch: func(data []byte) error {
+			_, err := client.AppsV1().Deployments(r.Namespace).Patch(name, types.MergePatchType, data)
+			return err
+		},
+	})
 }
 
 func (r *Reaper) destroyService(name string) error {
@@ -142,7 +231,16 @@ func (r *Reaper) destroyService(name string) error {
 		Interface: r.Wrapper.Interface,
 		Namespace: r.Namespace,
 	}
-	return client.Destroy(name)
+	return r.destroy(deletion{
+		kind:   "service",
+		name:   name,
+		get:    func() (metav1.Object, error) { return client.Get(name) },
+		delete: func() error { return client.Destroy(name, k8s.DeleteOptionsFor("service")) },
+		patch: func(data []byte) error {
+			_, err := client.CoreV1().Services(r.Namespace).Patch(name, types.MergePatchType, data)
+			return err
+		},
+	})
 }
 
 func (r *Reaper) destroyHPA(name string) error {
@@ -150,7 +248,33 @@ func (r *Reaper) destroyHPA(name string) error {
 		Interface: r.Wrapper.Interface,
 		Namespace: r.Namespace,
 	}
-	return client.Destroy(name)
+	return r.destroy(deletion{
+		kind:   "hpa",
+		name:   name,
+		get:    func() (metav1.Object, error) { return client.Get(name) },
+		delete: func() error { return client.Destroy(name, k8s.DeleteOptionsFor("hpa")) },
+		patch: func(data []byte) error {
+			_, err := client.AutoscalingV2beta2().HorizontalPodAutoscalers(r.Namespace).Patch(name, types.MergePatchType, data)
+			return err
+		},
+	})
+}
+
+func (r *Reaper) destroyServiceMonitor(name string) error {
+	client := k8s.Manifest{
+		Interface: r.Wrapper.Interface,
+		Namespace: r.Namespace,
+	}
+	monitor := &unstructured.Unstructured{}
+	monitor.SetAPIVersion(k8s.ServiceMonitorAPIVersion)
+	monitor.SetKind("ServiceMonitor")
+	monitor.SetName(name)
+
+	log.Infof("REAPER: deleting servicemonitor %s", name)
+	if err := client.Destroy(monitor, k8s.DeleteOptionsFor("servicemonitor")); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
 }
 
 func (r *Reaper) destroyPersistentVolume(name string) error {
@@ -158,22 +282,46 @@ func (r *Reaper) destroyPersistentVolume(name string) error {
 		Interface: r.Wrapper.Interface,
 		Namespace: r.Namespace,
 	}
-	return client.Destroy(name)
+	return r.destroy(deletion{
+		kind:   "pvc",
+		name:   name,
+		get:    func() (metav1.Object, error) { return client.Get(name) },
+		delete: func() error { return client.Destroy(name, k8s.DeleteOptionsFor("pvc")) },
+		patch: func(data []byte) error {
+			_, err := client.CoreV1().PersistentVolumeClaims(r.Namespace).Patch(name, types.MergePatchType, data)
+			return err
+		},
+	})
 }
 
 func (r *Reaper) destroyCustomResourceDefinition(name string) error {
 	return nil
 }
 
-func (r *Reaper) destroyResource(name string, rstype string) error {
-	switch rstype {
+func (r *Reaper) destroyManifests(res bitesize.Gist) error {
+	client := k8s.Manifest{
+		Interface: r.Wrapper.Interface,
+		Namespace: r.Namespace,
+	}
+	for _, obj := range res.Manifests {
+		log.Infof("REAPER: deleting %s %s of manifest %s", obj.GetKind(), obj.GetName(), res.Name)
+		if err := client.Destroy(&obj, k8s.DeleteOptionsFor("manifest")); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return client.DestroyInventory(res.Name)
+}
+
+func (r *Reaper) destroyResource(res bitesize.Gist) error {
+	name := res.Name
+	switch res.Type {
 	case bitesize.TypeConfigMap:
 		{
 			client := k8s.ConfigMap{
 				Interface: r.Wrapper.Interface,
 				Namespace: r.Namespace,
 			}
-			return client.Destroy(name)
+			return client.Destroy(name, k8s.DeleteOptionsFor("configmap"))
 		}
 	case bitesize.TypeJob:
 		{
@@ -181,7 +329,7 @@ func (r *Reaper) destroyResource(name string, rstype string) error {
 				Interface: r.Wrapper.Interface,
 				Namespace: r.Namespace,
 			}
-			return client.Destroy(name)
+			return client.Destroy(name, k8s.DeleteOptionsFor("job"))
 		}
 	case bitesize.TypeCronJob:
 		{
@@ -189,8 +337,10 @@ func (r *Reaper) destroyResource(name string, rstype string) error {
 				Interface: r.Wrapper.Interface,
 				Namespace: r.Namespace,
 			}
-			return client.Destroy(name)
+			return client.Destroy(name, k8s.DeleteOptionsFor("cronjob"))
 		}
+	case bitesize.TypeManifest:
+		return r.destroyManifests(res)
 	}
 
 	return nil
@@ -205,6 +355,15 @@ func (r *Reaper) CleanupIngress(configSvc, clusterSvc *bitesize.Service) {
 			log.Error(err)
 		}
 	}
+
+	if configSvc != nil && (!configSvc.HasExternalURL() || len(configSvc.WeightedBackends) == 0) &&
+		len(clusterSvc.WeightedBackends) != 0 {
+		log.Infof("REAPER: deleting ingress %s because weighted_backends were removed from the service config", clusterSvc.CanaryIngressName())
+		err := r.destroyIngress(clusterSvc.CanaryIngressName())
+		if err != nil {
+			log.Error(err)
+		}
+	}
 }
 
 // CleanupHPA deletes HPA object if HPA config is removed from the service config
@@ -215,6 +374,28 @@ func (r *Reaper) CleanupHPA(configSvc, clusterSvc *bitesize.Service) {
 	}
 }
 
+// CleanupEnvExternalSecrets deletes ExternalSecrets of env vars whose
+// external_key, or the secret they read, was removed from the service config
+func (r *Reaper) CleanupEnvExternalSecrets(configSvc, clusterSvc *bitesize.Service) {
+	desired := map[string]bool{}
+	for _, e := range configSvc.EnvVars {
+		if e.Secret != "" && e.ExternalKey != "" {
+			name, _ := e.SecretRef()
+			desired[bitesize.EnvExternalSecretName(configSvc.Name, name)] = true
+		}
+	}
+
+	for _, name := range clusterSvc.Status.EnvExternalSecrets {
+		if desired[name] {
+			continue
+		}
+		log.Infof("REAPER: deleting ExternalSecret %s because it was removed from the service config", name)
+		if err := r.destroyExternalSecret(name); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
 // CleanupGists deletes all gist types imported, if the corresponding gist is removed from the config
 func (r *Reaper) CleanupGists(configRes bitesize.Gists, clusterRes bitesize.Gists) {
 	for _, res := range clusterRes {
@@ -226,7 +407,7 @@ func (r *Reaper) CleanupGists(configRes bitesize.Gists, clusterRes bitesize.Gist
 		}
 		if !found {
 			log.Infof("REAPER: Found orphan resource %s, type %s deleting.", res.Name, res.Type)
-			err := r.destroyResource(res.Name, res.Type)
+			err := r.destroyResource(res)
 			if err != nil {
 				log.Error(err)
 			}
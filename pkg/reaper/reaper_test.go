@@ -5,7 +5,10 @@ import (
 
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/cluster"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/metrics"
 	fakecrd "github.com/pearsontechnology/environment-operator/pkg/util/k8s/fake"
+	dto "github.com/prometheus/client_model/go"
 	apps_v1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -80,3 +83,148 @@ func TestDeleteService(t *testing.T) {
 	}
 
 }
+
+func TestCleanupServiceNotInEnvironment(t *testing.T) {
+	deployment := func(name string) *apps_v1.Deployment {
+		return &apps_v1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "sample",
+				Labels:    map[string]string{"creator": "pipeline"},
+			},
+			Spec: apps_v1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{Containers: []v1.Container{{}}},
+				},
+			},
+		}
+	}
+	c := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "sample",
+				Labels: map[string]string{"environment": "prod"},
+			},
+		},
+		deployment("api"),
+		deployment("toolbox"),
+	)
+
+	wrapper := &cluster.Cluster{
+		Interface: c,
+		CRDClient: fakecrd.CRDClient("prsn.io", "v1"),
+	}
+	reaper := Reaper{
+		Wrapper:   wrapper,
+		Namespace: "sample",
+	}
+
+	cfg := &bitesize.Environment{
+		Namespace: "sample",
+		Services: bitesize.Services{
+			{Name: "api"},
+			{Name: "toolbox", Environments: []string{"dev"}},
+		},
+	}
+	if err := reaper.Cleanup(cfg); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if _, err := wrapper.AppsV1().Deployments("sample").Get("api", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected deployment api to be kept, got: %s", err.Error())
+	}
+	if d, err := wrapper.AppsV1().Deployments("sample").Get("toolbox", metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected deployment toolbox to be pruned, got: %+v", d)
+	}
+}
+
+func TestCleanupOrphanPolicy(t *testing.T) {
+	defer func(policy string) { config.Env.OrphanPolicy = policy }(config.Env.OrphanPolicy)
+
+	for _, policy := range []string{OrphanWarn, OrphanIgnore} {
+		config.Env.OrphanPolicy = policy
+		c := fake.NewSimpleClientset(
+			&v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "sample",
+					Labels: map[string]string{"environment": "prod"},
+				},
+			},
+			&apps_v1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "orphan",
+					Namespace: "sample",
+					Labels:    map[string]string{"creator": "pipeline"},
+				},
+				Spec: apps_v1.DeploymentSpec{
+					Template: v1.PodTemplateSpec{
+						Spec: v1.PodSpec{Containers: []v1.Container{{}}},
+					},
+				},
+			},
+		)
+		wrapper := &cluster.Cluster{
+			Interface: c,
+			CRDClient: fakecrd.CRDClient("prsn.io", "v1"),
+		}
+		reaper := Reaper{Wrapper: wrapper, Namespace: "sample"}
+
+		cfg := &bitesize.Environment{Namespace: "sample", Services: bitesize.Services{{Name: "api"}}}
+		if err := reaper.Cleanup(cfg); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if _, err := wrapper.AppsV1().Deployments("sample").Get("orphan", metav1.GetOptions{}); err != nil {
+			t.Errorf("Expected orphan deployment to be kept with policy %s, got: %s", policy, err.Error())
+		}
+
+		m := &dto.Metric{}
+		metrics.OrphanServices.WithLabelValues("sample").Write(m)
+		if m.GetGauge().GetValue() != 1 {
+			t.Errorf("Expected 1 orphan service with policy %s, got %v", policy, m.GetGauge().GetValue())
+		}
+	}
+}
+
+func TestCleanupKeepsServicesOfOtherPrefixes(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.OrphanPolicy = OrphanPrune
+	config.Env.ServiceNamePrefix = "tenant-"
+
+	deployment := func(name string) *apps_v1.Deployment {
+		return &apps_v1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "sample",
+				Labels:    map[string]string{"creator": "pipeline"},
+			},
+			Spec: apps_v1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{Containers: []v1.Container{{}}},
+				},
+			},
+		}
+	}
+	c := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "sample",
+				Labels: map[string]string{"environment": "prod"},
+			},
+		},
+		deployment("tenant-api"),
+		deployment("tenant-a-api"),
+	)
+	wrapper := &cluster.Cluster{
+		Interface: c,
+		CRDClient: fakecrd.CRDClient("prsn.io", "v1"),
+	}
+	reaper := Reaper{Wrapper: wrapper, Namespace: "sample"}
+
+	cfg := &bitesize.Environment{Namespace: "sample", Services: bitesize.Services{{Name: "tenant-api"}}}
+	if err := reaper.Cleanup(cfg); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := wrapper.AppsV1().Deployments("sample").Get("tenant-a-api", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected deployment of operator with prefix tenant-a- to be kept, got: %s", err.Error())
+	}
+}
@@ -0,0 +1,258 @@
+package source
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+)
+
+const (
+	// credentialsExpiryWindow is how long before they expire temporary
+	// credentials are fetched again
+	credentialsExpiryWindow = 5 * time.Minute
+	// credentialsRetryMin and credentialsRetryMax bound the backoff
+	// between failed credential lookups
+	credentialsRetryMin = 30 * time.Second
+	credentialsRetryMax = 10 * time.Minute
+)
+
+// containerCredentialsHosts are hosts of the ECS and EKS Pod Identity
+// credential endpoints. AWS_CONTAINER_CREDENTIALS_FULL_URI may only point
+// to these or to loopback addresses
+var containerCredentialsHosts = []string{"169.254.170.2", "169.254.170.23", "fd00:ec2::23"}
+
+// Endpoints of AWS credential services, variables so that tests can
+// point them to a local server
+var (
+	stsEndpoint = func(region string) string {
+		return fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+	}
+	containerCredentialsEndpoint = "http://169.254.170.2"
+	instanceMetadataEndpoint     = "http://169.254.169.254"
+)
+
+// awsCredentials sign requests to AWS. Temporary credentials have an
+// expiry time, static ones don't
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+func (c *awsCredentials) valid(now time.Time) bool {
+	return c.Expires.IsZero() || now.Before(c.Expires.Add(-credentialsExpiryWindow))
+}
+
+// credentialsProvider returns credentials from a single source, nil when
+// the source is not configured
+type credentialsProvider func(client *http.Client) (*awsCredentials, error)
+
+// credentialChain returns providers in the order AWS SDKs look credentials
+// up, after the static credentials of the environment
+func credentialChain(c config.Config) []credentialsProvider {
+	return []credentialsProvider{
+		webIdentityCredentials(c),
+		containerCredentials(c),
+		instanceMetadataCredentials(c),
+	}
+}
+
+// webIdentityCredentials assumes AWS_ROLE_ARN with the token in
+// AWS_WEB_IDENTITY_TOKEN_FILE, as set up by IAM roles for service accounts
+func webIdentityCredentials(c config.Config) credentialsProvider {
+	return func(client *http.Client) (*awsCredentials, error) {
+		if c.AWSWebIdentityTokenFile == "" || c.AWSRoleARN == "" {
+			return nil, nil
+		}
+		token, err := ioutil.ReadFile(c.AWSWebIdentityTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read web identity token: %s", err.Error())
+		}
+
+		query := url.Values{}
+		query.Set("Action", "AssumeRoleWithWebIdentity")
+		query.Set("Version", "2011-06-15")
+		query.Set("RoleArn", c.AWSRoleARN)
+		query.Set("RoleSessionName", c.AWSRoleSessionName)
+		query.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+		req, err := http.NewRequest("GET", stsEndpoint(c.S3Region)+"/?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := fetchCredentials(client, req)
+		if err != nil {
+			return nil, fmt.Errorf("could not assume role %s: %s", c.AWSRoleARN, err.Error())
+		}
+
+		var resp struct {
+			Credentials struct {
+				AccessKeyID     string    `xml:"AccessKeyId"`
+				SecretAccessKey string    `xml:"SecretAccessKey"`
+				SessionToken    string    `xml:"SessionToken"`
+				Expiration      time.Time `xml:"Expiration"`
+			} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+		}
+		if err := xml.Unmarshal(body, &resp); err != nil {
+			return nil, fmt.Errorf("could not assume role %s: %s", c.AWSRoleARN, err.Error())
+		}
+		return &awsCredentials{
+			AccessKeyID:     resp.Credentials.AccessKeyID,
+			SecretAccessKey: resp.Credentials.SecretAccessKey,
+			SessionToken:    resp.Credentials.SessionToken,
+			Expires:         resp.Credentials.Expiration,
+		}, nil
+	}
+}
+
+// containerCredentials fetches credentials of ECS task roles and EKS Pod
+// Identity from the container credentials endpoint
+func containerCredentials(c config.Config) credentialsProvider {
+	return func(client *http.Client) (*awsCredentials, error) {
+		endpoint := c.AWSContainerCredentialsFullURI
+		if c.AWSContainerCredentialsRelativeURI != "" {
+			endpoint = containerCredentialsEndpoint + c.AWSContainerCredentialsRelativeURI
+		}
+		if endpoint == "" {
+			return nil, nil
+		}
+		if c.AWSContainerCredentialsRelativeURI == "" {
+			if err := allowedContainerEndpoint(endpoint); err != nil {
+				return nil, err
+			}
+		}
+
+		req, err := http.NewRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		token := c.AWSContainerAuthorizationToken
+		if c.AWSContainerAuthorizationTokenFile != "" {
+			data, err := ioutil.ReadFile(c.AWSContainerAuthorizationTokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not read container authorization token: %s", err.Error())
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+
+		body, err := fetchCredentials(client, req)
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch container credentials: %s", err.Error())
+		}
+		return decodeCredentials(body)
+	}
+}
+
+// allowedContainerEndpoint checks that the full container credentials URI
+// points to loopback or to the ECS/EKS credential endpoints, so that the
+// authorization token is not sent to other hosts
+func allowedContainerEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid container credentials endpoint: %s", err.Error())
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsLoopback() {
+			return nil
+		}
+		for _, h := range containerCredentialsHosts {
+			if ip.Equal(net.ParseIP(h)) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("container credentials endpoint %s is not a loopback or ECS/EKS credentials address", host)
+}
+
+// instanceMetadataCredentials fetches credentials of the EC2 instance
+// profile with IMDSv2. Instance metadata not responding is taken as not
+// running on EC2
+func instanceMetadataCredentials(c config.Config) credentialsProvider {
+	return func(client *http.Client) (*awsCredentials, error) {
+		if c.AWSEC2MetadataDisabled {
+			return nil, nil
+		}
+
+		req, err := http.NewRequest("PUT", instanceMetadataEndpoint+"/latest/api/token", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+		token, err := fetchCredentials(&http.Client{Timeout: time.Second}, req)
+		if err != nil {
+			log.Debugf("instance metadata not available: %s", err.Error())
+			return nil, nil
+		}
+
+		path := instanceMetadataEndpoint + "/latest/meta-data/iam/security-credentials/"
+		get := func(url string) ([]byte, error) {
+			req, err := http.NewRequest("GET", url, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("X-aws-ec2-metadata-token", string(token))
+			return fetchCredentials(client, req)
+		}
+		role, err := get(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not get instance profile role: %s", err.Error())
+		}
+		body, err := get(path + strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]))
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch instance profile credentials: %s", err.Error())
+		}
+		return decodeCredentials(body)
+	}
+}
+
+// decodeCredentials decodes credentials in the JSON format served by the
+// container and instance metadata credential endpoints
+func decodeCredentials(body []byte) (*awsCredentials, error) {
+	var resp struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("could not decode credentials: %s", err.Error())
+	}
+	return &awsCredentials{
+		AccessKeyID:     resp.AccessKeyID,
+		SecretAccessKey: resp.SecretAccessKey,
+		SessionToken:    resp.Token,
+		Expires:         resp.Expiration,
+	}, nil
+}
+
+func fetchCredentials(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,21 @@
+package source
+
+import "testing"
+
+func TestAllowedContainerEndpoint(t *testing.T) {
+	tests := map[string]bool{
+		"http://127.0.0.1:8080/credentials":     true,
+		"http://localhost/credentials":          true,
+		"http://[::1]/credentials":              true,
+		"http://169.254.170.2/v2/credentials":   true,
+		"http://169.254.170.23/v1/credentials":  true,
+		"http://[fd00:ec2::23]/v1/credentials":  true,
+		"https://credentials.example.com/creds": false,
+		"http://10.0.0.1/credentials":           false,
+	}
+	for endpoint, allowed := range tests {
+		if err := allowedContainerEndpoint(endpoint); (err == nil) != allowed {
+			t.Errorf("%s: expected allowed %t, got %v", endpoint, allowed, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package source
+
+import (
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+)
+
+// ConfigMap loads environment from a configmap key named after BITESIZE_FILE
+type ConfigMap struct {
+	Client  *k8s.Client
+	Name    string
+	Key     string
+	EnvName string
+}
+
+// NewConfigMap returns configmap config source for a given client
+func NewConfigMap(client *k8s.Client, c config.Config) *ConfigMap {
+	return &ConfigMap{
+		Client:  client,
+		Name:    c.EnvConfigMap,
+		Key:     filepath.Base(c.EnvFile),
+		EnvName: c.EnvName,
+	}
+}
+
+// Refresh is a no-op, configmap is read on every Load
+func (s *ConfigMap) Refresh() error {
+	return nil
+}
+
+// Load returns environment from the configmap
+func (s *ConfigMap) Load() (*bitesize.Environment, error) {
+	return bitesize.LoadEnvironmentFromConfigMap(s.Client, s.Name, s.Key, s.EnvName)
+}
+
+// CurrentRevision returns resource version of the configmap
+func (s *ConfigMap) CurrentRevision() (string, error) {
+	cm, err := s.Client.ConfigMap().Get(s.Name)
+	if err != nil {
+		return "", err
+	}
+	return cm.ResourceVersion, nil
+}
+
+// Changes signals on the returned channel whenever the configmap changes.
+// Watch is re-established when closed by the API server
+func (s *ConfigMap) Changes() <-chan struct{} {
+	changed := make(chan struct{}, 1)
+
+	go func() {
+		for {
+			w, err := s.Client.ConfigMap().Watch(s.Name)
+			if err != nil {
+				log.Errorf("error watching configmap %s: %s", s.Name, err.Error())
+				time.Sleep(5 * time.Second)
+				continue
+			}
+			for range w.ResultChan() {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+			log.Debugf("watch on configmap %s closed, restarting", s.Name)
+		}
+	}()
+	return changed
+}
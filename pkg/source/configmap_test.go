@@ -0,0 +1,63 @@
+package source
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapSource(t *testing.T) {
+	contents, err := ioutil.ReadFile("../../test/assets/environments.bitesize")
+	if err != nil {
+		t.Fatalf("Unexpected error reading bitesize file: %s", err.Error())
+	}
+	client := &k8s.Client{
+		Namespace: "sample",
+		Interface: fake.NewSimpleClientset(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "bitesize",
+				Namespace:       "sample",
+				ResourceVersion: "42",
+			},
+			Data: map[string]string{"environments.bitesize": string(contents)},
+		}),
+	}
+
+	var src ConfigSource = NewConfigMap(client, config.Config{
+		EnvConfigMap: "bitesize",
+		EnvFile:      "config/environments.bitesize",
+		EnvName:      "environment2",
+	})
+
+	if err := src.Refresh(); err != nil {
+		t.Errorf("Unexpected error on refresh: %s", err.Error())
+	}
+
+	e, err := src.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error loading environment: %s", err.Error())
+	}
+	if len(e.Services) != 7 {
+		t.Errorf("Unexpected count of services. Expected 7, got: %d", len(e.Services))
+	}
+
+	revision, err := src.CurrentRevision()
+	if err != nil {
+		t.Fatalf("Unexpected error getting revision: %s", err.Error())
+	}
+	if revision != "42" {
+		t.Errorf("Expected revision 42, got %s", revision)
+	}
+}
+
+func TestFromConfigUnknownSource(t *testing.T) {
+	_, err := FromConfig(config.Config{ConfigSource: "ftp"})
+	if err == nil || err.Error() != "unknown config source ftp" {
+		t.Errorf("Expected unknown config source error, got %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package source
+
+import (
+	"path/filepath"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/git"
+)
+
+// Git loads environment from BITESIZE_FILE in a git repository
+type Git struct {
+	Client  *git.Git
+	File    string
+	EnvName string
+}
+
+// NewGit returns git config source for a given client
+func NewGit(client *git.Git, c config.Config) *Git {
+	return &Git{
+		Client:  client,
+		File:    filepath.Join(client.LocalPath, c.EnvFile),
+		EnvName: c.EnvName,
+	}
+}
+
+// Refresh pulls in changes from the remote repository, if there are any
+func (g *Git) Refresh() error {
+	return g.Client.Refresh()
+}
+
+// Load returns environment from the local repository copy
+func (g *Git) Load() (*bitesize.Environment, error) {
+	return bitesize.LoadEnvironment(g.File, g.EnvName)
+}
+
+// CurrentRevision returns commit hash of the local repository copy
+func (g *Git) CurrentRevision() (string, error) {
+	return g.Client.Revision()
+}
@@ -0,0 +1,304 @@
+package source
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+)
+
+// emptyPayloadHash is the SHA256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 loads environment from an object in S3 compatible object storage.
+// Object version (or ETag for unversioned buckets) is used as revision
+type S3 struct {
+	Bucket    string
+	Key       string
+	Region    string
+	Endpoint  string
+	VersionID string
+	EnvName   string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	HTTPClient *http.Client
+
+	providers   []credentialsProvider
+	credentials *awsCredentials
+	// failed credential lookups are not retried before retryAt
+	lookupErr error
+	retryAt   time.Time
+	backoff   time.Duration
+
+	contents string
+	etag     string
+	revision string
+}
+
+// NewS3 returns S3 config source configured from environment
+func NewS3(c config.Config) *S3 {
+	key := c.S3Key
+	if key == "" {
+		key = c.EnvFile
+	}
+	return &S3{
+		Bucket:          c.S3Bucket,
+		Key:             strings.TrimPrefix(key, "/"),
+		Region:          c.S3Region,
+		Endpoint:        c.S3Endpoint,
+		VersionID:       c.S3VersionID,
+		EnvName:         c.EnvName,
+		AccessKeyID:     c.AWSAccessKeyID,
+		SecretAccessKey: c.AWSSecretAccessKey,
+		SessionToken:    c.AWSSessionToken,
+		HTTPClient:      &http.Client{Timeout: 30 * time.Second},
+		providers:       credentialChain(c),
+	}
+}
+
+// Refresh fetches the object if it changed since the last refresh
+func (s *S3) Refresh() error {
+	req, err := http.NewRequest("GET", s.objectURL(), nil)
+	if err != nil {
+		return err
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	now := time.Now().UTC()
+	creds, err := s.resolveCredentials(now)
+	if err != nil {
+		return err
+	}
+	s.sign(req, creds, now)
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error fetching %s: %s %s", s, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	s.contents = string(body)
+	s.etag = resp.Header.Get("ETag")
+	s.revision = resp.Header.Get("X-Amz-Version-Id")
+	if s.revision == "" || s.revision == "null" {
+		s.revision = strings.Trim(s.etag, `"`)
+	}
+	log.Infof("fetched %s revision %s", s, s.revision)
+	return nil
+}
+
+// Load returns environment from the last fetched object
+func (s *S3) Load() (*bitesize.Environment, error) {
+	if s.revision == "" {
+		return nil, fmt.Errorf("%s has not been fetched", s)
+	}
+	return bitesize.LoadEnvironmentFromString(s.contents, s.EnvName, s.String(), "")
+}
+
+// CurrentRevision returns version of the last fetched object
+func (s *S3) CurrentRevision() (string, error) {
+	if s.revision == "" {
+		return "", fmt.Errorf("%s has not been fetched", s)
+	}
+	return s.revision, nil
+}
+
+func (s *S3) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, s.Key)
+}
+
+// objectURL returns virtual-hosted style URL for AWS, or path style
+// URL when custom endpoint is set
+func (s *S3) objectURL() string {
+	var u string
+	if s.Endpoint != "" {
+		u = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.Endpoint, "/"), s.Bucket, uriEncode(s.Key, false))
+	} else {
+		u = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Region, uriEncode(s.Key, false))
+	}
+	if s.VersionID != "" {
+		u += "?versionId=" + uriEncode(s.VersionID, true)
+	}
+	return u
+}
+
+// resolveCredentials returns static credentials when configured, otherwise
+// the first credentials found in the provider chain. Temporary credentials
+// are reused until they are about to expire. Nil is returned when no
+// credentials are available. Failed or empty lookups are remembered and
+// the chain is not run again before the backoff passes
+func (s *S3) resolveCredentials(now time.Time) (*awsCredentials, error) {
+	if s.AccessKeyID != "" && s.SecretAccessKey != "" {
+		return &awsCredentials{
+			AccessKeyID:     s.AccessKeyID,
+			SecretAccessKey: s.SecretAccessKey,
+			SessionToken:    s.SessionToken,
+		}, nil
+	}
+	if s.credentials != nil && s.credentials.valid(now) {
+		return s.credentials, nil
+	}
+	if now.Before(s.retryAt) {
+		return nil, s.lookupErr
+	}
+
+	creds, err := s.lookupCredentials()
+	if creds != nil {
+		s.credentials, s.lookupErr, s.backoff = creds, nil, 0
+		return creds, nil
+	}
+
+	s.backoff *= 2
+	if s.backoff < credentialsRetryMin {
+		s.backoff = credentialsRetryMin
+	}
+	if s.backoff > credentialsRetryMax {
+		s.backoff = credentialsRetryMax
+	}
+	s.credentials, s.lookupErr, s.retryAt = nil, err, now.Add(s.backoff)
+	return nil, err
+}
+
+// lookupCredentials runs the provider chain, returning credentials of the
+// first provider that has any
+func (s *S3) lookupCredentials() (*awsCredentials, error) {
+	for _, provider := range s.providers {
+		creds, err := provider(s.HTTPClient)
+		if err != nil {
+			return nil, err
+		}
+		if creds != nil {
+			return creds, nil
+		}
+	}
+	return nil, nil
+}
+
+// sign adds AWS Signature Version 4 headers to the request. Requests
+// are left anonymous when no credentials are given
+func (s *S3) sign(req *http.Request, creds *awsCredentials, now time.Time) {
+	if creds == nil || creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := strings.Join([]string{date, s.Region, "s3", "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders string
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func canonicalQuery(values url.Values) string {
+	var params []string
+	for k, vs := range values {
+		for _, v := range vs {
+			params = append(params, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode escapes everything but unreserved characters as required by
+// SigV4. Slashes are kept as is unless encodeSlash is set
+func uriEncode(str string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(str) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hexSHA256(str string) string {
+	sum := sha256.Sum256([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,74 @@
+package source
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+)
+
+// Shared is a config source used by both the reconcile loop and the web
+// API. Access to the underlying source is serialized, and the environment
+// it last loaded is kept for the API, so that API requests neither refresh
+// the source again nor race the reconcile loop
+type Shared struct {
+	src ConfigSource
+
+	mu          sync.Mutex
+	environment *bitesize.Environment
+	err         error
+}
+
+// NewShared wraps src to be shared between the reconcile loop and the API
+func NewShared(src ConfigSource) *Shared {
+	return &Shared{src: src}
+}
+
+// Refresh brings the underlying source up to date
+func (s *Shared) Refresh() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Refresh()
+}
+
+// Load loads environment from the underlying source, recording its
+// revision, and keeps it for Environment
+func (s *Shared) Load() (*bitesize.Environment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.src.Load()
+	if err == nil {
+		if revision, e2 := s.src.CurrentRevision(); e2 == nil {
+			e.Revision = revision
+		}
+	}
+	s.environment, s.err = e, err
+	return e, err
+}
+
+// CurrentRevision returns revision of the underlying source
+func (s *Shared) CurrentRevision() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.CurrentRevision()
+}
+
+// Changes returns changes of the underlying source, or nil if it can't
+// signal them
+func (s *Shared) Changes() <-chan struct{} {
+	if w, ok := s.src.(Watcher); ok {
+		return w.Changes()
+	}
+	return nil
+}
+
+// Environment returns environment last loaded, or the error loading it
+func (s *Shared) Environment() (*bitesize.Environment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.environment == nil && s.err == nil {
+		return nil, errors.New("configuration has not been loaded yet")
+	}
+	return s.environment, s.err
+}
@@ -0,0 +1,51 @@
+package source
+
+import (
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+)
+
+type countingSource struct {
+	refreshes int
+	loads     int
+}
+
+func (s *countingSource) Refresh() error { s.refreshes++; return nil }
+
+func (s *countingSource) Load() (*bitesize.Environment, error) {
+	s.loads++
+	return &bitesize.Environment{Name: "dev"}, nil
+}
+
+func (s *countingSource) CurrentRevision() (string, error) { return "abc", nil }
+
+func TestSharedEnvironment(t *testing.T) {
+	src := &countingSource{}
+	shared := NewShared(src)
+
+	if _, err := shared.Environment(); err == nil {
+		t.Error("Expected error before environment is loaded")
+	}
+
+	shared.Refresh()
+	if _, err := shared.Load(); err != nil {
+		t.Fatalf("Unexpected error loading environment: %s", err.Error())
+	}
+
+	for i := 0; i < 2; i++ {
+		e, err := shared.Environment()
+		if err != nil {
+			t.Fatalf("Unexpected error getting environment: %s", err.Error())
+		}
+		if e.Name != "dev" || e.Revision != "abc" {
+			t.Errorf("Expected environment dev at revision abc, got %s at %s", e.Name, e.Revision)
+		}
+	}
+	if src.refreshes != 1 || src.loads != 1 {
+		t.Errorf("Expected source to be refreshed and loaded once, got %d refreshes and %d loads", src.refreshes, src.loads)
+	}
+	if shared.Changes() != nil {
+		t.Error("Expected no changes channel for source that can't watch")
+	}
+}
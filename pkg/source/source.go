@@ -0,0 +1,66 @@
+package source
+
+import (
+	"fmt"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/git"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+)
+
+// Supported config source types
+const (
+	TypeGit       = "git"
+	TypeConfigMap = "configmap"
+	TypeS3        = "s3"
+)
+
+// ConfigSource represents a place environment configuration is loaded from
+type ConfigSource interface {
+	// Refresh brings local copy of the configuration up to date
+	Refresh() error
+	// Load returns environment from the current configuration
+	Load() (*bitesize.Environment, error)
+	// CurrentRevision identifies configuration version last refreshed
+	CurrentRevision() (string, error)
+}
+
+// Watcher is implemented by sources able to signal configuration changes
+// without waiting for the next polling interval
+type Watcher interface {
+	Changes() <-chan struct{}
+}
+
+// FromConfig returns config source selected by CONFIG_SOURCE. When not set,
+// configmap source is used if BITESIZE_CONFIGMAP is present, git otherwise
+func FromConfig(c config.Config) (ConfigSource, error) {
+	sourceType := c.ConfigSource
+	if sourceType == "" {
+		sourceType = TypeGit
+		if c.EnvConfigMap != "" {
+			sourceType = TypeConfigMap
+		}
+	}
+
+	switch sourceType {
+	case TypeGit:
+		return NewGit(git.Client(), c), nil
+	case TypeConfigMap:
+		if c.EnvConfigMap == "" {
+			return nil, fmt.Errorf("BITESIZE_CONFIGMAP is required for %s config source", TypeConfigMap)
+		}
+		client, err := k8s.ClientForNamespace(c.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		return NewConfigMap(client, c), nil
+	case TypeS3:
+		if c.S3Bucket == "" {
+			return nil, fmt.Errorf("S3_BUCKET is required for %s config source", TypeS3)
+		}
+		return NewS3(c), nil
+	default:
+		return nil, fmt.Errorf("unknown config source %s", sourceType)
+	}
+}
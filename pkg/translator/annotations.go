@@ -0,0 +1,36 @@
+package translator
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+// annotationSource is a set of annotations contributed to an object, named
+// so that conflicts between sources can be reported
+type annotationSource struct {
+	name   string
+	values map[string]string
+}
+
+// mergeAnnotations merges annotations of sources into a new map. Sources
+// are given in increasing order of precedence, so annotations the operator
+// relies on are passed after the ones configured by users. When sources set
+// the same annotation to different values, the later one wins and the
+// conflict is logged. Returns nil if no source sets any annotation
+func mergeAnnotations(object string, sources ...annotationSource) map[string]string {
+	var retval map[string]string
+	setBy := map[string]string{}
+
+	for _, src := range sources {
+		for k, v := range src.values {
+			if retval == nil {
+				retval = map[string]string{}
+			}
+			if current, ok := retval[k]; ok && current != v {
+				log.Warnf("%s: annotation %s set by %s is overridden by %s", object, k, setBy[k], src.name)
+			}
+			retval[k] = v
+			setBy[k] = src.name
+		}
+	}
+	return retval
+}
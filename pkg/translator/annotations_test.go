@@ -0,0 +1,31 @@
+package translator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeAnnotations(t *testing.T) {
+	merged := mergeAnnotations("service api",
+		annotationSource{name: "service_annotations", values: map[string]string{"team": "platform", "deployment_method": "manual"}},
+		annotationSource{name: "environment-operator", values: map[string]string{"deployment_method": "rolling-upgrade"}},
+	)
+	expected := map[string]string{"team": "platform", "deployment_method": "rolling-upgrade"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Unexpected annotations. Expected %v, got %v", expected, merged)
+	}
+
+	if merged := mergeAnnotations("pod template api", annotationSource{name: "annotations"}); merged != nil {
+		t.Errorf("Expected no annotations, got %v", merged)
+	}
+}
+
+func TestServiceAnnotationsDoNotOverrideGenerated(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.ServiceAnnotations = map[string]string{"deployment_method": "bluegreen"}
+
+	svc, _ := w.Service()
+	if svc.Annotations["deployment_method"] != w.BiteService.DeploymentMethod() {
+		t.Errorf("Expected deployment_method %s, got %s", w.BiteService.DeploymentMethod(), svc.Annotations["deployment_method"])
+	}
+}
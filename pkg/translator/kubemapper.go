@@ -3,10 +3,14 @@ package translator
 // translator package converts objects between Kubernetes and Bitesize
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
@@ -20,15 +24,26 @@ import (
 	netwk_v1beta1 "k8s.io/api/networking/v1beta1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// deploymentTypeMeta is the kind and group/version services are deployed
+// as. HPAs scale deployments of the same group/version
+var deploymentTypeMeta = metav1.TypeMeta{
+	Kind:       "Deployment",
+	APIVersion: apps_v1.SchemeGroupVersion.String(),
+}
+
 // KubeMapper maps BitesizeService object to Kubernetes objects
 type KubeMapper struct {
 	BiteService *bitesize.Service
 	Gists       *bitesize.Gists
 	Namespace   string
-	Config      struct {
+	// Client checks that secrets referenced by env vars exist. Checks are
+	// skipped when nil, e.g. when rendering manifests offline
+	Client *k8s.Client
+	Config struct {
 		Project        string
 		DockerRegistry string
 	}
@@ -36,23 +51,25 @@ type KubeMapper struct {
 
 // Service extracts Kubernetes object from Bitesize definition
 func (w *KubeMapper) Service() (*v1.Service, error) {
+	if w.BiteService.IsExternalName() {
+		retval := w.externalNameService()
+		if err := w.applyPatches(bitesize.PatchTargetService, retval); err != nil {
+			return nil, err
+		}
+		return retval, nil
+	}
+
 	targetServiceName := w.BiteService.Name
 	if w.BiteService.IsBlueGreenParentDeployment() {
 		targetServiceName = w.BiteService.ActiveDeploymentName()
 	}
 	var ports []v1.ServicePort
 	for _, p := range w.BiteService.Ports {
-		servicePort := v1.ServicePort{
+		ports = append(ports, v1.ServicePort{
 			Port:       int32(p),
-			TargetPort: intstr.FromInt(p),
-			Name:       fmt.Sprintf("http-%d", p),
-		}
-
-		if strings.EqualFold(w.BiteService.Protocol, "tcp") {
-			servicePort.Name = fmt.Sprintf("tcp-port-%d", p)
-		}
-
-		ports = append(ports, servicePort)
+			TargetPort: intstr.FromInt(w.BiteService.TargetPort(p)),
+			Name:       w.servicePortName(p),
+		})
 	}
 	retval := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -67,11 +84,41 @@ func (w *KubeMapper) Service() (*v1.Service, error) {
 				"creator": "pipeline",
 				"name":    targetServiceName,
 			},
+			ClusterIP:                w.BiteService.ClusterIP,
+			PublishNotReadyAddresses: w.BiteService.PublishNotReadyAddresses,
 		},
 	}
+
+	if w.BiteService.Headless {
+		retval.Spec.ClusterIP = v1.ClusterIPNone
+	}
+
+	if w.BiteService.ServiceType != "" {
+		retval.Spec.Type = v1.ServiceType(w.BiteService.ServiceType)
+		retval.Spec.LoadBalancerSourceRanges = w.BiteService.LoadBalancerSourceRanges
+	}
+
+	if err := w.applyPatches(bitesize.PatchTargetService, retval); err != nil {
+		return nil, err
+	}
 	return retval, nil
 }
 
+func (w *KubeMapper) externalNameService() *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        w.BiteService.Name,
+			Namespace:   w.Namespace,
+			Labels:      w.labels(),
+			Annotations: w.annotations(),
+		},
+		Spec: v1.ServiceSpec{
+			Type:         v1.ServiceTypeExternalName,
+			ExternalName: w.BiteService.ExternalName,
+		},
+	}
+}
+
 // HeadlessService extracts Kubernetes Headless Service object (No ClusterIP) from Bitesize definition
 func (w *KubeMapper) HeadlessService() (*v1.Service, error) {
 	targetServiceName := w.BiteService.Name
@@ -82,17 +129,11 @@ func (w *KubeMapper) HeadlessService() (*v1.Service, error) {
 	var ports []v1.ServicePort
 	//Need to update this to have an option to create the headless service (no loadbalancing with Cluster IP not getting set)
 	for _, p := range w.BiteService.Ports {
-		servicePort := v1.ServicePort{
+		ports = append(ports, v1.ServicePort{
 			Port:       int32(p),
-			TargetPort: intstr.FromInt(p),
-			Name:       fmt.Sprintf("http-%d", p),
-		}
-
-		if strings.EqualFold(w.BiteService.Protocol, "tcp") {
-			servicePort.Name = fmt.Sprintf("tcp-port-%d", p)
-		}
-
-		ports = append(ports, servicePort)
+			TargetPort: intstr.FromInt(w.BiteService.TargetPort(p)),
+			Name:       w.servicePortName(p),
+		})
 	}
 	retval := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -111,7 +152,8 @@ func (w *KubeMapper) HeadlessService() (*v1.Service, error) {
 				"creator": "pipeline",
 				"name":    targetServiceName,
 			},
-			ClusterIP: v1.ClusterIPNone,
+			ClusterIP:                v1.ClusterIPNone,
+			PublishNotReadyAddresses: w.BiteService.PublishNotReadyAddresses,
 		},
 	}
 	return retval, nil
@@ -129,6 +171,18 @@ func (w *KubeMapper) ConfigMaps() ([]v1.ConfigMap, error) {
 				retval = append(retval, c.ConfigMap)
 			}
 		}
+		for _, s := range vol.Sources {
+			if c := w.Gists.FindByName(s.ConfigMap, bitesize.TypeConfigMap); s.ConfigMap != "" && c != nil {
+				retval = append(retval, c.ConfigMap)
+			}
+		}
+	}
+
+	if f := w.BiteService.SecretFetch; f != nil && f.ConfigMap != "" {
+		c := w.Gists.FindByName(f.ConfigMap, bitesize.TypeConfigMap)
+		if c != nil {
+			retval = append(retval, c.ConfigMap)
+		}
 	}
 
 	if w.BiteService.InitContainers != nil {
@@ -152,48 +206,67 @@ func (w *KubeMapper) PersistentVolumeClaims() ([]v1.PersistentVolumeClaim, error
 	var retval []v1.PersistentVolumeClaim
 
 	for _, vol := range w.BiteService.Volumes {
-		//Create a PVC only if the volume is not coming from a secret or ConfigMap
-		if vol.IsSecretVolume() || vol.IsConfigMapVolume() {
+		//Create a PVC only if the volume is not coming from a secret, ConfigMap, node or projection
+		if !vol.IsPersistentVolume() {
 			continue
 		}
 
-		ret := v1.PersistentVolumeClaim{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      vol.Name,
-				Namespace: w.Namespace,
-				Labels: map[string]string{
-					"creator":    "pipeline",
-					"deployment": w.BiteService.Name,
-					"mount_path": strings.Replace(vol.Path, "/", "2F", -1),
-					"size":       vol.Size,
-					"type":       strings.ToLower(vol.Type),
+		retval = append(retval, w.volumeClaim(vol))
+	}
+	return retval, nil
+}
+
+// volumeClaim builds PVC for the volume, carrying volume's labels,
+// annotations and storage class
+func (w *KubeMapper) volumeClaim(vol bitesize.Volume) v1.PersistentVolumeClaim {
+	labels := map[string]string{}
+	for k, v := range vol.Labels {
+		labels[k] = v
+	}
+	labels["creator"] = "pipeline"
+	labels["deployment"] = w.BiteService.Name
+	labels["mount_path"] = strings.Replace(vol.Path, "/", "2F", -1)
+	labels["size"] = vol.Size
+	labels["type"] = strings.ToLower(vol.Type)
+
+	generated := map[string]string{}
+	if !vol.HasManualProvisioning() && vol.StorageClass == "" {
+		generated["volume.beta.kubernetes.io/storage-class"] = "aws-" + strings.ToLower(vol.Type)
+	}
+	annotations := mergeAnnotations("pvc "+vol.Name,
+		annotationSource{name: "volume annotations", values: vol.Annotations},
+		annotationSource{name: "environment-operator", values: generated},
+	)
+
+	ret := v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        vol.Name,
+			Namespace:   w.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: getAccessModesFromString(vol.Modes),
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse(vol.Size),
 				},
 			},
-			Spec: v1.PersistentVolumeClaimSpec{
-				AccessModes: getAccessModesFromString(vol.Modes),
-				Resources: v1.ResourceRequirements{
-					Requests: v1.ResourceList{
-						v1.ResourceName(v1.ResourceStorage): resource.MustParse(vol.Size),
-					},
-				},
+		},
+	}
+	if vol.StorageClass != "" {
+		storageClass := vol.StorageClass
+		ret.Spec.StorageClassName = &storageClass
+	}
+	if vol.HasManualProvisioning() {
+		ret.Spec.VolumeName = vol.Name
+		ret.Spec.Selector = &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				"name": vol.Name,
 			},
 		}
-		if vol.HasManualProvisioning() {
-			ret.Spec.VolumeName = vol.Name
-			ret.Spec.Selector = &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"name": vol.Name,
-				},
-			}
-		} else {
-			ret.ObjectMeta.Annotations = map[string]string{
-				"volume.beta.kubernetes.io/storage-class": "aws-" + strings.ToLower(vol.Type),
-			}
-		}
-
-		retval = append(retval, ret)
 	}
-	return retval, nil
+	return ret
 }
 
 // Deployment extracts Kubernetes object from BiteSize definition
@@ -203,8 +276,10 @@ func (w *KubeMapper) Deployment() (*apps_v1.Deployment, error) {
 	}
 	replicas := int32(w.BiteService.Replicas)
 	container, err := w.container()
-	initContainers, _ := w.initContainers()
-
+	if err != nil {
+		return nil, err
+	}
+	initContainers, err := w.initContainers()
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +293,9 @@ func (w *KubeMapper) Deployment() (*apps_v1.Deployment, error) {
 		return nil, err
 	}
 
+	revisionHistory := w.BiteService.RevisionHistory()
 	retval := &apps_v1.Deployment{
+		TypeMeta: deploymentTypeMeta,
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      w.BiteService.Name,
 			Namespace: w.Namespace,
@@ -229,9 +306,12 @@ func (w *KubeMapper) Deployment() (*apps_v1.Deployment, error) {
 				"version":     w.BiteService.Version,
 				"app":         w.BiteService.Application,
 			},
+			Annotations: w.deploymentAnnotations(),
 		},
 		Spec: apps_v1.DeploymentSpec{
-			Replicas: &replicas,
+			Replicas:             &replicas,
+			MinReadySeconds:      w.BiteService.MinReadySeconds,
+			RevisionHistoryLimit: &revisionHistory,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"creator": "pipeline",
@@ -249,24 +329,61 @@ func (w *KubeMapper) Deployment() (*apps_v1.Deployment, error) {
 						"version":     w.BiteService.Version,
 						"app":         w.BiteService.Application,
 					},
-					Annotations: w.BiteService.Annotations,
+					Annotations: w.podAnnotations(),
 				},
 				Spec: v1.PodSpec{
-					NodeSelector:     map[string]string{"role": "minion"},
+					NodeSelector:     w.nodeSelector(),
 					Containers:       []v1.Container{*container},
 					ImagePullSecrets: imagePullSecrets,
 					Volumes:          volumes,
 					InitContainers:   initContainers,
+					SchedulerName:    w.BiteService.SchedulerName,
+					NodeName:         w.BiteService.NodeName,
+					HostNetwork:      w.BiteService.HostNetwork,
+					HostPID:          w.BiteService.HostPID,
+					HostIPC:          w.BiteService.HostIPC,
 				},
 			},
 		},
 	}
 
+	// replicas of autoscaled services are owned by the HPA, which scales
+	// new deployments up to min_replicas
+	if w.BiteService.HPA.MinReplicas != 0 {
+		retval.Spec.Replicas = nil
+	}
+
+	// pods on host network resolve through node's DNS otherwise
+	if w.BiteService.HostNetwork {
+		retval.Spec.Template.Spec.DNSPolicy = v1.DNSClusterFirstWithHostNet
+	}
+
+	if w.BiteService.RuntimeClass != "" {
+		runtimeClass := w.BiteService.RuntimeClass
+		retval.Spec.Template.Spec.RuntimeClassName = &runtimeClass
+	}
+
+	retval.Spec.Template.Spec.Affinity = w.affinity(retval.Spec.Template.Labels)
+	retval.Spec.Template.Spec.Tolerations = convertTolerations(w.BiteService.Tolerations)
+
+	for _, gate := range w.BiteService.ReadinessGates {
+		retval.Spec.Template.Spec.ReadinessGates = append(retval.Spec.Template.Spec.ReadinessGates,
+			v1.PodReadinessGate{ConditionType: v1.PodConditionType(gate)})
+	}
+
+	if err := w.applyPatches(bitesize.PatchTargetDeployment, retval); err != nil {
+		return nil, err
+	}
 	return retval, nil
 }
 func (w *KubeMapper) imagePullSecrets() ([]v1.LocalObjectReference, error) {
 	var retval []v1.LocalObjectReference
 
+	// image_pull_secrets: none clears DOCKER_PULL_SECRETS for public images
+	if w.BiteService.ImagePullSecrets == "none" {
+		return nil, nil
+	}
+
 	pullSecrets := util.RegistrySecrets()
 
 	if pullSecrets != "" {
@@ -283,6 +400,201 @@ func (w *KubeMapper) imagePullSecrets() ([]v1.LocalObjectReference, error) {
 	return retval, nil
 }
 
+// servicePortName returns name of the kubernetes Service port
+func (w *KubeMapper) servicePortName(port int) string {
+	if strings.EqualFold(w.BiteService.Protocol, "tcp") {
+		return fmt.Sprintf("tcp-port-%d", port)
+	}
+	return fmt.Sprintf("http-%d", port)
+}
+
+// ServiceMonitor returns Prometheus Operator ServiceMonitor scraping the
+// service, or nil if service_monitor is not set
+func (w *KubeMapper) ServiceMonitor() (*unstructured.Unstructured, error) {
+	monitor := w.BiteService.ServiceMonitor
+	if monitor == nil {
+		return nil, nil
+	}
+
+	endpoint := map[string]interface{}{
+		"port": w.servicePortName(monitor.Port),
+		"path": monitor.Path,
+	}
+	if monitor.Interval != "" {
+		endpoint["interval"] = monitor.Interval
+	}
+
+	retval := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{
+				"matchLabels": map[string]interface{}{
+					"creator": "pipeline",
+					"name":    w.BiteService.Name,
+				},
+			},
+			"endpoints": []interface{}{endpoint},
+		},
+	}}
+	retval.SetAPIVersion(k8s.ServiceMonitorAPIVersion)
+	retval.SetKind("ServiceMonitor")
+	retval.SetName(w.BiteService.Name)
+	retval.SetNamespace(w.Namespace)
+	retval.SetLabels(map[string]string{
+		"creator": "pipeline",
+		"name":    w.BiteService.Name,
+	})
+	return retval, nil
+}
+
+// nodeSelector returns the service node_selector, falling back to the
+// operator-wide DEFAULT_NODE_SELECTOR
+func (w *KubeMapper) nodeSelector() map[string]string {
+	if len(w.BiteService.NodeSelector) > 0 {
+		return w.BiteService.NodeSelector
+	}
+	if len(config.Env.DefaultNodeSelector) == 0 {
+		return nil
+	}
+	selector := make(map[string]string, len(config.Env.DefaultNodeSelector))
+	for k, v := range config.Env.DefaultNodeSelector {
+		selector[k] = v
+	}
+	return selector
+}
+
+// affinity returns the service affinity with anti-affinity spreading pods
+// sharing the anti_affinity label (name or application) of podLabels across
+// nodes. Keyed on application, blue and green deployments of a service are
+// spread too. Pods still share nodes when there are not enough of them
+func (w *KubeMapper) affinity(podLabels map[string]string) *v1.Affinity {
+	retval := convertAffinity(w.BiteService.Affinity)
+	label := w.BiteService.AntiAffinity
+	if label == "" {
+		return retval
+	}
+	if retval == nil {
+		retval = &v1.Affinity{}
+	}
+	retval.PodAntiAffinity = &v1.PodAntiAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
+			{
+				Weight: 100,
+				PodAffinityTerm: v1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{label: podLabels[label]},
+					},
+					TopologyKey: v1.LabelHostname,
+				},
+			},
+		},
+	}
+	return retval
+}
+
+func convertAffinity(affinity *bitesize.Affinity) *v1.Affinity {
+	if affinity == nil {
+		return nil
+	}
+	retval := &v1.Affinity{}
+	if a := affinity.NodeAffinity; a != nil {
+		retval.NodeAffinity = &v1.NodeAffinity{}
+		if len(a.RequiredDuringSchedulingIgnoredDuringExecution) > 0 {
+			selector := &v1.NodeSelector{}
+			for _, term := range a.RequiredDuringSchedulingIgnoredDuringExecution {
+				selector.NodeSelectorTerms = append(selector.NodeSelectorTerms, convertNodeSelectorTerm(term))
+			}
+			retval.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = selector
+		}
+		for _, term := range a.PreferredDuringSchedulingIgnoredDuringExecution {
+			retval.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+				retval.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+				v1.PreferredSchedulingTerm{Weight: term.Weight, Preference: convertNodeSelectorTerm(term.Preference)},
+			)
+		}
+	}
+	if a := affinity.PodAffinity; a != nil {
+		required, preferred := convertPodAffinityTerms(*a)
+		retval.PodAffinity = &v1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution:  required,
+			PreferredDuringSchedulingIgnoredDuringExecution: preferred,
+		}
+	}
+	if a := affinity.PodAntiAffinity; a != nil {
+		required, preferred := convertPodAffinityTerms(bitesize.PodAffinity(*a))
+		retval.PodAntiAffinity = &v1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution:  required,
+			PreferredDuringSchedulingIgnoredDuringExecution: preferred,
+		}
+	}
+	return retval
+}
+
+func convertNodeSelectorTerm(term bitesize.NodeSelectorTerm) v1.NodeSelectorTerm {
+	var retval v1.NodeSelectorTerm
+	for _, r := range term.MatchExpressions {
+		retval.MatchExpressions = append(retval.MatchExpressions, v1.NodeSelectorRequirement{
+			Key:      r.Key,
+			Operator: v1.NodeSelectorOperator(r.Operator),
+			Values:   r.Values,
+		})
+	}
+	for _, r := range term.MatchFields {
+		retval.MatchFields = append(retval.MatchFields, v1.NodeSelectorRequirement{
+			Key:      r.Key,
+			Operator: v1.NodeSelectorOperator(r.Operator),
+			Values:   r.Values,
+		})
+	}
+	return retval
+}
+
+func convertPodAffinityTerms(affinity bitesize.PodAffinity) ([]v1.PodAffinityTerm, []v1.WeightedPodAffinityTerm) {
+	var required []v1.PodAffinityTerm
+	var preferred []v1.WeightedPodAffinityTerm
+	for _, term := range affinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		required = append(required, convertPodAffinityTerm(term))
+	}
+	for _, term := range affinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		preferred = append(preferred, v1.WeightedPodAffinityTerm{
+			Weight:          term.Weight,
+			PodAffinityTerm: convertPodAffinityTerm(term.PodAffinityTerm),
+		})
+	}
+	return required, preferred
+}
+
+func convertPodAffinityTerm(term bitesize.PodAffinityTerm) v1.PodAffinityTerm {
+	retval := v1.PodAffinityTerm{
+		Namespaces:  term.Namespaces,
+		TopologyKey: term.TopologyKey,
+	}
+	if s := term.LabelSelector; s != nil {
+		retval.LabelSelector = &metav1.LabelSelector{MatchLabels: s.MatchLabels}
+		for _, r := range s.MatchExpressions {
+			retval.LabelSelector.MatchExpressions = append(retval.LabelSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+				Key:      r.Key,
+				Operator: metav1.LabelSelectorOperator(r.Operator),
+				Values:   r.Values,
+			})
+		}
+	}
+	return retval
+}
+
+func convertTolerations(tolerations []bitesize.Toleration) []v1.Toleration {
+	var retval []v1.Toleration
+	for _, t := range tolerations {
+		retval = append(retval, v1.Toleration{
+			Key:               t.Key,
+			Operator:          v1.TolerationOperator(t.Operator),
+			Value:             t.Value,
+			Effect:            v1.TaintEffect(t.Effect),
+			TolerationSeconds: t.TolerationSeconds,
+		})
+	}
+	return retval
+}
+
 // HPA extracts Kubernetes object from Bitesize definition
 func (w *KubeMapper) HPA() (*autoscale_v2beta2.HorizontalPodAutoscaler, error) {
 	if w.BiteService.IsBlueGreenParentDeployment() {
@@ -292,13 +604,13 @@ func (w *KubeMapper) HPA() (*autoscale_v2beta2.HorizontalPodAutoscaler, error) {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      w.BiteService.Name,
 			Namespace: w.Namespace,
-			Labels:    w.labels(),
+			Labels:    w.hpaLabels(),
 		},
 		Spec: autoscale_v2beta2.HorizontalPodAutoscalerSpec{
 			ScaleTargetRef: autoscale_v2beta2.CrossVersionObjectReference{
-				Kind:       "Deployment",
+				Kind:       deploymentTypeMeta.Kind,
 				Name:       w.BiteService.Name,
-				APIVersion: "apps/v1",
+				APIVersion: deploymentTypeMeta.APIVersion,
 			},
 			MinReplicas: &w.BiteService.HPA.MinReplicas,
 			MaxReplicas: w.BiteService.HPA.MaxReplicas,
@@ -309,6 +621,19 @@ func (w *KubeMapper) HPA() (*autoscale_v2beta2.HorizontalPodAutoscaler, error) {
 	return retval, nil
 }
 
+// hpaLabels returns service labels, without version, and hpa specific
+// labels. Version is left out so a new release doesn't update the hpa
+func (w *KubeMapper) hpaLabels() map[string]string {
+	labels := w.labels()
+	delete(labels, "version")
+	for k, v := range w.BiteService.HPA.Labels {
+		if _, ok := labels[k]; !ok {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
 func (w *KubeMapper) getMetricSpec() (m []autoscale_v2beta2.MetricSpec) {
 	if w.BiteService.HPA.Metric.Name == "cpu" || w.BiteService.HPA.Metric.Name == "memory" {
 		if w.BiteService.HPA.Metric.Name == "cpu" && w.BiteService.HPA.Metric.TargetAverageUtilization != 0 {
@@ -361,8 +686,34 @@ func (w *KubeMapper) initContainers() ([]v1.Container, error) {
 	var retval []v1.Container
 	// TODO: Need to add volume, env and other configs support here
 
+	// secrets are fetched first, so other init containers can use them too
+	if f := w.BiteService.SecretFetch; f != nil {
+		evars, err := w.initEnvVars(bitesize.Container{EnvVars: f.EnvVars})
+		if err != nil {
+			return nil, err
+		}
+
+		con := v1.Container{
+			Name:    bitesize.SecretFetchName,
+			Image:   f.Image,
+			Env:     evars,
+			Command: f.Command,
+			VolumeMounts: []v1.VolumeMount{
+				{Name: bitesize.SecretFetchName, MountPath: f.Path},
+			},
+		}
+		if f.ConfigMap != "" {
+			con.VolumeMounts = append(con.VolumeMounts, v1.VolumeMount{
+				Name:      bitesize.SecretFetchConfigName,
+				MountPath: bitesize.SecretFetchConfigPath,
+				ReadOnly:  true,
+			})
+		}
+		retval = append(retval, con)
+	}
+
 	if w.BiteService.InitContainers == nil {
-		return nil, nil
+		return retval, nil
 	}
 
 	for _, container := range *w.BiteService.InitContainers {
@@ -426,22 +777,24 @@ func (w *KubeMapper) container() (*v1.Container, error) {
 	var ports []v1.ContainerPort
 	for _, port := range w.BiteService.Ports {
 		containerPort := v1.ContainerPort{
-			ContainerPort: int32(port),
+			ContainerPort: int32(w.BiteService.TargetPort(port)),
 			Protocol:      "TCP",
 		}
 		ports = append(ports, containerPort)
 	}
 
 	retval = &v1.Container{
-		Name:           w.BiteService.Name,
-		Image:          "",
-		Env:            evars,
-		VolumeMounts:   mounts,
-		Resources:      resources,
-		Command:        w.BiteService.Commands,
-		LivenessProbe:  liveness,
-		ReadinessProbe: readiness,
-		Ports:          ports,
+		Name:            w.BiteService.Name,
+		Image:           "",
+		Env:             evars,
+		EnvFrom:         w.envFrom(),
+		VolumeMounts:    mounts,
+		Resources:       resources,
+		Command:         w.BiteService.Commands,
+		ImagePullPolicy: v1.PullPolicy(w.BiteService.ImagePullPolicy),
+		LivenessProbe:   liveness,
+		ReadinessProbe:  readiness,
+		Ports:           ports,
 	}
 
 	return retval, nil
@@ -500,6 +853,20 @@ func convertProbeType(probe *bitesize.Probe) *v1.Probe {
 				TCPSocket: socket,
 			}
 		}
+
+		// gRPC probes (GRPCAction) are only available from Kubernetes 1.24
+		// API, which the operator is not built with. Services are warned
+		// about when their config is loaded
+		if probe.GRPC != nil {
+			log.Debugf("gRPC probes are not supported, checking port %d with a TCP probe instead", probe.GRPC.Port)
+
+			socket := &v1.TCPSocketAction{}
+			socket.Port.IntVal = probe.GRPC.Port
+
+			retval.Handler = v1.Handler{
+				TCPSocket: socket,
+			}
+		}
 	}
 
 	return retval
@@ -522,28 +889,18 @@ func (w *KubeMapper) readinessProbe() (*v1.Probe, error) {
 func (w *KubeMapper) initEnvVars(container bitesize.Container) ([]v1.EnvVar, error) {
 	var retval []v1.EnvVar
 	var err error
-	//Create in cluster rest client to be utilized for secrets processing
-	client, _ := k8s.ClientForNamespace(config.Env.Namespace)
 
 	for _, e := range container.EnvVars {
 		var evar v1.EnvVar
 		switch {
 		case e.Secret != "":
-			kv := strings.Split(e.Value, "/")
-			secretName := ""
-			secretDataKey := ""
-
-			if len(kv) == 2 {
-				secretName = kv[0]
-				secretDataKey = kv[1]
-			} else {
-				secretName = kv[0]
-				secretDataKey = secretName
-			}
+			secretName, secretDataKey := e.SecretRef()
 
-			if !client.Secret().Exists(secretName) {
+			if w.Client != nil && !w.Client.Secret().Exists(secretName) {
 				log.Debugf("Unable to find Secret %s", secretName)
-				err = fmt.Errorf("Unable to find secret [%s] in namespace [%s] when processing envvars for init containers [%s]", secretName, config.Env.Namespace, w.BiteService.Name)
+				if e := k8s.MissingReference(fmt.Errorf("Unable to find secret [%s] in namespace [%s] when processing envvars for init containers [%s]", secretName, w.Namespace, w.BiteService.Name)); e != nil {
+					err = e
+				}
 			}
 
 			evar = v1.EnvVar{
@@ -588,28 +945,21 @@ func (w *KubeMapper) initEnvVars(container bitesize.Container) ([]v1.EnvVar, err
 func (w *KubeMapper) envVars() ([]v1.EnvVar, error) {
 	var retval []v1.EnvVar
 	var err error
-	//Create in cluster rest client to be utilized for secrets processing
-	client, _ := k8s.ClientForNamespace(config.Env.Namespace)
 
 	for _, e := range w.BiteService.EnvVars {
 		var evar v1.EnvVar
 		switch {
 		case e.Secret != "":
-			kv := strings.Split(e.Value, "/")
-			secretName := ""
-			secretDataKey := ""
-
-			if len(kv) == 2 {
-				secretName = kv[0]
-				secretDataKey = kv[1]
-			} else {
-				secretName = kv[0]
-				secretDataKey = secretName
+			secretName, secretDataKey := e.SecretRef()
+			if e.ExternalKey != "" {
+				secretName = bitesize.EnvExternalSecretName(w.BiteService.Name, secretName)
 			}
 
-			if !client.Secret().Exists(secretName) {
+			if w.Client != nil && !w.Client.Secret().Exists(secretName) {
 				log.Debugf("Unable to find Secret %s", secretName)
-				err = fmt.Errorf("unable to find secret [%s] in namespace [%s] when processing envvars for deployment [%s]", secretName, config.Env.Namespace, w.BiteService.Name)
+				if e := k8s.MissingReference(fmt.Errorf("unable to find secret [%s] in namespace [%s] when processing envvars for deployment [%s]", secretName, w.Namespace, w.BiteService.Name)); e != nil {
+					err = e
+				}
 			}
 
 			evar = v1.EnvVar{
@@ -651,6 +1001,24 @@ func (w *KubeMapper) envVars() ([]v1.EnvVar, error) {
 	return retval, err
 }
 
+func (w *KubeMapper) envFrom() []v1.EnvFromSource {
+	var retval []v1.EnvFromSource
+	for _, src := range w.BiteService.EnvFrom {
+		source := v1.EnvFromSource{Prefix: src.Prefix}
+		if src.ConfigMap != "" {
+			source.ConfigMapRef = &v1.ConfigMapEnvSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: src.ConfigMap},
+			}
+		} else {
+			source.SecretRef = &v1.SecretEnvSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: src.Secret},
+			}
+		}
+		retval = append(retval, source)
+	}
+	return retval
+}
+
 func (w *KubeMapper) initVolumeMounts(container bitesize.Container) ([]v1.VolumeMount, error) {
 	var retval []v1.VolumeMount
 
@@ -688,6 +1056,14 @@ func (w *KubeMapper) volumeMounts() ([]v1.VolumeMount, error) {
 		}
 		retval = append(retval, vol)
 	}
+
+	if f := w.BiteService.SecretFetch; f != nil {
+		retval = append(retval, v1.VolumeMount{
+			Name:      bitesize.SecretFetchName,
+			MountPath: f.Path,
+			ReadOnly:  true,
+		})
+	}
 	return retval, nil
 }
 
@@ -701,34 +1077,62 @@ func (w *KubeMapper) volumes() ([]v1.Volume, error) {
 		retval = append(retval, vol)
 	}
 
+	if f := w.BiteService.SecretFetch; f != nil {
+		retval = append(retval, v1.Volume{
+			Name: bitesize.SecretFetchName,
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory},
+			},
+		})
+		if f.ConfigMap != "" {
+			retval = append(retval, v1.Volume{
+				Name: bitesize.SecretFetchConfigName,
+				VolumeSource: v1.VolumeSource{
+					ConfigMap: &v1.ConfigMapVolumeSource{
+						LocalObjectReference: v1.LocalObjectReference{Name: f.ConfigMap},
+					},
+				},
+			})
+		}
+	}
+
 	return retval, nil
 }
 
 func (w *KubeMapper) volumeSource(vol bitesize.Volume) v1.VolumeSource {
 	if vol.IsSecretVolume() {
 		return v1.VolumeSource{
-			Secret: &v1.SecretVolumeSource{SecretName: vol.Name},
+			Secret: &v1.SecretVolumeSource{
+				SecretName:  vol.Name,
+				Items:       keyToPaths(vol.Items),
+				DefaultMode: vol.DefaultMode,
+			},
 		}
 	}
 
-	if vol.IsConfigMapVolume() {
-
-		var items []v1.KeyToPath
+	if vol.IsProjectedVolume() {
+		return v1.VolumeSource{
+			Projected: &v1.ProjectedVolumeSource{Sources: projections(vol.Sources)},
+		}
+	}
 
-		for _, v := range vol.Items {
-			items = append(items, v1.KeyToPath{
-				Key:  v.Key,
-				Path: v.Path,
-				Mode: v.Mode,
-			})
+	if vol.IsHostPathVolume() {
+		source := &v1.HostPathVolumeSource{Path: vol.HostPath}
+		if vol.HostPathType != "" {
+			hostPathType := v1.HostPathType(vol.HostPathType)
+			source.Type = &hostPathType
 		}
+		return v1.VolumeSource{HostPath: source}
+	}
 
+	if vol.IsConfigMapVolume() {
 		return v1.VolumeSource{
 			ConfigMap: &v1.ConfigMapVolumeSource{
 				LocalObjectReference: v1.LocalObjectReference{
 					Name: vol.Name,
 				},
-				Items: items,
+				Items:       keyToPaths(vol.Items),
+				DefaultMode: vol.DefaultMode,
 			},
 		}
 	}
@@ -739,6 +1143,54 @@ func (w *KubeMapper) volumeSource(vol bitesize.Volume) v1.VolumeSource {
 
 }
 
+// keyToPaths maps items of secret and configmap volumes, nil if none are set
+// so that all keys are mounted
+func keyToPaths(items []bitesize.KeyToPath) []v1.KeyToPath {
+	var retval []v1.KeyToPath
+	for _, it := range items {
+		retval = append(retval, v1.KeyToPath{Key: it.Key, Path: it.Path, Mode: it.Mode})
+	}
+	return retval
+}
+
+func projections(sources []bitesize.ProjectedSource) []v1.VolumeProjection {
+	var retval []v1.VolumeProjection
+	for _, s := range sources {
+		items := keyToPaths(s.Items)
+
+		var p v1.VolumeProjection
+		switch {
+		case s.Secret != "":
+			p.Secret = &v1.SecretProjection{
+				LocalObjectReference: v1.LocalObjectReference{Name: s.Secret},
+				Items:                items,
+			}
+		case s.ConfigMap != "":
+			p.ConfigMap = &v1.ConfigMapProjection{
+				LocalObjectReference: v1.LocalObjectReference{Name: s.ConfigMap},
+				Items:                items,
+			}
+		case len(s.DownwardAPI) != 0:
+			p.DownwardAPI = &v1.DownwardAPIProjection{}
+			for _, f := range s.DownwardAPI {
+				p.DownwardAPI.Items = append(p.DownwardAPI.Items, v1.DownwardAPIVolumeFile{
+					Path:     f.Path,
+					FieldRef: &v1.ObjectFieldSelector{FieldPath: f.FieldRef},
+				})
+			}
+		case s.ServiceAccountToken != nil:
+			expiration := s.ServiceAccountToken.ExpirationSeconds
+			p.ServiceAccountToken = &v1.ServiceAccountTokenProjection{
+				Path:              s.ServiceAccountToken.Path,
+				Audience:          s.ServiceAccountToken.Audience,
+				ExpirationSeconds: &expiration,
+			}
+		}
+		retval = append(retval, p)
+	}
+	return retval
+}
+
 // Ingress extracts Kubernetes object from BiteSize definition
 func (w *KubeMapper) Ingress() (*netwk_v1beta1.Ingress, error) {
 	labels := map[string]string{
@@ -759,12 +1211,27 @@ func (w *KubeMapper) Ingress() (*netwk_v1beta1.Ingress, error) {
 		labels["http2"] = w.BiteService.HTTP2
 	}
 
-	port := intstr.FromInt(w.BiteService.Ports[0])
+	if len(w.BiteService.WeightedBackends) != 0 {
+		labels["weighted"] = "true"
+	}
+
+	ingressPort, err := w.ingressPort()
+	if err != nil {
+		return nil, err
+	}
+
+	annotations, err := w.ingressAnnotations()
+	if err != nil {
+		return nil, err
+	}
+
+	port := intstr.FromInt(ingressPort)
 	retval := &netwk_v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      w.BiteService.Name,
-			Namespace: w.Namespace,
-			Labels:    labels,
+			Name:        w.BiteService.Name,
+			Namespace:   w.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Spec: netwk_v1beta1.IngressSpec{
 			Rules: []netwk_v1beta1.IngressRule{},
@@ -803,13 +1270,98 @@ func (w *KubeMapper) Ingress() (*netwk_v1beta1.Ingress, error) {
 		if w.BiteService.Backend != "" {
 			rule.IngressRuleValue.HTTP.Paths[0].Backend.ServiceName = w.BiteService.Backend
 		}
-		if w.BiteService.BackendPort != 0 {
-			rule.IngressRuleValue.HTTP.Paths[0].Backend.ServicePort = intstr.FromInt(w.BiteService.BackendPort)
+		// Primary of weighted backends gets the remaining traffic
+		if len(w.BiteService.WeightedBackends) != 0 {
+			rule.IngressRuleValue.HTTP.Paths[0].Backend.ServiceName = w.BiteService.WeightedBackends[0].Service
 		}
 		retval.Spec.Rules = append(retval.Spec.Rules, rule)
 
 	}
 
+	if w.BiteService.CertManager != nil {
+		retval.Spec.TLS = []netwk_v1beta1.IngressTLS{
+			{
+				Hosts:      w.BiteService.CertManagerHosts(),
+				SecretName: w.BiteService.CertManagerSecretName(),
+			},
+		}
+	}
+
+	return retval, nil
+}
+
+// ingressAnnotations returns ingress_annotations merged with the ones
+// requesting cert_manager certificates and backend_health_check
+func (w *KubeMapper) ingressAnnotations() (map[string]string, error) {
+	var certManager map[string]string
+	if cm := w.BiteService.CertManager; cm != nil {
+		annotation := k8s.CertManagerClusterIssuerAnnotation
+		if cm.IssuerKind == "Issuer" {
+			annotation = k8s.CertManagerIssuerAnnotation
+		}
+		certManager = map[string]string{annotation: cm.Issuer}
+	}
+
+	var healthCheck map[string]string
+	if hc := w.BiteService.BackendHealthCheck; hc != nil {
+		var interval time.Duration
+		if hc.Interval != "" {
+			interval, _ = time.ParseDuration(hc.Interval)
+		}
+		var err error
+		healthCheck, err = k8s.HealthCheckAnnotations(config.Env.IngressController, hc.Path, interval)
+		if err != nil {
+			return nil, fmt.Errorf("backend_health_check of service %s: %s", w.BiteService.Name, err.Error())
+		}
+		settings, _ := json.Marshal(hc)
+		healthCheck[k8s.BackendHealthCheckAnnotation] = string(settings)
+	}
+
+	return mergeAnnotations("ingress "+w.BiteService.Name,
+		annotationSource{name: "ingress_annotations", values: w.BiteService.IngressAnnotations},
+		annotationSource{name: "cert_manager", values: certManager},
+		annotationSource{name: "backend_health_check", values: healthCheck},
+	), nil
+}
+
+// ingressPort returns port external_url traffic is routed to: backend_port
+// override if set, first of service ports otherwise
+func (w *KubeMapper) ingressPort() (int, error) {
+	if w.BiteService.BackendPort != 0 {
+		return w.BiteService.BackendPort, nil
+	}
+	if len(w.BiteService.Ports) == 0 {
+		return 0, fmt.Errorf("service %s has external_url but no ports or backend_port", w.BiteService.Name)
+	}
+	return w.BiteService.Ports[0], nil
+}
+
+// CanaryIngress extracts ingress sending weighted share of external_url traffic
+// to the second of service's weighted_backends. Returns nil if service has no
+// weighted backends
+func (w *KubeMapper) CanaryIngress() (*netwk_v1beta1.Ingress, error) {
+	if len(w.BiteService.WeightedBackends) < 2 {
+		return nil, nil
+	}
+
+	retval, err := w.Ingress()
+	if err != nil {
+		return nil, err
+	}
+
+	backend := w.BiteService.WeightedBackends[1]
+	retval.ObjectMeta.Name = w.BiteService.CanaryIngressName()
+	// canary ingress doesn't request certificates, the primary ingress does
+	retval.ObjectMeta.Annotations = mergeAnnotations("ingress "+retval.Name,
+		annotationSource{name: "ingress_annotations", values: w.BiteService.IngressAnnotations},
+		annotationSource{name: "weighted_backends", values: map[string]string{
+			"nginx.ingress.kubernetes.io/canary":        "true",
+			"nginx.ingress.kubernetes.io/canary-weight": strconv.Itoa(backend.Weight),
+		}},
+	)
+	for _, rule := range retval.Spec.Rules {
+		rule.IngressRuleValue.HTTP.Paths[0].Backend.ServiceName = backend.Service
+	}
 	return retval, nil
 }
 
@@ -858,6 +1410,57 @@ func (w *KubeMapper) ExternalSecretTLS() (*ext.ExternalSecret, error) {
 	}, nil
 }
 
+// EnvExternalSecrets returns an ExternalSecret for each Kubernetes secret
+// env vars with external_key refer to, syncing the secret manager entries
+// into the keys the env vars read. ExternalSecrets, and the secrets they
+// create, are named per service with bitesize.EnvExternalSecretName
+func (w *KubeMapper) EnvExternalSecrets() []ext.ExternalSecret {
+	data := map[string][]map[string]string{}
+	for _, e := range w.BiteService.EnvVars {
+		if e.Secret == "" || e.ExternalKey == "" {
+			continue
+		}
+		name, key := e.SecretRef()
+		entry := map[string]string{"key": e.ExternalKey, "name": key}
+		if e.ExternalProperty != "" {
+			entry["property"] = e.ExternalProperty
+		}
+		data[name] = append(data[name], entry)
+	}
+
+	var names []string
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var retval []ext.ExternalSecret
+	for _, name := range names {
+		retval = append(retval, ext.ExternalSecret{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "kubernetes-client.io/v1",
+				Kind:       "ExternalSecret",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      bitesize.EnvExternalSecretName(w.BiteService.Name, name),
+				Namespace: w.Namespace,
+				Labels: map[string]string{
+					"creator":          "pipeline",
+					"application":      w.BiteService.Application,
+					"name":             w.BiteService.Name,
+					k8s.EnvSecretLabel: name,
+				},
+			},
+			SecretDescriptor: ext.ExternalSecretSecretDescriptor{
+				BackendType: config.Env.ExternalSecretsBackend,
+				Type:        "Opaque",
+				Data:        data[name],
+			},
+		})
+	}
+	return retval
+}
+
 // CustomResourceDefinition extracts Kubernetes object from BiteSize definition
 func (w *KubeMapper) CustomResourceDefinition() (*ext.PrsnExternalResource, error) {
 	ports := []*ext.Port{}
@@ -988,8 +1591,12 @@ func (w *KubeMapper) ServiceMeshGateway() (*ext.PrsnExternalResource, error) {
 
 // ServiceMeshVirtualService extracts Kubernetes object from BiteSize definition
 func (w *KubeMapper) ServiceMeshVirtualService() (*ext.PrsnExternalResource, error) {
+	port, err := w.ingressPort()
+	if err != nil {
+		return nil, err
+	}
+
 	hosts := []string{}
-	port := w.BiteService.Ports[0]
 
 	for _, url := range w.BiteService.ExternalURL {
 		hosts = append(hosts, url)
@@ -1100,13 +1707,45 @@ func (w *KubeMapper) resources() (v1.ResourceRequirements, error) {
 	}, nil
 }
 
+// annotations returns annotations of the kubernetes Service
 func (w *KubeMapper) annotations() map[string]string {
-	retval := map[string]string{}
-	retval["deployment_method"] = w.BiteService.DeploymentMethod()
+	generated := map[string]string{}
+	generated["deployment_method"] = w.BiteService.DeploymentMethod()
 	if w.BiteService.IsBlueGreenParentDeployment() {
-		retval["deployment_active"] = w.BiteService.ActiveDeploymentTag().String()
+		generated["deployment_active"] = w.BiteService.ActiveDeploymentTag().String()
 	}
-	return retval
+	if w.BiteService.TopologyAwareRouting {
+		generated[k8s.TopologyAwareHintsAnnotation] = "Auto"
+	}
+	// patches are recorded so that changes to them are detected
+	if len(w.BiteService.Patches) != 0 {
+		patches, _ := json.Marshal(w.BiteService.Patches)
+		generated[k8s.PatchesAnnotation] = string(patches)
+	}
+	if w.BiteService.ServiceMonitor != nil {
+		monitor, _ := json.Marshal(w.BiteService.ServiceMonitor)
+		generated[k8s.ServiceMonitorAnnotation] = string(monitor)
+	}
+	// settings the operator reads back from the service take precedence
+	return mergeAnnotations("service "+w.BiteService.Name,
+		annotationSource{name: "service_annotations", values: w.BiteService.ServiceAnnotations},
+		annotationSource{name: "environment-operator", values: generated},
+	)
+}
+
+// deploymentAnnotations returns annotations of the Deployment object itself,
+// kept separate from the pod template annotations
+func (w *KubeMapper) deploymentAnnotations() map[string]string {
+	return mergeAnnotations("deployment "+w.BiteService.Name,
+		annotationSource{name: "deployment_annotations", values: w.BiteService.DeploymentAnnotations},
+	)
+}
+
+// podAnnotations returns annotations of the deployment pod template
+func (w *KubeMapper) podAnnotations() map[string]string {
+	return mergeAnnotations("pod template "+w.BiteService.Name,
+		annotationSource{name: "annotations", values: w.BiteService.Annotations},
+	)
 }
 
 func (w *KubeMapper) labels() map[string]string {
@@ -3,13 +3,21 @@ package translator
 import (
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	apps_v1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	netwk_v1beta1 "k8s.io/api/networking/v1beta1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestCRD(t *testing.T) {
@@ -103,6 +111,17 @@ func TestDockerPullSecrets(t *testing.T) {
 	}
 }
 
+func TestDockerPullSecretsCleared(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.ImagePullSecrets = "none"
+	os.Setenv("DOCKER_PULL_SECRETS", "pullsecret")
+	deploy, _ := w.Deployment()
+	os.Unsetenv("DOCKER_PULL_SECRETS")
+	if s := deploy.Spec.Template.Spec.ImagePullSecrets; len(s) != 0 {
+		t.Errorf("Expected no ImagePullSecrets with image_pull_secrets: none, got %+v", s)
+	}
+}
+
 func TestVolumeFromSecret(t *testing.T) {
 	w := BuildKubeMapper()
 	w.BiteService.Name = "test"
@@ -130,6 +149,123 @@ func TestVolumeFromSecret(t *testing.T) {
 	}
 }
 
+func TestVolumeItems(t *testing.T) {
+	mode := int32(0400)
+	items := []bitesize.KeyToPath{{Key: "tls.key", Path: "private/key.pem", Mode: &mode}}
+	w := BuildKubeMapper()
+	w.BiteService.Volumes = []bitesize.Volume{
+		{Name: "api-tls", Path: "/etc/tls", Type: "secret", Items: items},
+		{Name: "api-config", Path: "/etc/api", Type: "configmap", Items: items},
+	}
+	generatedVolumes, _ := w.volumes()
+
+	expectedItems := []v1.KeyToPath{{Key: "tls.key", Path: "private/key.pem", Mode: &mode}}
+	if s := generatedVolumes[0].Secret; s == nil || !reflect.DeepEqual(s.Items, expectedItems) {
+		t.Errorf("incorrect secret volume: %+v generated; expecting items: %+v", generatedVolumes[0], expectedItems)
+	}
+	if c := generatedVolumes[1].ConfigMap; c == nil || !reflect.DeepEqual(c.Items, expectedItems) {
+		t.Errorf("incorrect configmap volume: %+v generated; expecting items: %+v", generatedVolumes[1], expectedItems)
+	}
+}
+
+func TestVolumeDefaultMode(t *testing.T) {
+	mode := int32(0400)
+	w := BuildKubeMapper()
+	w.BiteService.Volumes = []bitesize.Volume{
+		{Name: "ssh-key", Path: "/etc/ssh", Type: "secret", DefaultMode: &mode},
+		{Name: "settings", Path: "/etc/api", Type: "configmap", DefaultMode: &mode},
+	}
+	generatedVolumes, _ := w.volumes()
+
+	if s := generatedVolumes[0].Secret; s == nil || s.DefaultMode == nil || *s.DefaultMode != mode {
+		t.Errorf("incorrect secret volume: %+v generated; expecting default mode %o", generatedVolumes[0], mode)
+	}
+	if c := generatedVolumes[1].ConfigMap; c == nil || c.DefaultMode == nil || *c.DefaultMode != mode {
+		t.Errorf("incorrect configmap volume: %+v generated; expecting default mode %o", generatedVolumes[1], mode)
+	}
+}
+
+func TestVolumeFromHostPath(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Volumes = []bitesize.Volume{
+		{Name: "varlog", Path: "/var/log", Type: "hostpath", HostPath: "/var/log", HostPathType: "Directory"},
+		{Name: "data", Path: "/data", Type: "hostpath", HostPath: "/mnt/data"},
+	}
+	generatedVolumes, _ := w.volumes()
+
+	directory := v1.HostPathDirectory
+	expectedVolumes := []v1.Volume{
+		{
+			Name: "varlog",
+			VolumeSource: v1.VolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: "/var/log", Type: &directory},
+			},
+		},
+		{
+			Name: "data",
+			VolumeSource: v1.VolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: "/mnt/data"},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(generatedVolumes, expectedVolumes) {
+		t.Errorf("incorrect volumes: %v generated; expecting: %v ", generatedVolumes, expectedVolumes)
+	}
+
+	if claims, _ := w.PersistentVolumeClaims(); len(claims) != 0 {
+		t.Errorf("Unexpected claims for hostpath volumes: %v", claims)
+	}
+}
+
+func TestVolumeFromProjection(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Volumes = []bitesize.Volume{
+		{
+			Name: "aws-iam",
+			Path: "/var/run/secrets/aws",
+			Type: "projected",
+			Sources: []bitesize.ProjectedSource{
+				{ServiceAccountToken: &bitesize.ServiceAccountToken{Path: "token", Audience: "sts.amazonaws.com", ExpirationSeconds: 86400}},
+				{ConfigMap: "aws-config", Items: []bitesize.KeyToPath{{Key: "config", Path: "config"}}},
+				{Secret: "ca"},
+				{DownwardAPI: []bitesize.DownwardAPIFile{{Path: "labels", FieldRef: "metadata.labels"}}},
+			},
+		},
+	}
+	generatedVolumes, _ := w.volumes()
+
+	expiration := int64(86400)
+	expectedVolumes := []v1.Volume{
+		{
+			Name: "aws-iam",
+			VolumeSource: v1.VolumeSource{
+				Projected: &v1.ProjectedVolumeSource{
+					Sources: []v1.VolumeProjection{
+						{ServiceAccountToken: &v1.ServiceAccountTokenProjection{Path: "token", Audience: "sts.amazonaws.com", ExpirationSeconds: &expiration}},
+						{ConfigMap: &v1.ConfigMapProjection{
+							LocalObjectReference: v1.LocalObjectReference{Name: "aws-config"},
+							Items:                []v1.KeyToPath{{Key: "config", Path: "config"}},
+						}},
+						{Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: "ca"}}},
+						{DownwardAPI: &v1.DownwardAPIProjection{Items: []v1.DownwardAPIVolumeFile{
+							{Path: "labels", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.labels"}},
+						}}},
+					},
+				},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(generatedVolumes, expectedVolumes) {
+		t.Errorf("incorrect volumes: %+v generated; expecting: %+v ", generatedVolumes, expectedVolumes)
+	}
+
+	if claims, _ := w.PersistentVolumeClaims(); len(claims) != 0 {
+		t.Errorf("Unexpected claims for projected volumes: %v", claims)
+	}
+}
+
 func testTranslatorIngressHTTPSBackend(t *testing.T) {
 	w := BuildKubeMapper()
 	w.BiteService.HTTPSBackend = "true"
@@ -154,6 +290,75 @@ func testTranslatorIngressHTTPSOnly(t *testing.T) {
 	}
 }
 
+func TestTranslatorIngressCertManager(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.ExternalURL = []string{"www.test.com"}
+	w.BiteService.CertManager = &bitesize.CertManager{Issuer: "letsencrypt", IssuerKind: "ClusterIssuer"}
+
+	ingress, _ := w.Ingress()
+	if ingress.Annotations["cert-manager.io/cluster-issuer"] != "letsencrypt" {
+		t.Errorf("Expected cluster-issuer annotation, got %v", ingress.Annotations)
+	}
+	tls := []netwk_v1beta1.IngressTLS{{Hosts: []string{"www.test.com"}, SecretName: "test-tls"}}
+	if !reflect.DeepEqual(ingress.Spec.TLS, tls) {
+		t.Errorf("Unexpected ingress TLS section %v", ingress.Spec.TLS)
+	}
+
+	w.BiteService.CertManager = &bitesize.CertManager{
+		Issuer:     "local",
+		IssuerKind: "Issuer",
+		Hosts:      []string{"api.test.com"},
+	}
+	ingress, _ = w.Ingress()
+	if ingress.Annotations["cert-manager.io/issuer"] != "local" {
+		t.Errorf("Expected issuer annotation, got %v", ingress.Annotations)
+	}
+	if !reflect.DeepEqual(ingress.Spec.TLS[0].Hosts, []string{"api.test.com"}) {
+		t.Errorf("Unexpected hosts in ingress TLS section %v", ingress.Spec.TLS)
+	}
+}
+
+func TestTranslatorIngressBackendHealthCheck(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+
+	w := BuildKubeMapper()
+	w.BiteService.ExternalURL = []string{"www.test.com"}
+	w.BiteService.IngressAnnotations = map[string]string{"team": "platform"}
+	w.BiteService.BackendHealthCheck = &bitesize.BackendHealthCheck{Path: "/healthz", Interval: "1m"}
+
+	config.Env.IngressController = ""
+	if _, err := w.Ingress(); err == nil || !strings.Contains(err.Error(), "does not support backend health checks") {
+		t.Errorf("Expected unsupported controller error, got %v", err)
+	}
+
+	config.Env.IngressController = "alb"
+	ingress, err := w.Ingress()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	expected := map[string]string{
+		"team": "platform",
+		"alb.ingress.kubernetes.io/healthcheck-path":             "/healthz",
+		"alb.ingress.kubernetes.io/healthcheck-interval-seconds": "60",
+		"environment-operator/backend-health-check":              `{"path":"/healthz","interval":"1m"}`,
+	}
+	if !reflect.DeepEqual(ingress.Annotations, expected) {
+		t.Errorf("Expected ingress annotations %v, got %v", expected, ingress.Annotations)
+	}
+
+	config.Env.IngressController = "haproxy"
+	w.BiteService.BackendHealthCheck = &bitesize.BackendHealthCheck{Interval: "5s"}
+	ingress, _ = w.Ingress()
+	if a := ingress.Annotations; a["haproxy.org/check"] != "true" || a["haproxy.org/check-interval"] != "5s" || a["haproxy.org/check-http"] != "" {
+		t.Errorf("Expected haproxy TCP check every 5s, got %v", a)
+	}
+
+	config.Env.IngressController = "alb"
+	if _, err := w.Ingress(); err == nil || !strings.Contains(err.Error(), "path is required") {
+		t.Errorf("Expected alb TCP check error, got %v", err)
+	}
+}
+
 func TestTranslatorIngressBackendOverride(t *testing.T) {
 	w := BuildKubeMapper()
 	w.BiteService.ExternalURL = []string{"www.test.com"}
@@ -220,6 +425,485 @@ func TestInitContainers(t *testing.T) {
 	}
 }
 
+func TestTranslatorSchedulerName(t *testing.T) {
+	w := BuildKubeMapper()
+
+	d, _ := w.Deployment()
+	if d.Spec.Template.Spec.SchedulerName != "" {
+		t.Errorf("Unexpected scheduler name. Expected default, got: %s", d.Spec.Template.Spec.SchedulerName)
+	}
+
+	w.BiteService.SchedulerName = "gang-scheduler"
+	d, _ = w.Deployment()
+	if d.Spec.Template.Spec.SchedulerName != "gang-scheduler" {
+		t.Errorf("Unexpected scheduler name. Expected gang-scheduler, got: %s", d.Spec.Template.Spec.SchedulerName)
+	}
+}
+
+func TestTranslatorRuntimeClass(t *testing.T) {
+	w := BuildKubeMapper()
+
+	d, _ := w.Deployment()
+	if d.Spec.Template.Spec.RuntimeClassName != nil {
+		t.Errorf("Unexpected runtime class. Expected nil, got: %s", *d.Spec.Template.Spec.RuntimeClassName)
+	}
+
+	w.BiteService.RuntimeClass = "gvisor"
+	d, _ = w.Deployment()
+	if d.Spec.Template.Spec.RuntimeClassName == nil || *d.Spec.Template.Spec.RuntimeClassName != "gvisor" {
+		t.Errorf("Unexpected runtime class. Expected gvisor, got: %v", d.Spec.Template.Spec.RuntimeClassName)
+	}
+}
+
+func TestTranslatorTargetPorts(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Ports = []int{80, 443}
+	w.BiteService.TargetPorts = map[int]int{80: 8080}
+
+	svc, _ := w.Service()
+	if p := svc.Spec.Ports[0]; p.Port != 80 || p.TargetPort.IntVal != 8080 {
+		t.Errorf("Unexpected port mapping. Expected 80->8080, got: %d->%d", p.Port, p.TargetPort.IntVal)
+	}
+	if p := svc.Spec.Ports[1]; p.Port != 443 || p.TargetPort.IntVal != 443 {
+		t.Errorf("Unexpected port mapping. Expected 443->443, got: %d->%d", p.Port, p.TargetPort.IntVal)
+	}
+
+	d, _ := w.Deployment()
+	ports := d.Spec.Template.Spec.Containers[0].Ports
+	if len(ports) != 2 || ports[0].ContainerPort != 8080 || ports[1].ContainerPort != 443 {
+		t.Errorf("Unexpected container ports. Expected 8080 and 443, got: %+v", ports)
+	}
+}
+
+func TestTranslatorNodeName(t *testing.T) {
+	w := BuildKubeMapper()
+
+	d, _ := w.Deployment()
+	if d.Spec.Template.Spec.NodeName != "" {
+		t.Errorf("Unexpected node name. Expected none, got: %s", d.Spec.Template.Spec.NodeName)
+	}
+
+	w.BiteService.NodeName = "ip-10-0-1-23.ec2.internal"
+	d, _ = w.Deployment()
+	if d.Spec.Template.Spec.NodeName != "ip-10-0-1-23.ec2.internal" {
+		t.Errorf("Unexpected node name. Expected ip-10-0-1-23.ec2.internal, got: %s", d.Spec.Template.Spec.NodeName)
+	}
+}
+
+func TestTranslatorHostNamespaces(t *testing.T) {
+	w := BuildKubeMapper()
+
+	d, _ := w.Deployment()
+	spec := d.Spec.Template.Spec
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC || spec.DNSPolicy != "" {
+		t.Errorf("Unexpected host namespaces, got: %+v", spec)
+	}
+
+	w.BiteService.HostNetwork = true
+	w.BiteService.HostPID = true
+	d, _ = w.Deployment()
+	spec = d.Spec.Template.Spec
+	if !spec.HostNetwork || !spec.HostPID || spec.HostIPC {
+		t.Errorf("Expected host network and pid namespaces, got: %+v", spec)
+	}
+	if spec.DNSPolicy != v1.DNSClusterFirstWithHostNet {
+		t.Errorf("Unexpected dns policy. Expected ClusterFirstWithHostNet, got: %s", spec.DNSPolicy)
+	}
+}
+
+func TestTranslatorHTTPProbes(t *testing.T) {
+	w := BuildKubeMapper()
+	d, _ := w.Deployment()
+	if c := d.Spec.Template.Spec.Containers[0]; c.LivenessProbe != nil || c.ReadinessProbe != nil {
+		t.Fatalf("Expected no probes without probe config, got: %+v, %+v", c.LivenessProbe, c.ReadinessProbe)
+	}
+
+	w.BiteService.ReadinessProbe = &bitesize.Probe{
+		Handler:             bitesize.Handler{HTTPGet: &bitesize.HTTPGetAction{Path: "/ready", Port: 80}},
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
+		TimeoutSeconds:      2,
+		FailureThreshold:    3,
+	}
+	d, _ = w.Deployment()
+	expected := &v1.Probe{
+		Handler: v1.Handler{
+			HTTPGet: &v1.HTTPGetAction{Path: "/ready", Port: intstr.FromInt(80)},
+		},
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
+		TimeoutSeconds:      2,
+		FailureThreshold:    3,
+	}
+	if probe := d.Spec.Template.Spec.Containers[0].ReadinessProbe; !reflect.DeepEqual(probe, expected) {
+		t.Errorf("Unexpected readiness probe. Expected %+v, got: %+v", expected, probe)
+	}
+}
+
+func TestTranslatorGRPCProbe(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.LivenessProbe = &bitesize.Probe{
+		Handler:       bitesize.Handler{GRPC: &bitesize.GRPCAction{Port: 9090, Service: "api"}},
+		PeriodSeconds: 5,
+	}
+
+	d, _ := w.Deployment()
+	probe := d.Spec.Template.Spec.Containers[0].LivenessProbe
+	if probe == nil || probe.TCPSocket == nil || probe.TCPSocket.Port.IntVal != 9090 {
+		t.Fatalf("Expected TCP probe of port 9090, got: %+v", probe)
+	}
+	if probe.PeriodSeconds != 5 {
+		t.Errorf("Unexpected period. Expected 5, got: %d", probe.PeriodSeconds)
+	}
+}
+
+func TestTranslatorServiceAnnotations(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.ServiceAnnotations = map[string]string{
+		"traffic.sidecar.istio.io/excludeInboundPorts": "9090",
+		"deployment_method":                            "custom",
+	}
+
+	svc, _ := w.Service()
+	if svc.Annotations["traffic.sidecar.istio.io/excludeInboundPorts"] != "9090" {
+		t.Errorf("Expected service annotation to be set, got: %+v", svc.Annotations)
+	}
+
+	if svc.Annotations["deployment_method"] != w.BiteService.DeploymentMethod() {
+		t.Errorf("Expected deployment_method annotation not to be overridden, got: %s", svc.Annotations["deployment_method"])
+	}
+}
+
+func TestTranslatorServiceType(t *testing.T) {
+	w := BuildKubeMapper()
+
+	svc, _ := w.Service()
+	if svc.Spec.Type != "" || svc.Spec.LoadBalancerSourceRanges != nil {
+		t.Errorf("Expected default ClusterIP service, got type %q, source ranges %v", svc.Spec.Type, svc.Spec.LoadBalancerSourceRanges)
+	}
+
+	w.BiteService.ServiceType = "LoadBalancer"
+	w.BiteService.LoadBalancerSourceRanges = []string{"10.0.0.0/8"}
+	svc, _ = w.Service()
+	if svc.Spec.Type != v1.ServiceTypeLoadBalancer {
+		t.Errorf("Unexpected service type. Expected LoadBalancer, got: %s", svc.Spec.Type)
+	}
+	if !reflect.DeepEqual(svc.Spec.LoadBalancerSourceRanges, []string{"10.0.0.0/8"}) {
+		t.Errorf("Unexpected source ranges: %v", svc.Spec.LoadBalancerSourceRanges)
+	}
+}
+
+func TestTranslatorServiceClusterIP(t *testing.T) {
+	w := BuildKubeMapper()
+
+	svc, _ := w.Service()
+	if svc.Spec.ClusterIP != "" {
+		t.Errorf("Unexpected cluster ip. Expected none, got: %s", svc.Spec.ClusterIP)
+	}
+
+	w.BiteService.ClusterIP = "10.0.0.10"
+	svc, _ = w.Service()
+	if svc.Spec.ClusterIP != "10.0.0.10" {
+		t.Errorf("Unexpected cluster ip. Expected 10.0.0.10, got: %s", svc.Spec.ClusterIP)
+	}
+
+	w.BiteService.ClusterIP = ""
+	w.BiteService.Headless = true
+	svc, _ = w.Service()
+	if svc.Spec.ClusterIP != v1.ClusterIPNone {
+		t.Errorf("Unexpected cluster ip for headless service. Expected None, got: %s", svc.Spec.ClusterIP)
+	}
+}
+
+func TestTranslatorExternalNameService(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Type = bitesize.TypeExternalName
+	w.BiteService.ExternalName = "db.example.com"
+
+	svc, _ := w.Service()
+	if svc.Spec.Type != v1.ServiceTypeExternalName {
+		t.Errorf("Unexpected service type. Expected ExternalName, got: %s", svc.Spec.Type)
+	}
+
+	if svc.Spec.ExternalName != "db.example.com" {
+		t.Errorf("Unexpected external name. Expected db.example.com, got: %s", svc.Spec.ExternalName)
+	}
+
+	if len(svc.Spec.Ports) != 0 || len(svc.Spec.Selector) != 0 {
+		t.Errorf("Unexpected ports or selector for external name service: %+v", svc.Spec)
+	}
+}
+
+func TestTranslatorPublishNotReadyAddresses(t *testing.T) {
+	w := BuildKubeMapper()
+
+	svc, _ := w.Service()
+	if svc.Spec.PublishNotReadyAddresses {
+		t.Error("Unexpected publishNotReadyAddresses set on service by default")
+	}
+
+	w.BiteService.PublishNotReadyAddresses = true
+	svc, _ = w.Service()
+	if !svc.Spec.PublishNotReadyAddresses {
+		t.Error("Expected publishNotReadyAddresses to be set on service")
+	}
+
+	headless, _ := w.HeadlessService()
+	if !headless.Spec.PublishNotReadyAddresses {
+		t.Error("Expected publishNotReadyAddresses to be set on headless service")
+	}
+}
+
+func TestTranslatorCanaryIngress(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.ExternalURL = []string{"www.test.com"}
+
+	if canary, _ := w.CanaryIngress(); canary != nil {
+		t.Errorf("Unexpected canary ingress for service without weighted backends: %+v", canary)
+	}
+
+	w.BiteService.WeightedBackends = []bitesize.WeightedBackend{
+		{Service: "test-a", Weight: 70},
+		{Service: "test-b", Weight: 30},
+	}
+
+	ingress, _ := w.Ingress()
+	if backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName; backend != "test-a" {
+		t.Errorf("Unexpected ingress backend. Expected test-a, got: %s", backend)
+	}
+
+	canary, _ := w.CanaryIngress()
+	if canary.Name != "test-canary" {
+		t.Errorf("Unexpected canary ingress name. Expected test-canary, got: %s", canary.Name)
+	}
+
+	if backend := canary.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName; backend != "test-b" {
+		t.Errorf("Unexpected canary ingress backend. Expected test-b, got: %s", backend)
+	}
+
+	if canary.Annotations["nginx.ingress.kubernetes.io/canary-weight"] != "30" {
+		t.Errorf("Unexpected canary weight. Expected 30, got: %+v", canary.Annotations)
+	}
+}
+
+func TestTranslatorTopologyAwareRouting(t *testing.T) {
+	w := BuildKubeMapper()
+
+	svc, _ := w.Service()
+	if _, ok := svc.Annotations["service.kubernetes.io/topology-aware-hints"]; ok {
+		t.Errorf("Unexpected topology aware hints annotation: %+v", svc.Annotations)
+	}
+
+	w.BiteService.TopologyAwareRouting = true
+	svc, _ = w.Service()
+	if svc.Annotations["service.kubernetes.io/topology-aware-hints"] != "Auto" {
+		t.Errorf("Expected topology aware hints annotation to be Auto, got: %+v", svc.Annotations)
+	}
+}
+
+func TestIngressWithoutPorts(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Ports = nil
+	w.BiteService.ExternalURL = []string{"www.example.com"}
+	w.BiteService.Backend = "frontend"
+
+	if _, err := w.Ingress(); err == nil {
+		t.Error("Expected error for ingress without ports or backend_port")
+	}
+
+	w.BiteService.BackendPort = 8080
+	ingress, err := w.Ingress()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend
+	if backend.ServiceName != "frontend" || backend.ServicePort.IntValue() != 8080 {
+		t.Errorf("Expected backend frontend:8080, got %s:%s", backend.ServiceName, backend.ServicePort.String())
+	}
+}
+
+func TestTranslatorMinReadySeconds(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.MinReadySeconds = 30
+
+	d, _ := w.Deployment()
+	if d.Spec.MinReadySeconds != 30 {
+		t.Errorf("Expected minReadySeconds 30, got %d", d.Spec.MinReadySeconds)
+	}
+}
+
+func TestTranslatorRevisionHistoryLimit(t *testing.T) {
+	w := BuildKubeMapper()
+
+	d, _ := w.Deployment()
+	if l := d.Spec.RevisionHistoryLimit; l == nil || int(*l) != config.Env.RevisionHistoryLimit {
+		t.Errorf("Expected operator default revisionHistoryLimit %d, got %v", config.Env.RevisionHistoryLimit, l)
+	}
+
+	limit := int32(25)
+	w.BiteService.RevisionHistoryLimit = &limit
+	d, _ = w.Deployment()
+	if l := d.Spec.RevisionHistoryLimit; l == nil || *l != 25 {
+		t.Errorf("Expected revisionHistoryLimit 25, got %v", l)
+	}
+}
+
+func TestTranslatorHPALabels(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Version = "1.2"
+	w.BiteService.HPA.MinReplicas = 1
+	w.BiteService.HPA.MaxReplicas = 3
+	w.BiteService.HPA.Labels = map[string]string{"team": "payments", "name": "other"}
+
+	hpa, _ := w.HPA()
+	expected := map[string]string{
+		"creator":     "pipeline",
+		"application": "",
+		"name":        "test",
+		"team":        "payments",
+	}
+	if !reflect.DeepEqual(hpa.Labels, expected) {
+		t.Errorf("Unexpected hpa labels. Expected %v, got %v", expected, hpa.Labels)
+	}
+}
+
+func TestTranslatorHPAScaleTarget(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.HPA.MinReplicas = 1
+	w.BiteService.HPA.MaxReplicas = 3
+
+	deployment, err := w.Deployment()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	hpa, _ := w.HPA()
+	target := hpa.Spec.ScaleTargetRef
+	if target.APIVersion != deployment.APIVersion || target.Kind != deployment.Kind || target.Name != deployment.Name {
+		t.Errorf("Expected hpa to scale %s %s/%s, got %+v", deployment.APIVersion, deployment.Kind, deployment.Name, target)
+	}
+	if gv, _ := schema.ParseGroupVersion(target.APIVersion); gv != apps_v1.SchemeGroupVersion {
+		t.Errorf("Expected hpa target group/version %s, got %s", apps_v1.SchemeGroupVersion, target.APIVersion)
+	}
+}
+
+func TestTranslatorHPAReplicas(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Replicas = 2
+	w.BiteService.HPA.MinReplicas = 2
+	w.BiteService.HPA.MaxReplicas = 3
+
+	d, _ := w.Deployment()
+	if d.Spec.Replicas != nil {
+		t.Errorf("Expected replicas of autoscaled deployment to be left to HPA, got %d", *d.Spec.Replicas)
+	}
+}
+
+func TestTranslatorReadinessGates(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.ReadinessGates = []string{"target-health.elbv2.k8s.aws/front"}
+
+	d, _ := w.Deployment()
+	expected := []v1.PodReadinessGate{{ConditionType: "target-health.elbv2.k8s.aws/front"}}
+	if !reflect.DeepEqual(d.Spec.Template.Spec.ReadinessGates, expected) {
+		t.Errorf("Expected readiness gates %v, got %v", expected, d.Spec.Template.Spec.ReadinessGates)
+	}
+}
+
+func TestTranslatorSecretFetch(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.SecretFetch = &bitesize.SecretFetch{
+		Image:     "hashicorp/vault:1.13",
+		Command:   []string{"vault", "agent"},
+		EnvVars:   []bitesize.EnvVar{{Name: "VAULT_ADDR", Value: "https://vault:8200"}},
+		ConfigMap: "test-vault",
+		Path:      "/vault/secrets",
+	}
+
+	d, _ := w.Deployment()
+	spec := d.Spec.Template.Spec
+	if len(spec.InitContainers) != 1 || spec.InitContainers[0].Name != "secret-fetch" {
+		t.Fatalf("Expected secret-fetch init container, got %v", spec.InitContainers)
+	}
+	expectedMounts := []v1.VolumeMount{
+		{Name: "secret-fetch", MountPath: "/vault/secrets"},
+		{Name: "secret-fetch-config", MountPath: "/vault/config", ReadOnly: true},
+	}
+	if !reflect.DeepEqual(spec.InitContainers[0].VolumeMounts, expectedMounts) {
+		t.Errorf("Unexpected init container mounts %v", spec.InitContainers[0].VolumeMounts)
+	}
+	if spec.InitContainers[0].Env[0].Value != "https://vault:8200" {
+		t.Errorf("Unexpected init container env %v", spec.InitContainers[0].Env)
+	}
+
+	mount := v1.VolumeMount{Name: "secret-fetch", MountPath: "/vault/secrets", ReadOnly: true}
+	if !reflect.DeepEqual(spec.Containers[0].VolumeMounts, []v1.VolumeMount{mount}) {
+		t.Errorf("Unexpected container mounts %v", spec.Containers[0].VolumeMounts)
+	}
+	if len(spec.Volumes) != 2 || spec.Volumes[0].EmptyDir == nil || spec.Volumes[0].EmptyDir.Medium != v1.StorageMediumMemory {
+		t.Errorf("Expected in-memory secret-fetch volume, got %v", spec.Volumes)
+	}
+	if spec.Volumes[1].ConfigMap == nil || spec.Volumes[1].ConfigMap.Name != "test-vault" {
+		t.Errorf("Expected secret-fetch config volume, got %v", spec.Volumes)
+	}
+}
+
+func TestTranslatorSecretFetchMissingSecret(t *testing.T) {
+	defer func(policy string) { config.Env.MissingReferencePolicy = policy }(config.Env.MissingReferencePolicy)
+	config.Env.MissingReferencePolicy = k8s.MissingReferenceFail
+
+	w := BuildKubeMapper()
+	w.Client = &k8s.Client{Interface: fake.NewSimpleClientset(), Namespace: "testns"}
+	w.BiteService.SecretFetch = &bitesize.SecretFetch{
+		Image:   "hashicorp/vault:1.13",
+		EnvVars: []bitesize.EnvVar{{Secret: "VAULT_TOKEN", Value: "vault/token"}},
+		Path:    "/vault/secrets",
+	}
+
+	d, err := w.Deployment()
+	if err == nil || !strings.HasPrefix(err.Error(), "Unable to find secret [vault] in namespace [testns]") {
+		t.Errorf("Expected missing secret error, got %v", err)
+	}
+	if d != nil {
+		t.Errorf("Expected no deployment, got %v", d)
+	}
+}
+
+func TestTranslatorDeploymentAnnotations(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Annotations = map[string]string{"prometheus.io/scrape": "true"}
+	w.BiteService.DeploymentAnnotations = map[string]string{"keel.sh/policy": "minor"}
+
+	d, _ := w.Deployment()
+	if !reflect.DeepEqual(d.Annotations, w.BiteService.DeploymentAnnotations) {
+		t.Errorf("Unexpected deployment annotations %v", d.Annotations)
+	}
+	if !reflect.DeepEqual(d.Spec.Template.Annotations, w.BiteService.Annotations) {
+		t.Errorf("Unexpected pod template annotations %v", d.Spec.Template.Annotations)
+	}
+}
+
+func TestTranslatorIngressAnnotations(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.ExternalURL = []string{"api.example.com"}
+	w.BiteService.IngressAnnotations = map[string]string{
+		"alb.ingress.kubernetes.io/target-type": "ip",
+		"cert-manager.io/cluster-issuer":        "staging",
+	}
+	w.BiteService.CertManager = &bitesize.CertManager{Issuer: "letsencrypt", IssuerKind: "ClusterIssuer"}
+
+	ingress, err := w.Ingress()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	expected := map[string]string{
+		"alb.ingress.kubernetes.io/target-type": "ip",
+		"cert-manager.io/cluster-issuer":        "letsencrypt",
+	}
+	if !reflect.DeepEqual(ingress.Annotations, expected) {
+		t.Errorf("Expected ingress annotations %v, got %v", expected, ingress.Annotations)
+	}
+}
+
 func TestTranslatorHPA(t *testing.T) {
 
 	w := BuildKubeMapper()
@@ -279,6 +963,24 @@ func TestTranslatorEnvVars(t *testing.T) {
 	}
 }
 
+func TestTranslatorEnvFrom(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.EnvFrom = []bitesize.EnvFromSource{
+		{ConfigMap: "settings"},
+		{Secret: "credentials", Prefix: "DB_"},
+	}
+
+	d, _ := w.Deployment()
+
+	expected := []v1.EnvFromSource{
+		{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "settings"}}},
+		{Prefix: "DB_", SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "credentials"}}},
+	}
+	if generated := d.Spec.Template.Spec.Containers[0].EnvFrom; !reflect.DeepEqual(generated, expected) {
+		t.Errorf("incorrect env_from: %v generated; expecting: %v", generated, expected)
+	}
+}
+
 func TestTranslatorPVCs(t *testing.T) {
 	w := BuildKubeMapper()
 	w.BiteService.Name = "test"
@@ -346,6 +1048,65 @@ func TestTranslatorPVCs(t *testing.T) {
 
 }
 
+func TestTranslatorPVCLabelsAndStorageClass(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Volumes = []bitesize.Volume{
+		{
+			Name:         "data",
+			Path:         "/data",
+			Modes:        "ReadWriteOnce",
+			Size:         "1Gi",
+			Labels:       map[string]string{"backup": "daily", "creator": "someone"},
+			Annotations:  map[string]string{"backup.velero.io/backup-volumes": "data"},
+			StorageClass: "gp3",
+		},
+	}
+
+	pvcs, _ := w.PersistentVolumeClaims()
+	pvc := pvcs[0]
+	if pvc.Labels["backup"] != "daily" || pvc.Labels["creator"] != "pipeline" {
+		t.Errorf("Unexpected PVC labels %v", pvc.Labels)
+	}
+	expectedAnnotations := map[string]string{"backup.velero.io/backup-volumes": "data"}
+	if !reflect.DeepEqual(pvc.Annotations, expectedAnnotations) {
+		t.Errorf("Unexpected PVC annotations %v", pvc.Annotations)
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName != "gp3" {
+		t.Errorf("Expected storage class gp3, got %v", pvc.Spec.StorageClassName)
+	}
+}
+
+func TestTranslatorServiceMonitor(t *testing.T) {
+	w := BuildKubeMapper()
+	if m, _ := w.ServiceMonitor(); m != nil {
+		t.Errorf("Expected no ServiceMonitor without service_monitor, got %v", m)
+	}
+
+	w.BiteService.ServiceMonitor = &bitesize.ServiceMonitor{Port: 80, Path: "/metrics", Interval: "15s"}
+	m, err := w.ServiceMonitor()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if m.GetKind() != "ServiceMonitor" || m.GetAPIVersion() != "monitoring.coreos.com/v1" {
+		t.Errorf("Unexpected ServiceMonitor type %s %s", m.GetAPIVersion(), m.GetKind())
+	}
+
+	endpoints, _, _ := unstructured.NestedSlice(m.Object, "spec", "endpoints")
+	expected := map[string]interface{}{"port": "http-80", "path": "/metrics", "interval": "15s"}
+	if len(endpoints) != 1 || !reflect.DeepEqual(endpoints[0], expected) {
+		t.Errorf("Expected endpoint %v, got %v", expected, endpoints)
+	}
+	selector, _, _ := unstructured.NestedStringMap(m.Object, "spec", "selector", "matchLabels")
+	if selector["name"] != w.BiteService.Name {
+		t.Errorf("Expected ServiceMonitor to select service %s, got %v", w.BiteService.Name, selector)
+	}
+
+	svc, _ := w.Service()
+	if svc.Annotations[k8s.ServiceMonitorAnnotation] == "" {
+		t.Error("Expected service_monitor settings to be recorded on the service")
+	}
+}
+
 func TestServiceMeshGateway(t *testing.T) {
 	w := BuildKubeMapper()
 
@@ -404,3 +1165,147 @@ func TestServiceMeshVirtualService(t *testing.T) {
 		t.Errorf("Wrong destination host for the istio virtual service %s", d.Spec.HTTP[0].Route[0].Destination.Host)
 	}
 }
+
+func TestEnvExternalSecrets(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.EnvVars = []bitesize.EnvVar{
+		{Secret: "DB_USER", Value: "db/user", ExternalKey: "prod/db", ExternalProperty: "user"},
+		{Secret: "DB_PASSWORD", Value: "db/password", ExternalKey: "prod/db", ExternalProperty: "password"},
+		{Secret: "API_TOKEN", Value: "api-token", ExternalKey: "prod/token"},
+		{Secret: "LOCAL", Value: "local/key"},
+	}
+
+	secrets := w.EnvExternalSecrets()
+	if len(secrets) != 2 {
+		t.Fatalf("Expected 2 ExternalSecrets, got %d", len(secrets))
+	}
+	if secrets[0].Name != "test-env-api-token" || secrets[1].Name != "test-env-db" {
+		t.Errorf("Expected ExternalSecrets test-env-api-token and test-env-db, got %s and %s", secrets[0].Name, secrets[1].Name)
+	}
+	if l := secrets[1].Labels[k8s.EnvSecretLabel]; l != "db" {
+		t.Errorf("Expected ExternalSecret labelled with secret db, got %q", l)
+	}
+	expected := []map[string]string{
+		{"key": "prod/db", "name": "user", "property": "user"},
+		{"key": "prod/db", "name": "password", "property": "password"},
+	}
+	if !reflect.DeepEqual(secrets[1].SecretDescriptor.Data, expected) {
+		t.Errorf("Expected data %v, got %v", expected, secrets[1].SecretDescriptor.Data)
+	}
+	if d := secrets[0].SecretDescriptor.Data; len(d) != 1 || d[0]["name"] != "api-token" {
+		t.Errorf("Expected secret key to default to secret name, got %v", d)
+	}
+	if b := secrets[0].SecretDescriptor.BackendType; b != config.Env.ExternalSecretsBackend {
+		t.Errorf("Expected backend %s, got %s", config.Env.ExternalSecretsBackend, b)
+	}
+}
+
+func TestTranslatorImagePullPolicy(t *testing.T) {
+	w := BuildKubeMapper()
+	d, _ := w.Deployment()
+	if p := d.Spec.Template.Spec.Containers[0].ImagePullPolicy; p != "" {
+		t.Errorf("Expected kubernetes default pull policy, got %s", p)
+	}
+
+	w.BiteService.ImagePullPolicy = "Always"
+	d, _ = w.Deployment()
+	if p := d.Spec.Template.Spec.Containers[0].ImagePullPolicy; p != v1.PullAlways {
+		t.Errorf("Expected pull policy Always, got %s", p)
+	}
+}
+
+func TestTranslatorNodeSelector(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.DefaultNodeSelector = nil
+
+	w := BuildKubeMapper()
+	d, _ := w.Deployment()
+	if s := d.Spec.Template.Spec.NodeSelector; s != nil {
+		t.Errorf("Expected no nodeSelector without defaults, got %v", s)
+	}
+
+	config.Env.DefaultNodeSelector = map[string]string{"role": "minion"}
+	w = BuildKubeMapper()
+	d, _ = w.Deployment()
+	if s := d.Spec.Template.Spec.NodeSelector; !reflect.DeepEqual(s, config.Env.DefaultNodeSelector) {
+		t.Errorf("Expected operator default nodeSelector %v, got %v", config.Env.DefaultNodeSelector, s)
+	}
+
+	w = BuildKubeMapper()
+	w.BiteService.NodeSelector = map[string]string{"pool": "gpu"}
+	d, _ = w.Deployment()
+	if s := d.Spec.Template.Spec.NodeSelector; !reflect.DeepEqual(s, w.BiteService.NodeSelector) {
+		t.Errorf("Expected service nodeSelector %v to replace the default, got %v", w.BiteService.NodeSelector, s)
+	}
+}
+
+func TestTranslatorAffinityAndTolerations(t *testing.T) {
+	seconds := int64(60)
+	w := BuildKubeMapper()
+	w.BiteService.Tolerations = []bitesize.Toleration{
+		{Key: "dedicated", Operator: "Equal", Value: "stateful", Effect: "NoExecute", TolerationSeconds: &seconds},
+	}
+	w.BiteService.Affinity = &bitesize.Affinity{
+		NodeAffinity: &bitesize.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []bitesize.NodeSelectorTerm{
+				{MatchExpressions: []bitesize.SelectorRequirement{{Key: "topology.kubernetes.io/zone", Operator: "In", Values: []string{"eu-west-1a"}}}},
+			},
+		},
+	}
+	w.BiteService.AntiAffinity = "name"
+	d, _ := w.Deployment()
+
+	expected := []v1.Toleration{
+		{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "stateful", Effect: v1.TaintEffectNoExecute, TolerationSeconds: &seconds},
+	}
+	if s := d.Spec.Template.Spec.Tolerations; !reflect.DeepEqual(s, expected) {
+		t.Errorf("Expected tolerations %+v, got %+v", expected, s)
+	}
+
+	affinity := d.Spec.Template.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		t.Fatalf("Expected required node affinity, got %+v", affinity)
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	zone := v1.NodeSelectorRequirement{Key: "topology.kubernetes.io/zone", Operator: v1.NodeSelectorOpIn, Values: []string{"eu-west-1a"}}
+	if len(terms) != 1 || !reflect.DeepEqual(terms[0].MatchExpressions, []v1.NodeSelectorRequirement{zone}) {
+		t.Errorf("Expected zone node selector term, got %+v", terms)
+	}
+	if affinity.PodAntiAffinity == nil {
+		t.Errorf("Expected anti_affinity preset to be kept alongside node affinity")
+	}
+}
+
+func TestTranslatorAntiAffinity(t *testing.T) {
+	w := BuildKubeMapper()
+	d, _ := w.Deployment()
+	if d.Spec.Template.Spec.Affinity != nil {
+		t.Fatalf("Expected no affinity without anti_affinity, got %+v", d.Spec.Template.Spec.Affinity)
+	}
+
+	var selectors []map[string]string
+	for _, name := range []string{"api-blue", "api-green"} {
+		w := BuildKubeMapper()
+		w.BiteService.Name = name
+		w.BiteService.Application = "api"
+		w.BiteService.AntiAffinity = "application"
+		d, _ := w.Deployment()
+
+		affinity := d.Spec.Template.Spec.Affinity
+		if affinity == nil || affinity.PodAntiAffinity == nil || len(affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 {
+			t.Fatalf("Expected preferred pod anti-affinity for %s, got %+v", name, affinity)
+		}
+		term := affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm
+		if term.TopologyKey != v1.LabelHostname {
+			t.Errorf("Expected topology key %s, got %s", v1.LabelHostname, term.TopologyKey)
+		}
+		selectors = append(selectors, term.LabelSelector.MatchLabels)
+	}
+
+	expected := map[string]string{"application": "api"}
+	for _, s := range selectors {
+		if !reflect.DeepEqual(s, expected) {
+			t.Errorf("Expected blue and green pods to be spread by %v, got %v", expected, s)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package translator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// applyPatches applies service patches of the given target to obj, a
+// pointer to the generated kubernetes object, in the order they are listed
+func (w *KubeMapper) applyPatches(target string, obj interface{}) error {
+	var doc []byte
+	for i, p := range w.BiteService.Patches {
+		if p.Target != target {
+			continue
+		}
+		if doc == nil {
+			var err error
+			if doc, err = json.Marshal(obj); err != nil {
+				return err
+			}
+		}
+
+		patched, err := applyPatch(doc, p, obj)
+		if err != nil {
+			return fmt.Errorf("patches[%d]: %s patch does not apply to %s: %s", i, p.Type, target, err.Error())
+		}
+		doc = patched
+	}
+
+	if doc == nil {
+		return nil
+	}
+
+	// decode into a zero value, so that fields removed by patches are unset
+	v := reflect.ValueOf(obj).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(obj); err != nil {
+		return fmt.Errorf("patches: patched %s is invalid: %s", target, err.Error())
+	}
+	return nil
+}
+
+func applyPatch(doc []byte, p bitesize.Patch, obj interface{}) ([]byte, error) {
+	data, err := p.JSON()
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.Type {
+	case bitesize.PatchTypeJSON:
+		patch, err := jsonpatch.DecodePatch(data)
+		if err != nil {
+			return nil, err
+		}
+		return patch.Apply(doc)
+	case bitesize.PatchTypeMerge:
+		return jsonpatch.MergePatch(doc, data)
+	default:
+		return strategicpatch.StrategicMergePatch(doc, data, obj)
+	}
+}
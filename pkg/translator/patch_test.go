@@ -0,0 +1,112 @@
+package translator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestPatchDeploymentStrategic(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Patches = []bitesize.Patch{
+		{
+			Target: bitesize.PatchTargetDeployment,
+			Type:   bitesize.PatchTypeStrategic,
+			Patch: `
+spec:
+  progressDeadlineSeconds: 120
+  template:
+    spec:
+      terminationGracePeriodSeconds: 90
+      containers:
+      - name: test
+        stdin: true
+`,
+		},
+	}
+
+	d, err := w.Deployment()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if d.Spec.ProgressDeadlineSeconds == nil || *d.Spec.ProgressDeadlineSeconds != 120 {
+		t.Errorf("Expected progressDeadlineSeconds 120, got %v", d.Spec.ProgressDeadlineSeconds)
+	}
+	spec := d.Spec.Template.Spec
+	if spec.TerminationGracePeriodSeconds == nil || *spec.TerminationGracePeriodSeconds != 90 {
+		t.Errorf("Expected terminationGracePeriodSeconds 90, got %v", spec.TerminationGracePeriodSeconds)
+	}
+	// containers are merged by name rather than replaced
+	if len(spec.Containers) != 1 || !spec.Containers[0].Stdin || spec.Containers[0].Name != "test" {
+		t.Errorf("Expected patched test container, got %+v", spec.Containers)
+	}
+	if d.Labels["creator"] != "pipeline" {
+		t.Errorf("Expected generated labels to be kept, got %v", d.Labels)
+	}
+}
+
+func TestPatchServiceJSON(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Patches = []bitesize.Patch{
+		{
+			Target: bitesize.PatchTargetService,
+			Type:   bitesize.PatchTypeJSON,
+			Patch:  `[{"op": "add", "path": "/spec/sessionAffinity", "value": "ClientIP"}]`,
+		},
+		{
+			Target: bitesize.PatchTargetService,
+			Type:   bitesize.PatchTypeMerge,
+			Patch:  `{"spec": {"externalTrafficPolicy": "Local"}}`,
+		},
+	}
+
+	svc, err := w.Service()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if svc.Spec.SessionAffinity != v1.ServiceAffinityClientIP {
+		t.Errorf("Expected sessionAffinity ClientIP, got %s", svc.Spec.SessionAffinity)
+	}
+	if svc.Spec.ExternalTrafficPolicy != v1.ServiceExternalTrafficPolicyTypeLocal {
+		t.Errorf("Expected externalTrafficPolicy Local, got %s", svc.Spec.ExternalTrafficPolicy)
+	}
+	if svc.Annotations["environment-operator/patches"] == "" {
+		t.Errorf("Expected patches to be recorded, got %v", svc.Annotations)
+	}
+
+	// service patches don't touch the deployment
+	d, err := w.Deployment()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if d.Spec.Template.Spec.Containers[0].Name != "test" {
+		t.Errorf("Unexpected deployment %+v", d.Spec.Template.Spec)
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	var tests = []struct {
+		Patch    bitesize.Patch
+		Expected string
+	}{
+		{
+			bitesize.Patch{Target: bitesize.PatchTargetService, Type: bitesize.PatchTypeJSON, Patch: `[{"op": "replace", "path": "/spec/missing/field", "value": 1}]`},
+			"patches[0]: json patch does not apply to service",
+		},
+		{
+			bitesize.Patch{Target: bitesize.PatchTargetService, Type: bitesize.PatchTypeMerge, Patch: `{"spec": {"sessionAfinity": "ClientIP"}}`},
+			"patched service is invalid",
+		},
+	}
+
+	for _, tst := range tests {
+		w := BuildKubeMapper()
+		w.BiteService.Patches = []bitesize.Patch{tst.Patch}
+		_, err := w.Service()
+		if err == nil || !strings.Contains(err.Error(), tst.Expected) {
+			t.Errorf("Expected error containing %q, got %v", tst.Expected, err)
+		}
+	}
+}
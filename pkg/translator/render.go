@@ -0,0 +1,134 @@
+package translator
+
+import (
+	"github.com/pearsontechnology/environment-operator/pkg/util/k8s"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Manifests returns Kubernetes objects generated for the service, in the
+// same order cluster applies them. Unlike cluster.ApplyService it never
+// contacts the cluster, so ingress is always rendered regardless of
+// ingress_wait_ready, and the ServiceMonitor regardless of whether
+// Prometheus Operator is installed
+func (w *KubeMapper) Manifests() ([]runtime.Object, error) {
+	var objects []runtime.Object
+
+	if w.BiteService.IsExternalName() {
+		svc, err := w.Service()
+		if err != nil {
+			return nil, err
+		}
+		return withKinds(svc), nil
+	}
+
+	if w.BiteService.Type != "" {
+		crd, err := w.CustomResourceDefinition()
+		if err != nil {
+			return nil, err
+		}
+		return withKinds(crd), nil
+	}
+
+	pvcs, err := w.PersistentVolumeClaims()
+	if err != nil {
+		return nil, err
+	}
+	for i := range pvcs {
+		objects = append(objects, &pvcs[i])
+	}
+
+	cmaps, err := w.ConfigMaps()
+	if err != nil {
+		return nil, err
+	}
+	for i := range cmaps {
+		objects = append(objects, &cmaps[i])
+	}
+
+	secrets := w.EnvExternalSecrets()
+	for i := range secrets {
+		objects = append(objects, &secrets[i])
+	}
+
+	deployment, err := w.Deployment()
+	if err != nil {
+		return nil, err
+	}
+	svc, err := w.Service()
+	if err != nil {
+		return nil, err
+	}
+	hpa, err := w.HPA()
+	if err != nil {
+		return nil, err
+	}
+	monitor, err := w.ServiceMonitor()
+	if err != nil {
+		return nil, err
+	}
+	// blue/green parents only have a service, selecting the active colour
+	if deployment != nil {
+		objects = append(objects, deployment)
+	}
+	objects = append(objects, svc)
+	if hpa != nil {
+		objects = append(objects, hpa)
+	}
+	if monitor != nil {
+		objects = append(objects, monitor)
+	}
+
+	if !w.BiteService.HasExternalURL() {
+		return withKinds(objects...), nil
+	}
+
+	ingress, err := w.Ingress()
+	if err != nil {
+		return nil, err
+	}
+	objects = append(objects, ingress)
+
+	canary, err := w.CanaryIngress()
+	if err != nil {
+		return nil, err
+	}
+	if canary != nil {
+		objects = append(objects, canary)
+	}
+
+	if k8s.ExternalSecretsEnabled {
+		es, err := w.ExternalSecretTLS()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, es)
+	}
+
+	if w.BiteService.IsServiceMeshEnabled() {
+		gateway, err := w.ServiceMeshGateway()
+		if err != nil {
+			return nil, err
+		}
+		virtualService, err := w.ServiceMeshVirtualService()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, gateway, virtualService)
+	}
+
+	return withKinds(objects...), nil
+}
+
+// withKinds fills in apiVersion and kind of built-in objects, which are
+// left empty by the mapper
+func withKinds(objects ...runtime.Object) []runtime.Object {
+	for _, obj := range objects {
+		gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err != nil || len(gvks) == 0 {
+			continue
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+	}
+	return objects
+}
@@ -0,0 +1,80 @@
+package translator
+
+import (
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestManifests(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.ExternalURL = []string{"www.example.com"}
+	w.BiteService.WeightedBackends = []bitesize.WeightedBackend{
+		{Service: "test", Weight: 90},
+		{Service: "test-next", Weight: 10},
+	}
+
+	objects, err := w.Manifests()
+	if err != nil {
+		t.Fatalf("Unexpected error rendering manifests: %s", err.Error())
+	}
+
+	expected := []string{"Deployment", "Service", "HorizontalPodAutoscaler", "Ingress", "Ingress"}
+	if len(objects) != len(expected) {
+		t.Fatalf("Expected %d objects, got %d", len(expected), len(objects))
+	}
+	for i, obj := range objects {
+		if kind := obj.GetObjectKind().GroupVersionKind().Kind; kind != expected[i] {
+			t.Errorf("Expected object %d to be %s, got %s", i, expected[i], kind)
+		}
+	}
+}
+
+func TestManifestsExternalName(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.Type = bitesize.TypeExternalName
+	w.BiteService.ExternalName = "db.example.com"
+
+	objects, err := w.Manifests()
+	if err != nil {
+		t.Fatalf("Unexpected error rendering manifests: %s", err.Error())
+	}
+	if len(objects) != 1 || objects[0].GetObjectKind().GroupVersionKind().Kind != "Service" {
+		t.Errorf("Expected single Service, got %v", objects)
+	}
+}
+
+func TestManifestsServiceMonitor(t *testing.T) {
+	w := BuildKubeMapper()
+	w.BiteService.ServiceMonitor = &bitesize.ServiceMonitor{Port: 80, Path: "/metrics"}
+
+	objects, err := w.Manifests()
+	if err != nil {
+		t.Fatalf("Unexpected error rendering manifests: %s", err.Error())
+	}
+	last := objects[len(objects)-1]
+	if kind := last.GetObjectKind().GroupVersionKind().Kind; kind != "ServiceMonitor" {
+		t.Errorf("Expected ServiceMonitor to be rendered after the HPA, got %s", kind)
+	}
+}
+
+func TestManifestsBlueGreenParent(t *testing.T) {
+	active := bitesize.GreenService
+	w := BuildKubeMapper()
+	w.BiteService.Deployment = &bitesize.DeploymentSettings{
+		Method:    "bluegreen",
+		BlueGreen: &bitesize.BlueGreenSettings{Active: &active},
+	}
+
+	objects, err := w.Manifests()
+	if err != nil {
+		t.Fatalf("Unexpected error rendering manifests: %s", err.Error())
+	}
+	if len(objects) != 1 || objects[0].GetObjectKind().GroupVersionKind().Kind != "Service" {
+		t.Fatalf("Expected single Service, got %v", objects)
+	}
+	if selector := objects[0].(*v1.Service).Spec.Selector["name"]; selector != "test-green" {
+		t.Errorf("Expected parent service to select test-green, got %s", selector)
+	}
+}
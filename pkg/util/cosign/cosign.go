@@ -0,0 +1,121 @@
+// Package cosign verifies image signatures with the cosign CLI
+package cosign
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+)
+
+// verifyTimeout bounds a single cosign verify run, which fetches the image
+// manifest and signatures from the registry
+var verifyTimeout = 2 * time.Minute
+
+// run executes the cosign binary with env added to the operator's
+// environment and returns its standard output, or its error output on
+// failure
+var run = func(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.Stderr, err
+	}
+	return out, err
+}
+
+// signature is the part of cosign verify JSON output naming the image
+// digest the signature was verified for
+type signature struct {
+	Critical struct {
+		Image struct {
+			Digest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// Enabled returns true if images must be verified before they are deployed
+func Enabled() bool {
+	return config.Env.ImageVerify
+}
+
+// Verify checks that image is signed by COSIGN_KEY or, without a key, by
+// a keyless signature matching COSIGN_CERTIFICATE_IDENTITY and
+// COSIGN_CERTIFICATE_OIDC_ISSUER. It returns image pinned to the digest the
+// signature was verified for, so that a tag pushed again after the check
+// is not deployed. dockerConfig, if set, is a docker config.json holding
+// credentials of private registries
+func Verify(image string, dockerConfig []byte) (string, error) {
+	args, err := verifyArgs(image)
+	if err != nil {
+		return "", err
+	}
+
+	var env []string
+	if len(dockerConfig) != 0 {
+		dir, err := ioutil.TempDir("", "cosign")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), dockerConfig, 0600); err != nil {
+			return "", err
+		}
+		env = append(env, "DOCKER_CONFIG="+dir)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+	defer cancel()
+
+	out, err := run(ctx, env, config.Env.CosignBinary, args...)
+	if err != nil {
+		return "", fmt.Errorf("image %s signature verification failed: %s: %s", image, err.Error(), strings.TrimSpace(string(out)))
+	}
+
+	var signatures []signature
+	if err := json.Unmarshal(out, &signatures); err != nil {
+		return "", fmt.Errorf("image %s: could not parse cosign output: %s", image, err.Error())
+	}
+	if len(signatures) == 0 || signatures[0].Critical.Image.Digest == "" {
+		return "", fmt.Errorf("image %s: cosign reported no verified digest", image)
+	}
+	return Pin(image, signatures[0].Critical.Image.Digest), nil
+}
+
+// Pin returns image referring to digest, keeping its tag for readability.
+// Kubernetes pulls images by digest when both are set
+func Pin(image, digest string) string {
+	return Unpin(image) + "@" + digest
+}
+
+// Unpin returns image without its digest
+func Unpin(image string) string {
+	return strings.SplitN(image, "@", 2)[0]
+}
+
+func verifyArgs(image string) ([]string, error) {
+	args := []string{"verify", "--output", "json"}
+
+	switch {
+	case config.Env.CosignKey != "":
+		args = append(args, "--key", config.Env.CosignKey)
+	case config.Env.CosignCertificateIdentity != "" && config.Env.CosignCertificateOIDCIssuer != "":
+		args = append(args,
+			"--certificate-identity", config.Env.CosignCertificateIdentity,
+			"--certificate-oidc-issuer", config.Env.CosignCertificateOIDCIssuer,
+		)
+	default:
+		return nil, errors.New("image verification requires COSIGN_KEY or COSIGN_CERTIFICATE_IDENTITY and COSIGN_CERTIFICATE_OIDC_ISSUER")
+	}
+
+	return append(args, image), nil
+}
@@ -0,0 +1,137 @@
+package cosign
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+)
+
+// verifiedOutput is cosign verify output of a signature of digest sha256:abc
+const verifiedOutput = `[{"critical":{"identity":{"docker-reference":"registry/app"},"image":{"docker-manifest-digest":"sha256:abc"},"type":"cosign container image signature"},"optional":null}]`
+
+// stubRun replaces run with a stub returning out and err, recording the
+// command it was called with. The returned func restores run
+func stubRun(out string, err error) (*[]string, func()) {
+	var called []string
+	orig := run
+	run = func(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+		called = append([]string{name}, args...)
+		return []byte(out), err
+	}
+	return &called, func() { run = orig }
+}
+
+// setConfig sets cosign configuration, restored by the returned func
+func setConfig(key, identity, issuer string) func() {
+	orig := config.Env
+	config.Env.CosignBinary = "cosign"
+	config.Env.CosignKey = key
+	config.Env.CosignCertificateIdentity = identity
+	config.Env.CosignCertificateOIDCIssuer = issuer
+	return func() { config.Env = orig }
+}
+
+func TestVerifyWithKey(t *testing.T) {
+	defer setConfig("/etc/cosign/cosign.pub", "", "")()
+	called, restore := stubRun(verifiedOutput, nil)
+	defer restore()
+
+	pinned, err := Verify("registry/app:1.0", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if pinned != "registry/app:1.0@sha256:abc" {
+		t.Errorf("Expected image pinned to verified digest, got %s", pinned)
+	}
+	expected := []string{"cosign", "verify", "--output", "json", "--key", "/etc/cosign/cosign.pub", "registry/app:1.0"}
+	if !reflect.DeepEqual(*called, expected) {
+		t.Errorf("Unexpected cosign command. Expected %v, got %v", expected, *called)
+	}
+}
+
+func TestVerifyKeyless(t *testing.T) {
+	defer setConfig("", "https://github.com/pearsontechnology/app/.github/workflows/release.yml@refs/heads/main", "https://token.actions.githubusercontent.com")()
+	called, restore := stubRun(verifiedOutput, nil)
+	defer restore()
+
+	if _, err := Verify("registry/app:1.0", nil); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !strings.Contains(strings.Join(*called, " "), "--certificate-identity https://github.com/pearsontechnology/app/.github/workflows/release.yml@refs/heads/main --certificate-oidc-issuer") {
+		t.Errorf("Expected keyless verification, got %v", *called)
+	}
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	defer setConfig("/etc/cosign/cosign.pub", "", "")()
+	_, restore := stubRun("Error: no matching signatures", errors.New("exit status 1"))
+	defer restore()
+
+	_, err := Verify("registry/app:1.0", nil)
+	if err == nil || !strings.Contains(err.Error(), "registry/app:1.0") || !strings.Contains(err.Error(), "no matching signatures") {
+		t.Errorf("Expected unsigned image error, got %v", err)
+	}
+}
+
+func TestVerifyWithoutPolicy(t *testing.T) {
+	defer setConfig("", "", "")()
+	called, restore := stubRun("", nil)
+	defer restore()
+
+	if _, err := Verify("registry/app:1.0", nil); err == nil {
+		t.Error("Expected error without trusted key or identity")
+	}
+	if len(*called) != 0 {
+		t.Errorf("Expected cosign not to run, got %v", *called)
+	}
+}
+
+func TestVerifyRegistryCredentials(t *testing.T) {
+	defer setConfig("/etc/cosign/cosign.pub", "", "")()
+	orig := run
+	defer func() { run = orig }()
+
+	var dockerConfig string
+	run = func(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+		for _, e := range env {
+			if strings.HasPrefix(e, "DOCKER_CONFIG=") {
+				data, _ := ioutil.ReadFile(filepath.Join(strings.TrimPrefix(e, "DOCKER_CONFIG="), "config.json"))
+				dockerConfig = string(data)
+			}
+		}
+		return []byte(verifiedOutput), nil
+	}
+
+	auths := `{"auths":{"registry":{"auth":"dXNlcjpwYXNz"}}}`
+	if _, err := Verify("registry/app:1.0", []byte(auths)); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if dockerConfig != auths {
+		t.Errorf("Expected cosign to run with registry credentials, got %q", dockerConfig)
+	}
+}
+
+func TestPin(t *testing.T) {
+	var tests = []struct {
+		Image    string
+		Expected string
+	}{
+		{"registry:5000/app:1.0", "registry:5000/app:1.0@sha256:abc"},
+		{"app", "app@sha256:abc"},
+		{"app:1.0@sha256:old", "app:1.0@sha256:abc"},
+	}
+	for _, tst := range tests {
+		if pinned := Pin(tst.Image, "sha256:abc"); pinned != tst.Expected {
+			t.Errorf("Expected %s pinned as %s, got %s", tst.Image, tst.Expected, pinned)
+		}
+	}
+	if image := Unpin("app:1.0@sha256:abc"); image != "app:1.0" {
+		t.Errorf("Expected digest to be removed, got %s", image)
+	}
+}
@@ -3,6 +3,8 @@ package k8s
 import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -37,17 +39,21 @@ func (client *ConfigMap) Apply(resource *v1.ConfigMap) error {
 
 // Update updates existing ingress in k8s
 func (client *ConfigMap) Update(resource *v1.ConfigMap) error {
-	current, err := client.Get(resource.Name)
-	if err != nil {
-		return err
-	}
-	resource.ResourceVersion = current.GetResourceVersion()
+	desired := resource.DeepCopy()
+	return retryOnConflict(func() error {
+		desired.DeepCopyInto(resource)
+		current, err := client.Get(resource.Name)
+		if err != nil {
+			return err
+		}
+		resource.ResourceVersion = current.GetResourceVersion()
 
-	_, err = client.
-		CoreV1().
-		ConfigMaps(client.Namespace).
-		Update(resource)
-	return err
+		_, err = client.
+			CoreV1().
+			ConfigMaps(client.Namespace).
+			Update(resource)
+		return err
+	})
 }
 
 // Create creates new configmap in k8s
@@ -60,8 +66,8 @@ func (client *ConfigMap) Create(resource *v1.ConfigMap) error {
 }
 
 // Destroy deletes configmap from the k8 cluster
-func (client *ConfigMap) Destroy(name string) error {
-	return client.CoreV1().ConfigMaps(client.Namespace).Delete(name, &metav1.DeleteOptions{})
+func (client *ConfigMap) Destroy(name string, opts ...DeleteOptions) error {
+	return client.CoreV1().ConfigMaps(client.Namespace).Delete(name, deleteOptions(opts, ""))
 }
 
 // List returns the list of k8s services maintained by pipeline
@@ -72,3 +78,10 @@ func (client *ConfigMap) List() ([]v1.ConfigMap, error) {
 	}
 	return list.Items, nil
 }
+
+// Watch returns a watch on changes to configmap with the given name
+func (client *ConfigMap) Watch(name string) (watch.Interface, error) {
+	return client.CoreV1().ConfigMaps(client.Namespace).Watch(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+	})
+}
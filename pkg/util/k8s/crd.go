@@ -78,13 +78,21 @@ func (client *CustomResourceDefinition) Update(resource *extensions.PrsnExternal
 	if resource == nil {
 		return nil
 	}
-	var result extensions.PrsnExternalResource
-	return client.Interface.Put().
-		Resource(plural(client.Type)).
-		Name(resource.ObjectMeta.Name).
-		Namespace(client.Namespace).
-		Body(resource).
-		Do().Into(&result)
+	return retryOnConflict(func() error {
+		current, err := client.Get(resource.ObjectMeta.Name)
+		if err != nil {
+			return err
+		}
+		resource.ResourceVersion = current.GetResourceVersion()
+
+		var result extensions.PrsnExternalResource
+		return client.Interface.Put().
+			Resource(plural(client.Type)).
+			Name(resource.ObjectMeta.Name).
+			Namespace(client.Namespace).
+			Body(resource).
+			Do().Into(&result)
+	})
 }
 
 // Destroy deletes named resource
@@ -102,6 +110,7 @@ func (client *CustomResourceDefinition) List() ([]extensions.PrsnExternalResourc
 	err := client.Interface.Get().
 		Resource(plural(client.Type)).
 		Namespace(client.Namespace).
+		Param("labelSelector", listOptions().LabelSelector).
 		Do().Into(&result)
 	if err != nil {
 		return nil, err
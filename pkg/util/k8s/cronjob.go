@@ -2,7 +2,6 @@ package k8s
 
 import (
 	v1beta1 "k8s.io/api/batch/v1beta1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -42,22 +41,26 @@ func (client *CronJob) Create(resource *v1beta1.CronJob) error {
 
 // Update updates existing service in k8s
 func (client *CronJob) Update(resource *v1beta1.CronJob) error {
-	current, err := client.Get(resource.Name)
-	if err != nil {
-		return err
-	}
-	resource.ResourceVersion = current.GetResourceVersion()
+	desired := resource.DeepCopy()
+	return retryOnConflict(func() error {
+		desired.DeepCopyInto(resource)
+		current, err := client.Get(resource.Name)
+		if err != nil {
+			return err
+		}
+		resource.ResourceVersion = current.GetResourceVersion()
 
-	_, err = client.
-		BatchV1beta1().
-		CronJobs(client.Namespace).
-		Update(resource)
-	return err
+		_, err = client.
+			BatchV1beta1().
+			CronJobs(client.Namespace).
+			Update(resource)
+		return err
+	})
 }
 
 // Destroy deletes service from the k8 cluster
-func (client *CronJob) Destroy(name string) error {
-	return client.BatchV1beta1().CronJobs(client.Namespace).Delete(name, &metav1.DeleteOptions{})
+func (client *CronJob) Destroy(name string, opts ...DeleteOptions) error {
+	return client.BatchV1beta1().CronJobs(client.Namespace).Delete(name, deleteOptions(opts, ""))
 }
 
 // List returns the list of k8s services maintained by pipeline
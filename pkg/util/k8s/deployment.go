@@ -2,12 +2,26 @@ package k8s
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	apps_v1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 )
 
+// RestartedAtAnnotation is set on the pod template to trigger a rolling
+// restart, same as `kubectl rollout restart` does
+const RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// ReplicasAnnotation on a deployment holds its replica count when it is
+// owned by an external scaler. Operator keeps it instead of the configured
+// replicas
+const ReplicasAnnotation = "environment-operator/replicas"
+
 // Deployment type actions on ingresses in k8s cluster
 type Deployment struct {
 	kubernetes.Interface
@@ -28,6 +42,59 @@ func (client *Deployment) Exist(name string) bool {
 	return err == nil
 }
 
+// Ready returns true if deployment exists and all of its replicas are
+// updated and available
+func (client *Deployment) Ready(name string) bool {
+	return client.ReadyThreshold(name, "")
+}
+
+// ReadyThreshold returns true if deployment exists and at least threshold
+// of its replicas are updated and available. Threshold is either a number
+// of replicas ("2") or a percentage of desired replicas ("50%", rounded
+// up). Empty threshold requires all replicas; thresholds above the number
+// of desired replicas are capped to it
+func (client *Deployment) ReadyThreshold(name, threshold string) bool {
+	deployment, err := client.Get(name)
+	if err != nil {
+		return false
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+
+	required := replicas
+	if threshold != "" {
+		t := intstr.Parse(threshold)
+		n, err := intstr.GetValueFromIntOrPercent(&t, int(replicas), true)
+		if err != nil {
+			log.Warnf("deployment %s: invalid ready threshold %q, waiting for all replicas", name, threshold)
+		} else if int32(n) < replicas {
+			required = int32(n)
+		}
+	}
+
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas >= required &&
+		deployment.Status.AvailableReplicas >= required
+}
+
+// AnnotatedReplicas returns replica count set in ReplicasAnnotation.
+// Returns false if annotation is not set or is not a valid count
+func AnnotatedReplicas(meta metav1.ObjectMeta) (int32, bool) {
+	value, ok := meta.Annotations[ReplicasAnnotation]
+	if !ok {
+		return 0, false
+	}
+	replicas, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || replicas < 0 {
+		log.Warnf("deployment %s: ignoring invalid %s annotation %q", meta.Name, ReplicasAnnotation, value)
+		return 0, false
+	}
+	return int32(replicas), true
+}
+
 // Apply updates or creates deployment in k8s
 func (client *Deployment) Apply(deployment *apps_v1.Deployment) error {
 	if deployment == nil {
@@ -44,15 +111,51 @@ func (client *Deployment) Update(deployment *apps_v1.Deployment) error {
 	if deployment == nil {
 		return nil
 	}
+	desired := deployment.DeepCopy()
+	return retryOnConflict(func() error {
+		desired.DeepCopyInto(deployment)
+		return client.update(deployment)
+	})
+}
+
+// update makes a single attempt to update deployment, keeping replicas and
+// settings of the current deployment the operator doesn't own
+func (client *Deployment) update(deployment *apps_v1.Deployment) error {
 	current, err := client.Get(deployment.Name)
 	if err != nil {
 		return err
 	}
 	deployment.ResourceVersion = current.GetResourceVersion()
+	// deployments without replicas are scaled by HPA, keep the current count
+	if deployment.Spec.Replicas == nil {
+		deployment.Spec.Replicas = current.Spec.Replicas
+	}
+	// replicas owned by an external scaler are kept with their annotation
+	if value, ok := current.Annotations[ReplicasAnnotation]; ok {
+		if _, set := deployment.Annotations[ReplicasAnnotation]; !set {
+			if deployment.Annotations == nil {
+				deployment.Annotations = map[string]string{}
+			}
+			deployment.Annotations[ReplicasAnnotation] = value
+		}
+	}
+	if replicas, ok := AnnotatedReplicas(deployment.ObjectMeta); ok {
+		deployment.Spec.Replicas = &replicas
+	}
 	if deployment.ObjectMeta.Labels["version"] == "" {
 		deployment.ObjectMeta.Labels["version"] = current.ObjectMeta.Labels["version"]
 	}
 
+	// keep restart marker so an update doesn't roll the pods again
+	if restartedAt, ok := current.Spec.Template.Annotations[RestartedAtAnnotation]; ok {
+		if deployment.Spec.Template.Annotations == nil {
+			deployment.Spec.Template.Annotations = map[string]string{}
+		}
+		if deployment.Spec.Template.Annotations[RestartedAtAnnotation] == "" {
+			deployment.Spec.Template.Annotations[RestartedAtAnnotation] = restartedAt
+		}
+	}
+
 	if len(current.Spec.Template.Spec.Containers) > 0 &&
 		len(deployment.Spec.Template.Spec.Containers) > 0 &&
 		deployment.Spec.Template.Spec.Containers[0].Image == "" {
@@ -71,6 +174,9 @@ func (client *Deployment) Create(deployment *apps_v1.Deployment) error {
 	if deployment == nil {
 		return nil
 	}
+	if replicas, ok := AnnotatedReplicas(deployment.ObjectMeta); ok {
+		deployment.Spec.Replicas = &replicas
+	}
 	if len(deployment.Spec.Template.Spec.Containers) > 0 &&
 		deployment.Spec.Template.Spec.Containers[0].Image != "" {
 		_, err = client.
@@ -82,12 +188,22 @@ func (client *Deployment) Create(deployment *apps_v1.Deployment) error {
 	return fmt.Errorf("Error creating deployment %s; image not set", deployment.Name)
 }
 
+// Restart triggers a rolling restart of deployment pods by updating the
+// pod template restart annotation
+func (client *Deployment) Restart(name string) error {
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"%s":"%s"}}}}}`,
+		RestartedAtAnnotation, time.Now().Format(time.RFC3339))
+
+	_, err := client.
+		AppsV1().
+		Deployments(client.Namespace).
+		Patch(name, types.StrategicMergePatchType, []byte(patch))
+	return err
+}
+
 // Destroy deletes deployment from the k8 cluster
-func (client *Deployment) Destroy(name string) error {
-	deletePolicy := metav1.DeletePropagationForeground
-	options := &metav1.DeleteOptions{
-		PropagationPolicy: &deletePolicy,
-	}
+func (client *Deployment) Destroy(name string, opts ...DeleteOptions) error {
+	options := deleteOptions(opts, metav1.DeletePropagationForeground)
 	return client.AppsV1().Deployments(client.Namespace).Delete(name, options)
 }
 
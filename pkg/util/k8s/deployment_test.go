@@ -109,6 +109,116 @@ func TestDeploymentApplyExisting(t *testing.T) {
 	}
 }
 
+func TestDeploymentApplyAnnotatedReplicas(t *testing.T) {
+	d := createDeployment()
+	current, _ := d.Get("test")
+	current.Annotations = map[string]string{ReplicasAnnotation: "7"}
+	d.AppsV1().Deployments("sample").Update(current)
+
+	replicas := int32(2)
+	desired := current.DeepCopy()
+	desired.Annotations = nil
+	desired.Spec.Replicas = &replicas
+	if err := d.Apply(desired); err != nil {
+		t.Fatalf("Unexpected error applying deployment: %s", err.Error())
+	}
+
+	m, _ := d.Get("test")
+	if *m.Spec.Replicas != 7 {
+		t.Errorf("Expected annotated replicas to be kept, got %d", *m.Spec.Replicas)
+	}
+	if m.Annotations[ReplicasAnnotation] != "7" {
+		t.Errorf("Expected %s annotation to be kept, got %v", ReplicasAnnotation, m.Annotations)
+	}
+
+	m.Annotations[ReplicasAnnotation] = "many"
+	d.AppsV1().Deployments("sample").Update(m)
+	desired = m.DeepCopy()
+	desired.Annotations = nil
+	desired.Spec.Replicas = &replicas
+	if err := d.Apply(desired); err != nil {
+		t.Fatalf("Unexpected error applying deployment: %s", err.Error())
+	}
+	m, _ = d.Get("test")
+	if *m.Spec.Replicas != 2 {
+		t.Errorf("Expected configured replicas with invalid annotation, got %d", *m.Spec.Replicas)
+	}
+}
+
+func TestDeploymentRestart(t *testing.T) {
+	d := createDeployment()
+	if err := d.Restart("test"); err != nil {
+		t.Fatalf("Unexpected error restarting deployment: %s", err.Error())
+	}
+
+	m, _ := d.Get("test")
+	if m.Spec.Template.Annotations[RestartedAtAnnotation] == "" {
+		t.Errorf("Expected %s annotation to be set on pod template", RestartedAtAnnotation)
+	}
+
+	if err := d.Restart("nonexistent"); err == nil {
+		t.Error("Expected error restarting nonexistent deployment")
+	}
+}
+
+func TestDeploymentReadyThreshold(t *testing.T) {
+	replicas := int32(4)
+	d := Deployment{
+		Interface: fake.NewSimpleClientset(&apps_v1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "sample"},
+			Spec:       apps_v1.DeploymentSpec{Replicas: &replicas},
+			Status:     apps_v1.DeploymentStatus{UpdatedReplicas: 3, AvailableReplicas: 2},
+		}),
+		Namespace: "sample",
+	}
+
+	var tests = []struct {
+		Threshold string
+		Expected  bool
+	}{
+		{"", false},
+		{"2", true},
+		{"3", false},
+		{"50%", true},
+		{"60%", false},
+		{"10", false},
+		{"invalid", false},
+	}
+
+	for _, tst := range tests {
+		if d.ReadyThreshold("test", tst.Threshold) != tst.Expected {
+			t.Errorf("Unexpected readiness for threshold %q, expected %t", tst.Threshold, tst.Expected)
+		}
+	}
+
+	if d.ReadyThreshold("nonexistent", "1") {
+		t.Error("Expected nonexistent deployment not to be ready")
+	}
+}
+
+func TestDeploymentListSkipsManifests(t *testing.T) {
+	clientset := createSimpleDeploymentClient()
+	clientset.AppsV1().Deployments("sample").Create(&apps_v1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "raw",
+			Namespace: "sample",
+			Labels: map[string]string{
+				"creator":     "pipeline",
+				ManifestLabel: "extras",
+			},
+		},
+	})
+	client := Deployment{Interface: clientset, Namespace: "sample"}
+
+	list, err := client.List()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(list) != 1 || list[0].Name != "test" {
+		t.Errorf("Expected deployments of manifest gists not to be listed, got %d deployments", len(list))
+	}
+}
+
 func createDeployment() Deployment {
 	return Deployment{
 		Interface: createSimpleDeploymentClient(),
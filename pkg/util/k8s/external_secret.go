@@ -17,6 +17,10 @@ func init() {
 
 var ExternalSecretsEnabled = false
 
+// EnvSecretLabel marks ExternalSecrets of env vars with external_key, set
+// to the secret name the env vars are configured with
+const EnvSecretLabel = "env-secret"
+
 // ExternalSecret represents ExternalSecret crd on the cluster
 type ExternalSecret struct {
 	rest.Interface
@@ -89,13 +93,21 @@ func (client *ExternalSecret) Update(resource *extensions.ExternalSecret) error
 	if resource == nil {
 		return nil
 	}
-	var result extensions.ExternalSecret
-	return client.Interface.Put().
-		Resource(plural(client.Type)).
-		Name(resource.ObjectMeta.Name).
-		Namespace(client.Namespace).
-		Body(resource).
-		Do().Into(&result)
+	return retryOnConflict(func() error {
+		current, err := client.Get(resource.ObjectMeta.Name)
+		if err != nil {
+			return err
+		}
+		resource.ResourceVersion = current.GetResourceVersion()
+
+		var result extensions.ExternalSecret
+		return client.Interface.Put().
+			Resource(plural(client.Type)).
+			Name(resource.ObjectMeta.Name).
+			Namespace(client.Namespace).
+			Body(resource).
+			Do().Into(&result)
+	})
 }
 
 // Destroy deletes named resource
@@ -113,6 +125,7 @@ func (client *ExternalSecret) List() ([]extensions.ExternalSecret, error) {
 	err := client.Interface.Get().
 		Resource(plural(client.Type)).
 		Namespace(client.Namespace).
+		Param("labelSelector", listOptions().LabelSelector).
 		Do().Into(&result)
 	if err != nil {
 		return nil, err
@@ -14,8 +14,9 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/rest/fake"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 type fakeCRD struct {
@@ -88,19 +89,39 @@ func (f *fakeCRD) HandleRequest(req *http.Request) (*http.Response, error) {
 
 var manager *runtime.Scheme
 
-// CRDClient returns fake REST client to be used in TPR unit tests.
-func CRDClient(group string, version string, objects ...runtime.Object) *fake.RESTClient {
+// CRDClient returns fake REST client to be used in TPR unit tests. Unlike
+// fake.RESTClient, it is safe for concurrent use, as custom resources of
+// different kinds are listed concurrently
+func CRDClient(group string, version string, objects ...runtime.Object) *rest.RESTClient {
 	var schemeGroupVersion = schema.GroupVersion{Group: group, Version: version}
 
 	f := &fakeCRD{
 		Store: objectStore(objects),
 	}
 
-	return &fake.RESTClient{
-		GroupVersion:         schemeGroupVersion,
-		NegotiatedSerializer: serializer.WithoutConversionCodecFactory{CodecFactory: scheme.Codecs},
-		Client:               fake.CreateHTTPClient(f.HandleRequest),
+	prefix := "/" + group + "/" + version
+	client, err := rest.RESTClientFor(&rest.Config{
+		Host: "localhost",
+		ContentConfig: rest.ContentConfig{
+			GroupVersion:         &schemeGroupVersion,
+			NegotiatedSerializer: serializer.WithoutConversionCodecFactory{CodecFactory: scheme.Codecs},
+		},
+		RateLimiter: flowcontrol.NewFakeAlwaysRateLimiter(),
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.URL.Path = strings.TrimPrefix(req.URL.Path, prefix)
+			return f.HandleRequest(req)
+		}),
+	})
+	if err != nil {
+		panic(err)
 	}
+	return client
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
 }
 
 func objectStore(objects []runtime.Object) cache.Store {
@@ -1,6 +1,8 @@
 package k8s
 
 import (
+	"reflect"
+
 	autoscale_v2beta2 "k8s.io/api/autoscaling/v2beta2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -23,12 +25,16 @@ func (client *HorizontalPodAutoscaler) Exist(name string) bool {
 	return err == nil
 }
 
-// Apply updates or creates hpa in k8s
+// Apply updates or creates hpa in k8s. Existing hpa is left untouched
+// if its spec and labels already match
 func (client *HorizontalPodAutoscaler) Apply(resource *autoscale_v2beta2.HorizontalPodAutoscaler) error {
 	if resource == nil {
 		return nil
 	}
-	if client.Exist(resource.Name) {
+	if current, err := client.Get(resource.Name); err == nil {
+		if reflect.DeepEqual(current.Spec, resource.Spec) && reflect.DeepEqual(current.Labels, resource.Labels) {
+			return nil
+		}
 		return client.Update(resource)
 	}
 	return client.Create(resource)
@@ -53,13 +59,23 @@ func (client *HorizontalPodAutoscaler) Update(resource *autoscale_v2beta2.Horizo
 	if resource == nil {
 		return nil
 	}
-	_, err := client.AutoscalingV2beta2().HorizontalPodAutoscalers(client.Namespace).Update(resource)
-	return err
+	desired := resource.DeepCopy()
+	return retryOnConflict(func() error {
+		desired.DeepCopyInto(resource)
+		current, err := client.Get(resource.Name)
+		if err != nil {
+			return err
+		}
+		resource.ResourceVersion = current.GetResourceVersion()
+
+		_, err = client.AutoscalingV2beta2().HorizontalPodAutoscalers(client.Namespace).Update(resource)
+		return err
+	})
 }
 
 // Destroy deletes service from the k8 cluster
-func (client *HorizontalPodAutoscaler) Destroy(name string) error {
-	return client.AutoscalingV2beta2().HorizontalPodAutoscalers(client.Namespace).Delete(name, &metav1.DeleteOptions{})
+func (client *HorizontalPodAutoscaler) Destroy(name string, opts ...DeleteOptions) error {
+	return client.AutoscalingV2beta2().HorizontalPodAutoscalers(client.Namespace).Delete(name, deleteOptions(opts, ""))
 }
 
 // List returns the list of k8s hpa
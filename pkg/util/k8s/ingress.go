@@ -1,11 +1,144 @@
 package k8s
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	netwk_v1beta1 "k8s.io/api/networking/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
+// CertManagerClusterIssuerAnnotation requests cert-manager certificate for
+// ingress TLS hosts from the named ClusterIssuer
+const CertManagerClusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+
+// CertManagerIssuerAnnotation requests cert-manager certificate for ingress
+// TLS hosts from the named namespaced Issuer
+const CertManagerIssuerAnnotation = "cert-manager.io/issuer"
+
+// managedIngressAnnotations are owned by the operator and removed from the
+// ingress once no longer desired, as are annotations listed in
+// OwnedIngressAnnotationsAnnotation. Any other annotation on an existing
+// ingress has been set by someone else (e.g. cert-manager) and is kept on
+// update
+var managedIngressAnnotations = map[string]bool{
+	CertManagerClusterIssuerAnnotation:          true,
+	CertManagerIssuerAnnotation:                 true,
+	"nginx.ingress.kubernetes.io/canary":        true,
+	"nginx.ingress.kubernetes.io/canary-weight": true,
+}
+
+// BackendHealthCheckAnnotation records backend_health_check settings of the
+// service on its ingress
+const BackendHealthCheckAnnotation = "environment-operator/backend-health-check"
+
+// OwnedIngressAnnotationsAnnotation lists other annotations the operator
+// set on the ingress, comma separated. Listed annotations are removed on
+// update once they are no longer desired
+const OwnedIngressAnnotationsAnnotation = "environment-operator/ingress-annotations"
+
+// healthCheckAnnotations name annotations ingress controllers configure
+// active backend health checks with
+type healthCheckAnnotations struct {
+	// enabled is set to "true" if the controller needs checks turned on
+	enabled  string
+	path     string
+	interval string
+	// intervalFormat formats interval seconds
+	intervalFormat string
+	// httpOnly controllers can't check backends by TCP connect
+	httpOnly bool
+}
+
+var ingressHealthChecks = map[string]healthCheckAnnotations{
+	"alb": {
+		path:           "alb.ingress.kubernetes.io/healthcheck-path",
+		interval:       "alb.ingress.kubernetes.io/healthcheck-interval-seconds",
+		intervalFormat: "%d",
+		httpOnly:       true,
+	},
+	"haproxy": {
+		enabled:        "haproxy.org/check",
+		path:           "haproxy.org/check-http",
+		interval:       "haproxy.org/check-interval",
+		intervalFormat: "%ds",
+	},
+}
+
+func init() {
+	managedIngressAnnotations[BackendHealthCheckAnnotation] = true
+	managedIngressAnnotations[OwnedIngressAnnotationsAnnotation] = true
+	for _, a := range ingressHealthChecks {
+		for _, name := range []string{a.enabled, a.path, a.interval} {
+			if name != "" {
+				managedIngressAnnotations[name] = true
+			}
+		}
+	}
+}
+
+// HealthCheckAnnotations returns annotations requesting controller to check
+// backends with HTTP GET of path, or TCP connect if path is empty, every
+// interval. Zero interval leaves the controller's default
+func HealthCheckAnnotations(controller, path string, interval time.Duration) (map[string]string, error) {
+	a, ok := ingressHealthChecks[controller]
+	if !ok {
+		return nil, fmt.Errorf("INGRESS_CONTROLLER %q does not support backend health checks", controller)
+	}
+	if path == "" && a.httpOnly {
+		return nil, fmt.Errorf("%s can only check backends with HTTP, path is required", controller)
+	}
+	retval := map[string]string{}
+	if a.enabled != "" {
+		retval[a.enabled] = "true"
+	}
+	if path != "" {
+		retval[a.path] = path
+	}
+	if interval != 0 {
+		retval[a.interval] = fmt.Sprintf(a.intervalFormat, int(interval.Seconds()))
+	}
+	return retval, nil
+}
+
+// IsManagedIngressAnnotation returns true if annotation is set by the
+// operator for features other than ingress_annotations
+func IsManagedIngressAnnotation(name string) bool {
+	return managedIngressAnnotations[name]
+}
+
+// OwnedIngressAnnotations returns annotations listed in
+// OwnedIngressAnnotationsAnnotation of the ingress
+func OwnedIngressAnnotations(metadata metav1.ObjectMeta) map[string]bool {
+	retval := map[string]bool{}
+	for _, k := range strings.Split(metadata.Annotations[OwnedIngressAnnotationsAnnotation], ",") {
+		if k != "" {
+			retval[k] = true
+		}
+	}
+	return retval
+}
+
+// setOwnedIngressAnnotations records annotations of the ingress, other than
+// the managed ones, in OwnedIngressAnnotationsAnnotation
+func setOwnedIngressAnnotations(resource *netwk_v1beta1.Ingress) {
+	var owned []string
+	for k := range resource.Annotations {
+		if !managedIngressAnnotations[k] {
+			owned = append(owned, k)
+		}
+	}
+	delete(resource.Annotations, OwnedIngressAnnotationsAnnotation)
+	if len(owned) == 0 {
+		return
+	}
+	sort.Strings(owned)
+	resource.Annotations[OwnedIngressAnnotationsAnnotation] = strings.Join(owned, ",")
+}
+
 // Ingress type actions on ingresses in k8s cluster
 type Ingress struct {
 	kubernetes.Interface
@@ -43,17 +176,56 @@ func (client *Ingress) Update(resource *netwk_v1beta1.Ingress) error {
 	if resource == nil {
 		return nil
 	}
-	current, err := client.Get(resource.Name)
-	if err != nil {
+	desired := resource.DeepCopy()
+	return retryOnConflict(func() error {
+		desired.DeepCopyInto(resource)
+		current, err := client.Get(resource.Name)
+		if err != nil {
+			return err
+		}
+		resource.ResourceVersion = current.GetResourceVersion()
+		setOwnedIngressAnnotations(resource)
+
+		owned := OwnedIngressAnnotations(current.ObjectMeta)
+		for k, v := range current.Annotations {
+			if _, ok := resource.Annotations[k]; ok || managedIngressAnnotations[k] || owned[k] {
+				continue
+			}
+			if resource.Annotations == nil {
+				resource.Annotations = map[string]string{}
+			}
+			resource.Annotations[k] = v
+		}
+
+		_, err = client.
+			NetworkingV1beta1().
+			Ingresses(client.Namespace).
+			Update(resource)
 		return err
-	}
-	resource.ResourceVersion = current.GetResourceVersion()
+	})
+}
 
-	_, err = client.
-		NetworkingV1beta1().
-		Ingresses(client.Namespace).
-		Update(resource)
-	return err
+// Replace updates existing ingress to resource as is. Unlike Update it
+// doesn't keep annotations added since resource was read, it restores
+// ingresses to their prior state
+func (client *Ingress) Replace(resource *netwk_v1beta1.Ingress) error {
+	if resource == nil {
+		return nil
+	}
+	desired := resource.DeepCopy()
+	return retryOnConflict(func() error {
+		desired.DeepCopyInto(resource)
+		current, err := client.Get(resource.Name)
+		if err != nil {
+			return err
+		}
+		resource.ResourceVersion = current.GetResourceVersion()
+		_, err = client.
+			NetworkingV1beta1().
+			Ingresses(client.Namespace).
+			Update(resource)
+		return err
+	})
 }
 
 // Create creates new ingress in k8s
@@ -61,6 +233,7 @@ func (client *Ingress) Create(resource *netwk_v1beta1.Ingress) error {
 	if resource == nil {
 		return nil
 	}
+	setOwnedIngressAnnotations(resource)
 	_, err := client.
 		NetworkingV1beta1().
 		Ingresses(client.Namespace).
@@ -69,8 +242,8 @@ func (client *Ingress) Create(resource *netwk_v1beta1.Ingress) error {
 }
 
 // Destroy deletes ingress from the k8 cluster
-func (client *Ingress) Destroy(name string) error {
-	return client.NetworkingV1beta1().Ingresses(client.Namespace).Delete(name, &metav1.DeleteOptions{})
+func (client *Ingress) Destroy(name string, opts ...DeleteOptions) error {
+	return client.NetworkingV1beta1().Ingresses(client.Namespace).Delete(name, deleteOptions(opts, ""))
 }
 
 // List returns the list of k8s services maintained by pipeline
@@ -4,8 +4,11 @@ import (
 	"testing"
 
 	netwk_v1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestIngressGet(t *testing.T) {
@@ -78,6 +81,119 @@ func TestIngressApplyExisting(t *testing.T) {
 	// }
 }
 
+func TestIngressUpdateKeepsForeignAnnotations(t *testing.T) {
+	client := createIngress()
+	current, _ := client.Get("test")
+	current.Annotations = map[string]string{
+		"cert-manager.io/issue-temporary-certificate": "true",
+		CertManagerClusterIssuerAnnotation:            "letsencrypt",
+	}
+	if _, err := client.NetworkingV1beta1().Ingresses("sample").Update(current); err != nil {
+		t.Fatalf("Unexpected error annotating ingress: %s", err.Error())
+	}
+
+	desired := &netwk_v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "sample",
+		},
+	}
+	if err := client.Apply(desired); err != nil {
+		t.Fatalf("Unexpected error applying ingress: %s", err.Error())
+	}
+
+	m, _ := client.Get("test")
+	if m.Annotations["cert-manager.io/issue-temporary-certificate"] != "true" {
+		t.Errorf("Expected cert-manager annotation to be kept, got %v", m.Annotations)
+	}
+	if _, ok := m.Annotations[CertManagerClusterIssuerAnnotation]; ok {
+		t.Errorf("Expected operator-managed issuer annotation to be removed, got %v", m.Annotations)
+	}
+}
+
+func TestIngressUpdateRemovesOwnedAnnotations(t *testing.T) {
+	client := createIngress()
+	desired := &netwk_v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "sample",
+			Annotations: map[string]string{
+				"alb.ingress.kubernetes.io/scheme":      "internet-facing",
+				"alb.ingress.kubernetes.io/target-type": "ip",
+			},
+		},
+	}
+	if err := client.Apply(desired); err != nil {
+		t.Fatalf("Unexpected error applying ingress: %s", err.Error())
+	}
+
+	current, _ := client.Get("test")
+	if a := current.Annotations[OwnedIngressAnnotationsAnnotation]; a != "alb.ingress.kubernetes.io/scheme,alb.ingress.kubernetes.io/target-type" {
+		t.Errorf("Expected applied annotations to be recorded, got %q", a)
+	}
+	current.Annotations["alb.ingress.kubernetes.io/load-balancer-name"] = "k8s-sample"
+	client.NetworkingV1beta1().Ingresses("sample").Update(current)
+
+	desired = &netwk_v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "sample",
+			Annotations: map[string]string{"alb.ingress.kubernetes.io/target-type": "ip"},
+		},
+	}
+	if err := client.Apply(desired); err != nil {
+		t.Fatalf("Unexpected error applying ingress: %s", err.Error())
+	}
+
+	m, _ := client.Get("test")
+	if _, ok := m.Annotations["alb.ingress.kubernetes.io/scheme"]; ok {
+		t.Errorf("Expected annotation removed from config to be removed, got %v", m.Annotations)
+	}
+	if m.Annotations["alb.ingress.kubernetes.io/load-balancer-name"] != "k8s-sample" {
+		t.Errorf("Expected annotation of other tools to be kept, got %v", m.Annotations)
+	}
+	if a := m.Annotations[OwnedIngressAnnotationsAnnotation]; a != "alb.ingress.kubernetes.io/target-type" {
+		t.Errorf("Expected owned annotations to be updated, got %q", a)
+	}
+}
+
+func TestIngressUpdateRetriesOnConflict(t *testing.T) {
+	fakeClient := createSimpleIngressClient()
+	updates := 0
+	fakeClient.PrependReactor("update", "ingresses", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates > 1 {
+			return false, nil, nil
+		}
+		// cert-manager annotates the ingress between the operator's get and update
+		current, _ := fakeClient.Tracker().Get(action.GetResource(), "sample", "test")
+		annotated := current.(*netwk_v1beta1.Ingress).DeepCopy()
+		annotated.Annotations = map[string]string{"cert-manager.io/issue-temporary-certificate": "true"}
+		fakeClient.Tracker().Update(action.GetResource(), annotated, "sample")
+		return true, nil, errors.NewConflict(action.GetResource().GroupResource(), "test", nil)
+	})
+	client := Ingress{Interface: fakeClient, Namespace: "sample"}
+
+	desired := &netwk_v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "sample",
+			Annotations: map[string]string{"team": "platform"},
+		},
+	}
+	if err := client.Update(desired); err != nil {
+		t.Fatalf("Unexpected error updating ingress: %s", err.Error())
+	}
+	if updates != 2 {
+		t.Errorf("Expected update to be retried once, got %d attempts", updates)
+	}
+
+	m, _ := client.Get("test")
+	if m.Annotations["cert-manager.io/issue-temporary-certificate"] != "true" || m.Annotations["team"] != "platform" {
+		t.Errorf("Expected annotations of both writers, got %v", m.Annotations)
+	}
+}
+
 func createIngress() Ingress {
 	return Ingress{
 		Interface: createSimpleIngressClient(),
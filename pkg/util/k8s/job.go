@@ -2,7 +2,6 @@ package k8s
 
 import (
 	v1batch "k8s.io/api/batch/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -42,23 +41,27 @@ func (client *Job) Create(resource *v1batch.Job) error {
 
 // Update updates existing job in k8s
 func (client *Job) Update(job *v1batch.Job) error {
-	current, err := client.Get(job.Name)
-	if err != nil {
+	desired := job.DeepCopy()
+	return retryOnConflict(func() error {
+		desired.DeepCopyInto(job)
+		current, err := client.Get(job.Name)
+		if err != nil {
+			return err
+		}
+		job.ResourceVersion = current.GetResourceVersion()
+		_, err = client.
+			BatchV1().
+			Jobs(client.Namespace).
+			Update(job)
 		return err
-	}
-	job.ResourceVersion = current.GetResourceVersion()
-	_, err = client.
-		BatchV1().
-		Jobs(client.Namespace).
-		Update(job)
-	return err
+	})
 }
 
 // Destroy deletes service from the k8 cluster
-func (client *Job) Destroy(name string) error {
+func (client *Job) Destroy(name string, opts ...DeleteOptions) error {
 	return client.
 		BatchV1().
-		Jobs(client.Namespace).Delete(name, &metav1.DeleteOptions{})
+		Jobs(client.Namespace).Delete(name, deleteOptions(opts, ""))
 }
 
 // List returns the list of k8s services maintained by pipeline
@@ -1,6 +1,7 @@
 package k8s
 
 import (
+	"github.com/pearsontechnology/environment-operator/pkg/config"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -9,6 +10,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 )
 
 // Client is a top level struct, wrapping all other clients
@@ -18,9 +20,39 @@ type Client struct {
 	CRDClient rest.Interface
 }
 
+// RestConfig returns in-cluster REST config, identifying the operator
+// with the configured field manager name
+func RestConfig() (*rest.Config, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	return withFieldManager(restConfig), nil
+}
+
+// withFieldManager sets user agent of the config to FIELD_MANAGER. API
+// server records changes made without explicit fieldManager under the
+// user agent name in object's managedFields.
+func withFieldManager(restConfig *rest.Config) *rest.Config {
+	if config.Env.FieldManager != "" {
+		restConfig.UserAgent = config.Env.FieldManager
+	}
+	return restConfig
+}
+
+// retryOnConflict runs update until it succeeds or fails with other than a
+// conflict, retrying UPDATE_CONFLICT_RETRIES times at most. update has to
+// read the current object itself, so that each attempt is based on its
+// latest version
+func retryOnConflict(update func() error) error {
+	backoff := retry.DefaultRetry
+	backoff.Steps = config.Env.UpdateConflictRetries + 1
+	return retry.RetryOnConflict(backoff, update)
+}
+
 // ClientForNamespace configures REST client to operate in a given namespace
 func ClientForNamespace(ns string) (*Client, error) {
-	restConfig, err := rest.InClusterConfig()
+	restConfig, err := RestConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -39,7 +71,7 @@ func ClientForNamespace(ns string) (*Client, error) {
 
 // CRDClient returns rest.RESTClient for CustomResourceDefinitions
 func CRDClient(groupVersion *schema.GroupVersion) (*rest.RESTClient, error) {
-	config, err := rest.InClusterConfig()
+	config, err := RestConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -105,6 +137,11 @@ func (c *Client) StatefulSet() *StatefulSet {
 	return &StatefulSet{Interface: c.Interface, Namespace: c.Namespace}
 }
 
+// Manifest builds client for objects of arbitrary kind
+func (c *Client) Manifest() *Manifest {
+	return &Manifest{Interface: c.Interface, Namespace: c.Namespace}
+}
+
 // Ns builds Ingress client
 func (c *Client) Ns() *Namespace {
 	return &Namespace{Interface: c.Interface, Namespace: c.Namespace}
@@ -135,20 +172,74 @@ func (c *Client) ExternalSecret() *ExternalSecret {
 	}
 }
 
+// listOptions selects objects managed by the operator. Objects applied from
+// manifest gists are left out, they are tracked by the gist's inventory
 func listOptions() metav1.ListOptions {
+	selector := "!" + ManifestLabel
+	if config.Env.ManagedLabelSelector != "" {
+		selector = config.Env.ManagedLabelSelector + "," + selector
+	}
 	return metav1.ListOptions{
-		LabelSelector: "creator=pipeline",
+		LabelSelector: selector,
+	}
+}
+
+// DeleteOptions configure how Destroy deletes an object
+type DeleteOptions struct {
+	// Propagation policy for dependents: Foreground, Background or Orphan.
+	// Defaults to the policy of the client, or the resource's default
+	Propagation metav1.DeletionPropagation
+	// GracePeriodSeconds overrides grace period of the object when set
+	GracePeriodSeconds *int64
+}
+
+// DeleteOptionsFor returns options objects of the given kind (e.g.
+// deployment, pvc) are deleted with, as set by DELETE_PROPAGATION and
+// DELETE_GRACE_PERIOD
+func DeleteOptionsFor(kind string) DeleteOptions {
+	retval := DeleteOptions{
+		Propagation: metav1.DeletionPropagation(config.Env.DeletePropagation[kind]),
+	}
+	if config.Env.DeleteGracePeriod >= 0 {
+		gracePeriod := int64(config.Env.DeleteGracePeriod)
+		retval.GracePeriodSeconds = &gracePeriod
+	}
+	return retval
+}
+
+// deleteOptions builds options for Destroy from the first of opts, falling
+// back to propagation when none is set
+func deleteOptions(opts []DeleteOptions, propagation metav1.DeletionPropagation) *metav1.DeleteOptions {
+	retval := &metav1.DeleteOptions{}
+	if len(opts) > 0 {
+		if opts[0].Propagation != "" {
+			propagation = opts[0].Propagation
+		}
+		retval.GracePeriodSeconds = opts[0].GracePeriodSeconds
 	}
+	if propagation != "" {
+		retval.PropagationPolicy = &propagation
+	}
+	return retval
 }
 
 func getOptions() metav1.GetOptions {
 	return metav1.GetOptions{}
 }
 
-func logOptions() *v1.PodLogOptions {
-	return &v1.PodLogOptions{
+func logOptions(container string, previous bool) *v1.PodLogOptions {
+	opts := &v1.PodLogOptions{
+		Container: container,
+		Previous:  previous,
 		//SinceSeconds: &[]int64{300}[0], //Gets last 5 minutes of logs
-		TailLines:  &[]int64{500}[0], //Retrieve last 500 lines from pod log
-		Timestamps: true,             //Add timestamp to each line in the log
+		Timestamps: true, //Add timestamp to each line in the log
+	}
+	if config.Env.LogTailLines > 0 {
+		opts.TailLines = &[]int64{int64(config.Env.LogTailLines)}[0]
+	}
+	// one byte past the limit is requested so that truncation is detected
+	if config.Env.LogLimitBytes > 0 {
+		opts.LimitBytes = &[]int64{int64(config.Env.LogLimitBytes) + 1}[0]
 	}
+	return opts
 }
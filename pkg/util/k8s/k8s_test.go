@@ -0,0 +1,144 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRetryOnConflict(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.UpdateConflictRetries = 2
+
+	attempts := 0
+	err := retryOnConflict(func() error {
+		attempts++
+		return errors.NewConflict(schema.GroupResource{Resource: "ingresses"}, "test", nil)
+	})
+	if !errors.IsConflict(err) || attempts != 3 {
+		t.Errorf("Expected conflict after 3 attempts, got %v after %d", err, attempts)
+	}
+
+	attempts = 0
+	err = retryOnConflict(func() error {
+		attempts++
+		return errors.NewNotFound(schema.GroupResource{Resource: "ingresses"}, "test")
+	})
+	if !errors.IsNotFound(err) || attempts != 1 {
+		t.Errorf("Expected other errors not to be retried, got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestHPAUpdateRetriesOnConflict(t *testing.T) {
+	fakeClient := createFakeHPAClientset()
+	updates := 0
+	fakeClient.PrependReactor("update", "horizontalpodautoscalers", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates > 1 {
+			return false, nil, nil
+		}
+		return true, nil, errors.NewConflict(action.GetResource().GroupResource(), "fakehpa", nil)
+	})
+	client := HorizontalPodAutoscaler{Interface: fakeClient, Namespace: "sample"}
+
+	desired, _ := client.Get("fakehpa")
+	desired.Labels["application"] = "updatedmyapp"
+	if err := client.Update(desired); err != nil {
+		t.Fatalf("Unexpected error updating hpa: %s", err.Error())
+	}
+	if updates != 2 {
+		t.Errorf("Expected update to be retried once, got %d attempts", updates)
+	}
+
+	hpa, _ := client.Get("fakehpa")
+	if hpa.Labels["application"] != "updatedmyapp" {
+		t.Errorf("Expected hpa to be updated, got labels %v", hpa.Labels)
+	}
+}
+
+func TestDeleteOptions(t *testing.T) {
+	gracePeriod := int64(10)
+
+	if opts := deleteOptions(nil, ""); opts.PropagationPolicy != nil || opts.GracePeriodSeconds != nil {
+		t.Errorf("Expected default delete options, got %+v", opts)
+	}
+
+	opts := deleteOptions(nil, metav1.DeletePropagationForeground)
+	if opts.PropagationPolicy == nil || *opts.PropagationPolicy != metav1.DeletePropagationForeground {
+		t.Errorf("Expected client default propagation Foreground, got %+v", opts)
+	}
+
+	opts = deleteOptions([]DeleteOptions{{Propagation: metav1.DeletePropagationBackground, GracePeriodSeconds: &gracePeriod}}, metav1.DeletePropagationForeground)
+	if opts.PropagationPolicy == nil || *opts.PropagationPolicy != metav1.DeletePropagationBackground {
+		t.Errorf("Expected propagation Background, got %+v", opts)
+	}
+	if opts.GracePeriodSeconds == nil || *opts.GracePeriodSeconds != 10 {
+		t.Errorf("Expected grace period 10, got %+v", opts)
+	}
+}
+
+func TestDeleteOptionsFor(t *testing.T) {
+	defer func(propagation map[string]string, gracePeriod int) {
+		config.Env.DeletePropagation, config.Env.DeleteGracePeriod = propagation, gracePeriod
+	}(config.Env.DeletePropagation, config.Env.DeleteGracePeriod)
+
+	config.Env.DeletePropagation = map[string]string{"pvc": "Background"}
+	config.Env.DeleteGracePeriod = -1
+
+	if opts := DeleteOptionsFor("deployment"); opts.Propagation != "" || opts.GracePeriodSeconds != nil {
+		t.Errorf("Expected default options for deployment, got %+v", opts)
+	}
+
+	config.Env.DeleteGracePeriod = 0
+	opts := DeleteOptionsFor("pvc")
+	if opts.Propagation != metav1.DeletePropagationBackground {
+		t.Errorf("Expected Background propagation for pvc, got %s", opts.Propagation)
+	}
+	if opts.GracePeriodSeconds == nil || *opts.GracePeriodSeconds != 0 {
+		t.Errorf("Expected grace period 0, got %v", opts.GracePeriodSeconds)
+	}
+}
+
+func TestLogOptions(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+	config.Env.LogTailLines = 500
+	config.Env.LogLimitBytes = 1024
+
+	opts := logOptions("proxy", true)
+	if opts.Container != "proxy" || !opts.Previous {
+		t.Errorf("Expected previous logs of proxy container, got %+v", opts)
+	}
+	if *opts.TailLines != 500 || *opts.LimitBytes != 1025 || !opts.Timestamps {
+		t.Errorf("Expected configured limits and timestamps, got %+v", opts)
+	}
+
+	if opts := logOptions("", false); opts.Previous {
+		t.Errorf("Expected current container logs, got %+v", opts)
+	}
+
+	config.Env.LogTailLines = 0
+	config.Env.LogLimitBytes = 0
+	if opts := logOptions("", false); opts.TailLines != nil || opts.LimitBytes != nil {
+		t.Errorf("Expected unlimited logs, got %+v", opts)
+	}
+}
+
+func TestWithFieldManager(t *testing.T) {
+	defer func(cfg config.Config) { config.Env = cfg }(config.Env)
+
+	config.Env.FieldManager = "eo-migration"
+	if c := withFieldManager(&rest.Config{}); c.UserAgent != "eo-migration" {
+		t.Errorf("Expected user agent eo-migration, got %q", c.UserAgent)
+	}
+
+	config.Env.FieldManager = ""
+	if c := withFieldManager(&rest.Config{UserAgent: "default"}); c.UserAgent != "default" {
+		t.Errorf("Expected user agent to be kept, got %q", c.UserAgent)
+	}
+}
@@ -0,0 +1,255 @@
+package k8s
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// ManifestHashAnnotation holds the hash of the manifest last applied
+	ManifestHashAnnotation = "environment-operator/manifest-hash"
+	// ManifestInventoryLabel marks configmaps listing objects applied from
+	// a manifest gist
+	ManifestInventoryLabel = "manifest-inventory"
+	// ManifestLabel marks objects applied from a manifest gist, set to the
+	// gist name
+	ManifestLabel = "manifest"
+
+	manifestInventoryPrefix = "eo-manifest-"
+	manifestInventoryKey    = "objects"
+)
+
+// Manifest type actions on arbitrary kubernetes objects. Resources are
+// resolved through the discovery API, so any kind served by the cluster,
+// including custom resources, can be applied
+type Manifest struct {
+	kubernetes.Interface
+	Namespace string
+}
+
+// Apply creates the object or updates it when it has changed since it was
+// last applied. Only namespaced kinds are applied
+func (client *Manifest) Apply(resource *unstructured.Unstructured) error {
+	obj := resource.DeepCopy()
+	path, namespaced, err := client.path(obj)
+	if err != nil {
+		return err
+	}
+	if !namespaced {
+		return fmt.Errorf("kind %s is cluster scoped, manifests are only applied into the namespace", obj.GetKind())
+	}
+
+	hash, err := manifestHash(obj)
+	if err != nil {
+		return err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[ManifestHashAnnotation] = hash
+	obj.SetAnnotations(annotations)
+
+	current, err := client.get(path)
+	if k8serrors.IsNotFound(err) {
+		return client.write("POST", strings.TrimSuffix(path, "/"+obj.GetName()), obj)
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.GetAnnotations()[ManifestHashAnnotation] == hash {
+		return nil
+	}
+	obj.SetResourceVersion(current.GetResourceVersion())
+	return client.write("PUT", path, obj)
+}
+
+// Served returns true if the cluster serves kind in apiVersion, e.g. when
+// the custom resource definition of an optional operator is installed
+func (client *Manifest) Served(apiVersion, kind string) bool {
+	resources, err := client.Discovery().ServerResourcesForGroupVersion(apiVersion)
+	if err != nil {
+		return false
+	}
+	for _, r := range resources.APIResources {
+		if r.Kind == kind && !strings.Contains(r.Name, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the object identified by apiVersion, kind and name
+func (client *Manifest) Get(resource *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	path, _, err := client.path(resource.DeepCopy())
+	if err != nil {
+		return nil, err
+	}
+	return client.get(path)
+}
+
+// Destroy deletes the object identified by apiVersion, kind and name
+func (client *Manifest) Destroy(resource *unstructured.Unstructured, opts ...DeleteOptions) error {
+	path, _, err := client.path(resource.DeepCopy())
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(deleteOptions(opts, ""))
+	if err != nil {
+		return err
+	}
+	return client.restClient().Delete().
+		AbsPath(path).
+		SetHeader("Content-Type", "application/json").
+		Body(data).
+		Do().
+		Error()
+}
+
+// Inventory returns the objects last applied from the named manifest gist
+func (client *Manifest) Inventory(gist string) ([]unstructured.Unstructured, error) {
+	cm, err := client.CoreV1().ConfigMaps(client.Namespace).Get(manifestInventoryPrefix+gist, getOptions())
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ManifestInventory(*cm)
+}
+
+// SaveInventory records the objects applied from the named manifest gist
+func (client *Manifest) SaveInventory(gist string, resources []unstructured.Unstructured) error {
+	var objects []map[string]interface{}
+	for _, r := range resources {
+		objects = append(objects, manifestIdentity(r.GetAPIVersion(), r.GetKind(), r.GetName()).Object)
+	}
+	data, err := json.Marshal(objects)
+	if err != nil {
+		return err
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      manifestInventoryPrefix + gist,
+			Namespace: client.Namespace,
+			Labels: map[string]string{
+				"creator":              "pipeline",
+				ManifestInventoryLabel: gist,
+			},
+		},
+		Data: map[string]string{
+			manifestInventoryKey: string(data),
+		},
+	}
+	c := ConfigMap{Interface: client.Interface, Namespace: client.Namespace}
+	return c.Apply(cm)
+}
+
+// DestroyInventory deletes the inventory of the named manifest gist
+func (client *Manifest) DestroyInventory(gist string) error {
+	c := ConfigMap{Interface: client.Interface, Namespace: client.Namespace}
+	return c.Destroy(manifestInventoryPrefix + gist)
+}
+
+// ManifestInventory returns objects listed in manifest inventory configmap
+func ManifestInventory(cm v1.ConfigMap) ([]unstructured.Unstructured, error) {
+	var objects []map[string]interface{}
+	if err := json.Unmarshal([]byte(cm.Data[manifestInventoryKey]), &objects); err != nil {
+		return nil, fmt.Errorf("invalid manifest inventory %s: %s", cm.Name, err.Error())
+	}
+
+	var retval []unstructured.Unstructured
+	for _, o := range objects {
+		retval = append(retval, unstructured.Unstructured{Object: o})
+	}
+	return retval, nil
+}
+
+func manifestIdentity(apiVersion, kind, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetName(name)
+	return obj
+}
+
+func manifestHash(obj *unstructured.Unstructured) (string, error) {
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+func (client *Manifest) restClient() rest.Interface {
+	return client.Discovery().RESTClient()
+}
+
+// path resolves object's kind to its API resource and returns the object
+// URL and whether the kind is namespaced. Namespace of namespaced objects is
+// set to the client namespace
+func (client *Manifest) path(obj *unstructured.Unstructured) (string, bool, error) {
+	if client.restClient() == nil {
+		return "", false, errors.New("discovery client is not available")
+	}
+
+	apiVersion := obj.GetAPIVersion()
+	resources, err := client.Discovery().ServerResourcesForGroupVersion(apiVersion)
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, r := range resources.APIResources {
+		if r.Kind != obj.GetKind() || strings.Contains(r.Name, "/") {
+			continue
+		}
+
+		path := "/apis/" + apiVersion
+		if !strings.Contains(apiVersion, "/") {
+			path = "/api/" + apiVersion
+		}
+		if r.Namespaced {
+			obj.SetNamespace(client.Namespace)
+			path += "/namespaces/" + client.Namespace
+		}
+		return path + "/" + r.Name + "/" + obj.GetName(), r.Namespaced, nil
+	}
+	return "", false, fmt.Errorf("kind %s is not served by %s", obj.GetKind(), apiVersion)
+}
+
+func (client *Manifest) get(path string) (*unstructured.Unstructured, error) {
+	data, err := client.restClient().Get().AbsPath(path).Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (client *Manifest) write(verb, path string, obj *unstructured.Unstructured) error {
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return client.restClient().Verb(verb).
+		AbsPath(path).
+		SetHeader("Content-Type", "application/json").
+		Body(data).
+		Do().
+		Error()
+}
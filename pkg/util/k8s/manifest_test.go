@@ -0,0 +1,195 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const serviceMonitorResources = `{
+  "kind": "APIResourceList",
+  "apiVersion": "v1",
+  "groupVersion": "monitoring.coreos.com/v1",
+  "resources": [
+    {"name": "servicemonitors", "namespaced": true, "kind": "ServiceMonitor", "verbs": ["get", "create", "update", "delete"]},
+    {"name": "servicemonitors/status", "namespaced": true, "kind": "ServiceMonitor", "verbs": ["get"]},
+    {"name": "clustermonitors", "namespaced": false, "kind": "ClusterMonitor", "verbs": ["get", "create", "update", "delete"]}
+  ]
+}`
+
+const notFoundStatus = `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`
+
+// manifestServer serves discovery and stores objects by their URL path
+type manifestServer struct {
+	sync.Mutex
+	objects  map[string]string
+	requests []string
+}
+
+func (s *manifestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path == "/apis/monitoring.coreos.com/v1" {
+		w.Write([]byte(serviceMonitorResources))
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	switch r.Method {
+	case "POST":
+		obj := &unstructured.Unstructured{}
+		obj.UnmarshalJSON(body)
+		s.objects[r.URL.Path+"/"+obj.GetName()] = string(body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	case "PUT":
+		s.objects[r.URL.Path] = string(body)
+		w.Write(body)
+	case "GET", "DELETE":
+		obj, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(notFoundStatus))
+			return
+		}
+		if r.Method == "DELETE" {
+			delete(s.objects, r.URL.Path)
+		}
+		w.Write([]byte(obj))
+	}
+}
+
+func (s *manifestServer) count(method string) int {
+	s.Lock()
+	defer s.Unlock()
+	n := 0
+	for _, r := range s.requests {
+		if strings.HasPrefix(r, method+" ") {
+			n++
+		}
+	}
+	return n
+}
+
+// createManifest returns a Manifest client of a fake API server, stopped by
+// the returned func
+func createManifest(t *testing.T) (*Manifest, *manifestServer, func()) {
+	srv := &manifestServer{objects: map[string]string{}}
+	ts := httptest.NewServer(srv)
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: ts.URL})
+	if err != nil {
+		ts.Close()
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return &Manifest{Interface: clientset, Namespace: "sample"}, srv, ts.Close
+}
+
+func serviceMonitor(port string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"endpoints": []interface{}{
+				map[string]interface{}{"port": port},
+			},
+		},
+	}}
+	obj.SetAPIVersion("monitoring.coreos.com/v1")
+	obj.SetKind("ServiceMonitor")
+	obj.SetName("api")
+	return obj
+}
+
+func TestManifestServed(t *testing.T) {
+	client, _, done := createManifest(t)
+	defer done()
+	if !client.Served("monitoring.coreos.com/v1", "ServiceMonitor") {
+		t.Error("Expected ServiceMonitor to be served")
+	}
+	if client.Served("monitoring.coreos.com/v1", "PodMonitor") {
+		t.Error("Expected PodMonitor not to be served")
+	}
+	if client.Served("example.com/v1", "Widget") {
+		t.Error("Expected kind of missing group not to be served")
+	}
+}
+
+func TestManifestApply(t *testing.T) {
+	client, srv, done := createManifest(t)
+	defer done()
+	path := "/apis/monitoring.coreos.com/v1/namespaces/sample/servicemonitors/api"
+
+	if err := client.Apply(serviceMonitor("metrics")); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, ok := srv.objects[path]; !ok {
+		t.Fatalf("Expected object at %s, got %v", path, srv.objects)
+	}
+	if !strings.Contains(srv.objects[path], `"namespace":"sample"`) {
+		t.Errorf("Expected namespace to be set, got %s", srv.objects[path])
+	}
+
+	if err := client.Apply(serviceMonitor("metrics")); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if n := srv.count("PUT"); n != 0 {
+		t.Errorf("Expected unchanged manifest not to be updated, got %d updates", n)
+	}
+
+	if err := client.Apply(serviceMonitor("http")); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if n := srv.count("PUT"); n != 1 {
+		t.Errorf("Expected changed manifest to be updated, got %d updates", n)
+	}
+	if !strings.Contains(srv.objects[path], `"port":"http"`) {
+		t.Errorf("Expected updated object, got %s", srv.objects[path])
+	}
+}
+
+func TestManifestDestroy(t *testing.T) {
+	client, srv, done := createManifest(t)
+	defer done()
+
+	if err := client.Apply(serviceMonitor("metrics")); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := client.Destroy(manifestIdentity("monitoring.coreos.com/v1", "ServiceMonitor", "api")); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(srv.objects) != 0 {
+		t.Errorf("Expected object to be deleted, got %v", srv.objects)
+	}
+}
+
+func TestManifestUnknownKind(t *testing.T) {
+	client, _, done := createManifest(t)
+	defer done()
+	obj := manifestIdentity("monitoring.coreos.com/v1", "PodMonitor", "api")
+
+	if err := client.Apply(obj); err == nil {
+		t.Error("Expected error for kind not served by the cluster")
+	}
+}
+
+func TestManifestClusterScopedKind(t *testing.T) {
+	client, srv, done := createManifest(t)
+	defer done()
+	obj := manifestIdentity("monitoring.coreos.com/v1", "ClusterMonitor", "api")
+
+	if err := client.Apply(obj); err == nil {
+		t.Error("Expected error for cluster scoped kind")
+	}
+	if n := srv.count("POST"); n != 0 {
+		t.Errorf("Expected cluster scoped object not to be created, got %d requests", n)
+	}
+}
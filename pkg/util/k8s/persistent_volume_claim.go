@@ -52,30 +52,34 @@ func (client *PersistentVolumeClaim) Update(resource *v1.PersistentVolumeClaim)
 	if resource == nil {
 		return nil
 	}
-	current, err := client.Get(resource.Name)
-	if err != nil {
-		return err
-	}
-	resource.ResourceVersion = current.GetResourceVersion()
-	resource.Spec.VolumeName = current.Spec.VolumeName
+	desired := resource.DeepCopy()
+	return retryOnConflict(func() error {
+		desired.DeepCopyInto(resource)
+		current, err := client.Get(resource.Name)
+		if err != nil {
+			return err
+		}
+		resource.ResourceVersion = current.GetResourceVersion()
+		resource.Spec.VolumeName = current.Spec.VolumeName
 
-	log.Warningf("attemting to update volume \"%s\", service \"%s\", but PVC Spec is immutable so this may fail.", current.ObjectMeta.Name, current.ObjectMeta.Labels["deployment"])
+		log.Warningf("attemting to update volume \"%s\", service \"%s\", but PVC Spec is immutable so this may fail.", current.ObjectMeta.Name, current.ObjectMeta.Labels["deployment"])
 
-	_, err = client.
-		CoreV1().
-		PersistentVolumeClaims(client.Namespace).
-		Update(resource)
+		_, err = client.
+			CoreV1().
+			PersistentVolumeClaims(client.Namespace).
+			Update(resource)
 
-	if err == nil {
-		log.Warningf("succesfully  updated volume \"%s\", service \"%s\".", current.ObjectMeta.Name, current.ObjectMeta.Labels["deployment"])
-	}
+		if err == nil {
+			log.Warningf("succesfully  updated volume \"%s\", service \"%s\".", current.ObjectMeta.Name, current.ObjectMeta.Labels["deployment"])
+		}
 
-	return err
+		return err
+	})
 }
 
 // Destroy deletes pvc from the k8 cluster
-func (client *PersistentVolumeClaim) Destroy(name string) error {
-	return client.CoreV1().PersistentVolumeClaims(client.Namespace).Delete(name, &metav1.DeleteOptions{})
+func (client *PersistentVolumeClaim) Destroy(name string, opts ...DeleteOptions) error {
+	return client.CoreV1().PersistentVolumeClaims(client.Namespace).Delete(name, deleteOptions(opts, ""))
 }
 
 // List returns the list of k8s services maintained by pipeline
@@ -2,7 +2,10 @@ package k8s
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 
+	"github.com/pearsontechnology/environment-operator/pkg/config"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -13,17 +16,39 @@ type Pod struct {
 	Namespace string
 }
 
-// GetLogs returns pod's logs as a string
-func (client *Pod) GetLogs(name string) (string, error) {
+// GetLogs returns logs of pod's container as a string. Container can be
+// left empty for single container pods. If previous is set, logs of the
+// last terminated instance of the container are returned. Logs are capped
+// at LOG_TAIL_LINES lines and LOG_LIMIT_BYTES bytes
+func (client *Pod) GetLogs(name, container string, previous bool) (string, error) {
 
-	reader, err := client.CoreV1().Pods(client.Namespace).GetLogs(name, logOptions()).Stream()
+	reader, err := client.CoreV1().Pods(client.Namespace).GetLogs(name, logOptions(container, previous)).Stream()
 	if err != nil {
 		return "", err
 	}
 	defer reader.Close()
+	return readLogs(reader, int64(config.Env.LogLimitBytes))
+}
+
+// readLogs reads at most limit bytes of logs, appending a marker if logs
+// were truncated. Limit of 0 or less reads all logs
+func readLogs(reader io.Reader, limit int64) (string, error) {
 	buf := new(bytes.Buffer)
-	buf.ReadFrom(reader)
-	return buf.String(), err
+	if limit <= 0 {
+		_, err := buf.ReadFrom(reader)
+		return buf.String(), err
+	}
+
+	// read a byte past the limit to tell whether logs were truncated
+	n, err := buf.ReadFrom(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return "", err
+	}
+	if n > limit {
+		buf.Truncate(int(limit))
+		fmt.Fprintf(buf, "\n[log truncated at %d bytes]\n", limit)
+	}
+	return buf.String(), nil
 }
 
 // List returns the list of k8s services maintained by pipeline
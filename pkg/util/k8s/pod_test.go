@@ -1,6 +1,7 @@
 package k8s
 
 import (
+	"strings"
 	"testing"
 
 	"k8s.io/api/core/v1"
@@ -12,16 +13,38 @@ import (
 Currently disabled. Not able to test Log Streams due to SegFault Issue: https://github.com/kubernetes/client-go/issues/196
 func TestPodGetLogs(t *testing.T) {
 	client := createPod()
-	if _, err := client.GetLogs("test"); err != nil {
+	if _, err := client.GetLogs("test", "", false); err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 	}
 
-	if m, err := client.GetLogs("nonexistent"); err == nil {
+	if m, err := client.GetLogs("nonexistent", "", false); err == nil {
 		t.Errorf("Unexpected Pod: %v", m)
 	}
 
 }*/
 
+func TestReadLogs(t *testing.T) {
+	var tests = []struct {
+		Logs     string
+		Limit    int64
+		Expected string
+	}{
+		{"line1\nline2\n", 0, "line1\nline2\n"},
+		{"line1\nline2\n", 12, "line1\nline2\n"},
+		{"line1\nline2\n", 8, "line1\nli\n[log truncated at 8 bytes]\n"},
+	}
+
+	for _, tst := range tests {
+		got, err := readLogs(strings.NewReader(tst.Logs), tst.Limit)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if got != tst.Expected {
+			t.Errorf("Unexpected logs with limit %d. Expected %q, got %q", tst.Limit, tst.Expected, got)
+		}
+	}
+}
+
 func TestPodList(t *testing.T) {
 	client := createPod()
 	s, err := client.List()
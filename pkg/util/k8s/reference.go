@@ -0,0 +1,23 @@
+package k8s
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/pearsontechnology/environment-operator/pkg/config"
+)
+
+// MISSING_REFERENCE_POLICY values
+const (
+	MissingReferenceFail = "fail"
+	MissingReferenceWarn = "warn"
+)
+
+// MissingReference handles err about a deployment referencing a secret or
+// configmap that doesn't exist. It is returned to fail the deployment,
+// unless MISSING_REFERENCE_POLICY is warn, when it is only logged
+func MissingReference(err error) error {
+	if config.Env.MissingReferencePolicy == MissingReferenceWarn {
+		log.Warn(err.Error())
+		return nil
+	}
+	return err
+}
@@ -66,17 +66,21 @@ func (client *Secret) Update(resource *v1.Secret) error {
 	if resource == nil {
 		return nil
 	}
-	current, err := client.Get(resource.Name)
-	if err != nil {
-		return err
-	}
-	resource.ResourceVersion = current.GetResourceVersion()
+	desired := resource.DeepCopy()
+	return retryOnConflict(func() error {
+		desired.DeepCopyInto(resource)
+		current, err := client.Get(resource.Name)
+		if err != nil {
+			return err
+		}
+		resource.ResourceVersion = current.GetResourceVersion()
 
-	_, err = client.
-		CoreV1().
-		Secrets(client.Namespace).
-		Update(resource)
-	return err
+		_, err = client.
+			CoreV1().
+			Secrets(client.Namespace).
+			Update(resource)
+		return err
+	})
 }
 
 // Get returns secret object from the k8s by name
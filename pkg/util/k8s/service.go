@@ -2,10 +2,26 @@ package k8s
 
 import (
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/client-go/kubernetes"
 )
 
+// TopologyAwareHintsAnnotation makes kube-proxy prefer endpoints in the same
+// zone as the client
+const TopologyAwareHintsAnnotation = "service.kubernetes.io/topology-aware-hints"
+
+// PatchesAnnotation records patches applied to objects generated for the
+// service
+const PatchesAnnotation = "environment-operator/patches"
+
+// ServiceMonitorAnnotation records settings of the Prometheus Operator
+// ServiceMonitor created for the service
+const ServiceMonitorAnnotation = "environment-operator/service-monitor"
+
+// ServiceMonitorAPIVersion is the API version ServiceMonitors are served by
+const ServiceMonitorAPIVersion = "monitoring.coreos.com/v1"
+
 // Service type actions on pvcs in k8s cluster
 type Service struct {
 	kubernetes.Interface
@@ -51,12 +67,33 @@ func (client *Service) Update(resource *v1.Service) error {
 	if resource == nil {
 		return nil
 	}
+	desired := resource.DeepCopy()
+	return retryOnConflict(func() error {
+		desired.DeepCopyInto(resource)
+		return client.update(resource)
+	})
+}
+
+// update makes a single attempt to update service, keeping its cluster ip
+// and node ports. Changing the cluster ip, including to and from headless,
+// fails with an invalid error, as the service has to be recreated for it
+func (client *Service) update(resource *v1.Service) error {
 	current, err := client.Get(resource.Name)
 	if err != nil {
 		return err
 	}
+	if resource.Spec.ClusterIP != current.Spec.ClusterIP &&
+		(resource.Spec.ClusterIP != "" || current.Spec.ClusterIP == v1.ClusterIPNone) {
+		return k8serrors.NewInvalid(v1.SchemeGroupVersion.WithKind("Service").GroupKind(), resource.Name, field.ErrorList{
+			field.Invalid(field.NewPath("spec", "clusterIP"), resource.Spec.ClusterIP, "field is immutable"),
+		})
+	}
+
 	resource.ResourceVersion = current.GetResourceVersion()
-	resource.Spec.ClusterIP = current.Spec.ClusterIP
+	if resource.Spec.Type != v1.ServiceTypeExternalName {
+		resource.Spec.ClusterIP = current.Spec.ClusterIP
+	}
+	keepNodePorts(resource, current)
 
 	_, err = client.
 		CoreV1().
@@ -66,8 +103,8 @@ func (client *Service) Update(resource *v1.Service) error {
 }
 
 // Destroy deletes service from the k8 cluster
-func (client *Service) Destroy(name string) error {
-	return client.CoreV1().Services(client.Namespace).Delete(name, &metav1.DeleteOptions{})
+func (client *Service) Destroy(name string, opts ...DeleteOptions) error {
+	return client.CoreV1().Services(client.Namespace).Delete(name, deleteOptions(opts, ""))
 }
 
 // List returns the list of k8s services maintained by pipeline
@@ -78,3 +115,30 @@ func (client *Service) List() ([]v1.Service, error) {
 	}
 	return list.Items, nil
 }
+
+// keepNodePorts copies node ports allocated to current onto ports of
+// resource without one, so that updating a NodePort or LoadBalancer service
+// doesn't move it to new node ports
+func keepNodePorts(resource, current *v1.Service) {
+	if resource.Spec.Type != v1.ServiceTypeNodePort && resource.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return
+	}
+	for i, port := range resource.Spec.Ports {
+		if port.NodePort != 0 {
+			continue
+		}
+		for _, c := range current.Spec.Ports {
+			if c.Port == port.Port && servicePortProtocol(c) == servicePortProtocol(port) {
+				resource.Spec.Ports[i].NodePort = c.NodePort
+			}
+		}
+	}
+}
+
+// servicePortProtocol returns protocol of port, TCP unless set
+func servicePortProtocol(port v1.ServicePort) v1.Protocol {
+	if port.Protocol == "" {
+		return v1.ProtocolTCP
+	}
+	return port.Protocol
+}
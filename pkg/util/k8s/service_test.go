@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
 )
@@ -73,6 +74,77 @@ func TestServiceApplyExisting(t *testing.T) {
 	}
 }
 
+func TestServiceApplyHeadless(t *testing.T) {
+	client := createService()
+	if err := client.Destroy("test"); err != nil {
+		t.Fatalf("Unexpected error deleting service: %s", err.Error())
+	}
+	headless := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "sample",
+			Labels: map[string]string{
+				"creator": "pipeline",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: v1.ClusterIPNone,
+		},
+	}
+	if err := client.Apply(headless); err != nil {
+		t.Errorf("Unexpected error applying service: %s", err.Error())
+	}
+
+	s, _ := client.Get("test")
+	if s.Spec.ClusterIP != v1.ClusterIPNone {
+		t.Errorf("Unexpected cluster ip. Expected None, got: %s", s.Spec.ClusterIP)
+	}
+
+	headless.Spec.ClusterIP = ""
+	if err := client.Apply(headless); !k8serrors.IsInvalid(err) {
+		t.Errorf("Expected invalid error turning headless service off, got %v", err)
+	}
+
+	s, _ = client.Get("test")
+	if s.Spec.ClusterIP != v1.ClusterIPNone {
+		t.Error("Expected service not to be recreated")
+	}
+}
+
+func TestServiceUpdateKeepsNodePorts(t *testing.T) {
+	client := createService()
+	nodePort := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "sample"},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeNodePort,
+			Ports: []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}},
+		},
+	}
+	if err := client.Apply(nodePort); err != nil {
+		t.Fatalf("Unexpected error applying service: %s", err.Error())
+	}
+
+	updated := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "sample"},
+		Spec: v1.ServiceSpec{
+			Type:                     v1.ServiceTypeLoadBalancer,
+			Ports:                    []v1.ServicePort{{Port: 80}, {Port: 443}},
+			LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+		},
+	}
+	if err := client.Apply(updated); err != nil {
+		t.Fatalf("Unexpected error applying service: %s", err.Error())
+	}
+
+	s, _ := client.Get("test")
+	if s.Spec.Type != v1.ServiceTypeLoadBalancer {
+		t.Errorf("Expected service type LoadBalancer, got %s", s.Spec.Type)
+	}
+	if s.Spec.Ports[0].NodePort != 30080 || s.Spec.Ports[1].NodePort != 0 {
+		t.Errorf("Expected node port 30080 to be kept for port 80 only, got %+v", s.Spec.Ports)
+	}
+}
+
 func TestServiceUpdateNonexisting(t *testing.T) {
 	client := createService()
 	resource := &v1.Service{
@@ -2,7 +2,6 @@ package k8s
 
 import (
 	apps_v1 "k8s.io/api/apps/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -42,24 +41,19 @@ func (client *StatefulSet) Update(resource *apps_v1.StatefulSet) error {
 	if resource == nil {
 		return nil
 	}
-	current, err := client.Get(resource.Name)
-	if err != nil {
-		return err
-	}
+	return retryOnConflict(func() error {
+		current, err := client.Get(resource.Name)
+		if err != nil {
+			return err
+		}
 
-	current.Spec.Replicas = resource.Spec.Replicas
-	_, err = client.
-		AppsV1().
-		StatefulSets(client.Namespace).
-		Update(current)
-
-	/*resource.ResourceVersion = current.GetResourceVersion()
-	_, err = client.
-		Apps().
-		StatefulSets(client.Namespace).
-		Update(resource)
-	*/
-	return err
+		current.Spec.Replicas = resource.Spec.Replicas
+		_, err = client.
+			AppsV1().
+			StatefulSets(client.Namespace).
+			Update(current)
+		return err
+	})
 }
 
 // Create creates new statefulset in k8s
@@ -75,8 +69,8 @@ func (client *StatefulSet) Create(resource *apps_v1.StatefulSet) error {
 }
 
 // Destroy deletes statefulset from the k8 cluster
-func (client *StatefulSet) Destroy(name string) error {
-	return client.AppsV1().StatefulSets(client.Namespace).Delete(name, &metav1.DeleteOptions{})
+func (client *StatefulSet) Destroy(name string, opts ...DeleteOptions) error {
+	return client.AppsV1().StatefulSets(client.Namespace).Delete(name, deleteOptions(opts, ""))
 }
 
 // List returns the list of k8s services maintained by pipeline
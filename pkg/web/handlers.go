@@ -4,13 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/cluster"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
+	"github.com/pearsontechnology/environment-operator/pkg/health"
+	"github.com/pearsontechnology/environment-operator/pkg/leader"
 	"github.com/pearsontechnology/environment-operator/pkg/metrics"
+	"github.com/pearsontechnology/environment-operator/pkg/reaper"
+	"github.com/pearsontechnology/environment-operator/version"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/gorilla/mux"
@@ -20,10 +25,17 @@ import (
 // Router returns mux.Router with all paths served
 func Router() *mux.Router {
 	r := mux.NewRouter()
-	r.HandleFunc("/deploy", postDeploy).Methods("POST")
+	r.HandleFunc("/deploy", leaderOnly(postDeploy)).Methods("POST")
+	r.HandleFunc("/restart/{service}", leaderOnly(postRestart)).Methods("POST")
+	r.HandleFunc("/sync/{service}", leaderOnly(postSync)).Methods("POST")
+	r.HandleFunc("/diff", getDiff).Methods("GET")
+	r.HandleFunc("/diff/{service}", getDiff).Methods("GET")
 	r.HandleFunc("/status", getStatus).Methods("GET")
 	r.HandleFunc("/status/{service}", getServiceStatus).Methods("GET")
 	r.HandleFunc("/status/{service}/pods", getPodStatus).Methods("GET")
+	r.HandleFunc("/readyz", Readyz).Methods("GET")
+	r.HandleFunc("/version", getVersion).Methods("GET")
+	r.HandleFunc("/config", getConfig).Methods("GET")
 	r.Handle("/metrics", promhttp.Handler())
 
 	return r
@@ -51,6 +63,18 @@ func Auth(h http.Handler) http.Handler {
 	})
 }
 
+// leaderOnly refuses requests changing the cluster unless this replica is
+// the leader, as the API is served before leader election completes
+func leaderOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !leader.IsLeader() {
+			http.Error(w, "Service Unavailable: not the leader", http.StatusServiceUnavailable)
+			return
+		}
+		h(w, r)
+	}
+}
+
 func postDeploy(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-type", "application/json")
 	client, err := cluster.Client()
@@ -111,6 +135,165 @@ func postDeploy(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// postSync compares service as it is in the config against the cluster and
+// applies it if it changed, regardless of its reconcile mode. Other services
+// are left untouched. It is the only way manual services are applied
+func postSync(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serviceName := vars["service"]
+
+	w.Header().Set("Content-type", "application/json")
+	client, err := cluster.Client()
+	if err != nil {
+		log.Errorf("error creating sync Kubernetes client: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	environment, err := loadEnvironmentFromSource()
+	if err != nil {
+		log.Errorf("error loading environment: %s", err.Error())
+		http.Error(w, fmt.Sprintf("Bad Request: Could not load env: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	service := findService(environment.Services, serviceName)
+	if service == nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %s not found", serviceName), http.StatusBadRequest)
+		return
+	}
+	if service.IsBlueGreenParentDeployment() {
+		http.Error(w, fmt.Sprintf("Bad Request: blue/green service %s is deployed through /deploy", serviceName), http.StatusBadRequest)
+		return
+	}
+	serviceName = service.Name
+
+	change, err := client.SyncService(environment, serviceName)
+	if err != nil {
+		log.Errorf("error syncing service %s: %s", serviceName, err.Error())
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		metrics.Syncs.With(prometheus.Labels{"status": "failed"}).Inc()
+		return
+	}
+	metrics.Syncs.With(prometheus.Labels{"status": "succeeded"}).Inc()
+
+	// ingress and hpa removed from the config are deleted, as the reaper
+	// leaves them in place for services it doesn't reconcile
+	if current, err := loadServiceFromCluster(serviceName); err == nil {
+		reap := reaper.Reaper{Wrapper: client, Namespace: config.Env.Namespace}
+		reap.CleanupIngress(service, &current)
+		reap.CleanupHPA(service, &current)
+	}
+
+	status := map[string]string{
+		"status": "syncing",
+		"change": change,
+	}
+	if change == "" {
+		status["status"] = "unchanged"
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func postRestart(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serviceName := vars["service"]
+
+	w.Header().Set("Content-type", "application/json")
+	client, err := cluster.Client()
+	if err != nil {
+		log.Errorf("error creating restart Kubernetes client: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	service, err := loadServiceFromConfig(serviceName)
+	if err != nil {
+		log.Errorf("error getting service %s: %s", serviceName, err.Error())
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if err := client.RestartService(service, config.Env.Namespace); err != nil {
+		log.Errorf("error restarting service %s: %s", serviceName, err.Error())
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		metrics.Restarts.With(prometheus.Labels{"status": "failed"}).Inc()
+		return
+	}
+	metrics.Restarts.With(prometheus.Labels{"status": "succeeded"}).Inc()
+
+	status := map[string]string{
+		"status": "restarting",
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// getDiff returns changes the operator would apply to bring the cluster to
+// the state in the config, for the whole environment or a single service
+func getDiff(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serviceName := vars["service"]
+
+	w.Header().Set("Content-Type", "application/json")
+	client, err := cluster.Client()
+	if err != nil {
+		log.Errorf("error creating diff Kubernetes client: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	environment, err := loadEnvironmentFromSource()
+	if err != nil {
+		log.Errorf("error loading environment: %s", err.Error())
+		http.Error(w, fmt.Sprintf("Bad Request: Could not load env: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if serviceName != "" {
+		service := findService(environment.Services, serviceName)
+		if service == nil {
+			http.Error(w, fmt.Sprintf("Bad Request: %s not found", serviceName), http.StatusBadRequest)
+			return
+		}
+		serviceName = service.Name
+	}
+
+	changes, err := client.Diff(environment)
+	if err != nil {
+		log.Errorf("error comparing environment: %s", err.Error())
+		http.Error(w, fmt.Sprintf("Bad Request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	resp := diffResponse(environment, changes, serviceName)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error(err)
+	}
+}
+
+func diffResponse(env *bitesize.Environment, changes map[string]string, serviceName string) DiffResponse {
+	resp := DiffResponse{
+		EnvironmentName: env.Name,
+		Namespace:       env.Namespace,
+		Changes:         map[string]string{},
+	}
+	for name, change := range changes {
+		if serviceName == "" || name == serviceName {
+			resp.Changes[name] = change
+		}
+	}
+	resp.Pending = len(resp.Changes) > 0
+	return resp
+}
+
 func getStatus(w http.ResponseWriter, r *http.Request) {
 
 	client, err := cluster.Client()
@@ -129,9 +312,10 @@ func getStatus(w http.ResponseWriter, r *http.Request) {
 	s := &StatusResponse{
 		EnvironmentName: e.Name,
 		Namespace:       e.Namespace,
+		QueueDepth:      cluster.QueueDepth(),
 	}
 
-	for _, svc := range e.Services {
+	for _, svc := range ownedServices(e.Services) {
 
 		if svc.IsBlueGreenParentDeployment() {
 			if loadSvc, err := loadServiceFromCluster(svc.InactiveDeploymentName()); err == nil {
@@ -159,7 +343,18 @@ func getPodStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 	}
 
-	pods, err := client.LoadPods(config.Env.Namespace)
+	// logs of the main service container are returned unless requested
+	// otherwise with ?container=. ?previous=true returns logs of the last
+	// terminated container instance
+	query := r.URL.Query()
+	previous := false
+	if p := query.Get("previous"); p != "" {
+		if previous, err = strconv.ParseBool(p); err != nil {
+			http.Error(w, fmt.Sprintf("invalid previous parameter: %s", p), http.StatusBadRequest)
+			return
+		}
+	}
+	pods, err := client.LoadPods(config.Env.Namespace, query.Get("container"), previous)
 
 	deploySVC, err := loadServiceFromCluster(serviceName)
 
@@ -187,6 +382,49 @@ func getPodStatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Readyz reports operator unhealthy once reconcile failed
+// RECONCILE_FAILURE_THRESHOLD times in a row. Standby replicas are reported
+// not ready, so that API requests are only served by the leader. The
+// endpoint is not authenticated, so reconcile errors are not returned
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	failures, _ := health.Reconcile.Status()
+	resp := ReadyResponse{
+		Healthy:  health.Reconcile.Healthy(),
+		Leader:   leader.IsLeader(),
+		Failures: failures,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Healthy || !resp.Leader {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error(err)
+	}
+}
+
+// getConfig returns the configuration the operator resolved from its
+// environment, with credentials masked
+func getConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(config.Env.Redacted()); err != nil {
+		log.Error(err)
+	}
+}
+
+func getVersion(w http.ResponseWriter, r *http.Request) {
+	resp := VersionResponse{
+		Version:   version.Version,
+		GitCommit: version.GitCommit,
+		BuildDate: version.BuildDate,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error(err)
+	}
+}
+
 func getServiceStatus(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
@@ -225,11 +463,16 @@ func statusForService(svc bitesize.Service) StatusService {
 		status = "green"
 	}
 
+	// pods of the previous and updated replica sets differ during rollouts
+	rolledOut := svc.Status.CurrentReplicas == svc.Status.DesiredReplicas
+
 	return StatusService{
 		Name:       svc.Name,
 		Version:    svc.Version,
 		DeployedAt: svc.Status.DeployedAt,
 		Status:     status,
+		Images:     svc.Status.ImageDigests,
+		ImageDrift: rolledOut && len(svc.Status.ImageDigests) > 1,
 		Replicas: StatusReplicas{
 			Available: svc.Status.AvailableReplicas,
 			UpToDate:  svc.Status.CurrentReplicas,
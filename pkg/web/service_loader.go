@@ -8,23 +8,45 @@ import (
 	"github.com/pearsontechnology/environment-operator/pkg/bitesize"
 	"github.com/pearsontechnology/environment-operator/pkg/cluster"
 	"github.com/pearsontechnology/environment-operator/pkg/config"
-	"github.com/pearsontechnology/environment-operator/pkg/git"
+	"github.com/pearsontechnology/environment-operator/pkg/source"
 )
 
-func loadServiceFromConfig(name string) (*bitesize.Service, error) {
-	gitClient := git.Client()
-	gitClient.Refresh()
+// Source is the config source of the reconcile loop. The API serves the
+// environment it last loaded
+var Source *source.Shared
 
-	environment, err := bitesize.LoadEnvironmentFromConfig(config.Env)
+func loadEnvironmentFromSource() (*bitesize.Environment, error) {
+	if Source == nil {
+		return nil, errors.New("config source is not initialized")
+	}
+	return Source.Environment()
+}
+
+func loadServiceFromConfig(name string) (*bitesize.Service, error) {
+	environment, err := loadEnvironmentFromSource()
 	if err != nil {
 		return nil, fmt.Errorf("Could not load env: %s", err.Error())
 	}
 
-	service := environment.Services.FindByName(name)
+	service := findService(environment.Services, name)
 	if service == nil {
 		log.Warnf("Services: %v", environment.Services)
 		return nil, fmt.Errorf("%s not found", name)
 	}
+
+	if len(service.Environments) != 0 {
+		client, err := cluster.Client()
+		if err != nil {
+			return nil, fmt.Errorf("Error cluster client: %s", err.Error())
+		}
+		env, err := client.NamespaceEnvironment(config.Env.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if !service.DeployedIn(env) {
+			return nil, fmt.Errorf("%s is not deployed in environment %s", name, env)
+		}
+	}
 	return service, nil
 }
 
@@ -39,18 +61,45 @@ func loadServiceFromCluster(name string) (bitesize.Service, error) {
 		return bitesize.Service{}, errors.New(fmt.Sprintf("Error getting environment: %s", err.Error()))
 	}
 
-	s := e.Services.FindByName(name)
+	s := findService(e.Services, name)
 	if s == nil {
 		return bitesize.Service{}, errors.New("Error getting service: name")
 	}
 	return *s, nil
 }
 
-func loadConfigMapsFromConfig() (*bitesize.Gists, error) {
-	gitClient := git.Client()
-	gitClient.Refresh()
+// findService finds service by its object name or by the name it is
+// configured with, without SERVICE_NAME_PREFIX and SERVICE_NAME_SUFFIX
+func findService(services bitesize.Services, name string) *bitesize.Service {
+	if s := services.FindByName(name); s != nil {
+		return s
+	}
+	return services.FindByName(bitesize.ServiceObjectName(name))
+}
+
+// ownedServices returns services of this operator. With SERVICE_NAME_PREFIX
+// or SERVICE_NAME_SUFFIX set, those are services of the configured
+// environment; services are returned as they are if it can't be loaded
+func ownedServices(services bitesize.Services) bitesize.Services {
+	if config.Env.ServiceNamePrefix == "" && config.Env.ServiceNameSuffix == "" {
+		return services
+	}
+	environment, err := loadEnvironmentFromSource()
+	if err != nil {
+		log.Warnf("could not load environment to filter services of other operators: %s", err.Error())
+		return services
+	}
+	var owned bitesize.Services
+	for _, svc := range services {
+		if bitesize.IsServiceObjectName(svc.Name, environment.Services) {
+			owned = append(owned, svc)
+		}
+	}
+	return owned
+}
 
-	environment, err := bitesize.LoadEnvironmentFromConfig(config.Env)
+func loadConfigMapsFromConfig() (*bitesize.Gists, error) {
+	environment, err := loadEnvironmentFromSource()
 	if err != nil {
 		return nil, fmt.Errorf("Could not load env: %s", err.Error())
 	}
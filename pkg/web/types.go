@@ -15,9 +15,29 @@ type DeployRequest struct {
 type StatusResponse struct {
 	EnvironmentName string          `json:"environment"`
 	Namespace       string          `json:"namespace"`
+	QueueDepth      int             `json:"queue_depth"`
 	Services        []StatusService `json:"services"`
 }
 
+type DiffResponse struct {
+	EnvironmentName string            `json:"environment"`
+	Namespace       string            `json:"namespace"`
+	Pending         bool              `json:"pending"`
+	Changes         map[string]string `json:"changes"`
+}
+
+type ReadyResponse struct {
+	Healthy  bool `json:"healthy"`
+	Leader   bool `json:"leader"`
+	Failures int  `json:"failures"`
+}
+
+type VersionResponse struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildDate string `json:"build_date"`
+}
+
 type StatusService struct {
 	Name       string         `json:"name"`
 	Version    string         `json:"version,omitempty"`
@@ -25,6 +45,8 @@ type StatusService struct {
 	DeployedAt string         `json:"deployed_at,omitempty"`
 	Replicas   StatusReplicas `json:"replicas,omitempty"`
 	Status     string         `json:"status,omitempty"`
+	Images     []string       `json:"images,omitempty"`
+	ImageDrift bool           `json:"image_drift,omitempty"`
 }
 
 type StatusPods struct {
@@ -7,7 +7,7 @@ buildDocker(){
   echo "***************** Building Docker Image ${FULL_IMAGE} **********************"
   echo "****************************************************************************"
 
-  docker build -f ./hack/build/Dockerfile -t $FULL_IMAGE .
+  docker build -f ./hack/build/Dockerfile --build-arg COSIGN_SHA256="${COSIGN_SHA256}" -t $FULL_IMAGE .
 
   echo "****************************************************************************"
   echo "***************** Pushing Docker Image ${FULL_IMAGE} ***********************"
@@ -17,6 +17,10 @@ buildDocker(){
 }
 
 bin_dir="_output/bin"
+
+VERSION_PKG=github.com/pearsontechnology/environment-operator/version
+LDFLAGS="-X ${VERSION_PKG}.GitCommit=$(git rev-parse --short HEAD) -X ${VERSION_PKG}.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+
 mkdir -p ${bin_dir} || true
 
 docker login -u="$DOCKER_USERNAME" -p="$DOCKER_PASSWORD"
@@ -30,7 +34,7 @@ BUILD_IMAGE="golang:1.12-alpine"
 docker run --rm -v "$(pwd)":/go/src/github.com/pearsontechnology/environment-operator \
 	-w /go/src/github.com/pearsontechnology/environment-operator \
 	${BUILD_IMAGE} \
-    go build -v -o ${bin_dir}/environment-operator ./cmd/operator/main.go
+    go build -v -ldflags "${LDFLAGS}" -o ${bin_dir}/environment-operator ./cmd/operator/main.go
 
 REPO=pearsontechnology/environment-operator
 
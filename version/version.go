@@ -2,3 +2,10 @@ package version
 
 // Version for environment-operator
 var Version = "1.4.8"
+
+// GitCommit and BuildDate are set at build time with
+// -ldflags "-X github.com/pearsontechnology/environment-operator/version.GitCommit=..."
+var (
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)